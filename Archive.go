@@ -0,0 +1,143 @@
+// year archive: a contact sheet page per year of capture dates (archive/2024/index.html), with a dense grid of thumbnails for each
+// month, for a quick visual overview of a year's output - and archive/index.html listing the years. The homepage links to the
+// archive when any work has a capture date; works without one aren't in it.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// directory (in the output directory) of the year archive
+const archiveDir = "archive"
+
+// type struct representing the year archive as one of its pages shows it
+type archiveView struct {
+	Years  []archiveYear  // every year with works, newest first
+	Year   int            // year pages: the page's year (0 on the list of years)
+	Months []archiveMonth // year pages: the months with works, in order
+	Prev   string         // year pages: link to the previous year with works ("" for none)
+	Next   string         // year pages: link to the next year with works ("" for none)
+}
+
+// type struct representing a year in the list of years
+type archiveYear struct {
+	Year  int
+	Href  string // the year's page, relative to the archive page
+	Works int
+}
+
+// type struct representing a month on a year's page
+type archiveMonth struct {
+	Name  string // e.g. March
+	ID    string // anchor of the month's section, e.g. m03
+	Items []galleryItem
+}
+
+// write the list of years and a page per year - pages that can't be written are recorded with the site writer
+// returns whether there's an archive (some work has a capture date) for the homepage to link to
+func generateArchive(site *siteWriter, works []*Work, assets *siteAssets) bool {
+	byYear := map[int][]*Work{}
+	var years []int
+	for _, wk := range chronologicalWorks(works) {
+		if wk.Date.IsZero() {
+			continue
+		}
+		y := wk.Date.Year()
+		if byYear[y] == nil {
+			years = append([]int{y}, years...) // newest first
+		}
+		byYear[y] = append(byYear[y], wk)
+	}
+
+	if len(years) == 0 {
+		return false
+	}
+
+	list := make([]archiveYear, len(years))
+	for i, y := range years {
+		list[i] = archiveYear{Year: y, Works: len(byYear[y])}
+	}
+
+	// archive/index.html, with links down into the year directories
+	view := &pageView{Title: "Photos by year", Assets: rootedAssets(assets, "../"), Root: "../", Archive: &archiveView{Years: yearLinks(list, "")}}
+	site.writeArchivePage(archiveDir, view)
+
+	for i, y := range years {
+		dir := archiveDir + "/" + strconv.Itoa(y)
+		a := &archiveView{Years: yearLinks(list, "../"), Year: y}
+		if i+1 < len(years) {
+			a.Prev = "../" + strconv.Itoa(years[i+1]) + "/index.html"
+		}
+		if i > 0 {
+			a.Next = "../" + strconv.Itoa(years[i-1]) + "/index.html"
+		}
+
+		for _, wk := range byYear[y] {
+			if len(a.Months) == 0 || a.Months[len(a.Months)-1].Name != wk.Date.Month().String() {
+				a.Months = append(a.Months, archiveMonth{Name: wk.Date.Month().String(), ID: fmt.Sprintf("m%02d", int(wk.Date.Month()))})
+			}
+
+			image := workImage(wk, "small")
+			image.Alt = wk.FileName
+			item := galleryItem{Page: "../../" + wk.pageURL(), Image: rootedImage(image, "../../"), Work: wk}
+			a.Months[len(a.Months)-1].Items = append(a.Months[len(a.Months)-1].Items, item)
+		}
+
+		site.writeArchivePage(dir, &pageView{Title: "Photos taken in " + strconv.Itoa(y), Assets: rootedAssets(assets, "../../"), Root: "../../", Archive: a})
+	}
+
+	return true
+}
+
+// return the list of years with links to their pages from a page in the given directory relative to the archive
+func yearLinks(years []archiveYear, up string) []archiveYear {
+	links := make([]archiveYear, len(years))
+	for i, y := range years {
+		links[i] = y
+		links[i].Href = up + strconv.Itoa(y.Year) + "/index.html"
+	}
+
+	return links
+}
+
+// write the index.html of an archive directory
+func (s *siteWriter) writeArchivePage(dir string, view *pageView) {
+	file := dir + "/index.html"
+	view.Canonical = s.canonical(file)
+
+	if err := os.MkdirAll(filepath.Join("./"+s.outputFolderLocation, filepath.FromSlash(dir)), 0755); err != nil {
+		s.fail(fmt.Errorf("Error creating archive directory (%s): %v", dir, err))
+		return
+	}
+
+	if err := s.writeView(file, "archive", view); err != nil {
+		s.fail(fmt.Errorf("Error writing output to archive HTML file (%s): %v", file, err))
+	}
+}
+
+// return the site assets as linked from a page in a subdirectory, root being the way back up to the site root
+func rootedAssets(assets *siteAssets, root string) *siteAssets {
+	rooted := *assets
+	rooted.Stylesheet = rootRelative(root, assets.Stylesheet)
+	rooted.Script = rootRelative(root, assets.Script)
+
+	return &rooted
+}
+
+// return the image as shown on a page in a subdirectory, root being the way back up to the site root
+func rootedImage(image imageView, root string) imageView {
+	image.Src = rootRelative(root, image.Src)
+
+	var sources []imageVariant
+	for _, v := range image.Sources {
+		v.Src = rootRelative(root, v.Src)
+		sources = append(sources, v)
+	}
+	image.Sources = sources
+
+	return image
+}
@@ -0,0 +1,212 @@
+// site assets: the shared stylesheet and navigation script, content-hash fingerprinting of emitted assets for long-lived immutable caching,
+// and subresource integrity hashes for them.
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// type struct representing the assets linked from every page
+type siteAssets struct {
+	Stylesheet          string // href of the stylesheet
+	StylesheetIntegrity string // SRI hash of the stylesheet (empty unless --csp)
+	Script              string // href of the navigation script
+	ScriptIntegrity     string // SRI hash of the navigation script (empty unless --csp)
+	CSP                 string // Content-Security-Policy for a <meta> tag (empty unless --csp)
+}
+
+// write the stylesheet (with the rules the catalog's galleries need) and navigation script into the output directory
+// returns the assets for pages to link to and the files written
+func writeSiteAssets(catalog *Catalog, outputFolderLocation string, opts *buildOptions, theme *siteTheme) (*siteAssets, []string, error) {
+	css := []byte(theme.CSS + galleryCSS(catalog, opts.Layouts) + theme.ExtraCSS)
+	js := []byte(theme.JS)
+
+	stylesheet, err := writeAsset(outputFolderLocation, "style.css", css, opts.Fingerprint)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error writing stylesheet: %v", err)
+	}
+
+	script, err := writeAsset(outputFolderLocation, "nav.js", js, opts.Fingerprint)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error writing navigation script: %v", err)
+	}
+
+	assets := &siteAssets{Stylesheet: stylesheet, Script: script}
+	if opts.CSP {
+		assets.StylesheetIntegrity = subresourceIntegrity(css)
+		assets.ScriptIntegrity = subresourceIntegrity(js)
+		assets.CSP = contentSecurityPolicy(catalog, false)
+	}
+
+	return assets, []string{stylesheet, script}, nil
+}
+
+// write an asset into the output directory and return its file name - when fingerprint is set, the name carries a hash of its content
+// (e.g. style.3fa9c2d1e0.css)
+func writeAsset(outputFolderLocation, name string, data []byte, fingerprint bool) (string, error) {
+	if fingerprint {
+		name = fingerprintName(name, data)
+	}
+
+	if err := os.WriteFile(filepath.Join("./"+outputFolderLocation, name), data, 0644); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// return the style rules for grid galleries and dominant color placeholders used by the catalog's pages
+// these live in the stylesheet rather than style attributes so a Content-Security-Policy doesn't need to allow inline styles
+func galleryCSS(catalog *Catalog, layouts map[string]pageLayout) string {
+	columns := map[int]bool{}
+	for _, l := range layouts {
+		if l.Columns > 0 {
+			columns[l.Columns] = true
+		}
+	}
+
+	colors := map[string]bool{}
+	for _, wk := range catalog.Works {
+		if wk != nil && validColor(wk.DominantColor) {
+			colors[wk.DominantColor] = true
+		}
+	}
+
+	var rules []string
+	for n := range columns {
+		rules = append(rules, fmt.Sprintf(".cols-%d { display: grid; grid-template-columns: repeat(%d, 1fr); gap: 10px; }", n, n))
+	}
+	for color := range colors {
+		rules = append(rules, "."+colorClass(color)+" { background-color: "+color+"; }")
+	}
+
+	if len(rules) == 0 {
+		return ""
+	}
+
+	sort.Strings(rules)
+	return strings.Join(rules, "\n") + "\n"
+}
+
+// return the class name giving an element the given #rrggbb background color
+func colorClass(color string) string {
+	return "bg-" + strings.TrimPrefix(color, "#")
+}
+
+// report whether a dominant color is in the #rrggbb form the stylesheet rules are generated for
+func validColor(color string) bool {
+	return hexColorPattern.MatchString(color)
+}
+
+var hexColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// return the subresource integrity value (sha384) of an asset
+func subresourceIntegrity(data []byte) string {
+	sum := sha512.Sum384(data)
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// return the file name with the first 10 hex digits of the SHA-256 of data inserted before its extension
+func fingerprintName(name string, data []byte) string {
+	sum := sha256.Sum256(data)
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:])[:10] + ext
+}
+
+// give every local image a fingerprinted copy next to it and point the works (and their WebP/AVIF variants) at the copies
+// the originals stay in place so later builds can still recognize already-downloaded images
+func fingerprintImages(catalog *Catalog, outputFolderLocation string) error {
+	outputDir := "./" + outputFolderLocation
+	fingerprinted := map[string]string{} // original path -> fingerprinted path, so shared images are only hashed once
+
+	fingerprint := func(local string) (string, error) {
+		if local == "" {
+			return "", nil
+		}
+
+		if done, ok := fingerprinted[local]; ok {
+			return done, nil
+		}
+
+		source := filepath.Join(outputDir, filepath.FromSlash(local))
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return "", err
+		}
+
+		hashed := path.Join(path.Dir(local), fingerprintName(path.Base(local), data))
+		target := filepath.Join(outputDir, filepath.FromSlash(hashed))
+
+		if _, err := os.Stat(target); err != nil {
+			if err := copyFile(source, target); err != nil {
+				return "", err
+			}
+		}
+
+		fingerprinted[local] = hashed
+		return hashed, nil
+	}
+
+	for _, wk := range catalog.Works {
+		if wk == nil {
+			continue
+		}
+
+		for _, local := range []*string{&wk.LocalSmall, &wk.LocalMedium, &wk.LocalLarge} {
+			hashed, err := fingerprint(*local)
+			if err != nil {
+				return fmt.Errorf("Error fingerprinting image %s: %v", *local, err)
+			}
+			*local = hashed
+		}
+
+		for size, variants := range wk.Variants {
+			for i := range variants {
+				hashed, err := fingerprint(variants[i].Src)
+				if err != nil {
+					return fmt.Errorf("Error fingerprinting image %s: %v", variants[i].Src, err)
+				}
+				wk.Variants[size][i].Src = hashed
+			}
+		}
+	}
+
+	return nil
+}
+
+// copy a file, hard-linking it instead where the filesystem allows
+func copyFile(source, target string) error {
+	if err := os.Link(source, target); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,99 @@
+// authors: the photographer of a work, read from its <author> or <artist> element, gets a gallery page of their works
+// (author-<name>.html), listed on an authors page (authors.html) the homepage links to, and the work pages link to their author:
+//
+//	<work>
+//	  <author>Jane Doe</author>
+//	  ...
+//
+// Authors are told apart by name, whitespace collapsed (see Interning.go). A work with several <author>s is credited to the first.
+// Sites whose works have no author get no author pages.
+
+package main
+
+import "fmt"
+
+// name of the page listing the authors
+const authorsPageFile = "authors.html"
+
+// type struct representing the author (photographer) of works
+type Author struct {
+	Name    string
+	PageURL string // the author's gallery page, without extension
+	Works   []*Work
+}
+
+// create and return a pointer to an author with a given name
+func createAuthor(name string) *Author {
+	return &Author{Name: name, PageURL: "author-" + pageSlug(name)}
+}
+
+// return the number of works on the author's page, for the count on the authors page
+func (a *Author) WorkCount() int {
+	return len(a.Works)
+}
+
+// return the author with the given name (nil if there's none)
+func (l *nameLookup) findAuthor(name string) *Author {
+	return l.authors[lookupName(name)]
+}
+
+// record an author - the first author recorded under a name keeps it
+func (l *nameLookup) addAuthor(a *Author) {
+	if key := lookupName(a.Name); l.authors[key] == nil {
+		l.authors[key] = a
+	}
+}
+
+// return the author with the given name, creating it and adding it to the catalog if it isn't recorded yet
+func (c *Catalog) findOrCreateAuthor(name string) *Author {
+	if a := c.names().findAuthor(name); a != nil {
+		return a
+	}
+
+	a := createAuthor(name)
+	c.Authors = append(c.Authors, a)
+	c.names().addAuthor(a)
+	return a
+}
+
+// credit a work to the author with the given name ("" for none), taking it off the works of its previous author
+func (c *Catalog) assignAuthor(wk *Work, name string) {
+	if wk.Author != nil {
+		wk.Author.Works = removeWork(wk.Author.Works, wk)
+		wk.Author = nil
+	}
+
+	if lookupName(name) == "" {
+		return
+	}
+
+	wk.Author = c.findOrCreateAuthor(name)
+	wk.Author.Works = append(wk.Author.Works, wk)
+}
+
+// write the authors page and a gallery page per author - pages that can't be written are recorded with the site writer
+// returns whether there are author pages for the homepage to link to
+func generateAuthorPages(site *siteWriter, catalog *Catalog, assets *siteAssets, layout pageLayout) bool {
+	var authors []*Author
+	for _, a := range catalog.Authors {
+		if a != nil && len(a.Works) > 0 {
+			authors = append(authors, a)
+		}
+	}
+
+	if len(authors) == 0 {
+		return false
+	}
+
+	if err := site.writeView(authorsPageFile, "authors", &pageView{Title: "Photographers", Assets: assets, Authors: authors, Canonical: site.canonical(authorsPageFile)}); err != nil {
+		site.fail(fmt.Errorf("Error writing output to authors HTML file (%s): %v", authorsPageFile, err))
+	}
+
+	for _, a := range authors {
+		if err := site.writeGallery(a.PageURL+".html", "author", a.Works, layout, &pageView{Title: "Photos by " + a.Name, Assets: assets, Author: a}); err != nil {
+			site.fail(fmt.Errorf("Error writing output to author HTML file (%s.html): %v", a.PageURL, err))
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,326 @@
+// CDN cache purging: once the after-write hooks have deployed a build, the files that changed since the last build are purged from
+// the CDN in front of the site, so visitors see the new pages without waiting for cached copies to expire. What changed comes from the
+// build manifests (see Manifest.go), which record a hash of every file written. The CDN is set in the config file's "cdn_purge"
+// section, e.g. one of:
+//
+//	"cdn_purge": {"provider": "cloudfront", "distribution_id": "E2QWRUHAPOMQZL",
+//	              "access_key_id_env": "AWS_ACCESS_KEY_ID", "secret_access_key_env": "AWS_SECRET_ACCESS_KEY"}
+//	"cdn_purge": {"provider": "cloudflare", "zone_id": "023e105f4ecef8ad9ca31a8372d0c353", "api_token_env": "CF_API_TOKEN"}
+//	"cdn_purge": {"provider": "fastly", "api_token_env": "FASTLY_API_TOKEN"}
+//
+// CloudFront is sent paths; Cloudflare and Fastly purge URLs, made from base_url (default: the site's --base-url). A changed
+// index.html purges its directory's URL too.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// most paths in one CloudFront invalidation before the whole distribution is invalidated instead
+const cloudFrontMaxPaths = 1000
+
+// most URLs in one Cloudflare purge request
+const cloudflareMaxFiles = 30
+
+// type struct representing the config file's "cdn_purge" section
+type cdnPurgeConfig struct {
+	Provider string `json:"provider"` // cloudfront, cloudflare or fastly
+	BaseURL  string `json:"base_url"` // public URL of the site, for providers purging URLs (default: the site's --base-url)
+	Endpoint string `json:"endpoint"` // API URL to use instead of the provider's (e.g. a proxy)
+
+	// cloudfront
+	DistributionID     string `json:"distribution_id"`
+	AccessKeyID        string `json:"access_key_id"`
+	AccessKeyIDEnv     string `json:"access_key_id_env"` // environment variable holding the access key id instead
+	SecretAccessKey    string `json:"secret_access_key"`
+	SecretAccessKeyEnv string `json:"secret_access_key_env"` // environment variable holding the secret key instead
+	SessionTokenEnv    string `json:"session_token_env"`     // environment variable holding a session token (temporary credentials)
+
+	// cloudflare and fastly
+	ZoneID      string `json:"zone_id"` // Cloudflare zone of the site
+	APIToken    string `json:"api_token"`
+	APITokenEnv string `json:"api_token_env"` // environment variable holding the API token instead
+}
+
+// check the purge settings are complete
+func (c *cdnPurgeConfig) check() error {
+	if c == nil {
+		return nil
+	}
+
+	switch c.Provider {
+	case "cloudfront":
+		if c.DistributionID == "" {
+			return fmt.Errorf("Error in cdn_purge config: cloudfront needs a distribution_id")
+		}
+		if secretValue(c.AccessKeyID, c.AccessKeyIDEnv) == "" || secretValue(c.SecretAccessKey, c.SecretAccessKeyEnv) == "" {
+			return fmt.Errorf("Error in cdn_purge config: cloudfront needs an access key id and secret access key")
+		}
+	case "cloudflare", "fastly":
+		if c.Provider == "cloudflare" && c.ZoneID == "" {
+			return fmt.Errorf("Error in cdn_purge config: cloudflare needs a zone_id")
+		}
+		if secretValue(c.APIToken, c.APITokenEnv) == "" {
+			return fmt.Errorf("Error in cdn_purge config: %s needs an API token", c.Provider)
+		}
+	default:
+		return fmt.Errorf("Error in cdn_purge config: unknown provider %q (expected cloudfront, cloudflare or fastly)", c.Provider)
+	}
+
+	return nil
+}
+
+// return the files whose content differs between two builds' manifests, including files the new build no longer writes - every
+// file of the new build if there's no previous manifest to compare with
+func changedFiles(prev, next *buildManifest) []string {
+	var changed []string
+	for file, sum := range next.Hashes {
+		if prev == nil || prev.Hashes[file] != sum {
+			changed = append(changed, file)
+		}
+	}
+	if prev != nil {
+		for file := range prev.Hashes {
+			if _, ok := next.Hashes[file]; !ok {
+				changed = append(changed, file)
+			}
+		}
+	}
+	sort.Strings(changed)
+
+	return changed
+}
+
+// return the URL paths (from the site root) to purge for the changed files
+func purgePaths(files []string) []string {
+	var paths []string
+	for _, file := range files {
+		escaped := "/" + escapePath(file)
+
+		paths = append(paths, escaped)
+		if path.Base(file) == "index.html" {
+			paths = append(paths, strings.TrimSuffix(escaped, "index.html"))
+		}
+	}
+
+	return paths
+}
+
+// purge the changed files from the configured CDN
+func purgeCDN(cfg *cdnPurgeConfig, baseURL string, files []string) error {
+	if cfg == nil || len(files) == 0 {
+		return nil
+	}
+
+	paths := purgePaths(files)
+	if cfg.BaseURL != "" {
+		baseURL = cfg.BaseURL
+	}
+
+	var err error
+	switch cfg.Provider {
+	case "cloudfront":
+		err = purgeCloudFront(cfg, paths)
+	case "cloudflare", "fastly":
+		if baseURL == "" {
+			return fmt.Errorf("Error purging %s cache: no base URL to purge (set --base-url or cdn_purge's base_url)", cfg.Provider)
+		}
+		urls := make([]string, len(paths))
+		for i, p := range paths {
+			urls[i] = strings.TrimSuffix(baseURL, "/") + p
+		}
+
+		if cfg.Provider == "cloudflare" {
+			err = purgeCloudflare(cfg, urls)
+		} else {
+			err = purgeFastly(cfg, urls)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Error purging %s cache: %v", cfg.Provider, err)
+	}
+
+	fmt.Printf("Purged %d changed paths from the %s cache.\n", len(paths), cfg.Provider)
+	return nil
+}
+
+// send a purge request and return an error for any status but 2xx
+func sendPurgeRequest(req *http.Request) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: HTTP %s %s", req.Method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+//----------------- CloudFront -------------------------------
+
+// type struct representing a CloudFront CreateInvalidation request body
+type cloudFrontInvalidation struct {
+	XMLName         xml.Name `xml:"http://cloudfront.amazonaws.com/doc/2020-05-31/ InvalidationBatch"`
+	Quantity        int      `xml:"Paths>Quantity"`
+	Items           []string `xml:"Paths>Items>Path"`
+	CallerReference string   `xml:"CallerReference"`
+}
+
+// create an invalidation of the paths (or of everything, when there are too many)
+func purgeCloudFront(cfg *cdnPurgeConfig, paths []string) error {
+	if len(paths) > cloudFrontMaxPaths {
+		paths = []string{"/*"}
+	}
+
+	body, err := xml.Marshal(cloudFrontInvalidation{Quantity: len(paths), Items: paths, CallerReference: "imgproc-" + strconv.FormatInt(time.Now().UnixNano(), 10)})
+	if err != nil {
+		return err
+	}
+
+	endpoint := "https://cloudfront.amazonaws.com"
+	if cfg.Endpoint != "" {
+		endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint+"/2020-05-31/distribution/"+url.PathEscape(cfg.DistributionID)+"/invalidation", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/xml")
+	signAWSRequest(req, body, "cloudfront", "us-east-1", secretValue(cfg.AccessKeyID, cfg.AccessKeyIDEnv),
+		secretValue(cfg.SecretAccessKey, cfg.SecretAccessKeyEnv), secretValue("", cfg.SessionTokenEnv), time.Now().UTC())
+
+	return sendPurgeRequest(req)
+}
+
+// add a SigV4 Authorization header to the request
+func signAWSRequest(req *http.Request, body []byte, service, region, accessKeyID, secretKey, sessionToken string, signedAt time.Time) {
+	amzDate := signedAt.Format("20060102T150405Z")
+	day := signedAt.Format("20060102")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if sessionToken != "" {
+		signedHeaders = append(signedHeaders, "x-amz-security-token")
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsPathEncode(req.URL.EscapedPath()),
+		awsQueryEncode(req.URL.Query()),
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSum(sha256.New, []byte("AWS4"+secretKey), day)
+	key = hmacSum(sha256.New, key, region)
+	key = hmacSum(sha256.New, key, service)
+	key = hmacSum(sha256.New, key, "aws4_request")
+	signature := hex.EncodeToString(hmacSum(sha256.New, key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKeyID+"/"+scope+", SignedHeaders="+strings.Join(signedHeaders, ";")+", Signature="+signature)
+}
+
+//----------------- Cloudflare -------------------------------
+
+// purge the URLs from the zone's cache, a batch at a time
+func purgeCloudflare(cfg *cdnPurgeConfig, urls []string) error {
+	endpoint := "https://api.cloudflare.com/client/v4"
+	if cfg.Endpoint != "" {
+		endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	}
+
+	for start := 0; start < len(urls); start += cloudflareMaxFiles {
+		end := start + cloudflareMaxFiles
+		if end > len(urls) {
+			end = len(urls)
+		}
+
+		body, err := json.Marshal(map[string][]string{"files": urls[start:end]})
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequest(http.MethodPost, endpoint+"/zones/"+url.PathEscape(cfg.ZoneID)+"/purge_cache", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+secretValue(cfg.APIToken, cfg.APITokenEnv))
+
+		if err := sendPurgeRequest(req); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//----------------- Fastly -------------------------------
+
+// purge each URL from Fastly's cache
+func purgeFastly(cfg *cdnPurgeConfig, urls []string) error {
+	endpoint := "https://api.fastly.com"
+	if cfg.Endpoint != "" {
+		endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	}
+
+	for _, u := range urls {
+		// the purge API takes the URL without its scheme
+		_, target, ok := strings.Cut(u, "://")
+		if !ok {
+			return fmt.Errorf("base URL %q isn't absolute", u)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, endpoint+"/purge/"+target, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Fastly-Key", secretValue(cfg.APIToken, cfg.APITokenEnv))
+		req.Header.Set("Accept", "application/json")
+
+		if err := sendPurgeRequest(req); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
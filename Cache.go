@@ -0,0 +1,149 @@
+// build cache: facts about remote resources remembered between runs so they don't have to be fetched again. They're kept in the
+// persistent cache directory (see CacheDir.go), shared by every site built on the machine, or in the output directory when that's disabled.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// name of the build cache file, kept in the output directory when the persistent cache is disabled
+const buildCacheFile = ".build-cache.json"
+
+// name of the build cache file in the persistent cache directory
+const cacheMetadataFile = "metadata.json"
+
+// type struct representing the pixel dimensions of an image
+type imageDims struct {
+	Width       int `json:"width"`
+	Height      int `json:"height"`
+	Orientation int `json:"orientation,omitempty"` // EXIF orientation the width and height are corrected for (see Orientation.go)
+}
+
+// type struct representing everything remembered between builds
+type buildCache struct {
+	Dimensions map[string]imageDims `json:"dimensions"` // probed image dimensions keyed by remote URI
+	Colors     map[string]string    `json:"colors"`     // dominant thumbnail colors keyed by remote URI
+	Places     map[string]geoPlace  `json:"places"`     // reverse geocoding service answers keyed by request URL (see Places.go)
+
+	ImageHashes      map[string]string `json:"image_hashes"`      // SHA-256 of downloaded images keyed by remote URI (see Dedupe.go)
+	PerceptualHashes map[string]string `json:"perceptual_hashes"` // perceptual hashes of remote thumbnails, in hex (see NearDuplicates.go)
+
+	path  string
+	dirty bool
+}
+
+// read the build cache (from the persistent cache directory, or else the given output directory) - a missing or unreadable cache gives an empty one
+func loadBuildCache(outputFolderLocation string) *buildCache {
+	c := &buildCache{path: filepath.Join("./"+outputFolderLocation, buildCacheFile)}
+	if dir, err := cacheDir(); err == nil {
+		c.path = filepath.Join(dir, cacheMetadataFile)
+	}
+
+	if data, err := os.ReadFile(c.path); err == nil {
+		json.Unmarshal(data, c)
+	}
+
+	if c.Dimensions == nil {
+		c.Dimensions = map[string]imageDims{}
+	}
+
+	if c.Colors == nil {
+		c.Colors = map[string]string{}
+	}
+
+	if c.Places == nil {
+		c.Places = map[string]geoPlace{}
+	}
+
+	if c.ImageHashes == nil {
+		c.ImageHashes = map[string]string{}
+	}
+
+	if c.PerceptualHashes == nil {
+		c.PerceptualHashes = map[string]string{}
+	}
+
+	return c
+}
+
+// write the build cache back to disk if anything was added to it - entries another build saved in the meantime are kept
+func (c *buildCache) save() error {
+	if !c.dirty {
+		return nil
+	}
+
+	var saved buildCache
+	if data, err := os.ReadFile(c.path); err == nil {
+		json.Unmarshal(data, &saved)
+	}
+	for uri, d := range saved.Dimensions {
+		if _, ok := c.Dimensions[uri]; !ok {
+			c.Dimensions[uri] = d
+		}
+	}
+	for uri, color := range saved.Colors {
+		if _, ok := c.Colors[uri]; !ok {
+			c.Colors[uri] = color
+		}
+	}
+	for uri, place := range saved.Places {
+		if _, ok := c.Places[uri]; !ok {
+			c.Places[uri] = place
+		}
+	}
+	for uri, h := range saved.ImageHashes {
+		if _, ok := c.ImageHashes[uri]; !ok {
+			c.ImageHashes[uri] = h
+		}
+	}
+	for uri, h := range saved.PerceptualHashes {
+		if _, ok := c.PerceptualHashes[uri]; !ok {
+			c.PerceptualHashes[uri] = h
+		}
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(c.path, data); err != nil {
+		return err
+	}
+
+	c.dirty = false
+	return nil
+}
+
+// record the dimensions of a remote image
+func (c *buildCache) setDimensions(uri string, d imageDims) {
+	c.Dimensions[uri] = d
+	c.dirty = true
+}
+
+// record the dominant color of a remote image
+func (c *buildCache) setColor(uri, color string) {
+	c.Colors[uri] = color
+	c.dirty = true
+}
+
+// record the content hash of a downloaded image
+func (c *buildCache) setImageHash(uri, hash string) {
+	c.ImageHashes[uri] = hash
+	c.dirty = true
+}
+
+// record the perceptual hash of a remote thumbnail
+func (c *buildCache) setPerceptualHash(uri, hash string) {
+	c.PerceptualHashes[uri] = hash
+	c.dirty = true
+}
+
+// record a reverse geocoding service's answer
+func (c *buildCache) setPlace(uri string, place geoPlace) {
+	c.Places[uri] = place
+	c.dirty = true
+}
@@ -0,0 +1,192 @@
+// deploy cache headers: the netlify and cloudflare-pages deploy targets publish the site with a Cache-Control header for every file,
+// written into the _headers file both hosts read (alongside the security headers of --csp). Each file gets the value of the first rule
+// its path matches - the rules of the config file's deploy section first, then the defaults:
+//
+//	"deploy": {
+//	  "cache_control": [
+//	    {"path": "/feeds/*", "value": "public, max-age=600"},
+//	    {"path": "*.pdf", "value": "public, max-age=86400"}
+//	  ],
+//	  ...
+//
+//   - fingerprinted files (a 10 hex digit content hash before the extension, see Assets.go): public, max-age=31536000, immutable
+//   - images: public, max-age=31536000
+//   - everything else, pages included: public, max-age=300, must-revalidate
+//
+// A path ending in /* matches everything below that directory, one without a slash matches file names (e.g. *.html), and any other is
+// matched against the whole path from the site root. A rule with an empty value leaves the files it matches without a Cache-Control
+// header, so {"path": "/*", "value": ""} turns the defaults off. GitHub Pages can't set headers, so the gh-pages target ignores the rules.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// most rules in a _headers file Cloudflare Pages reads
+const cloudflareMaxHeaderRules = 100
+
+// type struct representing a Cache-Control rule of the deploy section
+type cacheControlRule struct {
+	Path  string `json:"path"`  // pattern the path of a file from the site root is matched against
+	Value string `json:"value"` // the Cache-Control value ("" for none)
+
+	fingerprinted bool // matches fingerprinted file names instead of the path (a default rule)
+}
+
+// file names with a content hash fingerprint in them
+var fingerprintedFile = regexp.MustCompile(`\.[0-9a-f]{10}\.[A-Za-z0-9]+$`)
+
+// rules applied after the configured ones
+var defaultCacheControl = []cacheControlRule{
+	{fingerprinted: true, Value: fmt.Sprintf("public, max-age=%d, immutable", longCacheSeconds)},
+	{Path: "/" + imagesDir + "/*", Value: fmt.Sprintf("public, max-age=%d", longCacheSeconds)},
+	{Path: "/*", Value: "public, max-age=300, must-revalidate"},
+}
+
+// check that every rule's pattern is valid
+func checkCacheControl(rules []cacheControlRule) error {
+	for _, rule := range rules {
+		if rule.Path == "" {
+			return fmt.Errorf("Error in deploy config: a cache_control rule has no path")
+		}
+		if _, err := path.Match(strings.TrimPrefix(rule.Path, "/"), ""); err != nil {
+			return fmt.Errorf("Error in deploy config: bad cache_control path %q: %v", rule.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// return whether the rule matches a file (a path from the site root with forward slashes, without a leading slash)
+func (rule cacheControlRule) matches(file string) bool {
+	pattern := rule.Path
+	switch {
+	case rule.fingerprinted:
+		return fingerprintedFile.MatchString(path.Base(file))
+	case strings.HasSuffix(pattern, "/*"):
+		return strings.HasPrefix(file, strings.TrimPrefix(strings.TrimSuffix(pattern, "*"), "/"))
+	case !strings.Contains(pattern, "/"):
+		ok, _ := path.Match(pattern, path.Base(file))
+		return ok
+	}
+
+	ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), file)
+	return ok
+}
+
+// return the Cache-Control value of every file - the value of the first rule the file matches
+func cacheControlValues(files []string, rules []cacheControlRule) map[string]string {
+	rules = append(append([]cacheControlRule{}, rules...), defaultCacheControl...)
+
+	values := map[string]string{}
+	for _, file := range files {
+		for _, rule := range rules {
+			if rule.matches(file) {
+				values[file] = rule.Value
+				break
+			}
+		}
+	}
+
+	return values
+}
+
+// return the _headers rules setting the files' Cache-Control values, as path -> value - a directory whose files all have the same
+// value gets one rule for everything below it, and every other file a rule of its own (an index.html one for its directory's URL too),
+// so no two rules match the same URL and the hosts have no values to combine
+func cacheControlHeaderRules(values map[string]string) map[string]string {
+	files := make([]string, 0, len(values))
+	for file := range values {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	rules := map[string]string{}
+	var collapse func(dir string, files []string)
+	collapse = func(dir string, files []string) {
+		same := true
+		for _, file := range files {
+			same = same && values[file] == values[files[0]]
+		}
+		if same {
+			if values[files[0]] != "" {
+				rules["/"+escapePath(dir)+"*"] = values[files[0]]
+			}
+			return
+		}
+
+		subdirs := map[string][]string{}
+		var names []string
+		for _, file := range files {
+			rest := strings.TrimPrefix(file, dir)
+			if i := strings.Index(rest, "/"); i >= 0 {
+				sub := dir + rest[:i+1]
+				if len(subdirs[sub]) == 0 {
+					names = append(names, sub)
+				}
+				subdirs[sub] = append(subdirs[sub], file)
+				continue
+			}
+
+			if value := values[file]; value != "" {
+				rules["/"+escapePath(file)] = value
+				if rest == "index.html" {
+					rules["/"+escapePath(dir)] = value
+				}
+			}
+		}
+
+		for _, sub := range names {
+			collapse(sub, subdirs[sub])
+		}
+	}
+	if len(files) > 0 {
+		collapse("", files)
+	}
+
+	return rules
+}
+
+// return the content of the _headers file to deploy: the site's own (written with --csp) followed by the Cache-Control rules, and
+// the number of rules in it
+func deployHeadersFile(outputFolderLocation string, files []string, rules []cacheControlRule) ([]byte, int, error) {
+	var b bytes.Buffer
+	count := 0
+
+	own, err := os.ReadFile(filepath.Join("./"+outputFolderLocation, headersFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, 0, err
+	}
+	if len(own) > 0 {
+		b.Write(own)
+		if !bytes.HasSuffix(own, []byte("\n")) {
+			b.WriteString("\n")
+		}
+		for _, line := range strings.Split(string(own), "\n") {
+			if strings.HasPrefix(line, "/") {
+				count++
+			}
+		}
+	}
+
+	headerRules := cacheControlHeaderRules(cacheControlValues(withoutFile(files, headersFile), rules))
+	paths := make([]string, 0, len(headerRules))
+	for p := range headerRules {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		fmt.Fprintf(&b, "%s\n  Cache-Control: %s\n", p, headerRules[p])
+	}
+
+	return b.Bytes(), count + len(paths), nil
+}
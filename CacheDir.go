@@ -0,0 +1,418 @@
+// persistent cache directory: fetched feeds, downloaded images and remembered image facts (dimensions, dominant colors) shared by
+// every build on this machine, so rebuilding a site - or building a second one from the same images - doesn't fetch anything twice.
+// It lives in the user cache directory ($XDG_CACHE_HOME/imgproc, ~/.cache/imgproc, ~/Library/Caches/imgproc or
+// %LocalAppData%\imgproc), or wherever IMGPROC_CACHE_DIR or --cache-dir points. Page templates are parsed once per run and
+// aren't cached. The cache subcommand shows, cleans and garbage collects it.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cache sub-directories
+const (
+	cacheFeedsDir  = "feeds"
+	cacheImagesDir = "images"
+)
+
+// cache location set on the command line ("" to use IMGPROC_CACHE_DIR or the user cache directory)
+var cacheDirOverride string
+
+// set to build without the persistent cache (--no-cache, and golden tests, whose output mustn't depend on earlier runs)
+var cacheDisabled bool
+
+// set to build from cached feeds and images only, without contacting the API or image hosts (--offline)
+var offline bool
+
+// errors returned when the persistent cache is turned off, and when an --offline build needs something the cache doesn't have
+var (
+	errCacheDisabled = errors.New("persistent cache disabled")
+	errNotCached     = errors.New("not in the cache (--offline)")
+)
+
+func init() {
+	registerCommand(&command{
+		Name:    "cache",
+		Usage:   "[--cache-dir dir] dir | info | clean | gc [--max-size 500MB] [--max-age 720h]",
+		Summary: "show, empty or garbage collect the persistent cache of feeds, images and image metadata",
+		Setup:   setupCache,
+	})
+}
+
+// return the cache directory (not necessarily created yet)
+func cacheDir() (string, error) {
+	if cacheDisabled {
+		return "", errCacheDisabled
+	}
+
+	if cacheDirOverride != "" {
+		return cacheDirOverride, nil
+	}
+
+	if dir := os.Getenv("IMGPROC_CACHE_DIR"); dir != "" {
+		return dir, nil
+	}
+
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("no cache directory (set IMGPROC_CACHE_DIR or use --cache-dir): %v", err)
+	}
+
+	return filepath.Join(base, "imgproc"), nil
+}
+
+// return the path a cached copy of the resource at uri is kept under in the given section - named after a hash of the URI, keeping its extension
+func cacheFile(section, uri string) (string, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(uri))
+	name := hex.EncodeToString(sum[:16])
+
+	if ext := strings.ToLower(path.Ext(strings.SplitN(uri, "?", 2)[0])); ext != "" && len(ext) <= 5 && !strings.ContainsAny(ext, "/\\") {
+		name += ext
+	}
+
+	return filepath.Join(dir, section, name[:2], name), nil
+}
+
+// return the path of the cached copy of uri if there is one, marking it as recently used for garbage collection
+func cachedCopy(section, uri string) (string, bool) {
+	p, err := cacheFile(section, uri)
+	if err != nil {
+		return "", false
+	}
+
+	info, err := os.Stat(p)
+	if err != nil || info.Size() == 0 {
+		return "", false
+	}
+
+	now := time.Now()
+	os.Chtimes(p, now, now)
+	return p, true
+}
+
+// write data to the cache as the copy of uri, replacing any earlier copy - errors are reported but don't fail the build
+func storeInCache(section, uri string, data []byte) {
+	p, err := cacheFile(section, uri)
+	if err != nil {
+		return
+	}
+
+	// watch mode polls the same feed every few seconds, so an unchanged copy is only marked as used
+	if existing, err := os.ReadFile(p); err == nil && bytes.Equal(existing, data) {
+		now := time.Now()
+		os.Chtimes(p, now, now)
+		return
+	}
+
+	if err := writeFileAtomic(p, data); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing to cache (%s): %v\n", p, err)
+	}
+}
+
+// copy a downloaded file into the cache as the copy of uri - errors are reported but don't fail the build
+func storeFileInCache(section, uri, source string) {
+	p, err := cacheFile(section, uri)
+	if err != nil {
+		return
+	}
+
+	if err := copyFileAtomic(source, p); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing to cache (%s): %v\n", p, err)
+	}
+}
+
+// open a remote works feed: fetched from the API and kept in the cache, or with --offline read from the cached copy of the last fetch
+func openRemoteFeed(location string) (io.ReadCloser, error) {
+	if offline {
+		cached, ok := cachedCopy(cacheFeedsDir, location)
+		if !ok {
+			return nil, errNotCached
+		}
+
+		return os.Open(cached)
+	}
+
+	body, err := fetcher.Fetch(context.Background(), location)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	storeInCache(cacheFeedsDir, location, data)
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// write a file through a temporary file in the same directory, so readers never see it half-written
+func writeFileAtomic(target string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), target)
+}
+
+// copy a file through a temporary file in the target's directory - never hardlinked, so nothing done to the copy can change the cache
+func copyFileAtomic(source, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tmp, in)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), target)
+}
+
+//----------------- cache subcommand -------------------------------
+
+// type struct representing a file in the cache
+type cacheEntry struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// define the cache subcommand's flags and return its runner: show, clean or garbage collect the cache
+func setupCache(flags *flag.FlagSet) func(positional []string) int {
+	flags.StringVar(&cacheDirOverride, "cache-dir", "", "cache directory (default $IMGPROC_CACHE_DIR or the user cache directory)")
+	maxSize := flags.String("max-size", "", "gc: remove the least recently used files until the cache is at most this size (e.g. 500MB, 2GB)")
+	maxAge := flags.Duration("max-age", 0, "gc: remove files not used for this long (e.g. 720h)")
+
+	return func(positional []string) int {
+		if len(positional) < 1 {
+			fmt.Fprintln(os.Stderr, "Error: please enter a cache action: dir, info, clean or gc (e.g. >go run ImageProcessor cache gc --max-size 500MB)")
+			return 2
+		}
+
+		dir, err := cacheDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+
+		switch positional[0] {
+		case "dir":
+			fmt.Println(dir)
+			return 0
+
+		case "info":
+			entries, err := cacheEntries(dir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading cache: %v\n", err)
+				return 1
+			}
+
+			sections := map[string][]cacheEntry{}
+			for _, e := range entries {
+				section := strings.SplitN(filepath.ToSlash(e.Path[len(dir)+1:]), "/", 2)[0]
+				sections[section] = append(sections[section], e)
+			}
+
+			fmt.Printf("Cache directory: %s\n", dir)
+			for _, section := range []string{cacheFeedsDir, cacheImagesDir, cacheMetadataFile} {
+				fmt.Printf("  %-18s %6d files  %s\n", section, len(sections[section]), formatSize(totalSize(sections[section])))
+			}
+			fmt.Printf("  %-18s %6d files  %s\n", "total", len(entries), formatSize(totalSize(entries)))
+			return 0
+
+		case "clean":
+			if err := os.RemoveAll(dir); err != nil {
+				fmt.Fprintf(os.Stderr, "Error removing cache: %v\n", err)
+				return 1
+			}
+
+			fmt.Printf("Removed %s.\n", dir)
+			return 0
+
+		case "gc":
+			limit := int64(-1)
+			if *maxSize != "" {
+				if limit, err = parseSize(*maxSize); err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					return 2
+				}
+			}
+
+			if limit < 0 && *maxAge <= 0 {
+				fmt.Fprintln(os.Stderr, "Error: cache gc needs --max-size and/or --max-age")
+				return 2
+			}
+
+			removed, freed, remaining, err := collectCache(dir, limit, *maxAge)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error collecting cache: %v\n", err)
+				return 1
+			}
+
+			fmt.Printf("Removed %d files (%s), %s left in %s.\n", removed, formatSize(freed), formatSize(remaining), dir)
+			return 0
+		}
+
+		fmt.Fprintf(os.Stderr, "Error: unknown cache action %q (expected dir, info, clean or gc)\n", positional[0])
+		return 2
+	}
+}
+
+// return every file in the cache, least recently used first
+func cacheEntries(dir string) ([]cacheEntry, error) {
+	var entries []cacheEntry
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, cacheEntry{Path: p, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ModTime.Before(entries[j].ModTime) })
+	return entries, err
+}
+
+// remove cache files unused for longer than maxAge (if set), then the least recently used files until the cache is at most
+// maxSize bytes (if not negative) - returns the number of files removed, the bytes freed and the bytes left
+func collectCache(dir string, maxSize int64, maxAge time.Duration) (int, int64, int64, error) {
+	entries, err := cacheEntries(dir)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	total := totalSize(entries)
+	removed, freed := 0, int64(0)
+
+	for _, e := range entries {
+		tooOld := maxAge > 0 && time.Since(e.ModTime) > maxAge
+		tooBig := maxSize >= 0 && total > maxSize
+		if !tooOld && !tooBig {
+			continue
+		}
+
+		if err := os.Remove(e.Path); err != nil {
+			return removed, freed, total, err
+		}
+
+		removed++
+		freed += e.Size
+		total -= e.Size
+	}
+
+	return removed, freed, total, nil
+}
+
+func totalSize(entries []cacheEntry) int64 {
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+
+	return total
+}
+
+// parse a size such as 500MB, 2G or 1048576 (bytes) - units are powers of 1024
+func parseSize(text string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(text))
+	s = strings.TrimSuffix(s, "B")
+
+	multiplier := int64(1)
+	for i, unit := range []string{"K", "M", "G", "T"} {
+		if strings.HasSuffix(s, unit) {
+			multiplier = int64(1) << (10 * (i + 1))
+			s = strings.TrimSuffix(s, unit)
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (e.g. 500MB or 2GB)", text)
+	}
+
+	return int64(n * float64(multiplier)), nil
+}
+
+// format a byte count for people (e.g. 12.3 MB)
+func formatSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	value, unit := float64(n), ""
+	for _, u := range []string{"KB", "MB", "GB", "TB"} {
+		value /= 1024
+		unit = u
+		if value < 1024 {
+			break
+		}
+	}
+
+	return fmt.Sprintf("%.1f %s", value, unit)
+}
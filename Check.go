@@ -0,0 +1,81 @@
+// check subcommand: a preflight for a build, run before starting a long one (in CI, say) - the works source answers with a works feed
+// (XML whose root element is <works>), the output directory can be written to and the templates, the default ones or a --theme's,
+// compile and define every page the build writes:
+//
+//	>go run ImageProcessor check --theme mytheme http://localhost/test/api/v1/works.xml code/html/output
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCommand(&command{
+		Name:    "check",
+		Usage:   "[--theme dir] [--timeout D] <api-url> <output-dir>",
+		Summary: "check the works feed, output directory and templates before a build, printing pass/fail results",
+		Setup:   setupCheck,
+	})
+}
+
+// templates a build renders pages with - the slideshow and mobile templates are optional (a theme without them gets no slideshows or
+// mobile pages)
+var requiredTemplates = []string{"index", "make", "model", "nomake", "work", "archive", "author", "authors", "lens", "lenses", "facet", "facets", "place", "places", "compare"}
+
+// define the check subcommand's flags and return its runner: run the preflight checks, print the results and return 0 if every
+// check passed (1 otherwise)
+func setupCheck(fs *flag.FlagSet) func(positional []string) int {
+	theme := fs.String("theme", "", "theme directory (or CSS file) the build will use - its templates are compiled along with the check")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout for the feed check")
+
+	return func(positional []string) int {
+		if len(positional) < 2 {
+			fmt.Fprintln(os.Stderr, "Error: please enter the image API URL and an output directory location (e.g. >go run ImageProcessor check http://localhost/test/api/v1/works.xml code/html/output)")
+			return 2
+		}
+
+		return printCheckResults([]checkResult{
+			checkFeed(positional[0], *timeout, "works"),
+			checkOutputWritable(positional[1]),
+			checkTemplates(*theme),
+		})
+	}
+}
+
+// check that the theme's templates compile and define every page template the build renders
+func checkTemplates(themePath string) checkResult {
+	r := checkResult{Name: "templates"}
+
+	theme, err := loadTheme(themePath)
+	if err != nil {
+		r.Detail = err.Error()
+		r.Hint = "fix the template syntax - the error names the line - or start again from >go run ImageProcessor theme init <dir>"
+		return r
+	}
+
+	var missing []string
+	for _, name := range requiredTemplates {
+		if theme.Templates.Lookup(name) == nil {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		r.Detail = fmt.Sprintf("missing templates: %s", strings.Join(missing, ", "))
+		r.Hint = "define the missing templates - theme init writes the default ones to copy from"
+		return r
+	}
+
+	r.OK = true
+	if themePath == "" {
+		r.Detail = "the default templates compile"
+	} else {
+		r.Detail = fmt.Sprintf("the templates of %s compile and define every page", themePath)
+	}
+
+	return r
+}
@@ -0,0 +1,83 @@
+// feed checksums: a work can give the checksum of its images, and downloaded copies (--download-images) are then checked against it -
+// a copy that doesn't match, whether just downloaded, from the persistent cache or left by an earlier build, is downloaded again, and
+// the outcome for each checked image is recorded in the build manifest:
+//
+//	<work>
+//	  <md5>9e107d9d372bb6826bd81d3542a419d6</md5>
+//	  <checksum type="small">sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855</checksum>
+//	  ...
+//
+// <md5> and <checksum> are about the large image unless their type attribute names another (small or medium). A checksum is written
+// as hex, optionally after md5: or sha256: - a bare one is taken as MD5 or SHA-256 by its length.
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// type struct representing the outcome of checking one downloaded image against its feed checksum
+type checksumResult struct {
+	ID     WorkID `json:"id"`
+	Image  string `json:"image"`  // small, medium or large
+	Status string `json:"status"` // verified, redownloaded (a copy already there didn't match) or failed
+}
+
+// return a feed checksum in the form works keep it - the algorithm and the hex sum, e.g. md5:9e107d9d372bb6826bd81d3542a419d6
+func parseChecksum(text, algorithm string) (string, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if prefix, sum, ok := strings.Cut(text, ":"); ok {
+		algorithm, text = prefix, sum
+	}
+
+	switch algorithm {
+	case "sha256", "sha-256":
+		algorithm = "sha-256"
+	case "md5":
+	case "":
+		switch len(text) {
+		case 32:
+			algorithm = "md5"
+		case 64:
+			algorithm = "sha-256"
+		}
+	default:
+		return "", fmt.Errorf("unknown checksum algorithm %q (expected md5 or sha256)", algorithm)
+	}
+
+	sum, err := hex.DecodeString(text)
+	if err != nil || (algorithm == "md5" && len(sum) != 16) || (algorithm == "sha-256" && len(sum) != 32) || algorithm == "" {
+		return "", fmt.Errorf("%q isn't an MD5 or SHA-256 checksum in hex", text)
+	}
+
+	return algorithm + ":" + hex.EncodeToString(sum), nil
+}
+
+// return the work's checksum of an image as the digests a download is checked against (nil if the feed gave none)
+func (w *Work) checksumDigests(size string) map[string][]byte {
+	algorithm, text, ok := strings.Cut(w.Checksums[size], ":")
+	if !ok {
+		return nil
+	}
+
+	sum, err := hex.DecodeString(text)
+	if err != nil {
+		return nil
+	}
+
+	return map[string][]byte{algorithm: sum}
+}
+
+// return how many of the checked images had the given status
+func countChecksums(results []checksumResult, status string) int {
+	n := 0
+	for _, r := range results {
+		if r.Status == status {
+			n++
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,121 @@
+// dominant color extraction: finds each thumbnail's most common color, shown behind the image while it loads.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// largest thumbnail download accepted for color extraction
+const maxColorSampleBytes = 8 * 1024 * 1024
+
+// work out the dominant color of every work's thumbnail (the downloaded/generated copy if there is one, otherwise the remote small image)
+// remote results are remembered in the build cache, if one is given - returns the number of works colored and the number that failed
+func computeDominantColors(catalog *Catalog, outputFolderLocation string, cache *buildCache) (int, int) {
+	computed, failed := 0, 0
+
+	for _, wk := range catalog.Works {
+		if wk == nil {
+			continue
+		}
+
+		src := strings.TrimSpace(wk.smallSrc())
+		if src == "" {
+			continue
+		}
+
+		remote := strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+		if remote && cache != nil {
+			if color, ok := cache.Colors[src]; ok {
+				wk.DominantColor = color
+				computed++
+				continue
+			}
+		}
+
+		img, err := loadImage(src, outputFolderLocation)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading thumbnail of work %s for color extraction (%s): %v\n", wk.ID, src, err)
+			failed++
+			continue
+		}
+
+		wk.DominantColor = dominantColor(img)
+		computed++
+
+		if remote && cache != nil {
+			cache.setColor(src, wk.DominantColor)
+		}
+	}
+
+	return computed, failed
+}
+
+// decode an image given as a remote URI or a path relative to the output directory
+func loadImage(src, outputFolderLocation string) (image.Image, error) {
+	var data []byte
+	var err error
+
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		var body io.ReadCloser
+		body, err = fetcher.Fetch(context.Background(), signURL(src))
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+
+		data, err = io.ReadAll(io.LimitReader(body, maxColorSampleBytes))
+	} else {
+		data, err = os.ReadFile(filepath.Join("./"+outputFolderLocation, filepath.FromSlash(src)))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// return the dominant color of an image as #rrggbb: pixels are grouped into coarse color buckets and the average of the fullest bucket wins
+func dominantColor(img image.Image) string {
+	// a small sample is plenty and keeps this fast for large images
+	sample := resizeImage(img, 32, 32)
+
+	type bucket struct {
+		r, g, b, n int
+	}
+	var buckets [4096]bucket // 4 bits per channel
+
+	best := 0
+	for i := 0; i < len(sample.Pix); i += 4 {
+		r, g, b, a := int(sample.Pix[i]), int(sample.Pix[i+1]), int(sample.Pix[i+2]), int(sample.Pix[i+3])
+		if a < 128 {
+			continue // transparent areas don't count
+		}
+
+		k := (r>>4)<<8 | (g>>4)<<4 | b>>4
+		buckets[k].r += r
+		buckets[k].g += g
+		buckets[k].b += b
+		buckets[k].n++
+
+		if buckets[k].n > buckets[best].n {
+			best = k
+		}
+	}
+
+	bk := buckets[best]
+	if bk.n == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("#%02x%02x%02x", bk.r/bk.n, bk.g/bk.n, bk.b/bk.n)
+}
@@ -0,0 +1,112 @@
+// comments: the config file's "comments" section embeds a third-party comment system on the work pages, so a static gallery can
+// collect feedback without a backend of its own:
+//
+//	"comments": {"provider": "utterances", "repo": "jane/photos-comments"}
+//	"comments": {"provider": "giscus", "repo": "jane/photos", "repo_id": "R_kgDOH...", "category": "Photos", "category_id": "DIC_kwDOH..."}
+//	"comments": {"provider": "isso", "endpoint": "https://comments.example.com/"}
+//
+// utterances keeps each work's comments in a GitHub issue and giscus in a GitHub discussion, found by the page's path; Isso is a
+// self-hosted comment server. The embed is rendered by the "comments" template (theme/templates.html), which a theme can replace to
+// restyle it or embed another system. With --csp the Content-Security-Policy allows the provider's script and frames - Isso's own
+// stylesheet is inline, which the policy doesn't allow, so its thread is then left for the theme's stylesheet to style.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// type struct representing the config file's "comments" section
+type commentsConfig struct {
+	Provider   string `json:"provider"`    // utterances, giscus or isso
+	Repo       string `json:"repo"`        // utterances and giscus: GitHub repository (owner/name) holding the comments
+	IssueTerm  string `json:"issue_term"`  // utterances: how a page finds its issue - pathname (default), url, title or og:title
+	Label      string `json:"label"`       // utterances: label given to the issues it opens
+	RepoID     string `json:"repo_id"`     // giscus: the repository's id (from giscus.app)
+	Category   string `json:"category"`    // giscus: discussion category
+	CategoryID string `json:"category_id"` // giscus: the category's id (from giscus.app)
+	Mapping    string `json:"mapping"`     // giscus: how a page finds its discussion - pathname (default), url, title or og:title
+	Theme      string `json:"theme"`       // utterances and giscus: color theme (default github-light / light)
+	Endpoint   string `json:"endpoint"`    // isso: URL of the Isso server
+}
+
+// comment system embedded on the work pages (nil for none)
+var commentSystem *commentsConfig
+
+// set up the comment system described in the configuration (nil for none)
+func configureComments(c *commentsConfig) error {
+	commentSystem = nil
+	if c == nil {
+		return nil
+	}
+
+	cfg := *c
+	switch cfg.Provider {
+	case "utterances":
+		if !strings.Contains(cfg.Repo, "/") {
+			return fmt.Errorf("Error in comments config: utterances needs the repo (owner/name) holding the comments")
+		}
+		if cfg.IssueTerm == "" {
+			cfg.IssueTerm = "pathname"
+		}
+		if cfg.Theme == "" {
+			cfg.Theme = "github-light"
+		}
+
+	case "giscus":
+		if !strings.Contains(cfg.Repo, "/") || cfg.RepoID == "" || cfg.Category == "" || cfg.CategoryID == "" {
+			return fmt.Errorf("Error in comments config: giscus needs repo, repo_id, category and category_id (see giscus.app)")
+		}
+		if cfg.Mapping == "" {
+			cfg.Mapping = "pathname"
+		}
+		if cfg.Theme == "" {
+			cfg.Theme = "light"
+		}
+
+	case "isso":
+		u, err := url.Parse(cfg.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Error in comments config: isso needs the endpoint URL of the Isso server")
+		}
+		cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/") + "/"
+
+	default:
+		return fmt.Errorf("Error in comments config: unknown provider %q (expected utterances, giscus or isso)", cfg.Provider)
+	}
+
+	commentSystem = &cfg
+	return nil
+}
+
+// return the origin the comment system's script and frames are loaded from ("" for none)
+func (c *commentsConfig) origin() string {
+	switch c.Provider {
+	case "utterances":
+		return "https://utteranc.es"
+	case "giscus":
+		return "https://giscus.app"
+	case "isso":
+		u, _ := url.Parse(c.Endpoint)
+		return u.Scheme + "://" + u.Host
+	}
+
+	return ""
+}
+
+// return the Content-Security-Policy directives the comment system needs on top of the site's own
+func commentsCSP() (scriptSrc, frameSrc, connectSrc []string) {
+	if commentSystem == nil {
+		return nil, nil, nil
+	}
+
+	origin := commentSystem.origin()
+	if commentSystem.Provider == "isso" {
+		// Isso renders the thread into the page itself, from its API
+		return []string{origin}, nil, []string{origin}
+	}
+
+	return []string{origin}, []string{origin}, nil
+}
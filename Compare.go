@@ -0,0 +1,206 @@
+// comparison pages: the config file's "compare" section lists pairs of makes or models to show side by side - each with its number
+// of works, the range of their capture dates and a few sample works spread over that range - on a page of its own
+// (compare/canon-vs-nikon.html), for gear-review style sites. compare/index.html lists the comparisons, and the homepage links to it:
+//
+//	"compare": [
+//	  {"left": "Canon", "right": "NIKON CORPORATION", "name": "canon-vs-nikon"},
+//	  {"left": "Canon EOS 20D", "right": "NIKON D80", "samples": 4}
+//	]
+//
+// A side names a make or, if no make has that name, a model of any make. The page is named after both sides ("<left>-vs-<right>")
+// unless the pair gives a name, and shows 6 samples a side unless it gives another number. A pair naming a make or model the feed
+// doesn't have is skipped with a warning.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// directory (in the output directory) of the comparison pages
+const compareDir = "compare"
+
+// default number of sample works on each side of a comparison
+const defaultCompareSamples = 6
+
+// type struct representing a pair of makes or models to compare, from the config file
+type comparePair struct {
+	Left    string `json:"left"`
+	Right   string `json:"right"`
+	Name    string `json:"name"`    // page name, without extension ("" for <left>-vs-<right>)
+	Samples int    `json:"samples"` // sample works shown on each side (0 for the default)
+}
+
+// type struct representing a comparison as its page shows it
+type compareView struct {
+	Pairs []compareLink   // the list of comparisons
+	Sides [2]*compareSide // comparison pages: the left and right side
+}
+
+// type struct representing a link to a comparison page
+type compareLink struct {
+	Title string
+	Href  string // relative to the compare directory
+}
+
+// type struct representing one side of a comparison
+type compareSide struct {
+	Name    string
+	Href    string // the make's or model's page
+	Make    *Make
+	Model   *Model // nil when the side is a whole make
+	Works   int
+	First   string // capture date of the oldest work ("" if none has one)
+	Last    string // capture date of the newest work
+	Undated int    // works without a capture date
+	Samples []galleryItem
+}
+
+// check that every pair names two sides and a sensible number of samples
+func checkComparePairs(pairs []comparePair) error {
+	names := map[string]bool{}
+	for _, p := range pairs {
+		if strings.TrimSpace(p.Left) == "" || strings.TrimSpace(p.Right) == "" {
+			return fmt.Errorf("Error in compare config: a comparison needs both a left and a right make or model")
+		}
+		if p.Samples < 0 {
+			return fmt.Errorf("Error in compare config: %s vs %s has a negative number of samples", p.Left, p.Right)
+		}
+
+		name := p.pageName()
+		if names[name] {
+			return fmt.Errorf("Error in compare config: two comparisons have the page name %q", name)
+		}
+		names[name] = true
+	}
+
+	return nil
+}
+
+// return the name of the pair's page, without extension
+func (p comparePair) pageName() string {
+	if p.Name != "" {
+		return pageSlug(p.Name)
+	}
+
+	return strings.ToLower(pageSlug(p.Left) + "-vs-" + pageSlug(p.Right))
+}
+
+// return the side of a comparison with the given make or model name (nil if the catalog has neither)
+func (c *Catalog) compareSide(name string, samples int) *compareSide {
+	var side *compareSide
+	if mk := c.names().findMake(name); mk != nil {
+		side = &compareSide{Name: mk.DisplayName, Href: "../" + mk.PageURL + ".html", Make: mk}
+		side.setWorks(mk.Works, samples)
+		return side
+	}
+
+	for _, mk := range c.Makes {
+		if mk == nil {
+			continue
+		}
+		if md := c.names().findModel(mk, name); md != nil {
+			side = &compareSide{Name: md.DisplayName, Href: "../" + md.PageURL + ".html", Make: mk, Model: md}
+			side.setWorks(md.Works, samples)
+			return side
+		}
+	}
+
+	return nil
+}
+
+// fill in the counts, date range and samples of a side from its works
+func (s *compareSide) setWorks(works []*Work, samples int) {
+	sorted := chronologicalWorks(works)
+	s.Works = len(sorted)
+
+	for _, wk := range sorted {
+		if wk.Date.IsZero() {
+			s.Undated++
+			continue
+		}
+		if s.First == "" {
+			s.First = wk.Date.Format("2 January 2006")
+		}
+		s.Last = wk.Date.Format("2 January 2006")
+	}
+
+	// samples spread evenly from the oldest work to the newest
+	if samples > len(sorted) {
+		samples = len(sorted)
+	}
+	for i := 0; i < samples; i++ {
+		j := 0
+		if samples > 1 {
+			j = i * (len(sorted) - 1) / (samples - 1)
+		}
+
+		wk := sorted[j]
+		image := workImage(wk, "small")
+		image.Alt = wk.FileName
+		s.Samples = append(s.Samples, galleryItem{Page: "../" + wk.pageURL(), Image: rootedImage(image, "../"), Work: wk})
+	}
+}
+
+// write a page per configured comparison and the list of them - pages that can't be written are recorded with the site writer
+// returns whether there are comparison pages for the homepage to link to
+func generateComparePages(site *siteWriter, catalog *Catalog, pairs []comparePair, assets *siteAssets) bool {
+	type comparison struct {
+		file  string
+		title string
+		sides [2]*compareSide
+	}
+
+	var comparisons []comparison
+	for _, p := range pairs {
+		samples := p.Samples
+		if samples == 0 {
+			samples = defaultCompareSamples
+		}
+
+		left, right := catalog.compareSide(p.Left, samples), catalog.compareSide(p.Right, samples)
+		if left == nil || right == nil {
+			missing := p.Left
+			if left != nil {
+				missing = p.Right
+			}
+			fmt.Fprintf(os.Stderr, "Skipping comparison %s vs %s: there's no make or model named %s\n", p.Left, p.Right, missing)
+			continue
+		}
+
+		comparisons = append(comparisons, comparison{file: p.pageName() + ".html", title: left.Name + " vs " + right.Name, sides: [2]*compareSide{left, right}})
+	}
+
+	if len(comparisons) == 0 {
+		return false
+	}
+
+	if err := os.MkdirAll(filepath.Join("./"+site.outputFolderLocation, compareDir), 0755); err != nil {
+		site.fail(fmt.Errorf("Error creating comparison directory (%s): %v", compareDir, err))
+		return false
+	}
+
+	var links []compareLink
+	for _, c := range comparisons {
+		links = append(links, compareLink{Title: c.title, Href: c.file})
+	}
+
+	assets = rootedAssets(assets, "../")
+	file := compareDir + "/index.html"
+	if err := site.writeView(file, "compare", &pageView{Title: "Comparisons", Assets: assets, Root: "../", Compare: &compareView{Pairs: links}, Canonical: site.canonical(file)}); err != nil {
+		site.fail(fmt.Errorf("Error writing output to comparison HTML file (%s): %v", file, err))
+	}
+
+	for _, c := range comparisons {
+		file := compareDir + "/" + c.file
+		view := &pageView{Title: c.title, Assets: assets, Root: "../", Compare: &compareView{Pairs: links, Sides: c.sides}, Canonical: site.canonical(file)}
+		if err := site.writeView(file, "compare", view); err != nil {
+			site.fail(fmt.Errorf("Error writing output to comparison HTML file (%s): %v", file, err))
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,306 @@
+// shell completion and the man page: both are generated from the command definitions - the subcommands' names, synopses and
+// summaries, and the flags their Setup functions define (see ImageProcessor.go) - so they never fall behind the flags themselves:
+//
+//	>go run ImageProcessor completion bash > /etc/bash_completion.d/imgproc
+//	>go run ImageProcessor completion zsh > "${fpath[1]}/_imgproc"
+//	>go run ImageProcessor completion fish > ~/.config/fish/completions/imgproc.fish
+//	>go run ImageProcessor docs man > /usr/local/share/man/man1/imgproc.1
+//
+// They complete and document the program as imgproc; --name gives the name it's installed under.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func init() {
+	registerCommand(&command{
+		Name:    "completion",
+		Usage:   "[--name program] bash|zsh|fish",
+		Summary: "print a shell completion script for the subcommands and their flags",
+		Setup:   setupCompletion,
+	})
+	registerCommand(&command{
+		Name:    "docs",
+		Usage:   "[--name program] man",
+		Summary: "print the man page (roff) documenting the build, the subcommands and their flags",
+		Setup:   setupDocs,
+	})
+}
+
+// name the program is completed and documented as, unless --name gives another
+const defaultProgramName = "imgproc"
+
+// type struct representing a flag of a command, for completion and the man page
+type flagInfo struct {
+	Name    string
+	Value   string // name of the value it takes ("" for a boolean flag)
+	Usage   string
+	Default string // "" when the default is the zero value
+}
+
+// return the flags a command defines, in name order
+func commandFlags(c *command) []flagInfo {
+	fs, _ := c.flags()
+
+	var flags []flagInfo
+	fs.VisitAll(func(f *flag.Flag) {
+		value, usage := flag.UnquoteUsage(f)
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			value = ""
+		}
+
+		def := f.DefValue
+		switch def {
+		case "0", "0s", "false", "[]":
+			def = ""
+		}
+
+		flags = append(flags, flagInfo{Name: f.Name, Value: value, Usage: usage, Default: def})
+	})
+
+	return flags
+}
+
+// return the names of a command's flags, each with its leading dashes
+func flagNames(c *command) []string {
+	var names []string
+	for _, f := range commandFlags(c) {
+		names = append(names, "--"+f.Name)
+	}
+
+	return names
+}
+
+// define the completion subcommand's flags and return its runner: print the completion script for the named shell
+func setupCompletion(fs *flag.FlagSet) func(positional []string) int {
+	name := fs.String("name", defaultProgramName, "name of the program to complete")
+
+	return func(positional []string) int {
+		if len(positional) != 1 {
+			fmt.Fprintln(os.Stderr, "Error: please enter the shell to complete for: bash, zsh or fish (e.g. >go run ImageProcessor completion bash)")
+			return 2
+		}
+
+		switch positional[0] {
+		case "bash":
+			writeBashCompletion(os.Stdout, *name)
+		case "zsh":
+			writeZshCompletion(os.Stdout, *name)
+		case "fish":
+			writeFishCompletion(os.Stdout, *name)
+		default:
+			fmt.Fprintf(os.Stderr, "Error: unknown shell %q (expected bash, zsh or fish)\n", positional[0])
+			return 2
+		}
+
+		return 0
+	}
+}
+
+// return the name of the shell function completing the program
+func completionFunction(name string) string {
+	return "_" + strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// write the bash completion script: subcommand names and build flags first, then the flags of the subcommand given - anything else
+// falls back to file names
+func writeBashCompletion(out io.Writer, name string) {
+	fn := completionFunction(name)
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.Name)
+	}
+
+	fmt.Fprintf(out, "# bash completion for %s - generated by >%s completion bash\n\n", name, name)
+	fmt.Fprintf(out, "%s() {\n", fn)
+	fmt.Fprintln(out, "\tlocal cur=${COMP_WORDS[COMP_CWORD]} words")
+	fmt.Fprintln(out, "\tcase ${COMP_WORDS[1]} in")
+	for _, c := range commands {
+		fmt.Fprintf(out, "\t%s) words=%q ;;\n", c.Name, strings.Join(flagNames(c), " "))
+	}
+	fmt.Fprintf(out, "\t*) words=%q ;;\n", strings.Join(flagNames(buildCommand), " "))
+	fmt.Fprintln(out, "\tesac")
+	fmt.Fprintf(out, "\tif [[ $COMP_CWORD -eq 1 && $cur != -* ]]; then\n\t\twords=%q\n\tfi\n", strings.Join(names, " "))
+	fmt.Fprintln(out, "\tif [[ $COMP_CWORD -eq 1 || $cur == -* ]]; then")
+	fmt.Fprintln(out, "\t\tCOMPREPLY=($(compgen -W \"$words\" -- \"$cur\"))")
+	fmt.Fprintln(out, "\tfi")
+	fmt.Fprintln(out, "}")
+	fmt.Fprintf(out, "complete -o default -F %s %s\n", fn, name)
+}
+
+// return text quoted for a shell's single quotes
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// return a flag's _arguments spec for the zsh completion
+func zshFlagSpec(f flagInfo) string {
+	usage := strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(f.Usage)
+	spec := "--" + f.Name + "[" + usage + "]"
+	if f.Value != "" {
+		spec += ":" + f.Value + ":_files"
+	}
+
+	return shellQuote(spec)
+}
+
+// write the zsh completion script: subcommands with their summaries, and each command's flags with their descriptions
+func writeZshCompletion(out io.Writer, name string) {
+	fn := completionFunction(name)
+
+	fmt.Fprintf(out, "#compdef %s\n# zsh completion for %s - generated by >%s completion zsh\n\n", name, name, name)
+	fmt.Fprintf(out, "%s() {\n", fn)
+	fmt.Fprintln(out, "\tlocal -a subcommands")
+	fmt.Fprintln(out, "\tsubcommands=(")
+	for _, c := range commands {
+		fmt.Fprintf(out, "\t\t%s\n", shellQuote(c.Name+":"+c.Summary))
+	}
+	fmt.Fprintln(out, "\t)")
+	fmt.Fprintln(out, "\tif (( CURRENT == 2 )) && [[ $PREFIX != -* ]]; then")
+	fmt.Fprintln(out, "\t\t_describe -t commands 'subcommand' subcommands")
+	fmt.Fprintln(out, "\t\t_files")
+	fmt.Fprintln(out, "\t\treturn")
+	fmt.Fprintln(out, "\tfi")
+	fmt.Fprintln(out, "\tcase $words[2] in")
+
+	arguments := func(indent string, c *command) {
+		fmt.Fprintf(out, "%s_arguments \\\n", indent)
+		for _, f := range commandFlags(c) {
+			fmt.Fprintf(out, "%s\t%s \\\n", indent, zshFlagSpec(f))
+		}
+		fmt.Fprintf(out, "%s\t'*:file:_files'\n", indent)
+	}
+	for _, c := range commands {
+		fmt.Fprintf(out, "\t%s)\n\t\tshift words\n\t\t(( CURRENT-- ))\n", c.Name)
+		arguments("\t\t", c)
+		fmt.Fprintln(out, "\t\t;;")
+	}
+	fmt.Fprintln(out, "\t*)")
+	arguments("\t\t", buildCommand)
+	fmt.Fprintln(out, "\t\t;;")
+	fmt.Fprintln(out, "\tesac")
+	fmt.Fprintln(out, "}")
+	fmt.Fprintf(out, "\n%s \"$@\"\n", fn)
+}
+
+// write the fish completion script: subcommands with their summaries, the build's flags when no subcommand is given, and each
+// subcommand's flags after it
+func writeFishCompletion(out io.Writer, name string) {
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.Name)
+	}
+
+	flags := func(condition string, c *command) {
+		for _, f := range commandFlags(c) {
+			line := fmt.Sprintf("complete -c %s -n %s -l %s -d %s", name, shellQuote(condition), f.Name, shellQuote(f.Usage))
+			if f.Value != "" {
+				line += " -r"
+			}
+			fmt.Fprintln(out, line)
+		}
+	}
+
+	fmt.Fprintf(out, "# fish completion for %s - generated by >%s completion fish\n\n", name, name)
+	for _, c := range commands {
+		fmt.Fprintf(out, "complete -c %s -n __fish_use_subcommand -a %s -d %s\n", name, c.Name, shellQuote(c.Summary))
+	}
+	flags("not __fish_seen_subcommand_from "+strings.Join(names, " "), buildCommand)
+	for _, c := range commands {
+		flags("__fish_seen_subcommand_from "+c.Name, c)
+	}
+}
+
+// define the docs subcommand's flags and return its runner: print the named documentation
+func setupDocs(fs *flag.FlagSet) func(positional []string) int {
+	name := fs.String("name", defaultProgramName, "name of the program to document")
+
+	return func(positional []string) int {
+		if len(positional) != 1 || positional[0] != "man" {
+			fmt.Fprintln(os.Stderr, "Error: please enter the documentation to print: man (e.g. >go run ImageProcessor docs man > imgproc.1)")
+			return 2
+		}
+
+		writeManPage(os.Stdout, *name)
+		return 0
+	}
+}
+
+// return text escaped for roff, so dashes, backslashes and a leading dot or quote print as themselves
+func roffEscape(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+
+	return s
+}
+
+// write a command's flags as a roff tagged paragraph each
+func writeManFlags(out io.Writer, c *command) {
+	for _, f := range commandFlags(c) {
+		fmt.Fprintln(out, ".TP")
+		if f.Value != "" {
+			fmt.Fprintf(out, ".BI \\-\\-%s \" %s\"\n", roffEscape(f.Name), roffEscape(f.Value))
+		} else {
+			fmt.Fprintf(out, ".B \\-\\-%s\n", roffEscape(f.Name))
+		}
+		usage := f.Usage
+		if f.Default != "" {
+			usage += " (default " + f.Default + ")"
+		}
+		fmt.Fprintln(out, roffEscape(usage))
+	}
+}
+
+// write the man page: the build's synopsis and flags, each subcommand with its flags, and the exit codes
+func writeManPage(out io.Writer, name string) {
+	upper := strings.ToUpper(name)
+
+	fmt.Fprintf(out, ".TH %s 1 \"\" \"%s\" \"User Commands\"\n", roffEscape(upper), roffEscape(name))
+	fmt.Fprintln(out, ".SH NAME")
+	fmt.Fprintf(out, "%s \\- generate a static photo gallery site from a works feed\n", roffEscape(name))
+
+	fmt.Fprintln(out, ".SH SYNOPSIS")
+	fmt.Fprintf(out, ".B %s\n%s\n", roffEscape(name), roffEscape(buildCommand.Usage))
+	fmt.Fprintln(out, ".br")
+	fmt.Fprintf(out, ".B %s\n.I command\n[flags] [arguments]\n", roffEscape(name))
+
+	fmt.Fprintln(out, ".SH DESCRIPTION")
+	fmt.Fprintf(out, "Without a command, %s will %s.\n", roffEscape(name), roffEscape(buildCommand.Summary))
+	fmt.Fprintln(out, "Flags may come before, between or after the arguments, with one dash or two.")
+
+	fmt.Fprintln(out, ".SH OPTIONS")
+	writeManFlags(out, buildCommand)
+
+	fmt.Fprintln(out, ".SH COMMANDS")
+	for _, c := range commands {
+		fmt.Fprintf(out, ".SS \"%s %s\"\n", roffEscape(c.Name), strings.ReplaceAll(roffEscape(c.Usage), `"`, `""`))
+		fmt.Fprintln(out, roffEscape(c.Summary)+".")
+		writeManFlags(out, c)
+	}
+
+	fmt.Fprintln(out, ".SH EXIT STATUS")
+	fmt.Fprintln(out, "A build exits with")
+	for _, e := range exitCodeMeanings {
+		fmt.Fprintf(out, ".TP\n.B %d\n%s\n", e.Code, roffEscape(e.Meaning))
+	}
+	fmt.Fprintln(out, ".PP")
+	fmt.Fprintln(out, "The commands exit with 0 on success, 1 on failure and 2 on usage errors.")
+
+	fmt.Fprintln(out, ".SH ENVIRONMENT")
+	fmt.Fprintln(out, ".TP")
+	fmt.Fprintln(out, ".B IMGPROC_CACHE_DIR")
+	fmt.Fprintln(out, "persistent cache directory, unless \\-\\-cache\\-dir gives another (default the user cache directory)")
+}
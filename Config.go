@@ -0,0 +1,58 @@
+// optional JSON configuration file (passed with --config) for settings that don't fit on the command line.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// type struct representing the contents of the configuration file
+type Config struct {
+	Notifications []NotifierConfig           `json:"notifications"` // where to send warnings and failures (see Notify.go)
+	Layouts       map[string]json.RawMessage `json:"layouts"`       // gallery layout overrides per page type (see Layout.go)
+	GraphQL       *graphQLConfig             `json:"graphql"`       // GraphQL endpoint read by the "graphql:" works source (see GraphQL.go)
+	Sources       []string                   `json:"sources"`       // works sources read together by the "merge:" source (see Sources.go)
+	SignedURLs    []signedURLConfig          `json:"signed_urls"`   // signers for image URIs on protected hosts (see SignedURLs.go)
+	ImageMirrors  []imageMirrorConfig        `json:"image_mirrors"` // rewrites of image URIs in the generated pages, e.g. to a public CDN (see ImageMirrors.go)
+	CDNPurge      *cdnPurgeConfig            `json:"cdn_purge"`     // CDN to purge changed files from after deploying (see CDNPurge.go)
+	Deploy        *deployConfig              `json:"deploy"`        // settings of the --deploy targets (see Deploy.go)
+	Comments      *commentsConfig            `json:"comments"`      // comment system embedded on the work pages (see Comments.go)
+	Sites         []siteProfile              `json:"sites"`         // sites to generate from the one feed, instead of a single site (see Sites.go)
+	DisplayNames  map[string]string          `json:"display_names"` // names to show for makes and models, by the name the feed uses (see DisplayNames.go)
+	Exclude       exclusionList              `json:"exclude"`       // works that must never be published (see Exclusions.go)
+	Flagged       flaggedContent             `json:"flagged"`       // how works flagged as sensitive are shown (see Flagged.go)
+	License       string                     `json:"license"`       // license of every work the feed gives none, e.g. "CC-BY-4.0" (see License.go)
+	Geocode       *geocodeConfig             `json:"geocode"`       // reverse geocoding of the works' GPS positions (see Places.go)
+	Compare       []comparePair              `json:"compare"`       // pairs of makes or models to compare side by side (see Compare.go)
+	HTMLPolicy    *htmlPolicy                `json:"html_policy"`   // elements and attributes kept of the works' HTML captions (see Sanitize.go)
+}
+
+// read and decode the configuration file at the given path - an empty path returns an empty configuration
+func loadConfig(path string) (*Config, error) {
+	var cfg Config
+	if path == "" {
+		return &cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading config file (%s): %v", path, err)
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("Error parsing config file (%s): %v", path, err)
+	}
+
+	return &cfg, nil
+}
+
+// return the value of the given environment variable if name is set, otherwise the literal value - lets secrets stay out of the config file
+func secretValue(literal, envName string) string {
+	if envName != "" {
+		return os.Getenv(envName)
+	}
+
+	return literal
+}
@@ -0,0 +1,351 @@
+// PDF contact sheets: a printable grid of thumbnails with captions, one section per make or model, for reviewing a catalog on paper.
+// Images come from the local copies in the output directory (downloaded with --download-images or generated thumbnails) - works
+// without one get an empty frame. The PDF is written directly (no dependencies): JPEGs are embedded as they are and other images
+// re-encoded, and captions use the standard Helvetica font, so characters outside Latin-1 print as "?".
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// file name of the contact sheets in the output directory
+const contactSheetsFile = "contact-sheets.pdf"
+
+// page geometry, in points: A4 with a grid of 4 x 5 thumbnails
+const (
+	contactPageWidth  = 595
+	contactPageHeight = 842
+	contactMargin     = 36
+	contactHeading    = 32 // height of the section heading at the top of each page
+	contactColumns    = 4
+	contactRows       = 5
+	contactCaption    = 24 // height below each thumbnail for its two caption lines
+	contactMaxPixels  = 600
+)
+
+// type struct representing one section of the contact sheets: a make or model and its works
+type contactSection struct {
+	Title string
+	Works []*Work
+}
+
+// write the contact sheets for every make ("make") or model ("model") of the catalog, followed by the works without a make
+func writeContactSheets(catalog *Catalog, outputFolderLocation, groupBy string) error {
+	var sections []contactSection
+
+	for _, mk := range catalog.Makes {
+		if mk == nil {
+			continue
+		}
+
+		switch groupBy {
+		case "make":
+			sections = append(sections, contactSection{Title: mk.Name, Works: worksByMake(mk.Works, mk)})
+		case "model":
+			for _, md := range mk.Models {
+				if md != nil {
+					sections = append(sections, contactSection{Title: mk.DisplayName + " " + md.DisplayName, Works: worksByMake(md.Works, mk)})
+				}
+			}
+		default:
+			return fmt.Errorf("Error: unknown contact sheet grouping %q (expected make or model)", groupBy)
+		}
+	}
+
+	var generic []*Work
+	for _, wk := range catalog.WorksSM {
+		if wk != nil {
+			generic = append(generic, wk)
+		}
+	}
+	if len(generic) > 0 {
+		sections = append(sections, contactSection{Title: "Generic photos", Works: generic})
+	}
+
+	pdf := newPDFWriter()
+	missing := 0
+
+	for _, section := range sections {
+		perPage := contactColumns * contactRows
+		pages := (len(section.Works) + perPage - 1) / perPage
+
+		for page := 0; page < pages; page++ {
+			end := (page + 1) * perPage
+			if end > len(section.Works) {
+				end = len(section.Works)
+			}
+
+			var content strings.Builder
+			var images []pdfImage
+			heading := section.Title
+			if pages > 1 {
+				heading = fmt.Sprintf("%s (%d/%d)", section.Title, page+1, pages)
+			}
+			pdfText(&content, 14, contactMargin, contactPageHeight-contactMargin-16, heading)
+
+			cellWidth := float64(contactPageWidth-2*contactMargin) / contactColumns
+			cellHeight := float64(contactPageHeight-2*contactMargin-contactHeading) / contactRows
+
+			for i, wk := range section.Works[page*perPage : end] {
+				x := contactMargin + float64(i%contactColumns)*cellWidth
+				y := contactPageHeight - contactMargin - contactHeading - float64(i/contactColumns+1)*cellHeight
+
+				// the image fits a box above the caption, keeping its aspect ratio
+				boxX, boxY := x+4, y+contactCaption
+				boxW, boxH := cellWidth-8, cellHeight-contactCaption-4
+
+				img, err := pdf.workImage(wk, outputFolderLocation)
+				if err != nil {
+					missing++
+					fmt.Fprintf(&content, "0.6 G %.2f %.2f %.2f %.2f re S\n", boxX, boxY, boxW, boxH)
+				} else {
+					scale := boxW / float64(img.Width)
+					if s := boxH / float64(img.Height); s < scale {
+						scale = s
+					}
+					iw, ih := float64(img.Width)*scale, float64(img.Height)*scale
+					fmt.Fprintf(&content, "q %.2f 0 0 %.2f %.2f %.2f cm /%s Do Q\n", iw, ih, boxX+(boxW-iw)/2, boxY+(boxH-ih)/2, img.Name)
+					if !containsImage(images, img) {
+						images = append(images, img)
+					}
+				}
+
+				pdfText(&content, 7, boxX, y+14, fitCaption(fmt.Sprintf("%s  %s", wk.ID, wk.FileName), boxW, 7))
+				details := ""
+				if wk.WModel != nil && groupBy == "make" {
+					details = wk.WModel.DisplayName
+				}
+				if !wk.Date.IsZero() {
+					details = strings.TrimPrefix(details+"  "+wk.Date.Format("2 Jan 2006"), "  ")
+				}
+				pdfText(&content, 7, boxX, y+5, fitCaption(details, boxW, 7))
+			}
+
+			pdf.addPage(content.String(), images)
+		}
+	}
+
+	if len(sections) == 0 {
+		var content strings.Builder
+		pdfText(&content, 14, contactMargin, contactPageHeight-contactMargin-16, "No works")
+		pdf.addPage(content.String(), nil)
+	}
+
+	target := filepath.Join("./"+outputFolderLocation, contactSheetsFile)
+	if err := os.WriteFile(target, pdf.bytes(), 0644); err != nil {
+		return fmt.Errorf("Error writing contact sheets: %v", err)
+	}
+
+	if missing > 0 {
+		fmt.Printf("Contact sheets: %d works have no local image (build with --download-images to include them).\n", missing)
+	}
+	return nil
+}
+
+// return whether the image is in the list
+func containsImage(images []pdfImage, img pdfImage) bool {
+	for _, i := range images {
+		if i.Object == img.Object {
+			return true
+		}
+	}
+
+	return false
+}
+
+// write one line of Helvetica text at the given position
+func pdfText(b *strings.Builder, size, x, y float64, text string) {
+	fmt.Fprintf(b, "0 g BT /F1 %.0f Tf %.2f %.2f Td (%s) Tj ET\n", size, x, y, pdfString(text))
+}
+
+// shorten a caption to roughly fit the given width (Helvetica averages about half the font size per character)
+func fitCaption(text string, width, size float64) string {
+	max := int(width / (size * 0.5))
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+
+	return string(runes[:max-3]) + "..."
+}
+
+// escape text for a PDF string in WinAnsiEncoding - Latin-1 characters are kept, anything else becomes "?"
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || (r >= 0x7f && r < 0xa0) || r > 0xff:
+			b.WriteByte('?')
+		default:
+			b.WriteByte(byte(r))
+		}
+	}
+
+	return b.String()
+}
+
+//----------------- PDF writer -------------------------------
+
+// type struct representing a PDF document being written: objects 1-3 are the catalog, page tree and font, the rest are images,
+// page contents and pages in the order they're added
+type pdfWriter struct {
+	buf     bytes.Buffer
+	offsets map[int]int // byte offset of each object
+	next    int
+	pages   []int
+	images  map[string]pdfImage // by file path
+}
+
+// type struct representing an image XObject already in the document
+type pdfImage struct {
+	Name          string
+	Object        int
+	Width, Height int
+}
+
+// create and return a pointer to an empty PDF document
+func newPDFWriter() *pdfWriter {
+	p := &pdfWriter{offsets: map[int]int{}, next: 4, images: map[string]pdfImage{}}
+	p.buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	p.object(3, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	return p
+}
+
+// write an object with the given number
+func (p *pdfWriter) object(n int, body string) {
+	p.offsets[n] = p.buf.Len()
+	fmt.Fprintf(&p.buf, "%d 0 obj\n%s\nendobj\n", n, body)
+}
+
+// write a stream object and return its number
+func (p *pdfWriter) stream(dict string, data []byte) int {
+	n := p.next
+	p.next++
+
+	p.offsets[n] = p.buf.Len()
+	fmt.Fprintf(&p.buf, "%d 0 obj\n<< %s /Length %d >>\nstream\n", n, dict, len(data))
+	p.buf.Write(data)
+	p.buf.WriteString("\nendstream\nendobj\n")
+	return n
+}
+
+// add a page with the given content stream, drawing the given images
+func (p *pdfWriter) addPage(content string, images []pdfImage) {
+	contents := p.stream("", []byte(content))
+
+	var xobjects strings.Builder
+	for _, img := range images {
+		fmt.Fprintf(&xobjects, " /%s %d 0 R", img.Name, img.Object)
+	}
+
+	n := p.next
+	p.next++
+	p.object(n, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> /XObject <<%s >> >> /Contents %d 0 R >>",
+		contactPageWidth, contactPageHeight, xobjects.String(), contents))
+	p.pages = append(p.pages, n)
+}
+
+// add a work's local image to the document (once per file) and return it
+func (p *pdfWriter) workImage(wk *Work, outputFolderLocation string) (pdfImage, error) {
+	local := wk.LocalSmall
+	for _, l := range []string{wk.LocalMedium, wk.LocalLarge} {
+		if local == "" {
+			local = l
+		}
+	}
+	if local == "" {
+		return pdfImage{}, fmt.Errorf("no local image")
+	}
+
+	path := filepath.Join("./"+outputFolderLocation, filepath.FromSlash(local))
+	if img, ok := p.images[path]; ok {
+		return img, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return pdfImage{}, err
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return pdfImage{}, err
+	}
+
+	// JPEGs are embedded as they are only if upright, since PDF viewers ignore the EXIF orientation
+	orientation := imageOrientation(data)
+
+	colorSpace := "/DeviceRGB"
+	embed := format == "jpeg" && orientation == 1 && cfg.Width <= contactMaxPixels && cfg.Height <= contactMaxPixels
+	switch {
+	case embed && cfg.ColorModel == color.GrayModel:
+		colorSpace = "/DeviceGray"
+	case embed && cfg.ColorModel != color.YCbCrModel:
+		// CMYK JPEGs are stored inverted by some encoders, so anything unusual is re-encoded
+		embed = false
+	}
+
+	if !embed {
+		src, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return pdfImage{}, err
+		}
+		src = orientImage(src, orientation)
+
+		b := src.Bounds()
+		rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+
+		if w, h := rgba.Bounds().Dx(), rgba.Bounds().Dy(); w > contactMaxPixels || h > contactMaxPixels {
+			if w >= h {
+				rgba = resizeImage(rgba, contactMaxPixels, h*contactMaxPixels/w)
+			} else {
+				rgba = resizeImage(rgba, w*contactMaxPixels/h, contactMaxPixels)
+			}
+		}
+
+		var out bytes.Buffer
+		if err := jpeg.Encode(&out, rgba, &jpeg.Options{Quality: 85}); err != nil {
+			return pdfImage{}, err
+		}
+		data = out.Bytes()
+		cfg.Width, cfg.Height = rgba.Bounds().Dx(), rgba.Bounds().Dy()
+	}
+
+	n := p.stream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode", cfg.Width, cfg.Height, colorSpace), data)
+
+	img := pdfImage{Name: fmt.Sprintf("Im%d", n), Object: n, Width: cfg.Width, Height: cfg.Height}
+	p.images[path] = img
+	return img, nil
+}
+
+// finish the document (page tree, catalog, cross-reference table and trailer) and return its bytes
+func (p *pdfWriter) bytes() []byte {
+	var kids strings.Builder
+	for _, n := range p.pages {
+		fmt.Fprintf(&kids, "%d 0 R ", n)
+	}
+	p.object(2, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.TrimSpace(kids.String()), len(p.pages)))
+	p.object(1, "<< /Type /Catalog /Pages 2 0 R >>")
+
+	xref := p.buf.Len()
+	fmt.Fprintf(&p.buf, "xref\n0 %d\n0000000000 65535 f \n", p.next)
+	for n := 1; n < p.next; n++ {
+		fmt.Fprintf(&p.buf, "%010d 00000 n \n", p.offsets[n])
+	}
+	fmt.Fprintf(&p.buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", p.next, xref)
+
+	return p.buf.Bytes()
+}
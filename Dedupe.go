@@ -0,0 +1,135 @@
+// image deduplication: feeds re-upload the same file for several works, so downloaded images are compared by content (SHA-256) and
+// a rendition identical to another work's rendition of the same size is stored once - the later work (in feed order) is pointed at
+// the earlier one's copy and its own is removed. The build cache remembers the content hash of each downloaded URI, so a later
+// build doesn't download a known duplicate again. The duplicates are listed in the build manifest (see Manifest.go).
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// type struct representing a downloaded image identical to another work's, for the build manifest
+type duplicateImage struct {
+	ID    WorkID `json:"id"`
+	Image string `json:"image"` // small, medium or large
+	Of    string `json:"of"`    // the copy kept, relative to the output directory
+	Bytes int64  `json:"bytes"` // space the shared copy saves
+}
+
+// type struct representing the downloaded images of a build, by content
+type imageDeduper struct {
+	outputFolderLocation string
+	cache                *buildCache
+	kept                 map[string]string // size and content hash -> the copy kept
+	hashes               map[string]string // local path -> content hash
+	sizes                map[string]int64  // local path -> file size
+	found                []duplicateImage
+}
+
+// create and return a pointer to a deduplicator of the images downloaded into the output directory
+func newImageDeduper(outputFolderLocation string, cache *buildCache) *imageDeduper {
+	return &imageDeduper{outputFolderLocation: outputFolderLocation, cache: cache, kept: map[string]string{}, hashes: map[string]string{}, sizes: map[string]int64{}}
+}
+
+// return the content hash of a downloaded image, hashing it the first time it's asked for
+func (d *imageDeduper) hash(local string) (string, error) {
+	if h, ok := d.hashes[local]; ok {
+		return h, nil
+	}
+
+	f, err := os.Open(filepath.Join("./"+d.outputFolderLocation, filepath.FromSlash(local)))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+	n, err := io.Copy(sum, f)
+	if err != nil {
+		return "", err
+	}
+
+	h := hex.EncodeToString(sum.Sum(nil))
+	d.hashes[local], d.sizes[local] = h, n
+	return h, nil
+}
+
+// point every downloaded rendition identical to one kept earlier at that copy, removing its own - renditions are compared with
+// those of the same size only, so a watermark on the medium and large images never ends up on a thumbnail
+func (d *imageDeduper) dedupe(catalog *Catalog) {
+	for _, wk := range catalog.Works {
+		if wk == nil {
+			continue
+		}
+
+		for _, r := range workRenditions(wk) {
+			if !strings.HasPrefix(*r.local, imagesDir+"/") {
+				continue
+			}
+
+			h, err := d.hash(*r.local)
+			if err != nil {
+				continue
+			}
+			if d.cache.ImageHashes[r.uri] != h {
+				d.cache.setImageHash(r.uri, h)
+			}
+
+			key := r.size + ":" + h
+			kept, ok := d.kept[key]
+			if !ok {
+				d.kept[key] = *r.local
+				continue
+			}
+			if kept == *r.local {
+				continue
+			}
+
+			if err := os.Remove(filepath.Join("./"+d.outputFolderLocation, filepath.FromSlash(*r.local))); err != nil {
+				fmt.Fprintf(os.Stderr, "Error removing duplicate %s image of work %s: %v\n", r.size, wk.ID, err)
+				continue
+			}
+			d.found = append(d.found, duplicateImage{ID: wk.ID, Image: r.size, Of: kept, Bytes: d.sizes[*r.local]})
+			*r.local = kept
+		}
+	}
+}
+
+// return the copy kept of a rendition the build cache knows to be identical to a downloaded one ("" if there's none) - so a
+// known duplicate isn't downloaded again
+func (d *imageDeduper) knownCopy(size, uri string) string {
+	h, ok := d.cache.ImageHashes[uri]
+	if !ok {
+		return ""
+	}
+
+	return d.kept[size+":"+h]
+}
+
+// record a rendition pointed at a known copy instead of being downloaded
+func (d *imageDeduper) reuse(wk *Work, size, kept string) {
+	d.found = append(d.found, duplicateImage{ID: wk.ID, Image: size, Of: kept, Bytes: d.sizes[kept]})
+}
+
+// type struct representing one of a work's image renditions
+type workRendition struct {
+	size  string
+	uri   string
+	local *string
+}
+
+// return a work's small, medium and large renditions
+func workRenditions(wk *Work) []workRendition {
+	return []workRendition{
+		{"small", wk.URISmall, &wk.LocalSmall},
+		{"medium", wk.URIMedium, &wk.LocalMedium},
+		{"large", wk.URILarge, &wk.LocalLarge},
+	}
+}
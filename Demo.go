@@ -0,0 +1,184 @@
+// demo subcommand: generates a complete example site from embedded sample data, so evaluators can see the output with one command and
+// what each file in it is for. Nothing is fetched, so theme authors can use it as a stable fixture - --theme renders it with their
+// theme, and --feed writes the sample feed out for builds, serve and test --fixtures:
+//
+//	>go run ImageProcessor demo --theme mytheme --no-open demo-site
+//	>go run ImageProcessor demo --feed sample.xml demo-site
+
+package main
+
+import (
+	"bytes"
+	"embed"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+)
+
+// sample works feed plus the placeholder images it references (relative URIs under images/)
+//
+//go:embed demo
+var demoFiles embed.FS
+
+func init() {
+	registerCommand(&command{
+		Name:    "demo",
+		Usage:   "[--no-open] [--theme dir] [--feed file] <output-dir>",
+		Summary: "generate an example site from built-in sample data and open it in the browser",
+		Setup:   setupDemo,
+	})
+}
+
+// define the demo subcommand's flags and return its runner: generate the demo site into the given output directory and open its
+// index page
+func setupDemo(flags *flag.FlagSet) func(positional []string) int {
+	noOpen := flags.Bool("no-open", false, "don't open the generated site in a browser")
+	theme := flags.String("theme", "", "theme directory (or CSS file) to render the demo site with, as the build's --theme")
+	feedPath := flags.String("feed", "", "also write the embedded sample works feed to this file")
+
+	return func(positional []string) int {
+		if len(positional) < 1 {
+			fmt.Fprintln(os.Stderr, "Error: please enter an output directory location for the demo site (e.g. >go run ImageProcessor demo demo-site)")
+			return 2
+		}
+
+		outputFolderLocation := positional[0]
+
+		feed, err := demoFiles.ReadFile("demo/works.xml")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading embedded demo data: %v\n", err)
+			return 1
+		}
+
+		// the images go in first so the build can read their dimensions
+		if err := extractDemoImages("./" + outputFolderLocation); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing demo images: %v\n", err)
+			return 1
+		}
+
+		if *feedPath != "" {
+			if err := os.WriteFile(*feedPath, feed, 0644); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing the sample feed: %v\n", err)
+				return 1
+			}
+			fmt.Printf("Sample feed written to %s.\n", *feedPath)
+		}
+
+		opts := newBuildOptions()
+		opts.Theme = *theme
+		if err := buildSite(bytes.NewReader(feed), outputFolderLocation, opts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		if m, err := readBuildManifest(outputFolderLocation); err == nil {
+			printOutputStructure(os.Stdout, m)
+		}
+
+		index, err := filepath.Abs(filepath.Join(outputFolderLocation, "index.html"))
+		if err != nil {
+			index = filepath.Join(outputFolderLocation, "index.html")
+		}
+
+		fmt.Printf("Demo site written to ./%s - open %s to browse it.\n", outputFolderLocation, index)
+
+		if !*noOpen {
+			if err := openBrowser("file://" + filepath.ToSlash(index)); err != nil {
+				fmt.Fprintf(os.Stderr, "Couldn't open a browser automatically (%v) - open the page above manually.\n", err)
+			}
+		}
+
+		return 0
+	}
+}
+
+// print what the files of a build are, from its manifest - the gallery, slideshow and work pages summed up, the rest one by one
+func printOutputStructure(out io.Writer, m *buildManifest) {
+	galleries := map[string]bool{}
+	for _, mm := range m.Makes {
+		galleries[mm.Page] = true
+		for _, md := range mm.Models {
+			galleries[md.Page] = true
+		}
+	}
+	workPages := map[string]bool{}
+	for _, ew := range m.Works {
+		workPages["work-"+ew.ID.String()+".html"] = true
+	}
+
+	var others []string
+	var galleryPages, slideshows, works, images int
+	for _, f := range m.Files {
+		switch {
+		case galleries[f]:
+			galleryPages++
+		case strings.HasSuffix(f, ".slideshow.html"):
+			slideshows++
+		case workPages[f]:
+			works++
+		case strings.HasPrefix(f, imagesDir+"/"):
+			images++
+		default:
+			others = append(others, f)
+		}
+	}
+
+	fmt.Fprintln(out, "Output structure:")
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, f := range others {
+		fmt.Fprintf(tw, "  %s\n", f)
+	}
+	fmt.Fprintf(tw, "  <make>.html, <model>.html\t%d make and model gallery pages\n", galleryPages)
+	fmt.Fprintf(tw, "  <page>.slideshow.html\t%d slideshows of the gallery pages\n", slideshows)
+	fmt.Fprintf(tw, "  work-<id>.html\t%d work pages\n", works)
+	if images > 0 {
+		fmt.Fprintf(tw, "  %s/\t%d downloaded and generated images\n", imagesDir, images)
+	}
+	tw.Flush()
+}
+
+// copy the embedded placeholder images into the output directory, keeping their images/ sub-directory
+func extractDemoImages(outputDir string) error {
+	return fs.WalkDir(demoFiles, "demo/images", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		data, err := demoFiles.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel("demo", filepath.FromSlash(path))
+		target := filepath.Join(outputDir, rel)
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		return os.WriteFile(target, data, 0644)
+	})
+}
+
+// open the given URL in the user's default browser
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+
+	return cmd.Start()
+}
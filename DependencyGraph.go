@@ -0,0 +1,167 @@
+// dependency graph: which catalog entities (works, makes, models, authors and lenses) each generated output (page or Atom feed) shows. Incremental
+// builds keep the graph with a fingerprint of every entity's own data, so when some works change they know exactly which outputs to
+// regenerate - the works' pages, the galleries showing them (make, model and index pages) and the feeds listing them - and report
+// what caused each one to be regenerated.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// type representing the entities each output depends on: output path -> entity keys
+type dependencyGraph map[string][]string
+
+// return the outputs depending on any of the given entities
+func (g dependencyGraph) dependents(keys map[string]bool) map[string]bool {
+	outputs := map[string]bool{}
+	for output, deps := range g {
+		for _, key := range deps {
+			if keys[key] {
+				outputs[output] = true
+				break
+			}
+		}
+	}
+
+	return outputs
+}
+
+// return the key of a work, make, model, author or lens in the graph
+func workKey(wk *Work) string {
+	return "work " + wk.ID.String()
+}
+
+func makeKey(mk *Make) string {
+	return "make " + mk.Name
+}
+
+func modelKey(md *Model) string {
+	if md.MMake != nil {
+		return "model " + md.MMake.Name + "/" + md.Name
+	}
+
+	return "model " + md.Name
+}
+
+func authorKey(a *Author) string {
+	return "author " + a.Name
+}
+
+func lensKey(l *Lens) string {
+	return "lens " + l.Name
+}
+
+// return the key of the entity the value points to, "" if it isn't a work, make, model, author or lens
+func entityKey(v reflect.Value) string {
+	if v.Kind() != reflect.Ptr || v.IsNil() || !v.CanInterface() {
+		return ""
+	}
+
+	switch e := v.Interface().(type) {
+	case *Work:
+		return workKey(e)
+	case *Make:
+		return makeKey(e)
+	case *Model:
+		return modelKey(e)
+	case *Author:
+		return authorKey(e)
+	case *Lens:
+		return lensKey(e)
+	}
+
+	return ""
+}
+
+// return the keys of the works (and the make or model they're listed under, if any) shown by an output
+func worksDeps(works []*Work, owners ...string) []string {
+	deps := append([]string(nil), owners...)
+	for _, wk := range works {
+		if wk != nil {
+			deps = append(deps, workKey(wk))
+		}
+	}
+
+	return deps
+}
+
+// return a fingerprint of the own data of every work, make, model, author and lens in the catalog (their relations to each other are left to
+// the outputs' dependencies)
+func entityFingerprints(catalog *Catalog) map[string]string {
+	fps := map[string]string{}
+	add := func(key string, entity interface{}) {
+		h := sha256.New()
+		v := reflect.ValueOf(entity).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if isRelation(v.Field(i)) {
+				continue
+			}
+			fmt.Fprintf(h, "%s=", v.Type().Field(i).Name)
+			writeFingerprint(h, v.Field(i), nil)
+		}
+		// makes and models show how many works they have
+		if counted, ok := entity.(interface{ WorkCount() int }); ok {
+			fmt.Fprintf(h, "WorkCount=%d;", counted.WorkCount())
+		}
+		fps[key] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	for _, wk := range catalog.Works {
+		if wk != nil {
+			add(workKey(wk), wk)
+		}
+	}
+	for _, mk := range catalog.Makes {
+		if mk == nil {
+			continue
+		}
+		add(makeKey(mk), mk)
+		for _, md := range mk.Models {
+			if md != nil {
+				add(modelKey(md), md)
+			}
+		}
+	}
+	for _, a := range catalog.Authors {
+		if a != nil {
+			add(authorKey(a), a)
+		}
+	}
+	for _, lens := range catalog.Lenses {
+		if lens != nil {
+			add(lensKey(lens), lens)
+		}
+	}
+
+	return fps
+}
+
+// return whether a field links to other entities (a work's make, a make's works ...)
+func isRelation(v reflect.Value) bool {
+	t := v.Type()
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+
+	return t == reflect.TypeOf(&Work{}) || t == reflect.TypeOf(&Make{}) || t == reflect.TypeOf(&Model{}) || t == reflect.TypeOf(&Author{}) || t == reflect.TypeOf(&Lens{})
+}
+
+// return the sorted, de-duplicated keys
+func sortedKeys(keys []string) []string {
+	seen := map[string]bool{}
+	var list []string
+	for _, k := range keys {
+		if !seen[k] {
+			seen[k] = true
+			list = append(list, k)
+		}
+	}
+	sort.Strings(list)
+
+	return list
+}
@@ -0,0 +1,490 @@
+// deploy adapters: --deploy publishes the generated site once it's written, so fetching, building and publishing is one command:
+//
+//	>go run ImageProcessor --deploy netlify --config site.json http://localhost/test/api/v1/works.xml code/html/output
+//
+//   - netlify: uploads the site through Netlify's deploy API, sending only the files Netlify doesn't already have
+//   - gh-pages: commits the site to a gh-pages branch (of the current directory's origin remote unless a repo is configured) and
+//     pushes it - with git's own credentials - for GitHub Pages
+//   - cloudflare-pages: deploys the site to a Cloudflare Pages project with the wrangler CLI
+//
+// Settings go in the config file's "deploy" section:
+//
+//	"deploy": {
+//	  "netlify": {"site_id": "3970e0fe-8564-4c1a-9b5e-1e2b4ce3e2a0", "token_env": "NETLIFY_AUTH_TOKEN"},
+//	  "gh_pages": {"repo": "git@github.com:jane/photos.git", "branch": "gh-pages", "cname": "photos.example.com"},
+//	  "cloudflare_pages": {"project": "photos", "account_id": "023e105f4ecef8ad9ca31a8372d0c353", "token_env": "CLOUDFLARE_API_TOKEN"}
+//	}
+//
+// The netlify and cloudflare-pages targets also set each file's Cache-Control header, from the section's "cache_control" rules (see
+// CacheControl.go).
+//
+// The deploy runs as the last after-write hook, so a partial site is never published, and a failed deploy exits with the deploy
+// error code. The build's own state files (names starting with a dot) stay behind.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// deploy targets --deploy accepts
+var deployTargets = []string{"netlify", "gh-pages", "cloudflare-pages"}
+
+// type struct representing the config file's "deploy" section
+type deployConfig struct {
+	Netlify         netlifyDeployConfig   `json:"netlify"`
+	GHPages         ghPagesDeployConfig   `json:"gh_pages"`
+	CloudflarePages cloudflarePagesConfig `json:"cloudflare_pages"`
+	CacheControl    []cacheControlRule    `json:"cache_control"` // Cache-Control rules, before the defaults (see CacheControl.go)
+}
+
+// type struct representing the settings of the netlify deploy target
+type netlifyDeployConfig struct {
+	SiteID   string `json:"site_id"`   // the site's API id (or its name.netlify.app domain)
+	Token    string `json:"token"`     // personal access token
+	TokenEnv string `json:"token_env"` // environment variable holding the token instead (default NETLIFY_AUTH_TOKEN)
+	APIURL   string `json:"api_url"`   // API to use instead of https://api.netlify.com/api/v1
+
+	cacheControl []cacheControlRule
+}
+
+// type struct representing the settings of the gh-pages deploy target
+type ghPagesDeployConfig struct {
+	Repo    string `json:"repo"`    // repository to push to (default the current directory's origin remote)
+	Branch  string `json:"branch"`  // branch to commit the site to (default gh-pages)
+	CNAME   string `json:"cname"`   // custom domain, written to the CNAME file GitHub Pages reads
+	Message string `json:"message"` // commit message (default "Deploy site built <time>")
+}
+
+// type struct representing the settings of the cloudflare-pages deploy target
+type cloudflarePagesConfig struct {
+	Project   string `json:"project"`    // Pages project name
+	Branch    string `json:"branch"`     // branch the deployment is for (default the project's production branch)
+	AccountID string `json:"account_id"` // Cloudflare account id (default $CLOUDFLARE_ACCOUNT_ID)
+	Token     string `json:"token"`
+	TokenEnv  string `json:"token_env"` // environment variable holding the API token instead (default CLOUDFLARE_API_TOKEN)
+	Command   string `json:"command"`   // how to run wrangler (default "wrangler", e.g. "npx wrangler")
+
+	cacheControl []cacheControlRule
+}
+
+// check the settings of the deploy target and register the deploy as an after-write hook
+func registerDeploy(target string, cfg *deployConfig) error {
+	deploy, err := deployFunc(target, cfg)
+	if err != nil {
+		return err
+	}
+
+	onAfterWrite(func(outputFolderLocation string, files []string) error {
+		if err := deploy(outputFolderLocation); err != nil {
+			return fmt.Errorf("deploying to %s: %v", target, err)
+		}
+		return nil
+	})
+
+	return nil
+}
+
+// check the settings of the deploy target - its credentials among them - and return its deploy function
+func deployFunc(target string, cfg *deployConfig) (func(outputFolderLocation string) error, error) {
+	if cfg == nil {
+		cfg = &deployConfig{}
+	}
+	if err := checkCacheControl(cfg.CacheControl); err != nil {
+		return nil, err
+	}
+
+	var deploy func(outputFolderLocation string) error
+	switch target {
+	case "netlify":
+		c := cfg.Netlify
+		if c.SiteID == "" || c.token() == "" {
+			return nil, fmt.Errorf("Error in deploy config: netlify needs a site_id and an access token")
+		}
+		c.cacheControl = cfg.CacheControl
+		deploy = c.deploy
+
+	case "gh-pages":
+		c := cfg.GHPages
+		if c.Branch == "" {
+			c.Branch = "gh-pages"
+		}
+		if len(cfg.CacheControl) > 0 {
+			fmt.Fprintln(os.Stderr, "GitHub Pages can't set headers - the deploy section's cache_control rules are ignored.")
+		}
+		deploy = c.deploy
+
+	case "cloudflare-pages":
+		c := cfg.CloudflarePages
+		if c.Project == "" || c.token() == "" {
+			return nil, fmt.Errorf("Error in deploy config: cloudflare-pages needs a project and an API token")
+		}
+		c.cacheControl = cfg.CacheControl
+		deploy = c.deploy
+
+	default:
+		return nil, fmt.Errorf("Error: unknown deploy target %q (expected %s)", target, strings.Join(deployTargets, ", "))
+	}
+
+	return deploy, nil
+}
+
+// return the paths (relative to the output directory, with forward slashes) of every file to publish - everything but the build's
+// own state files and directories, whose names start with a dot
+func siteFiles(outputFolderLocation string) ([]string, error) {
+	root := "./" + outputFolderLocation
+	var files []string
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") && d.Name() != ".well-known" {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+
+	return files, err
+}
+
+// copy the files to publish into dir
+func stageSiteFiles(outputFolderLocation, dir string) (int, error) {
+	files, err := siteFiles(outputFolderLocation)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, file := range files {
+		if err := copyFileAtomic(filepath.Join("./"+outputFolderLocation, file), filepath.Join(dir, filepath.FromSlash(file))); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(files), nil
+}
+
+// return the files without the given one
+func withoutFile(files []string, name string) []string {
+	var kept []string
+	for _, file := range files {
+		if file != name {
+			kept = append(kept, file)
+		}
+	}
+
+	return kept
+}
+
+//----------------- Netlify -------------------------------
+
+// return the access token, from the config or the environment variable holding it
+func (c netlifyDeployConfig) token() string {
+	if c.Token == "" && c.TokenEnv == "" {
+		c.TokenEnv = "NETLIFY_AUTH_TOKEN"
+	}
+
+	return secretValue(c.Token, c.TokenEnv)
+}
+
+// return the URL of the Netlify API, without a trailing slash
+func (c netlifyDeployConfig) api() string {
+	if c.APIURL != "" {
+		return strings.TrimSuffix(c.APIURL, "/")
+	}
+
+	return "https://api.netlify.com/api/v1"
+}
+
+// create a deploy listing every file's SHA-1, then upload the files Netlify asks for
+func (c netlifyDeployConfig) deploy(outputFolderLocation string) error {
+	api := c.api()
+	token := c.token()
+
+	files, err := siteFiles(outputFolderLocation)
+	if err != nil {
+		return err
+	}
+
+	// the _headers file is deployed with the Cache-Control rules added to the site's own
+	headers, _, err := deployHeadersFile(outputFolderLocation, files, c.cacheControl)
+	if err != nil {
+		return err
+	}
+	files = append(withoutFile(files, headersFile), headersFile)
+	read := func(file string) ([]byte, error) {
+		if file == headersFile {
+			return headers, nil
+		}
+		return os.ReadFile(filepath.Join("./"+outputFolderLocation, file))
+	}
+
+	digests := map[string]string{} // "/path" -> SHA-1
+	bySum := map[string][]string{} // SHA-1 -> paths with that content
+	for _, file := range files {
+		data, err := read(file)
+		if err != nil {
+			return err
+		}
+		sum := sha1.Sum(data)
+		digest := hex.EncodeToString(sum[:])
+		digests["/"+file] = digest
+		bySum[digest] = append(bySum[digest], file)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"files": digests})
+	if err != nil {
+		return err
+	}
+
+	var created struct {
+		ID       string   `json:"id"`
+		Required []string `json:"required"`
+		URL      string   `json:"ssl_url"`
+	}
+	if err := netlifyRequest(http.MethodPost, api+"/sites/"+url.PathEscape(c.SiteID)+"/deploys", token, "application/json", body, &created); err != nil {
+		return err
+	}
+
+	// one upload per required content - Netlify fills in the other paths with the same content
+	sort.Strings(created.Required)
+	for _, digest := range created.Required {
+		paths := bySum[digest]
+		if len(paths) == 0 {
+			return fmt.Errorf("Netlify asked for a file the site doesn't have (SHA-1 %s)", digest)
+		}
+
+		data, err := read(paths[0])
+		if err != nil {
+			return err
+		}
+		if err := netlifyRequest(http.MethodPut, api+"/deploys/"+url.PathEscape(created.ID)+"/files/"+escapePath(paths[0]), token, "application/octet-stream", data, nil); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Deployed %d files to Netlify (%d uploaded).\n", len(files), len(created.Required))
+	if created.URL != "" {
+		fmt.Printf("The site is live at %s.\n", created.URL)
+	}
+	return nil
+}
+
+// send a Netlify API request, decoding the JSON response into result (if not nil)
+func netlifyRequest(method, uri, token, contentType string, body []byte, result interface{}) error {
+	req, err := http.NewRequest(method, uri, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: HTTP %s %s", method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// return the relative path with each segment percent-encoded
+func escapePath(file string) string {
+	segments := strings.Split(file, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+//----------------- GitHub Pages -------------------------------
+
+// commit the site to the branch in a temporary clone and push it
+func (c ghPagesDeployConfig) deploy(outputFolderLocation string) error {
+	repo := c.Repo
+	if repo == "" {
+		out, err := exec.Command("git", "remote", "get-url", "origin").Output()
+		if err != nil {
+			return fmt.Errorf("no repo configured and no origin remote in the current directory: %v", err)
+		}
+		repo = strings.TrimSpace(string(out))
+	}
+
+	dir, err := os.MkdirTemp("", "imgproc-gh-pages-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// start from the branch's last commit, or a new orphan branch the first time
+	if err := runGit("", "clone", "--quiet", "--depth", "1", "--branch", c.Branch, repo, dir); err != nil {
+		os.RemoveAll(dir)
+		if err := runGit("", "init", "--quiet", dir); err != nil {
+			return err
+		}
+		if err := runGit(dir, "checkout", "--quiet", "--orphan", c.Branch); err != nil {
+			return err
+		}
+		if err := runGit(dir, "remote", "add", "origin", repo); err != nil {
+			return err
+		}
+	} else if err := runGit(dir, "rm", "-r", "--quiet", "--ignore-unmatch", "."); err != nil {
+		return err
+	}
+
+	n, err := stageSiteFiles(outputFolderLocation, dir)
+	if err != nil {
+		return err
+	}
+
+	// keep GitHub Pages from running the site through Jekyll (which drops files starting with an underscore)
+	extra := map[string]string{".nojekyll": ""}
+	if c.CNAME != "" {
+		extra["CNAME"] = c.CNAME + "\n"
+	}
+	for name, content := range extra {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	if err := runGit(dir, "add", "--all"); err != nil {
+		return err
+	}
+	if runGit(dir, "diff", "--cached", "--quiet") == nil {
+		fmt.Printf("The %s branch already holds this site - nothing to deploy.\n", c.Branch)
+		return nil
+	}
+
+	message := c.Message
+	if message == "" {
+		message = "Deploy site built " + time.Now().Format("2006-01-02 15:04:05")
+	}
+	if err := runGit(dir, "commit", "--quiet", "-m", message); err != nil {
+		return err
+	}
+	if err := runGit(dir, "push", "--quiet", "origin", c.Branch); err != nil {
+		return err
+	}
+
+	fmt.Printf("Deployed %d files to the %s branch of %s.\n", n, c.Branch, repo)
+	return nil
+}
+
+// run a git command in dir ("" for the current directory), passing its errors on with what git said
+func runGit(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git %s: %v %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+//----------------- Cloudflare Pages -------------------------------
+
+// return the API token, from the config or the environment variable holding it
+func (c cloudflarePagesConfig) token() string {
+	if c.Token == "" && c.TokenEnv == "" {
+		c.TokenEnv = "CLOUDFLARE_API_TOKEN"
+	}
+
+	return secretValue(c.Token, c.TokenEnv)
+}
+
+// return the command line running wrangler
+func (c cloudflarePagesConfig) command() []string {
+	if command := strings.Fields(c.Command); len(command) > 0 {
+		return command
+	}
+
+	return []string{"wrangler"}
+}
+
+// deploy a copy of the site to the Pages project with wrangler
+func (c cloudflarePagesConfig) deploy(outputFolderLocation string) error {
+	dir, err := os.MkdirTemp("", "imgproc-cloudflare-pages-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	n, err := stageSiteFiles(outputFolderLocation, dir)
+	if err != nil {
+		return err
+	}
+
+	// stage the _headers file with the Cache-Control rules added to the site's own
+	files, err := siteFiles(outputFolderLocation)
+	if err != nil {
+		return err
+	}
+	headers, rules, err := deployHeadersFile(outputFolderLocation, files, c.cacheControl)
+	if err != nil {
+		return err
+	}
+	if rules > cloudflareMaxHeaderRules {
+		fmt.Fprintf(os.Stderr, "The _headers file has %d rules - Cloudflare Pages reads only the first %d, so some files get no Cache-Control header (give whole directories the same value to need fewer rules).\n", rules, cloudflareMaxHeaderRules)
+	}
+	if err := os.WriteFile(filepath.Join(dir, headersFile), headers, 0644); err != nil {
+		return err
+	}
+
+	command := c.command()
+	args := append(command[1:], "pages", "deploy", dir, "--project-name", c.Project, "--commit-dirty=true")
+	if c.Branch != "" {
+		args = append(args, "--branch", c.Branch)
+	}
+
+	cmd := exec.Command(command[0], args...)
+	cmd.Env = append(os.Environ(), "CLOUDFLARE_API_TOKEN="+c.token())
+	if c.AccountID != "" {
+		cmd.Env = append(cmd.Env, "CLOUDFLARE_ACCOUNT_ID="+c.AccountID)
+	}
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %v", strings.Join(command, " "), err)
+	}
+
+	fmt.Printf("Deployed %d files to the Cloudflare Pages project %s.\n", n, c.Project)
+	return nil
+}
@@ -0,0 +1,277 @@
+// diff subcommand: reports the works, makes and models added, removed or changed between two feeds or two builds,
+// so publishers can review what a rebuild will change before deploying it - with builds, also the pages those works appear on
+// (see Provenance.go).
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerCommand(&command{
+		Name:    "diff",
+		Usage:   "<old> <new>",
+		Summary: "compare two works feeds (URLs or XML files) or builds (output directories or build manifests)",
+		Setup:   setupDiff,
+	})
+}
+
+// type struct representing the difference between two catalogs
+type catalogDiff struct {
+	AddedWorks   []exportWork
+	RemovedWorks []exportWork
+	ChangedWorks []workChange
+
+	AddedMakes, RemovedMakes   []string
+	AddedModels, RemovedModels []string // as "make / model"
+}
+
+// type struct representing a work present in both catalogs with different details
+type workChange struct {
+	ID      WorkID
+	Changes []string // e.g. `make: "Canon" -> "NIKON CORPORATION"`
+}
+
+// define the diff subcommand's flags and return its runner: load both sides, print their differences and return 0 if they're the
+// same, 1 if they differ (like diff(1)) and 2 on errors
+func setupDiff(fs *flag.FlagSet) func(positional []string) int {
+	return func(positional []string) int {
+		if len(positional) < 2 {
+			fmt.Fprintln(os.Stderr, "Error: please enter the old and new feeds or builds to compare (e.g. >go run ImageProcessor diff old.xml http://localhost/test/api/v1/works.xml)")
+			return 2
+		}
+
+		oldWorks, oldPages, err := loadDiffSide(positional[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+
+		newWorks, newPages, err := loadDiffSide(positional[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+
+		d := diffWorks(oldWorks, newWorks)
+		if d.empty() {
+			fmt.Println("No differences.")
+			return 0
+		}
+
+		d.print()
+		d.printPages(oldPages, newPages)
+		return 1
+	}
+}
+
+// read the works of one side of a diff: a build manifest (an output directory or a .json file) or a works feed (anything else) -
+// and for a build, the pages each work appears on (nil for a feed)
+func loadDiffSide(location string) ([]exportWork, pageProvenance, error) {
+	if info, err := os.Stat(location); (err == nil && info.IsDir()) || strings.EqualFold(filepath.Ext(location), ".json") {
+		m, err := readBuildManifest(location)
+		if err != nil {
+			return nil, nil, err
+		}
+		return m.Works, m.Pages, nil
+	}
+
+	feed, err := openFeed(location)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error fetching XML works data from %s: %v", location, err)
+	}
+	defer feed.Close()
+
+	catalog, err := parseWorks(feed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return exportWorks(catalog), nil, nil
+}
+
+// compare two sets of works, matching works by ID - makes and models are compared by name
+func diffWorks(oldWorks, newWorks []exportWork) *catalogDiff {
+	d := &catalogDiff{}
+
+	oldByID := map[WorkID]exportWork{}
+	for _, w := range oldWorks {
+		oldByID[w.ID] = w
+	}
+
+	newByID := map[WorkID]exportWork{}
+	for _, w := range newWorks {
+		newByID[w.ID] = w
+
+		old, ok := oldByID[w.ID]
+		if !ok {
+			d.AddedWorks = append(d.AddedWorks, w)
+		} else if changes := workChanges(old, w); len(changes) > 0 {
+			d.ChangedWorks = append(d.ChangedWorks, workChange{ID: w.ID, Changes: changes})
+		}
+	}
+
+	for _, w := range oldWorks {
+		if _, ok := newByID[w.ID]; !ok {
+			d.RemovedWorks = append(d.RemovedWorks, w)
+		}
+	}
+
+	sort.Slice(d.AddedWorks, func(i, j int) bool { return d.AddedWorks[i].ID.Less(d.AddedWorks[j].ID) })
+	sort.Slice(d.RemovedWorks, func(i, j int) bool { return d.RemovedWorks[i].ID.Less(d.RemovedWorks[j].ID) })
+	sort.Slice(d.ChangedWorks, func(i, j int) bool { return d.ChangedWorks[i].ID.Less(d.ChangedWorks[j].ID) })
+
+	oldMakes, oldModels := makesAndModels(oldWorks)
+	newMakes, newModels := makesAndModels(newWorks)
+	d.AddedMakes, d.RemovedMakes = setDifference(newMakes, oldMakes), setDifference(oldMakes, newMakes)
+	d.AddedModels, d.RemovedModels = setDifference(newModels, oldModels), setDifference(oldModels, newModels)
+
+	return d
+}
+
+// describe each field that differs between two versions of a work
+func workChanges(old, new exportWork) []string {
+	var changes []string
+
+	fields := []struct {
+		name     string
+		old, new string
+	}{
+		{"filename", old.FileName, new.FileName},
+		{"make", old.Make, new.Make},
+		{"model", old.Model, new.Model},
+		{"lens", old.Lens, new.Lens},
+		{"focal length", formatSetting(old.FocalLength), formatSetting(new.FocalLength)},
+		{"aperture", formatSetting(old.Aperture), formatSetting(new.Aperture)},
+		{"iso", formatSetting(float64(old.ISO)), formatSetting(float64(new.ISO))},
+		{"author", old.Author, new.Author},
+		{"date", old.Date, new.Date},
+		{"small", old.URISmall, new.URISmall},
+		{"medium", old.URIMedium, new.URIMedium},
+		{"large", old.URILarge, new.URILarge},
+		{"dominant color", old.DominantColor, new.DominantColor},
+		{"copyright", old.Copyright, new.Copyright},
+		{"license", old.License, new.License},
+		{"position", old.GPS.String(), new.GPS.String()},
+		{"country", old.Country, new.Country},
+		{"city", old.City, new.City},
+		{"caption", old.Caption, new.Caption},
+		{"featured", strconv.FormatBool(old.Featured), strconv.FormatBool(new.Featured)},
+		{"flagged", strconv.FormatBool(old.Flagged), strconv.FormatBool(new.Flagged)},
+		{"rating", old.Rating, new.Rating},
+	}
+
+	for _, f := range fields {
+		if f.old != f.new {
+			changes = append(changes, fmt.Sprintf("%s: %q -> %q", f.name, f.old, f.new))
+		}
+	}
+
+	return changes
+}
+
+// return the sets of make names and "make / model" names used by the given works (works without a make are left out)
+func makesAndModels(works []exportWork) (makes, models map[string]bool) {
+	makes, models = map[string]bool{}, map[string]bool{}
+
+	for _, w := range works {
+		if w.Make == "" {
+			continue
+		}
+
+		makes[w.Make] = true
+		if w.Model != "" {
+			models[w.Make+" / "+w.Model] = true
+		}
+	}
+
+	return makes, models
+}
+
+// return the sorted members of a that aren't in b
+func setDifference(a, b map[string]bool) []string {
+	var diff []string
+	for k := range a {
+		if !b[k] {
+			diff = append(diff, k)
+		}
+	}
+
+	sort.Strings(diff)
+	return diff
+}
+
+func (d *catalogDiff) empty() bool {
+	return len(d.AddedWorks)+len(d.RemovedWorks)+len(d.ChangedWorks)+len(d.AddedMakes)+len(d.RemovedMakes)+len(d.AddedModels)+len(d.RemovedModels) == 0
+}
+
+// print the differences, one line per added/removed/changed item
+func (d *catalogDiff) print() {
+	fmt.Printf("Works: %d added, %d removed, %d changed\n", len(d.AddedWorks), len(d.RemovedWorks), len(d.ChangedWorks))
+	for _, w := range d.AddedWorks {
+		fmt.Printf("  + %s %s%s\n", w.ID, w.FileName, describeCamera(w))
+	}
+	for _, w := range d.RemovedWorks {
+		fmt.Printf("  - %s %s%s\n", w.ID, w.FileName, describeCamera(w))
+	}
+	for _, c := range d.ChangedWorks {
+		fmt.Printf("  ~ %s: %s\n", c.ID, strings.Join(c.Changes, "; "))
+	}
+
+	fmt.Printf("Makes: %d added, %d removed\n", len(d.AddedMakes), len(d.RemovedMakes))
+	for _, name := range d.AddedMakes {
+		fmt.Printf("  + %s\n", name)
+	}
+	for _, name := range d.RemovedMakes {
+		fmt.Printf("  - %s\n", name)
+	}
+
+	fmt.Printf("Models: %d added, %d removed\n", len(d.AddedModels), len(d.RemovedModels))
+	for _, name := range d.AddedModels {
+		fmt.Printf("  + %s\n", name)
+	}
+	for _, name := range d.RemovedModels {
+		fmt.Printf("  - %s\n", name)
+	}
+}
+
+// print the pages the added, removed and changed works appear on - in either build, as a removed work's pages change too
+func (d *catalogDiff) printPages(oldPages, newPages pageProvenance) {
+	if oldPages == nil && newPages == nil {
+		return
+	}
+
+	ids := map[WorkID]bool{}
+	for _, w := range append(append([]exportWork(nil), d.AddedWorks...), d.RemovedWorks...) {
+		ids[w.ID] = true
+	}
+	for _, c := range d.ChangedWorks {
+		ids[c.ID] = true
+	}
+
+	pages := map[string]bool{}
+	for _, page := range append(oldPages.showing(ids), newPages.showing(ids)...) {
+		pages[page] = true
+	}
+
+	fmt.Printf("Pages: %d affected\n", len(pages))
+	for _, page := range setDifference(pages, nil) {
+		fmt.Printf("  %s\n", page)
+	}
+}
+
+// return " (make / model)" for a work with a make, or an empty string
+func describeCamera(w exportWork) string {
+	if w.Make == "" {
+		return ""
+	}
+
+	return " (" + w.Make + " / " + w.Model + ")"
+}
@@ -0,0 +1,158 @@
+// image dimension probing: finds the intrinsic size of each thumbnail and medium image so <img> tags can carry width/height and avoid layout shift.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// number of leading bytes fetched from a remote image to read its header - enough for the dimensions of virtually every JPEG, PNG and GIF
+const probeBytes = 64 * 1024
+
+// find the dimensions of every work's thumbnail and medium images: local files are always probed, remote ones only if probeRemote is
+// set (with a ranged GET of the first few KB), and remote results are remembered in the build cache
+func probeDimensions(catalog *Catalog, outputFolderLocation string, cache *buildCache, probeRemote bool) {
+	probed, failed := 0, 0
+
+	for _, wk := range catalog.Works {
+		if wk == nil {
+			continue
+		}
+
+		for _, size := range []string{"small", "medium"} {
+			src, width, height := wk.smallSrc(), &wk.SmallWidth, &wk.SmallHeight
+			if size == "medium" {
+				src, width, height = wk.mediumSrc(), &wk.MediumWidth, &wk.MediumHeight
+			}
+
+			src = strings.TrimSpace(src)
+			if src == "" {
+				continue
+			}
+
+			remote := strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+
+			var dims imageDims
+			var err error
+
+			if !remote {
+				dims, err = probeLocalImage(filepath.Join("./"+outputFolderLocation, filepath.FromSlash(src)))
+			} else if cached, ok := cache.Dimensions[src]; ok {
+				dims = cached
+			} else if probeRemote {
+				dims, err = probeRemoteImage(src)
+				if err == nil {
+					cache.setDimensions(src, dims)
+				}
+			} else {
+				continue
+			}
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading dimensions of %s image for work %s (%s): %v\n", size, wk.ID, src, err)
+				failed++
+				continue
+			}
+
+			*width, *height = dims.Width, dims.Height
+			probed++
+		}
+	}
+
+	if probed > 0 || failed > 0 {
+		fmt.Printf("Image dimensions: %d known, %d failed.\n", probed, failed)
+	}
+}
+
+// read the dimensions of a local image file
+func probeLocalImage(path string) (imageDims, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return imageDims{}, err
+	}
+	defer f.Close()
+
+	return decodeDims(io.LimitReader(f, probeBytes))
+}
+
+// read the dimensions of a remote image from its first few KB, asking the server for just that range
+func probeRemoteImage(uri string) (imageDims, error) {
+	head, err := fetchHead(uri, probeBytes)
+	if err != nil {
+		return imageDims{}, err
+	}
+
+	return decodeDims(bytes.NewReader(head))
+}
+
+// decode just the header of a JPEG/PNG/GIF (or the root element of an SVG) and return its dimensions as displayed
+func decodeDims(r io.Reader) (imageDims, error) {
+	head, err := io.ReadAll(r)
+	if err != nil {
+		return imageDims{}, err
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(head))
+	if err == nil {
+		// the image is shown turned upright, so a quarter-turned one is as wide as its pixels are tall
+		dims := imageDims{Width: cfg.Width, Height: cfg.Height}
+		if o := imageOrientation(head); o != 1 {
+			dims.Orientation = o
+			if orientationSwapsAxes(o) {
+				dims.Width, dims.Height = cfg.Height, cfg.Width
+			}
+		}
+		return dims, nil
+	}
+
+	if dims, ok := svgDims(head); ok {
+		return dims, nil
+	}
+
+	return imageDims{}, err
+}
+
+// read the width and height attributes of an SVG document's root element (plain pixel values only)
+func svgDims(data []byte) (imageDims, bool) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return imageDims{}, false
+		}
+
+		el, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		if el.Name.Local != "svg" {
+			return imageDims{}, false
+		}
+
+		var dims imageDims
+		for _, attr := range el.Attr {
+			value, err := strconv.Atoi(strings.TrimSuffix(attr.Value, "px"))
+			if err != nil {
+				continue
+			}
+
+			switch attr.Name.Local {
+			case "width":
+				dims.Width = value
+			case "height":
+				dims.Height = value
+			}
+		}
+
+		return dims, dims.Width > 0 && dims.Height > 0
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// returned by freeDiskSpace on platforms where free space can't be measured
+var errUnsupported = errors.New("unsupported on this platform")
+
+// free disk space measurement isn't implemented on this platform
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errUnsupported
+}
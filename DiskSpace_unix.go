@@ -0,0 +1,21 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// returned by freeDiskSpace on platforms where free space can't be measured
+var errUnsupported = errors.New("unsupported on this platform")
+
+// return the number of bytes available to unprivileged users on the filesystem holding the given path
+func freeDiskSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
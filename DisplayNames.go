@@ -0,0 +1,65 @@
+// display names: how makes and models are shown on the pages, feeds and contact sheets, without changing the names the feed
+// uses - page file names and the data export keep the original values. Names are mapped through the config file's
+// "display_names" section (matched case-insensitively), and --title-case tidies names the feed writes in capitals:
+//
+//	"display_names": {"FUJIFILM": "Fujifilm", "CANON EOS 5D MARK II": "Canon EOS 5D Mark II"}
+
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// set the display name of every make and model: the mapped name if there is one, otherwise the name title-cased (if asked for) or as is
+func applyDisplayNames(catalog *Catalog, names map[string]string, titleCase bool) {
+	mapped := map[string]string{}
+	for name, display := range names {
+		mapped[strings.ToLower(strings.TrimSpace(name))] = display
+	}
+
+	display := func(name string) string {
+		if d, ok := mapped[strings.ToLower(strings.TrimSpace(name))]; ok && strings.TrimSpace(d) != "" {
+			return d
+		}
+		if titleCase {
+			return titleCaseName(name)
+		}
+		return name
+	}
+
+	for _, mk := range catalog.Makes {
+		if mk == nil {
+			continue
+		}
+
+		mk.DisplayName = display(mk.Name)
+		for _, md := range mk.Models {
+			if md != nil {
+				md.DisplayName = display(md.Name)
+			}
+		}
+	}
+}
+
+// return a name written all in capitals title-cased word by word ("NIKON CORPORATION" -> "Nikon Corporation") - words with digits
+// and words of up to three letters are taken to be model numbers and abbreviations and stay as they are ("EOS 5D MARK II" -> "EOS 5D Mark II")
+// names with any lowercase letters are left alone, since whoever wrote them chose their case
+func titleCaseName(name string) string {
+	if strings.ToUpper(name) != name {
+		return name
+	}
+
+	words := strings.Split(name, " ")
+	for i, w := range words {
+		if len([]rune(w)) <= 3 || strings.IndexFunc(w, unicode.IsDigit) >= 0 {
+			continue
+		}
+
+		r := []rune(strings.ToLower(w))
+		r[0] = unicode.ToUpper(r[0])
+		words[i] = string(r)
+	}
+
+	return strings.Join(words, " ")
+}
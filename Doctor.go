@@ -0,0 +1,441 @@
+// doctor subcommand: checks the environment a site build depends on and prints actionable pass/fail results for on-call triage. The
+// feed is reached with the build's fetch flags (--proxy, --ca-cert, ...). With --deploy it also checks the config's settings for that
+// deploy target, that the target's API accepts its credentials, and that the tools it runs are installed (see Deploy.go).
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCommand(&command{
+		Name:    "doctor",
+		Usage:   "[--min-free-mb N] [--timeout D] [--config FILE --deploy TARGET] <api-url> <output-dir>",
+		Summary: "check feed reachability, output writability, disk space and deploy credentials, and print pass/fail results",
+		Setup:   setupDoctor,
+	})
+}
+
+// type struct representing the outcome of a single doctor check
+type checkResult struct {
+	Name    string
+	OK      bool
+	Skipped bool   // check could not be run in this environment (e.g. unsupported platform)
+	Detail  string // what was observed
+	Hint    string // suggested action printed when the check fails
+}
+
+// define the doctor subcommand's flags and return its runner: run all doctor checks against the given API URL and output directory,
+// print the results and return 0 if every check passed (1 otherwise)
+func setupDoctor(fs *flag.FlagSet) func(positional []string) int {
+	minFreeMB := fs.Int64("min-free-mb", 100, "minimum free disk space (in MB) required in the output directory")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout for the feed reachability check and the deploy credentials check")
+	fs.StringVar(&cacheDirOverride, "cache-dir", "", "persistent cache directory to check (default $IMGPROC_CACHE_DIR or the user cache directory)")
+	configPath := fs.String("config", "", "path to the JSON configuration file the build uses")
+	deployTarget := fs.String("deploy", "", "deploy target whose settings and credentials to check: "+strings.Join(deployTargets, ", "))
+
+	// the feed is reached through the same proxy and TLS settings as in a build
+	var fetch fetchOptions
+	fetchFlags(fs, &fetch)
+
+	return func(positional []string) int {
+		if len(positional) < 2 {
+			fmt.Fprintln(os.Stderr, "Error: please enter the image API URL and an output directory location (e.g. >go run ImageProcessor doctor http://localhost/test/api/v1/works.xml code/html/output)")
+			return 2
+		}
+
+		apiLocation := positional[0]
+		outputFolderLocation := positional[1]
+
+		if err := configureFetching(fetch); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+
+		results := []checkResult{
+			checkFeed(apiLocation, *timeout, ""),
+			checkOutputWritable(outputFolderLocation),
+			checkDiskSpace(outputFolderLocation, *minFreeMB),
+			checkCache(),
+			checkDeploy(*deployTarget, *configPath, *timeout),
+		}
+
+		return printCheckResults(results)
+	}
+}
+
+// print the results of a run of checks, one line each with a hint under each failure, and return 0 if every check passed (1 otherwise)
+func printCheckResults(results []checkResult) int {
+	failed := 0
+	for _, r := range results {
+		status := "PASS"
+		if r.Skipped {
+			status = "SKIP"
+		} else if !r.OK {
+			status = "FAIL"
+			failed++
+		}
+
+		fmt.Printf("[%s] %s: %s\n", status, r.Name, r.Detail)
+		if status == "FAIL" && r.Hint != "" {
+			fmt.Printf("       -> %s\n", r.Hint)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed.\n", failed, len(results))
+		return 1
+	}
+
+	fmt.Println("All checks passed.")
+	return 0
+}
+
+// check that the works API responds successfully and that its body starts with an XML root element - named root, unless root is "" -
+// locations other than http(s) URLs (local files, other sources) are opened as a build opens them
+func checkFeed(location string, timeout time.Duration, root string) checkResult {
+	r := checkResult{Name: "feed reachability"}
+	start := time.Now()
+
+	var body io.Reader
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		client := &http.Client{Transport: httpClient.Transport, Timeout: timeout}
+		resp, err := client.Get(location)
+		if err != nil {
+			r.Detail = fmt.Sprintf("request to %s failed: %v", location, err)
+			r.Hint = "check the API URL, DNS and network access from this host"
+			return r
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			r.Detail = fmt.Sprintf("%s returned HTTP %s", location, resp.Status)
+			r.Hint = "check that the works API is up and the URL path is correct"
+			return r
+		}
+		body = resp.Body
+	} else {
+		feed, err := openFeed(location)
+		if err != nil {
+			r.Detail = fmt.Sprintf("cannot open %s: %v", location, err)
+			r.Hint = "check the path of the works file, or the settings of the works source"
+			return r
+		}
+		defer feed.Close()
+		body = feed
+	}
+
+	// read tokens until the first start element - anything else before it (other than the prolog) means this isn't an XML feed
+	dec := xml.NewDecoder(body)
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			r.Detail = fmt.Sprintf("%s returned an empty XML document", location)
+			r.Hint = "check that the API is serving works data"
+			return r
+		} else if err != nil {
+			r.Detail = fmt.Sprintf("%s did not return valid XML: %v", location, err)
+			r.Hint = "check that the URL points at the XML works endpoint (e.g. .../works.xml) and not an HTML page"
+			return r
+		}
+
+		if el, ok := token.(xml.StartElement); ok {
+			r.Detail = fmt.Sprintf("%s responded in %v with root element <%s>", location, time.Since(start).Round(time.Millisecond), el.Name.Local)
+			if root != "" && el.Name.Local != root {
+				r.Detail = fmt.Sprintf("%s returned XML with root element <%s>, not <%s>", location, el.Name.Local, root)
+				r.Hint = fmt.Sprintf("check that the URL points at the works feed - to see how another feed is laid out, run >go run ImageProcessor inspect %s", location)
+				return r
+			}
+			r.OK = true
+			return r
+		}
+	}
+}
+
+// check that files can be created in the output directory (or, if it doesn't exist yet, that it can be created)
+func checkOutputWritable(outputFolderLocation string) checkResult {
+	r := checkResult{Name: "output writability"}
+	dir := "./" + outputFolderLocation
+
+	fileInPlace, err := fileExists(dir)
+	if err != nil {
+		r.Detail = fmt.Sprintf("cannot stat %s: %v", dir, err)
+		r.Hint = "check permissions on the output directory and its parents"
+		return r
+	}
+
+	if !fileInPlace {
+		// the build creates the output directory itself, so it's enough for the nearest existing parent to be writable
+		dir = nearestExistingDir(dir)
+	}
+
+	probe, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		r.Detail = fmt.Sprintf("cannot create files in %s: %v", dir, err)
+		r.Hint = "fix ownership/permissions of the directory or choose another output location"
+		return r
+	}
+
+	probe.Close()
+	os.Remove(probe.Name())
+
+	r.OK = true
+	if fileInPlace {
+		r.Detail = fmt.Sprintf("%s exists and is writable", dir)
+	} else {
+		r.Detail = fmt.Sprintf("./%s doesn't exist yet, but %s is writable so it can be created", outputFolderLocation, dir)
+	}
+
+	return r
+}
+
+// check that the persistent cache directory can be written to, and report how much it holds
+func checkCache() checkResult {
+	r := checkResult{Name: "persistent cache"}
+
+	dir, err := cacheDir()
+	if err != nil {
+		r.Detail = err.Error()
+		r.Hint = "set IMGPROC_CACHE_DIR to a writable directory"
+		return r
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		r.Detail = fmt.Sprintf("cannot create %s: %v", dir, err)
+		r.Hint = "fix permissions, or point IMGPROC_CACHE_DIR (or --cache-dir) somewhere writable"
+		return r
+	}
+
+	probe, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		r.Detail = fmt.Sprintf("cannot create files in %s: %v", dir, err)
+		r.Hint = "fix permissions, or point IMGPROC_CACHE_DIR (or --cache-dir) somewhere writable"
+		return r
+	}
+
+	probe.Close()
+	os.Remove(probe.Name())
+
+	entries, err := cacheEntries(dir)
+	if err != nil {
+		r.Detail = fmt.Sprintf("cannot read %s: %v", dir, err)
+		return r
+	}
+
+	r.OK = true
+	r.Detail = fmt.Sprintf("%s is writable and holds %d files (%s)", dir, len(entries), formatSize(totalSize(entries)))
+	if totalSize(entries) > 1<<30 {
+		r.Detail += " - trim it with >go run ImageProcessor cache gc --max-size 1GB"
+	}
+
+	return r
+}
+
+// check that the filesystem holding the output directory has at least minFreeMB megabytes available
+func checkDiskSpace(outputFolderLocation string, minFreeMB int64) checkResult {
+	r := checkResult{Name: "disk space"}
+	dir := nearestExistingDir("./" + outputFolderLocation)
+
+	free, err := freeDiskSpace(dir)
+	if err == errUnsupported {
+		r.Skipped = true
+		r.Detail = "free space can't be measured on this platform"
+		return r
+	} else if err != nil {
+		r.Detail = fmt.Sprintf("cannot measure free space in %s: %v", dir, err)
+		r.Hint = "check that the output location is on a mounted filesystem"
+		return r
+	}
+
+	freeMB := int64(free / (1024 * 1024))
+	r.Detail = fmt.Sprintf("%d MB free in %s (minimum %d MB)", freeMB, dir, minFreeMB)
+	if freeMB < minFreeMB {
+		r.Hint = "free up space on the output volume or point the build at a larger one"
+		return r
+	}
+
+	r.OK = true
+	return r
+}
+
+// URL of the Cloudflare API the cloudflare-pages credentials are checked against
+const cloudflareAPIURL = "https://api.cloudflare.com/client/v4"
+
+// check that the config's deploy section has the settings and credentials the deploy target needs, that the target's API (or git
+// remote) accepts the credentials, and that the tools the deploy runs are installed
+func checkDeploy(target, configPath string, timeout time.Duration) checkResult {
+	r := checkResult{Name: "deploy target"}
+	if target == "" {
+		r.Skipped = true
+		r.Detail = "no --deploy target given"
+		return r
+	}
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		r.Detail = err.Error()
+		r.Hint = "fix the config file, or pass the one the build uses with --config"
+		return r
+	}
+
+	if _, err := deployFunc(target, cfg.Deploy); err != nil {
+		r.Detail = err.Error()
+		switch target {
+		case "netlify":
+			r.Hint = "set deploy.netlify.site_id in the config, and the access token in $NETLIFY_AUTH_TOKEN (or the variable token_env names)"
+		case "cloudflare-pages":
+			r.Hint = "set deploy.cloudflare_pages.project in the config, and the API token in $CLOUDFLARE_API_TOKEN (or the variable token_env names)"
+		default:
+			r.Hint = "check the --deploy target and the config's deploy section"
+		}
+		return r
+	}
+
+	switch target {
+	case "netlify":
+		c := cfg.Deploy.Netlify
+		status, err := deployAPIStatus(c.api()+"/sites/"+url.PathEscape(c.SiteID), c.token(), timeout)
+		if !deployAPIResult(&r, status, err, "netlify site "+c.SiteID, map[int]string{
+			http.StatusUnauthorized: "the access token was rejected - create a new personal access token and set it in $NETLIFY_AUTH_TOKEN (or the variable token_env names)",
+			http.StatusForbidden:    "the access token can't read this site - use a token of an account that is a member of the site's team",
+			http.StatusNotFound:     "check deploy.netlify.site_id - it's the site's API id (or its name.netlify.app domain)",
+		}) {
+			return r
+		}
+
+	case "gh-pages":
+		if _, err := exec.LookPath("git"); err != nil {
+			r.Detail = "git isn't installed"
+			r.Hint = "install git - the gh-pages target commits and pushes the site with it"
+			return r
+		}
+		repo := ""
+		if cfg.Deploy != nil {
+			repo = cfg.Deploy.GHPages.Repo
+		}
+		if repo == "" {
+			out, err := exec.Command("git", "remote", "get-url", "origin").Output()
+			if err != nil {
+				r.Detail = "no repo configured and no origin remote in the current directory"
+				r.Hint = "set deploy.gh_pages.repo in the config, or run the build from a clone with an origin remote"
+				return r
+			}
+			repo = strings.TrimSpace(string(out))
+		}
+
+		// listing the repo's branches needs the same access as cloning it - git's own credentials, without prompting for any
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "git", "ls-remote", "--heads", repo)
+		cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_SSH_COMMAND=ssh -o BatchMode=yes")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			r.Detail = fmt.Sprintf("cannot read %s: %v %s", repo, err, strings.Join(strings.Fields(string(out)), " "))
+			r.Hint = "check git's credentials for the repo (an SSH key or a credential helper) and that the repo URL is right"
+			return r
+		}
+		r.Detail = fmt.Sprintf("git's credentials can read %s", repo)
+
+	case "cloudflare-pages":
+		c := cfg.Deploy.CloudflarePages
+		command := c.command()
+		if _, err := exec.LookPath(command[0]); err != nil {
+			r.Detail = fmt.Sprintf("%s isn't installed", command[0])
+			r.Hint = "install wrangler (npm install -g wrangler), or set deploy.cloudflare_pages.command (e.g. \"npx wrangler\")"
+			return r
+		}
+
+		// with the account known the project itself is fetched, otherwise the token is only verified
+		account := c.AccountID
+		if account == "" {
+			account = os.Getenv("CLOUDFLARE_ACCOUNT_ID")
+		}
+		uri, what := cloudflareAPIURL+"/user/tokens/verify", "cloudflare API token"
+		if account != "" {
+			uri = cloudflareAPIURL + "/accounts/" + url.PathEscape(account) + "/pages/projects/" + url.PathEscape(c.Project)
+			what = "cloudflare pages project " + c.Project
+		}
+		status, err := deployAPIStatus(uri, c.token(), timeout)
+		if !deployAPIResult(&r, status, err, what, map[int]string{
+			http.StatusUnauthorized: "the API token was rejected - create a new one and set it in $CLOUDFLARE_API_TOKEN (or the variable token_env names)",
+			http.StatusForbidden:    "the API token lacks the Cloudflare Pages: Edit permission on this account",
+			http.StatusNotFound:     "check deploy.cloudflare_pages.project and account_id",
+		}) {
+			return r
+		}
+		r.Detail += fmt.Sprintf(", and %s is installed", command[0])
+	}
+
+	r.OK = true
+	return r
+}
+
+// send an authenticated GET to a deploy target's API and return the response status
+func deployAPIStatus(uri, token string, timeout time.Duration) (int, error) {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Transport: httpClient.Transport, Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
+
+// fill in the detail of a deploy check - and its hint, from hints by status - from the outcome of an authenticated API request for
+// what, and return whether it succeeded
+func deployAPIResult(r *checkResult, status int, err error, what string, hints map[int]string) bool {
+	switch {
+	case err != nil:
+		r.Detail = fmt.Sprintf("cannot reach the API to check the %s: %v", what, err)
+		r.Hint = "check network access from this host (and --proxy / --ca-cert)"
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		r.Detail = fmt.Sprintf("the credentials for the %s were refused (HTTP %d)", what, status)
+	case status == http.StatusNotFound:
+		r.Detail = fmt.Sprintf("the API has no %s (HTTP %d)", what, status)
+	case status/100 != 2:
+		r.Detail = fmt.Sprintf("checking the %s failed: HTTP %d", what, status)
+	default:
+		r.Detail = fmt.Sprintf("the credentials for the %s were accepted", what)
+		return true
+	}
+
+	if hint, ok := hints[status]; ok {
+		r.Hint = hint
+	}
+	return false
+}
+
+// walk up from the given path and return the first directory that exists
+func nearestExistingDir(path string) string {
+	dir := filepath.Clean(path)
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+
+		dir = parent
+	}
+}
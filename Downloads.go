@@ -0,0 +1,211 @@
+// download archives: --zip-downloads bundles the downloaded large images of each make and model into a zip archive in the output
+// directory (downloads/Canon.zip, downloads/Canon-EOS-20D.zip ...), and the make and model pages link to theirs, so visitors can grab
+// a whole set at once:
+//
+//	>go run ImageProcessor --download-images --zip-downloads http://localhost/test/api/v1/works.xml code/html/output
+//
+// Only works whose large image was downloaded go into an archive (a page with none gets no link). The images are stored as they are,
+// without compressing them again, and an archive whose images haven't changed since the last build is left as it is.
+
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// directory (in the output directory) the archives are written to
+const downloadsDir = "downloads"
+
+// type struct representing the link to a page's download archive
+type downloadView struct {
+	Href  string // the archive, relative to the page
+	Works int    // number of images in it
+	Size  string // its size, for people (e.g. 12.3 MB)
+}
+
+// type struct representing one image in an archive
+type archiveMember struct {
+	name  string // path in the archive
+	local string // path of the image file
+	info  os.FileInfo
+}
+
+// write the archive of every make and model with downloaded large images - returns the links for the pages, by page URL, and the
+// files written
+func writeDownloadArchives(makes []*Make, outputFolderLocation string) (map[string]*downloadView, []string, error) {
+	if err := os.MkdirAll(filepath.Join("./"+outputFolderLocation, downloadsDir), 0755); err != nil {
+		return nil, nil, fmt.Errorf("Error creating downloads directory: %v", err)
+	}
+
+	links := map[string]*downloadView{}
+	var written []string
+
+	write := func(pageURL string, works []*Work) error {
+		file := downloadsDir + "/" + pageURL + ".zip"
+		link, err := writeDownloadArchive(outputFolderLocation, file, pageURL, works)
+		if err != nil {
+			return fmt.Errorf("Error writing download archive (%s): %v", file, err)
+		}
+		if link != nil {
+			links[pageURL] = link
+			written = append(written, file)
+		}
+		return nil
+	}
+
+	for _, mk := range makes {
+		if mk == nil {
+			continue
+		}
+
+		if err := write(mk.PageURL, worksByMake(mk.Works, mk)); err != nil {
+			return nil, nil, err
+		}
+
+		for _, md := range mk.Models {
+			if md != nil {
+				if err := write(md.PageURL, worksByMake(md.Works, mk)); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+
+	fmt.Printf("Download archives: %d in %s/.\n", len(written), downloadsDir)
+	return links, written, nil
+}
+
+// write the archive of the works' downloaded large images into file, in a folder named after the page - returns nil (and writes
+// nothing) if none of the works has a downloaded large image
+func writeDownloadArchive(outputFolderLocation, file, folder string, works []*Work) (*downloadView, error) {
+	var members []archiveMember
+	used := map[string]bool{}
+	h := sha256.New()
+
+	for _, wk := range works {
+		if wk == nil || wk.LocalLarge == "" {
+			continue
+		}
+
+		local := filepath.Join("./"+outputFolderLocation, filepath.FromSlash(wk.LocalLarge))
+		info, err := os.Stat(local)
+		if err != nil {
+			continue
+		}
+
+		members = append(members, archiveMember{name: folder + "/" + archiveName(wk, used), local: local, info: info})
+		fmt.Fprintf(h, "%s|%s|%d|%d\n", members[len(members)-1].name, local, info.Size(), info.ModTime().UnixNano())
+	}
+
+	if len(members) == 0 {
+		return nil, nil
+	}
+
+	target := filepath.Join("./"+outputFolderLocation, filepath.FromSlash(file))
+	fingerprint := hex.EncodeToString(h.Sum(nil))
+
+	// the archive's comment holds the fingerprint of the images it was made from
+	if r, err := zip.OpenReader(target); err == nil {
+		unchanged := r.Comment == fingerprint
+		r.Close()
+		if unchanged {
+			return archiveLink(target, file, len(members))
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".zip-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	zw := zip.NewWriter(tmp)
+	for _, m := range members {
+		if err := addArchiveMember(zw, m); err != nil {
+			tmp.Close()
+			return nil, err
+		}
+	}
+	if err := zw.SetComment(fingerprint); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return nil, err
+	}
+
+	return archiveLink(target, file, len(members))
+}
+
+// copy an image into the archive, stored rather than compressed (images hardly compress any further)
+func addArchiveMember(zw *zip.Writer, m archiveMember) error {
+	header, err := zip.FileInfoHeader(m.info)
+	if err != nil {
+		return err
+	}
+	header.Name = m.name
+	header.Method = zip.Store
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(m.local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// return the name of a work's image in an archive: its file name (with the downloaded image's extension if it has none), made unique
+// among the names already used with the work's id
+func archiveName(wk *Work, used map[string]bool) string {
+	name := path.Base(strings.ReplaceAll(wk.FileName, "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		name = ""
+	}
+	if path.Ext(name) == "" {
+		name = strings.TrimSuffix(name, ".") + path.Ext(wk.LocalLarge)
+	}
+
+	ext := path.Ext(name)
+	if strings.TrimSuffix(name, ext) == "" || used[strings.ToLower(name)] {
+		name = strings.TrimSuffix(name, ext) + "-" + wk.ID.String() + ext
+		name = strings.TrimPrefix(name, "-")
+	}
+	used[strings.ToLower(name)] = true
+
+	return name
+}
+
+// return the page link to the archive written at target
+func archiveLink(target, file string, works int) (*downloadView, error) {
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return &downloadView{Href: file, Works: works, Size: formatSize(info.Size())}, nil
+}
@@ -0,0 +1,90 @@
+// exclusion list: works that must never appear in the generated site - takedown and privacy requests - listed in the config file's
+// "exclude" section by work id, file name glob or tag. Excluded works are dropped before anything else happens to them (no image is
+// downloaded, no page written) and recorded in the build manifest with the rule that caught them:
+//
+//	"exclude": {"ids": [31, 207], "filenames": ["*_private*", "IMG_00*.jpg"], "tags": ["private", "takedown"]}
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// type struct representing the config file's exclusion list
+type exclusionList struct {
+	IDs       []WorkID `json:"ids"`
+	FileNames []string `json:"filenames"` // glob patterns (path.Match syntax), matched case-insensitively
+	Tags      []string `json:"tags"`      // matched case-insensitively
+}
+
+// type struct representing a work the exclusion list kept out of the site
+type excludedWork struct {
+	ID   WorkID `json:"id"`
+	Rule string `json:"rule"` // the entry that matched, e.g. "tag private"
+}
+
+// return an error if any file name pattern is malformed - checked up front so a bad pattern can't let a work through
+func (x exclusionList) check() error {
+	for _, pattern := range x.FileNames {
+		if _, err := path.Match(strings.ToLower(pattern), ""); err != nil {
+			return fmt.Errorf("Error in config exclude section: bad file name pattern %q: %v", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// return the rule excluding the work, or "" if none does
+func (x exclusionList) match(wk *Work) string {
+	for _, id := range x.IDs {
+		if wk.ID == id {
+			return "id " + id.String()
+		}
+	}
+
+	name := strings.ToLower(wk.FileName)
+	for _, pattern := range x.FileNames {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			return "filename " + pattern
+		}
+	}
+
+	for _, tag := range x.Tags {
+		for _, t := range wk.Tags {
+			if strings.EqualFold(strings.TrimSpace(tag), t) {
+				return "tag " + tag
+			}
+		}
+	}
+
+	return ""
+}
+
+// remove the works the exclusion list matches from the catalog, recording them for the build manifest
+func applyExclusions(catalog *Catalog, exclude exclusionList) {
+	if len(exclude.IDs) == 0 && len(exclude.FileNames) == 0 && len(exclude.Tags) == 0 {
+		return
+	}
+
+	var ids []string
+	for _, wk := range append([]*Work(nil), catalog.Works...) {
+		if wk == nil {
+			continue
+		}
+
+		rule := exclude.match(wk)
+		if rule == "" {
+			continue
+		}
+
+		catalog.removeWork(wk)
+		catalog.Excluded = append(catalog.Excluded, excludedWork{ID: wk.ID, Rule: rule})
+		ids = append(ids, wk.ID.String())
+	}
+
+	if len(ids) > 0 {
+		fmt.Printf("Excluded: %d works (ids %s).\n", len(ids), strings.Join(ids, ", "))
+	}
+}
@@ -0,0 +1,389 @@
+// EXIF reading: a small JPEG/TIFF metadata parser, used to fill in work metadata the feed leaves out.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EXIF tag numbers we read (IFD0, Exif sub-IFD and GPS sub-IFD tags share one number space in practice)
+const (
+	exifTagMake             = 0x010F
+	exifTagModel            = 0x0110
+	exifTagDateTime         = 0x0132
+	exifTagOrientation      = 0x0112
+	exifTagExifIFD          = 0x8769
+	exifTagFNumber          = 0x829D
+	exifTagISO              = 0x8827
+	exifTagFocalLength      = 0x920A
+	exifTagGPSIFD           = 0x8825
+	exifTagDateTimeOriginal = 0x9003
+	exifTagLensModel        = 0xA434
+)
+
+// GPS sub-IFD tag numbers we read
+const (
+	gpsTagLatitudeRef  = 1
+	gpsTagLatitude     = 2
+	gpsTagLongitudeRef = 3
+	gpsTagLongitude    = 4
+)
+
+// number of leading bytes read from an image to find its EXIF block (APP1 segments are at most 64 KB)
+const exifProbeBytes = 128 * 1024
+
+// format of EXIF date/time values
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// size in bytes of one value of each TIFF field type we decode (BYTE, ASCII, SHORT, LONG, RATIONAL, UNDEFINED, SLONG, SRATIONAL)
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+// errNoExif is returned when an image has no EXIF metadata
+var errNoExif = errors.New("no EXIF metadata")
+
+// type struct representing a single decoded EXIF tag value
+type exifValue struct {
+	Text    string    // ASCII values
+	Numbers []float64 // BYTE/SHORT/LONG/RATIONAL values (rationals as num/den)
+}
+
+// EXIF tags of an image keyed by tag number; GPS tags are kept apart since their numbers overlap with IFD0
+type exifData struct {
+	Tags map[uint16]exifValue
+	GPS  map[uint16]exifValue
+}
+
+// return an ASCII tag value, trimmed of NULs and whitespace
+func (e *exifData) text(tag uint16) string {
+	return strings.TrimSpace(strings.Trim(e.Tags[tag].Text, "\x00"))
+}
+
+// return the capture date: DateTimeOriginal, falling back to DateTime
+func (e *exifData) date() (time.Time, bool) {
+	for _, tag := range []uint16{exifTagDateTimeOriginal, exifTagDateTime} {
+		if t, err := time.Parse(exifDateLayout, e.text(tag)); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// return the first value of a numeric tag, if it's there and positive
+func (e *exifData) number(tag uint16) (float64, bool) {
+	v := e.Tags[tag]
+	if len(v.Numbers) == 0 || v.Numbers[0] <= 0 {
+		return 0, false
+	}
+
+	return v.Numbers[0], true
+}
+
+// return the orientation (1-8, see Orientation.go), 1 (upright) if the tag is missing or out of range
+func (e *exifData) orientation() int {
+	v := e.Tags[exifTagOrientation]
+	if len(v.Numbers) == 0 || v.Numbers[0] < 1 || v.Numbers[0] > 8 {
+		return 1
+	}
+
+	return int(v.Numbers[0])
+}
+
+// return the GPS position, if the GPS tags give one
+func (e *exifData) gps() (*geoPoint, bool) {
+	// degrees, minutes and seconds, south and west negative
+	coordinate := func(tag, ref uint16, negative string) (float64, bool) {
+		v := e.GPS[tag].Numbers
+		if len(v) == 0 {
+			return 0, false
+		}
+		degrees := v[0]
+		if len(v) > 1 {
+			degrees += v[1] / 60
+		}
+		if len(v) > 2 {
+			degrees += v[2] / 3600
+		}
+		if strings.EqualFold(strings.TrimSpace(strings.Trim(e.GPS[ref].Text, "\x00")), negative) {
+			degrees = -degrees
+		}
+		return degrees, true
+	}
+
+	lat, ok := coordinate(gpsTagLatitude, gpsTagLatitudeRef, "S")
+	if !ok {
+		return nil, false
+	}
+	lon, ok := coordinate(gpsTagLongitude, gpsTagLongitudeRef, "W")
+	if !ok || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return nil, false
+	}
+
+	return &geoPoint{Lat: lat, Lon: lon}, true
+}
+
+// find the EXIF APP1 segment in JPEG data and decode it
+func readExif(data []byte) (*exifData, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		// not a JPEG - TIFF-based formats carry the same structure from the first byte
+		if bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")) {
+			return parseTIFF(data)
+		}
+
+		return nil, errNoExif
+	}
+
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil, errNoExif
+		}
+
+		marker := data[pos+1]
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+
+		// start of scan - metadata segments all come before the image data - or a corrupt length that would end before it starts
+		if marker == 0xDA || length < 2 {
+			return nil, errNoExif
+		}
+
+		end := pos + 2 + length
+		if end > len(data) {
+			end = len(data)
+		}
+
+		segment := data[pos+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return parseTIFF(segment[6:])
+		}
+
+		pos = pos + 2 + length
+	}
+
+	return nil, errNoExif
+}
+
+// decode the TIFF structure holding EXIF data: IFD0 plus the Exif and GPS sub-IFDs it points to
+func parseTIFF(tiff []byte) (*exifData, error) {
+	if len(tiff) < 8 {
+		return nil, errNoExif
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid TIFF byte order marker")
+	}
+
+	e := &exifData{Tags: map[uint16]exifValue{}, GPS: map[uint16]exifValue{}}
+
+	if err := readIFD(tiff, order, order.Uint32(tiff[4:]), e.Tags); err != nil {
+		return nil, err
+	}
+
+	if sub, ok := e.Tags[exifTagExifIFD]; ok && len(sub.Numbers) > 0 {
+		readIFD(tiff, order, uint32(sub.Numbers[0]), e.Tags)
+	}
+
+	if gps, ok := e.Tags[exifTagGPSIFD]; ok && len(gps.Numbers) > 0 {
+		readIFD(tiff, order, uint32(gps.Numbers[0]), e.GPS)
+	}
+
+	return e, nil
+}
+
+// decode the entries of the IFD at the given offset into tags
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32, tags map[uint16]exifValue) error {
+	if int(offset)+2 > len(tiff) {
+		return fmt.Errorf("IFD offset out of range")
+	}
+
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := int(offset) + 2 + i*12
+		if entry+12 > len(tiff) {
+			return fmt.Errorf("truncated IFD")
+		}
+
+		tag := order.Uint16(tiff[entry:])
+		typ := order.Uint16(tiff[entry+2:])
+		n := int(order.Uint32(tiff[entry+4:]))
+
+		size, known := tiffTypeSizes[typ]
+		if !known || n < 0 || n > 1<<20 {
+			continue
+		}
+
+		// values of up to four bytes are stored inline, larger ones at an offset
+		valueData := tiff[entry+8 : entry+12]
+		if size*n > 4 {
+			off := int(order.Uint32(tiff[entry+8:]))
+			if off < 0 || off+size*n > len(tiff) {
+				continue
+			}
+			valueData = tiff[off : off+size*n]
+		}
+
+		var v exifValue
+		switch typ {
+		case 2:
+			v.Text = string(valueData[:n])
+		case 1, 7:
+			for j := 0; j < n; j++ {
+				v.Numbers = append(v.Numbers, float64(valueData[j]))
+			}
+		case 3:
+			for j := 0; j < n; j++ {
+				v.Numbers = append(v.Numbers, float64(order.Uint16(valueData[j*2:])))
+			}
+		case 4, 9:
+			for j := 0; j < n; j++ {
+				if typ == 9 {
+					v.Numbers = append(v.Numbers, float64(int32(order.Uint32(valueData[j*4:]))))
+				} else {
+					v.Numbers = append(v.Numbers, float64(order.Uint32(valueData[j*4:])))
+				}
+			}
+		case 5, 10:
+			for j := 0; j < n; j++ {
+				num := order.Uint32(valueData[j*8:])
+				den := order.Uint32(valueData[j*8+4:])
+				value := 0.0
+				if den != 0 {
+					if typ == 10 {
+						value = float64(int32(num)) / float64(int32(den))
+					} else {
+						value = float64(num) / float64(den)
+					}
+				}
+				v.Numbers = append(v.Numbers, value)
+			}
+		}
+
+		tags[tag] = v
+	}
+
+	return nil
+}
+
+// read the EXIF metadata of a work's large image - from the local copy if there is one, otherwise from the first bytes of the remote file
+func workExif(wk *Work, outputFolderLocation string) (*exifData, error) {
+	var head []byte
+	var err error
+
+	local := wk.LocalLarge
+	uri := strings.TrimSpace(wk.URILarge)
+	if local == "" && uri != "" && !strings.HasPrefix(uri, "http://") && !strings.HasPrefix(uri, "https://") {
+		local = uri
+	}
+
+	if local != "" {
+		f, openErr := os.Open(filepath.Join("./"+outputFolderLocation, filepath.FromSlash(local)))
+		if openErr != nil {
+			return nil, openErr
+		}
+		head, err = io.ReadAll(io.LimitReader(f, exifProbeBytes))
+		f.Close()
+	} else if uri != "" {
+		head, err = fetchHead(uri, exifProbeBytes)
+	} else {
+		return nil, fmt.Errorf("work has no large image")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return readExif(head)
+}
+
+// fill in each work's make, model, lens, settings (focal length, aperture and ISO), capture date and GPS position from the EXIF data of its large image
+// precedence "feed" only fills fields the feed left empty, "exif" lets EXIF values replace the feed's
+func applyExif(catalog *Catalog, outputFolderLocation, precedence string) error {
+	if precedence != "feed" && precedence != "exif" {
+		return fmt.Errorf("Error: unknown EXIF precedence %q (expected feed or exif)", precedence)
+	}
+
+	updated, failed := 0, 0
+
+	for _, wk := range catalog.Works {
+		if wk == nil {
+			continue
+		}
+
+		// with feed precedence there's nothing to do for works the feed describes fully
+		if precedence == "feed" && wk.WMake != nil && wk.WModel != nil && wk.Lens != nil && wk.FocalLength > 0 && wk.Aperture > 0 && wk.ISO > 0 && !wk.Date.IsZero() && wk.GPS != nil {
+			continue
+		}
+
+		exif, err := workExif(wk, outputFolderLocation)
+		if err == errNoExif {
+			continue
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading EXIF data of work %s: %v\n", wk.ID, err)
+			failed++
+			continue
+		}
+
+		changed := false
+
+		makeName, modelName := exif.text(exifTagMake), exif.text(exifTagModel)
+		if makeName != "" && (wk.WMake == nil || precedence == "exif") {
+			if modelName == "" && wk.WModel != nil {
+				modelName = wk.WModel.Name
+			}
+			catalog.assignMakeModel(wk, makeName, modelName)
+			changed = true
+		} else if modelName != "" && wk.WMake != nil && (wk.WModel == nil || precedence == "exif") {
+			catalog.assignMakeModel(wk, wk.WMake.Name, modelName)
+			changed = true
+		}
+
+		if lens := exif.text(exifTagLensModel); lens != "" && (wk.Lens == nil || precedence == "exif") {
+			catalog.assignLens(wk, lens)
+			changed = true
+		}
+
+		if v, ok := exif.number(exifTagFocalLength); ok && (wk.FocalLength == 0 || precedence == "exif") {
+			wk.FocalLength = v
+			changed = true
+		}
+		if v, ok := exif.number(exifTagFNumber); ok && (wk.Aperture == 0 || precedence == "exif") {
+			wk.Aperture = v
+			changed = true
+		}
+		if v, ok := exif.number(exifTagISO); ok && (wk.ISO == 0 || precedence == "exif") {
+			wk.ISO = int(v)
+			changed = true
+		}
+
+		if date, ok := exif.date(); ok && (wk.Date.IsZero() || precedence == "exif") {
+			wk.Date = date
+			changed = true
+		}
+
+		if p, ok := exif.gps(); ok && (wk.GPS == nil || precedence == "exif") {
+			wk.GPS = p
+			changed = true
+		}
+
+		if changed {
+			updated++
+		}
+	}
+
+	fmt.Printf("EXIF: %d works updated, %d failed.\n", updated, failed)
+	return nil
+}
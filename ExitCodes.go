@@ -0,0 +1,101 @@
+// exit codes: a build exits with a code saying what kind of failure stopped it, so wrapper scripts and CI can branch on it without
+// reading stderr:
+//
+//	0  success
+//	1  any other failure
+//	2  bad command-line usage (unknown flag, missing or conflicting arguments)
+//	3  configuration error (config, overrides, theme, TLS or signing settings)
+//	4  the works feed couldn't be fetched
+//	5  the works feed couldn't be parsed (malformed XML, over --max-works)
+//	6  partial site: some pages couldn't be generated, the rest were written
+//	7  deploy error: an after-write hook (which publishes the site) failed
+//	8  another build holds the lock on the output directory (see Lock.go)
+//
+// Subcommands keep to 0, 1 and 2.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// process exit codes of a build
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+	exitConfig  = 3
+	exitFetch   = 4
+	exitParse   = 5
+	exitPartial = 6
+	exitDeploy  = 7
+	exitLocked  = 8
+)
+
+// type struct representing an error that ends the build with a particular exit code
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// return the error tagged with the exit code it should end the build with (nil stays nil)
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &exitError{code: code, err: err}
+}
+
+// return the exit code a build that failed with the given error ends with
+func exitCodeOf(err error) int {
+	if err == nil {
+		return exitOK
+	}
+
+	var ee *exitError
+	if errors.As(err, &ee) {
+		return ee.code
+	}
+
+	var pe pageErrors
+	if errors.As(err, &pe) {
+		return exitPartial
+	}
+
+	return exitFailure
+}
+
+// exit codes of a build and what they mean, for the usage message and the man page
+var exitCodeMeanings = []struct {
+	Code    int
+	Meaning string
+}{
+	{exitOK, "success"},
+	{exitFailure, "other failure"},
+	{exitUsage, "usage error"},
+	{exitConfig, "configuration error"},
+	{exitFetch, "feed fetch error"},
+	{exitParse, "feed parse error"},
+	{exitPartial, "partial site (some pages failed)"},
+	{exitDeploy, "deploy (after-write hook) error"},
+	{exitLocked, "output directory locked by another build"},
+}
+
+// print the exit codes for the build's usage message
+func printExitCodes(out io.Writer) {
+	fmt.Fprintln(out, "Exit codes:")
+	for _, e := range exitCodeMeanings {
+		fmt.Fprintf(out, "  %d %s\n", e.Code, e.Meaning)
+	}
+}
@@ -0,0 +1,255 @@
+// export subcommand: dumps the parsed catalog in a machine-readable format for other tools - the works (json, csv, xlsx, or xml as a
+// cleaned-up works feed - see WorksXML.go), or the make -> model tree (opml, tree - see Outline.go).
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func init() {
+	registerCommand(&command{
+		Name:    "export",
+		Usage:   "[--format json|csv|xlsx|xml|opml|tree] [--base-url url] [--output file] [--dominant-colors] <api-url>",
+		Summary: "write the parsed works catalog to stdout or a file",
+		Setup:   setupExport,
+	})
+}
+
+// formats --format accepts
+var exportFormats = []string{"json", "csv", "xlsx", "xml", "opml", "tree"}
+
+// type struct representing one work in the exported catalog
+type exportWork struct {
+	ID            WorkID            `json:"id"`
+	FileName      string            `json:"filename"`
+	Make          string            `json:"make,omitempty"`
+	Model         string            `json:"model,omitempty"`
+	Lens          string            `json:"lens,omitempty"`         // see Lenses.go
+	FocalLength   float64           `json:"focal_length,omitempty"` // in mm (see Facets.go)
+	Aperture      float64           `json:"aperture,omitempty"`     // f-number
+	ISO           int               `json:"iso,omitempty"`
+	Author        string            `json:"author,omitempty"` // see Authors.go
+	Date          string            `json:"date,omitempty"`
+	Featured      bool              `json:"featured,omitempty"`
+	Flagged       bool              `json:"flagged,omitempty"` // see Flagged.go
+	Rating        string            `json:"rating,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+	URISmall      string            `json:"small,omitempty"`
+	URIMedium     string            `json:"medium,omitempty"`
+	URILarge      string            `json:"large,omitempty"`
+	DominantColor string            `json:"dominant_color,omitempty"`
+	Checksums     map[string]string `json:"checksums,omitempty"` // by image size (see Checksums.go)
+	Copyright     string            `json:"copyright,omitempty"`
+	License       string            `json:"license,omitempty"`     // e.g. CC BY-SA 4.0 (see License.go)
+	LicenseURL    string            `json:"license_url,omitempty"` // the license deed or text
+	GPS           *geoPoint         `json:"gps,omitempty"`         // see Places.go
+	Country       string            `json:"country,omitempty"`
+	City          string            `json:"city,omitempty"`
+	Caption       string            `json:"caption,omitempty"` // HTML, as the feed gives it (see Sanitize.go)
+}
+
+// define the export subcommand's flags and return its runner: parse the works feed and write the catalog in the requested format
+func setupExport(fs *flag.FlagSet) func(positional []string) int {
+	format := fs.String("format", "json", "output format: json, csv, xlsx (an Excel workbook) or xml (a cleaned works feed) for the works, opml or tree (JSON) for the make -> model tree")
+	baseURL := fs.String("base-url", "", "public URL of the site, for the page and Atom feed links of the opml and tree formats")
+	output := fs.String("output", "", "file to write the export to (default stdout)")
+	colors := fs.Bool("dominant-colors", false, "include each work's dominant thumbnail color (downloads every thumbnail)")
+
+	return func(positional []string) int {
+		if len(positional) < 1 {
+			fmt.Fprintln(os.Stderr, "Error: please enter the image API URL (e.g. >go run ImageProcessor export --format json http://localhost/test/api/v1/works.xml)")
+			return 2
+		}
+
+		// checked before anything is fetched or the output file is truncated
+		known := false
+		for _, f := range exportFormats {
+			known = known || f == *format
+		}
+		if !known {
+			fmt.Fprintf(os.Stderr, "Error: unknown export format %q (expected %s)\n", *format, strings.Join(exportFormats, ", "))
+			return 2
+		}
+
+		feed, err := openFeed(positional[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error fetching XML works data from specified API URL (%s): %v\n", positional[0], err)
+			return 1
+		}
+		defer feed.Close()
+
+		catalog, err := parseWorks(feed)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		// the outlines show makes and models by their display names, as the pages do
+		applyDisplayNames(catalog, nil, false)
+
+		if *colors {
+			computed, failed := computeDominantColors(catalog, "", nil)
+			fmt.Fprintf(os.Stderr, "Dominant colors: %d computed, %d failed.\n", computed, failed)
+		}
+
+		var out io.Writer = os.Stdout
+		if *output != "" {
+			f, err := os.Create(*output)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating export file: %v\n", err)
+				return 1
+			}
+			defer f.Close()
+			out = f
+		}
+
+		switch *format {
+		case "json":
+			err = writeJSONExport(out, catalog)
+		case "csv":
+			err = writeCSVExport(out, catalog)
+		case "xlsx":
+			err = writeXLSXExport(out, catalog)
+		case "opml":
+			err = writeOPMLExport(out, catalog, *baseURL)
+		case "tree":
+			err = writeTreeExport(out, catalog, *baseURL)
+		case "xml":
+			if n := dedupeWorks(catalog); n > 0 {
+				fmt.Fprintf(os.Stderr, "Dropped %s whose id an earlier work already has.\n", pluralize("work", "works", n))
+			}
+			err = writeWorksXML(out, catalog)
+		default:
+			fmt.Fprintf(os.Stderr, "Error: unknown export format %q\n", *format)
+			return 2
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing export: %v\n", err)
+			return 1
+		}
+
+		return 0
+	}
+}
+
+// return the export representation of every work in the catalog
+func exportWorks(catalog *Catalog) []exportWork {
+	var works []exportWork
+
+	for _, wk := range catalog.Works {
+		if wk == nil {
+			continue
+		}
+
+		ew := exportWork{
+			ID:            wk.ID,
+			FileName:      wk.FileName,
+			URISmall:      wk.URISmall,
+			URIMedium:     wk.URIMedium,
+			URILarge:      wk.URILarge,
+			DominantColor: wk.DominantColor,
+			Featured:      wk.Featured,
+			Flagged:       wk.Flagged,
+			Rating:        wk.Rating,
+			Tags:          wk.Tags,
+			Checksums:     wk.Checksums,
+			Copyright:     wk.Copyright,
+			FocalLength:   wk.FocalLength,
+			Aperture:      wk.Aperture,
+			ISO:           wk.ISO,
+			GPS:           wk.GPS,
+			Country:       wk.Country,
+			City:          wk.City,
+			Caption:       wk.Caption,
+		}
+
+		if license := wk.license(); license != nil {
+			ew.License, ew.LicenseURL = license.Name, license.URL
+		}
+
+		if wk.WMake != nil {
+			ew.Make = wk.WMake.Name
+		}
+
+		if wk.WModel != nil {
+			ew.Model = wk.WModel.Name
+		}
+
+		if wk.Lens != nil {
+			ew.Lens = wk.Lens.Name
+		}
+
+		if wk.Author != nil {
+			ew.Author = wk.Author.Name
+		}
+
+		if !wk.Date.IsZero() {
+			ew.Date = wk.Date.Format("2006-01-02T15:04:05")
+		}
+
+		works = append(works, ew)
+	}
+
+	return works
+}
+
+// build a catalog from exported works - the reverse of exportWorks
+func catalogFromExport(works []exportWork) (*Catalog, error) {
+	catalog := &Catalog{}
+
+	for _, ew := range works {
+		wk := createWork()
+		wk.ID = ew.ID
+		wk.FileName = ew.FileName
+		wk.URISmall = ew.URISmall
+		wk.URIMedium = ew.URIMedium
+		wk.URILarge = ew.URILarge
+		wk.DominantColor = ew.DominantColor
+		wk.Featured = ew.Featured
+		wk.Flagged, wk.Rating = ew.Flagged, ew.Rating
+		wk.Tags = ew.Tags
+		wk.Checksums = ew.Checksums
+		wk.Copyright, wk.License, wk.LicenseURL = ew.Copyright, ew.License, ew.LicenseURL
+		wk.GPS, wk.Country, wk.City = ew.GPS, ew.Country, ew.City
+		wk.FocalLength, wk.Aperture, wk.ISO = ew.FocalLength, ew.Aperture, ew.ISO
+		wk.Caption = ew.Caption
+
+		if ew.Date != "" {
+			date, err := parseDate(ew.Date)
+			if err != nil {
+				return nil, fmt.Errorf("work %s: %v", ew.ID, err)
+			}
+			wk.Date = date
+		}
+
+		catalog.Works = append(catalog.Works, wk)
+
+		// as in the feed, works without a make are kept apart from the make/model tree, and works with a make but no model element
+		// name their make without being in its galleries
+		if ew.Make == "" {
+			catalog.WorksSM = append(catalog.WorksSM, wk)
+		} else if ew.Model == "" {
+			wk.WMake = catalog.findOrCreateMake(ew.Make)
+		} else {
+			catalog.assignMakeModel(wk, ew.Make, ew.Model)
+		}
+		catalog.assignAuthor(wk, ew.Author)
+		catalog.assignLens(wk, ew.Lens)
+	}
+
+	return catalog, nil
+}
+
+// write the catalog as an indented JSON document
+func writeJSONExport(out io.Writer, catalog *Catalog) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{"works": exportWorks(catalog)})
+}
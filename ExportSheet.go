@@ -0,0 +1,171 @@
+// spreadsheet exports: the catalog as CSV or as an Excel workbook (.xlsx), one row per work, for curators auditing or editing the data.
+
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// column headings of the spreadsheet exports
+var sheetColumns = []string{"id", "filename", "make", "model", "lens", "focal_length", "aperture", "iso", "author", "date", "featured", "small", "medium", "large", "dominant_color", "copyright", "license", "latitude", "longitude", "country", "city", "caption"}
+
+// return a work's row of the spreadsheet exports, in sheetColumns order
+func sheetRow(w exportWork) []string {
+	featured := ""
+	if w.Featured {
+		featured = "yes"
+	}
+
+	latitude, longitude := "", ""
+	if w.GPS != nil {
+		latitude, longitude = strconv.FormatFloat(w.GPS.Lat, 'f', -1, 64), strconv.FormatFloat(w.GPS.Lon, 'f', -1, 64)
+	}
+
+	return []string{w.ID.String(), w.FileName, w.Make, w.Model, w.Lens, formatSetting(w.FocalLength), formatSetting(w.Aperture), formatSetting(float64(w.ISO)), w.Author, w.Date, featured, w.URISmall, w.URIMedium, w.URILarge, w.DominantColor, w.Copyright, w.License,
+		latitude, longitude, w.Country, w.City, w.Caption}
+}
+
+// write the catalog as CSV with a heading row
+// cells a spreadsheet would take for a formula (starting with =, +, - or @) are prefixed with a quote, so opening an export of
+// untrusted feed data can't run anything
+func writeCSVExport(out io.Writer, catalog *Catalog) error {
+	w := csv.NewWriter(out)
+
+	if err := w.Write(sheetColumns); err != nil {
+		return err
+	}
+
+	for _, ew := range exportWorks(catalog) {
+		row := sheetRow(ew)
+		for i, cell := range row {
+			if cell != "" && strings.ContainsRune("=+-@", rune(cell[0])) {
+				row[i] = "'" + cell
+			}
+		}
+
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+//----------------- XLSX -------------------------------
+
+// package parts of a one-sheet workbook, other than the sheet itself
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/><Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/></Types>`
+
+	xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Works" sheetId="1" r:id="rId1"/></sheets></workbook>`
+
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`
+
+	// style 1 is the bold heading row
+	xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts><fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills><borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders><cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs><cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs></styleSheet>`
+)
+
+// write the catalog as an Excel workbook with one sheet ("Works"), a bold frozen heading row and a filter on every column
+// ids are numeric cells, everything else is text (dates stay in the feed's ISO format)
+func writeXLSXExport(out io.Writer, catalog *Catalog) error {
+	zw := zip.NewWriter(out)
+
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, p := range parts {
+		w, err := zw.Create(p.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, p.content); err != nil {
+			return err
+		}
+	}
+
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+
+	works := exportWorks(catalog)
+	lastCell := xlsxCellRef(len(sheetColumns)-1, len(works)+1)
+
+	fmt.Fprint(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+"\n")
+	fmt.Fprint(sheet, `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	fmt.Fprint(sheet, `<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews><sheetData>`)
+
+	writeRow := func(row int, cells []string, heading bool) error {
+		fmt.Fprintf(sheet, `<row r="%d">`, row)
+		for col, value := range cells {
+			ref := xlsxCellRef(col, row)
+			switch {
+			case heading:
+				fmt.Fprintf(sheet, `<c r="%s" t="inlineStr" s="1"><is><t>%s</t></is></c>`, ref, xlsxText(value))
+			case col == 0:
+				fmt.Fprintf(sheet, `<c r="%s"><v>%s</v></c>`, ref, value)
+			case value != "":
+				fmt.Fprintf(sheet, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, xlsxText(value))
+			}
+		}
+		_, err := fmt.Fprint(sheet, `</row>`)
+		return err
+	}
+
+	if err := writeRow(1, sheetColumns, true); err != nil {
+		return err
+	}
+	for i, w := range works {
+		if err := writeRow(i+2, sheetRow(w), false); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintf(sheet, `</sheetData><autoFilter ref="A1:%s"/></worksheet>`, lastCell); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// return the A1-style reference of a cell (column counted from 0, row from 1)
+func xlsxCellRef(col, row int) string {
+	name := ""
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = string(rune('A'+(col-1)%26)) + name
+	}
+
+	return name + strconv.Itoa(row)
+}
+
+// escape text for a cell, dropping the control characters XML 1.0 can't carry
+func xlsxText(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			return -1
+		}
+		return r
+	}, s)
+
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
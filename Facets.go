@@ -0,0 +1,328 @@
+// technical facets: works whose feed (or, with --exif, whose image's EXIF data) gives the focal length, aperture or ISO they were
+// taken at are grouped into ranges of each, with a gallery page per range (facet-<facet>-<range>.html) listed on a facets page
+// (facets.html) the homepage links to, and a JSON index of the ranges and each work's settings (facets.json) for client-side search
+// to filter on. The work pages link their settings to the ranges they fall in:
+//
+//	<work>
+//	  <exif>
+//	    <focal_length>35mm</focal_length>
+//	    <aperture>f/1.4</aperture>
+//	    <iso>400</iso>
+//	    ...
+//
+// Settings can be written with or without their unit (35, 35 mm, F1.4, ISO 400) or as EXIF rationals (350/10). A facet no work has
+// a value for gets no pages.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// name of the page listing the facets
+const facetsPageFile = "facets.html"
+
+// name of the JSON facet index
+const facetIndexFile = "facets.json"
+
+// type struct representing a technical setting works are grouped by
+type facet struct {
+	Name    string // name in the facet index and page names, e.g. focal-length
+	Label   string // e.g. Focal length
+	Buckets []facetBucket
+
+	value  func(wk *Work) float64 // the work's setting (0 if unknown)
+	format func(v float64) string // the setting as shown on work pages
+}
+
+// type struct representing a range of a facet
+type facetBucket struct {
+	Key   string  // name in the facet index and page names, e.g. wide
+	Label string  // e.g. Wide (24-34mm)
+	Below float64 // the range holds the values below this (and above the previous range's)
+}
+
+// the facets and their ranges, in the order they're listed
+var technicalFacets = []facet{
+	{Name: "focal-length", Label: "Focal length", Buckets: []facetBucket{
+		{"ultra-wide", "Ultra wide (under 24mm)", 24},
+		{"wide", "Wide (24-34mm)", 35},
+		{"standard", "Standard (35-69mm)", 70},
+		{"short-telephoto", "Short telephoto (70-135mm)", 136},
+		{"telephoto", "Telephoto (136-300mm)", 301},
+		{"super-telephoto", "Super telephoto (over 300mm)", math.Inf(1)},
+	}, value: func(wk *Work) float64 { return wk.FocalLength }, format: formatFocalLength},
+	{Name: "aperture", Label: "Aperture", Buckets: []facetBucket{
+		{"f2-and-faster", "f/2 and faster", 2.1},
+		{"f2.2-f2.8", "f/2.2 - f/2.8", 3},
+		{"f3.2-f5.6", "f/3.2 - f/5.6", 6},
+		{"f6.3-f11", "f/6.3 - f/11", 12},
+		{"f13-and-slower", "f/13 and slower", math.Inf(1)},
+	}, value: func(wk *Work) float64 { return wk.Aperture }, format: formatAperture},
+	{Name: "iso", Label: "ISO", Buckets: []facetBucket{
+		{"200-and-below", "200 and below", 250},
+		{"250-800", "250 - 800", 1000},
+		{"1000-3200", "1000 - 3200", 4000},
+		{"4000-and-above", "4000 and above", math.Inf(1)},
+	}, value: func(wk *Work) float64 { return float64(wk.ISO) }, format: formatISO},
+}
+
+// type struct representing a facet as its pages show it
+type facetGroup struct {
+	Label   string
+	Buckets []facetLink // the ranges with works
+	Current string      // facet pages: the page's range
+}
+
+// type struct representing a link to a facet range's page
+type facetLink struct {
+	Label string
+	Href  string
+	Works int
+}
+
+// type struct representing the JSON facet index
+type facetIndex struct {
+	Facets []facetIndexFacet `json:"facets"`
+	Works  []facetIndexWork  `json:"works"`
+}
+
+// type struct representing a facet in the JSON facet index
+type facetIndexFacet struct {
+	Name    string             `json:"name"`
+	Label   string             `json:"label"`
+	Buckets []facetIndexBucket `json:"buckets"`
+}
+
+// type struct representing a facet range in the JSON facet index
+type facetIndexBucket struct {
+	Key   string   `json:"key"`
+	Label string   `json:"label"`
+	Min   float64  `json:"min"`             // values from this
+	Below float64  `json:"below,omitempty"` // up to (not including) this - left out for the last range
+	Page  string   `json:"page"`
+	Works []WorkID `json:"works"`
+}
+
+// type struct representing a work's settings in the JSON facet index
+type facetIndexWork struct {
+	ID          WorkID  `json:"id"`
+	Page        string  `json:"page"`
+	FocalLength float64 `json:"focal_length,omitempty"`
+	Aperture    float64 `json:"aperture,omitempty"`
+	ISO         int     `json:"iso,omitempty"`
+}
+
+// return the number in a setting as the feed or EXIF data writes it, with the given prefixes and suffixes (units) taken off - the
+// number can be an EXIF rational, e.g. 350/10
+func parseSetting(text string, prefixes, suffixes []string) (float64, error) {
+	s := strings.ToLower(strings.TrimSpace(text))
+	for _, p := range prefixes {
+		s = strings.TrimSpace(strings.TrimPrefix(s, p))
+	}
+	for _, suffix := range suffixes {
+		s = strings.TrimSpace(strings.TrimSuffix(s, suffix))
+	}
+
+	var v float64
+	var err error
+	if num, den, ok := strings.Cut(s, "/"); ok {
+		var n, d float64
+		if n, err = strconv.ParseFloat(num, 64); err == nil {
+			if d, err = strconv.ParseFloat(den, 64); err == nil && d == 0 {
+				err = fmt.Errorf("zero denominator")
+			}
+		}
+		if err == nil {
+			v = n / d
+		}
+	} else {
+		v, err = strconv.ParseFloat(s, 64)
+	}
+
+	if err != nil || v <= 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0, fmt.Errorf("%q isn't a positive number", text)
+	}
+
+	return v, nil
+}
+
+// return a focal length in millimetres, e.g. from "35mm"
+func parseFocalLength(text string) (float64, error) {
+	return parseSetting(text, nil, []string{"mm"})
+}
+
+// return an aperture as an f-number, e.g. from "f/1.4"
+func parseAperture(text string) (float64, error) {
+	return parseSetting(text, []string{"f/", "f"}, nil)
+}
+
+// return an ISO speed, e.g. from "ISO 400"
+func parseISO(text string) (int, error) {
+	v, err := parseSetting(text, []string{"iso"}, nil)
+	return int(math.Round(v)), err
+}
+
+// return a setting as a plain number ("" if unknown), for exports
+func formatSetting(v float64) string {
+	if v <= 0 {
+		return ""
+	}
+
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// return a setting as the work pages show it: 35mm, f/1.4, ISO 400
+func formatFocalLength(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64) + "mm"
+}
+
+func formatAperture(v float64) string {
+	return "f/" + strconv.FormatFloat(math.Round(v*10)/10, 'f', -1, 64)
+}
+
+func formatISO(v float64) string {
+	return "ISO " + strconv.Itoa(int(v))
+}
+
+// return the index of the facet range a value is in (-1 for an unknown value)
+func (f *facet) bucket(v float64) int {
+	if v <= 0 {
+		return -1
+	}
+
+	for i, b := range f.Buckets {
+		if v < b.Below {
+			return i
+		}
+	}
+
+	return len(f.Buckets) - 1
+}
+
+// return the page (without extension) of a facet range
+func facetPageURL(f *facet, b facetBucket) string {
+	return "facet-" + f.Name + "-" + pageSlug(b.Key)
+}
+
+// return the links from a work's page to the ranges its settings are in
+func workSettings(wk *Work) []facetLink {
+	var links []facetLink
+	for i := range technicalFacets {
+		f := &technicalFacets[i]
+		if b := f.bucket(f.value(wk)); b >= 0 {
+			links = append(links, facetLink{Label: f.format(f.value(wk)), Href: facetPageURL(f, f.Buckets[b]) + ".html"})
+		}
+	}
+
+	return links
+}
+
+// write the facets page, a gallery page per facet range with works and the JSON facet index - pages that can't be written are
+// recorded with the site writer
+// returns whether there are facet pages for the homepage to link to
+func generateFacetPages(site *siteWriter, catalog *Catalog, assets *siteAssets, layout pageLayout) bool {
+	var works []*Work
+	for _, wk := range catalog.Works {
+		if wk != nil {
+			works = append(works, wk)
+		}
+	}
+
+	index := facetIndex{Facets: []facetIndexFacet{}, Works: []facetIndexWork{}}
+	var groups []facetGroup
+	var pages []*facet
+	var pageBuckets []int
+	var pageWorks [][]*Work
+
+	for i := range technicalFacets {
+		f := &technicalFacets[i]
+		byBucket := make([][]*Work, len(f.Buckets))
+		for _, wk := range works {
+			if b := f.bucket(f.value(wk)); b >= 0 {
+				byBucket[b] = append(byBucket[b], wk)
+			}
+		}
+
+		group := facetGroup{Label: f.Label}
+		entry := facetIndexFacet{Name: f.Name, Label: f.Label, Buckets: []facetIndexBucket{}}
+		for b, bucketWorks := range byBucket {
+			if len(bucketWorks) == 0 {
+				continue
+			}
+
+			page := facetPageURL(f, f.Buckets[b]) + ".html"
+			group.Buckets = append(group.Buckets, facetLink{Label: f.Buckets[b].Label, Href: page, Works: len(bucketWorks)})
+
+			ib := facetIndexBucket{Key: f.Buckets[b].Key, Label: f.Buckets[b].Label, Page: page}
+			if b > 0 {
+				ib.Min = f.Buckets[b-1].Below
+			}
+			if b < len(f.Buckets)-1 {
+				ib.Below = f.Buckets[b].Below
+			}
+			for _, wk := range bucketWorks {
+				ib.Works = append(ib.Works, wk.ID)
+			}
+			entry.Buckets = append(entry.Buckets, ib)
+
+			pages, pageBuckets, pageWorks = append(pages, f), append(pageBuckets, b), append(pageWorks, bucketWorks)
+		}
+
+		if len(group.Buckets) > 0 {
+			groups = append(groups, group)
+			index.Facets = append(index.Facets, entry)
+		}
+	}
+
+	if len(groups) == 0 {
+		return false
+	}
+
+	if err := site.writeView(facetsPageFile, "facets", &pageView{Title: "Browse by focal length, aperture and ISO", Assets: assets, Facets: groups, Canonical: site.canonical(facetsPageFile)}); err != nil {
+		site.fail(fmt.Errorf("Error writing output to facets HTML file (%s): %v", facetsPageFile, err))
+	}
+
+	for i, f := range pages {
+		bucket := f.Buckets[pageBuckets[i]]
+		file := facetPageURL(f, bucket) + ".html"
+
+		// the page links to the facet's other ranges
+		var group facetGroup
+		for _, g := range groups {
+			if g.Label == f.Label {
+				group = g
+			}
+		}
+		group.Current = file
+
+		view := &pageView{Title: f.Label + ": " + bucket.Label, Assets: assets, Facet: &group}
+		if err := site.writeGallery(file, "facet", pageWorks[i], layout, view); err != nil {
+			site.fail(fmt.Errorf("Error writing output to facet HTML file (%s): %v", file, err))
+		}
+	}
+
+	for _, wk := range works {
+		if wk.FocalLength > 0 || wk.Aperture > 0 || wk.ISO > 0 {
+			index.Works = append(index.Works, facetIndexWork{ID: wk.ID, Page: wk.pageURL(), FocalLength: wk.FocalLength, Aperture: wk.Aperture, ISO: wk.ISO})
+		}
+	}
+
+	data, err := json.Marshal(index)
+	if err == nil {
+		err = os.WriteFile(filepath.Join("./"+site.outputFolderLocation, facetIndexFile), data, 0644)
+	}
+	if err != nil {
+		site.fail(fmt.Errorf("Error writing facet index (%s): %v", facetIndexFile, err))
+	} else {
+		site.written = append(site.written, facetIndexFile)
+	}
+
+	return true
+}
@@ -0,0 +1,224 @@
+// Atom feeds: a feed of the newest works across the site (atom.xml), plus one per make and per model (<make or model page>.atom.xml) so
+// visitors can subscribe to new photos from a particular camera line. Feed readers need absolute links, so feeds are only written
+// for sites with a base URL (--base-url or a site profile's base_url). Pages link their feed for autodiscovery.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// file name of the site-wide feed
+const siteFeedFile = "atom.xml"
+
+// type struct representing an Atom feed document
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// type struct representing an Atom link
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+// type struct representing an Atom author (required on the feed when entries don't have their own)
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+// type struct representing an Atom entry - one work
+type atomEntry struct {
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Updated  string      `xml:"updated"`
+	Link     atomLink    `xml:"link"`
+	Category []atomTerm  `xml:"category"`
+	Content  atomContent `xml:"content"`
+}
+
+// type struct representing an Atom category
+type atomTerm struct {
+	Term string `xml:"term,attr"`
+}
+
+// type struct representing the HTML content of an entry
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// return the file name of the feed of a make or model page
+func feedFile(pageURL string) string {
+	return pageURL + ".atom.xml"
+}
+
+// write the site-wide feed and a feed per make and per model, each with at most limit of the newest works - returns the files written
+func writeAtomFeeds(catalog *Catalog, outputFolderLocation, baseURL string, limit int, incremental *incrementalBuild) ([]string, error) {
+	site := strings.TrimSuffix(baseURL, "/") + "/"
+	var written []string
+
+	write := func(file, title, page string, works []*Work, owners ...string) error {
+		// in an incremental build, a feed none of whose works or image links changed is left as it is
+		deps := worksDeps(works, owners...)
+		var srcs []string
+		for _, wk := range works {
+			if wk != nil {
+				srcs = append(srcs, publishedImageURL(wk.smallSrc()))
+			}
+		}
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%d|%q|%q", site, title, page, limit, deps, srcs)))
+		state := outputState{Data: hex.EncodeToString(sum[:]), Deps: sortedKeys(deps)}
+		if incremental != nil && !incremental.check(file, state, false) {
+			written = append(written, file)
+			return nil
+		}
+
+		data, err := atomFeedXML(site, file, title, page, works, limit)
+		if err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(filepath.Join("./"+outputFolderLocation, file), data, 0644); err != nil {
+			return fmt.Errorf("Error writing Atom feed (%s): %v", file, err)
+		}
+
+		if incremental != nil {
+			incremental.record(file, state)
+		}
+		written = append(written, file)
+		return nil
+	}
+
+	if err := write(siteFeedFile, "Photos", "index.html", catalog.Works); err != nil {
+		return nil, err
+	}
+
+	for _, mk := range catalog.Makes {
+		if mk == nil {
+			continue
+		}
+
+		if err := write(feedFile(mk.PageURL), "Photos taken with a "+mk.DisplayName, mk.PageURL+".html", worksByMake(mk.Works, mk), makeKey(mk)); err != nil {
+			return nil, err
+		}
+
+		for _, md := range mk.Models {
+			if md == nil {
+				continue
+			}
+
+			if err := write(feedFile(md.PageURL), "Photos taken with a "+md.DisplayName, md.PageURL+".html", worksByMake(md.Works, mk), modelKey(md)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// return an Atom feed of the newest works (works without a capture date come last), linked to the given page of the site
+func atomFeedXML(site, file, title, page string, works []*Work, limit int) ([]byte, error) {
+	newest := chronologicalWorks(works)
+
+	// chronologicalWorks puts the oldest first and undated works last - the feed wants the newest first, then the undated ones
+	dated := 0
+	for dated < len(newest) && !newest[dated].Date.IsZero() {
+		dated++
+	}
+	for i, j := 0, dated-1; i < j; i, j = i+1, j-1 {
+		newest[i], newest[j] = newest[j], newest[i]
+	}
+
+	if limit > 0 && len(newest) > limit {
+		newest = newest[:limit]
+	}
+
+	// the feed changes when its newest work does - undated feeds fall back to the epoch rather than the build time, so rebuilding
+	// an unchanged site doesn't make every feed look updated
+	updated := time.Unix(0, 0).UTC()
+	if len(newest) > 0 && !newest[0].Date.IsZero() {
+		updated = newest[0].Date
+	}
+
+	feed := atomFeed{
+		ID:      site + file,
+		Title:   title,
+		Updated: atomTime(updated),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: site + file},
+			{Rel: "alternate", Type: "text/html", Href: site + page},
+		},
+		Author: atomAuthor{Name: "Photos"},
+	}
+
+	for _, wk := range newest {
+		entryUpdated := updated
+		if !wk.Date.IsZero() {
+			entryUpdated = wk.Date
+		}
+
+		entry := atomEntry{
+			ID:      site + wk.pageURL(),
+			Title:   titleOr(wk.Title, wk.FileName),
+			Updated: atomTime(entryUpdated),
+			Link:    atomLink{Rel: "alternate", Type: "text/html", Href: site + wk.pageURL()},
+			Content: atomContent{Type: "html", Body: atomEntryHTML(site, wk)},
+		}
+
+		if wk.WMake != nil {
+			entry.Category = append(entry.Category, atomTerm{Term: wk.WMake.DisplayName})
+		}
+		if wk.WModel != nil {
+			entry.Category = append(entry.Category, atomTerm{Term: wk.WModel.DisplayName})
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// return the HTML of an entry: the work's thumbnail linked to its page, and the camera
+func atomEntryHTML(site string, wk *Work) string {
+	src := publishedImageURL(wk.smallSrc())
+	if src != "" && !strings.Contains(src, "://") {
+		src = site + src
+	}
+
+	var b strings.Builder
+	if src != "" {
+		fmt.Fprintf(&b, `<a href="%s"><img src="%s" alt="%s"></a>`, template.HTMLEscapeString(site+wk.pageURL()), template.HTMLEscapeString(src), template.HTMLEscapeString(wk.FileName))
+	}
+
+	if wk.WMake != nil && wk.WModel != nil {
+		fmt.Fprintf(&b, `<p>Taken with a %s %s</p>`, template.HTMLEscapeString(wk.WMake.DisplayName), template.HTMLEscapeString(wk.WModel.DisplayName))
+	}
+
+	return b.String()
+}
+
+// format a capture date for Atom - dates from the feed carry no time zone and are taken as UTC
+func atomTime(t time.Time) string {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC).Format(time.RFC3339)
+}
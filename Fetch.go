@@ -0,0 +1,471 @@
+// remote fetching: the Fetcher abstraction used for the works feed and image downloads, and the shared HTTP client behind it, with politeness controls
+// (requests-per-second limit, per-host concurrency cap and a descriptive User-Agent) so large builds don't hammer the upstream server,
+// and proxy/TLS settings for APIs behind corporate proxies or private certificate authorities.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// User-Agent sent with every feed and image request unless overridden
+const defaultUserAgent = "GoXMLProcessor/1.0 (+https://github.com/astdb/GoXMLProcessor)"
+
+// type struct representing the politeness, proxy and TLS settings for remote fetches
+type fetchOptions struct {
+	Rate      float64 // maximum requests started per second across all hosts (0 for no limit)
+	PerHost   int     // maximum requests in flight to any one host (0 for no limit)
+	UserAgent string
+
+	Proxy              string // proxy URL for all requests (default: HTTP_PROXY/HTTPS_PROXY/NO_PROXY from the environment)
+	CACert             string // PEM bundle of extra certificate authorities to trust, on top of the system ones
+	ClientCert         string // PEM client certificate for mutual TLS
+	ClientKey          string // PEM private key of the client certificate
+	InsecureSkipVerify bool   // don't verify server certificates (for testing only)
+
+	Retries int // further attempts at an image download after a transient failure (connection error, 5xx, 429, short or corrupt body)
+}
+
+// a Fetcher retrieves the content at a URL (or path) - the works feed and image downloads all go through one, so tests and custom
+// transports (signed URLs, SDK clients) can be injected by replacing the package-level fetcher
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) (io.ReadCloser, error)
+}
+
+// optional Fetcher extension for fetching only the first n bytes (e.g. to read image headers) without transferring the whole file
+type rangeFetcher interface {
+	FetchHead(ctx context.Context, url string, n int) (io.ReadCloser, error)
+}
+
+// optional Fetcher extension for resuming downloads: fetch the file from byte offset on (if the server still has the version
+// identified by validator, an ETag or Last-Modified date), along with what the server says about the whole file
+type resumeFetcher interface {
+	FetchFrom(ctx context.Context, url string, offset int64, validator string) (*fetchedBody, error)
+}
+
+// type struct representing a (possibly partial) download and what the server said about the whole file
+type fetchedBody struct {
+	io.ReadCloser
+	Offset    int64             // byte offset of the body within the file (0 when the server sent the whole file)
+	Total     int64             // size of the whole file (-1 if the server didn't say)
+	Validator string            // ETag or Last-Modified date of this version of the file ("" if the server sent neither)
+	Digests   map[string][]byte // checksums of the whole file sent by the server, keyed by algorithm ("md5" or "sha-256")
+}
+
+// type struct representing an HTTP response with an unexpected status
+type httpStatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *httpStatusError) Error() string {
+	return "HTTP " + e.Status
+}
+
+// HTTP client used for every request to the works API and image hosts - replaced by configureFetching
+var httpClient = &http.Client{Transport: newPoliteTransport(http.DefaultTransport, fetchOptions{UserAgent: defaultUserAgent})}
+
+// fetcher used for the works feed and images: http(s) URLs go to the shared HTTP client, anything else is read from the local filesystem
+var fetcher Fetcher = &SchemeFetcher{HTTP: &HTTPFetcher{}, File: &FileFetcher{}}
+
+// type struct representing a Fetcher over HTTP(S), using the shared client (or Client, if set) and treating any status but 200 as an error
+type HTTPFetcher struct {
+	Client *http.Client
+}
+
+func (f *HTTPFetcher) client() *http.Client {
+	if f.Client != nil {
+		return f.Client
+	}
+
+	return httpClient
+}
+
+func (f *HTTPFetcher) Fetch(ctx context.Context, uri string) (io.ReadCloser, error) {
+	return f.get(ctx, uri, "")
+}
+
+// fetch the first n bytes with a ranged GET (servers that ignore the Range header send the whole file, so only n bytes are passed on)
+func (f *HTTPFetcher) FetchHead(ctx context.Context, uri string, n int) (io.ReadCloser, error) {
+	body, err := f.get(ctx, uri, "bytes=0-"+strconv.Itoa(n-1))
+	if err != nil {
+		return nil, err
+	}
+
+	return &limitedReadCloser{Reader: io.LimitReader(body, int64(n)), Closer: body}, nil
+}
+
+func (f *HTTPFetcher) get(ctx context.Context, uri, byteRange string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+
+	resp, err := f.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK && !(byteRange != "" && resp.StatusCode == http.StatusPartialContent) {
+		resp.Body.Close()
+		return nil, &httpStatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	return resp.Body, nil
+}
+
+// fetch the file from offset on with a ranged GET - a server that ignores the range, or whose copy no longer matches validator,
+// sends the whole file, which the returned Offset of 0 tells the caller
+func (f *HTTPFetcher) FetchFrom(ctx context.Context, uri string, offset int64, validator string) (*fetchedBody, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+		if validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+	}
+
+	resp, err := f.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body := &fetchedBody{ReadCloser: resp.Body, Total: resp.ContentLength, Validator: resp.Header.Get("ETag")}
+	if body.Validator == "" {
+		body.Validator = resp.Header.Get("Last-Modified")
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		// Content-MD5 is the checksum of the body sent, so it only covers the whole file in a full response - and none of the
+		// checksums match a body the transport has transparently decompressed
+		if !resp.Uncompressed {
+			body.Digests = responseDigests(resp.Header, true)
+		}
+
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		// Content-Range: bytes <first>-<last>/<total or *>
+		var first, last int64
+		var total string
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%s", &first, &last, &total); err != nil || first != offset {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unexpected Content-Range %q for a download resumed at byte %d", resp.Header.Get("Content-Range"), offset)
+		}
+
+		body.Offset = first
+		body.Total = -1
+		if n, err := strconv.ParseInt(total, 10, 64); err == nil {
+			body.Total = n
+		}
+		body.Digests = responseDigests(resp.Header, false)
+
+	default:
+		resp.Body.Close()
+		return nil, &httpStatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	return body, nil
+}
+
+// return the checksums of the whole file a response carries: Digest (RFC 3230) and Google Cloud Storage's x-goog-hash, plus
+// Content-MD5 when the response is the whole file
+func responseDigests(h http.Header, wholeFile bool) map[string][]byte {
+	digests := map[string][]byte{}
+
+	var values []string
+	for _, header := range []string{"Digest", "X-Goog-Hash"} {
+		for _, v := range h.Values(header) {
+			values = append(values, strings.Split(v, ",")...)
+		}
+	}
+	if md5 := h.Get("Content-MD5"); md5 != "" && wholeFile {
+		values = append(values, "md5="+md5)
+	}
+
+	for _, v := range values {
+		algorithm, encoded, ok := strings.Cut(strings.TrimSpace(v), "=")
+		if !ok {
+			continue
+		}
+
+		algorithm = strings.ToLower(algorithm)
+		if algorithm != "md5" && algorithm != "sha-256" {
+			continue
+		}
+
+		if sum, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+			digests[algorithm] = sum
+		}
+	}
+
+	return digests
+}
+
+// type struct representing a Fetcher for the local filesystem - accepts file:// URLs and plain paths (relative paths are resolved against Root)
+type FileFetcher struct {
+	Root string
+}
+
+func (f *FileFetcher) Fetch(ctx context.Context, uri string) (io.ReadCloser, error) {
+	path := strings.TrimPrefix(uri, "file://")
+	if f.Root != "" && !strings.HasPrefix(path, "/") {
+		path = f.Root + "/" + path
+	}
+
+	return os.Open(path)
+}
+
+// type struct representing a Fetcher serving fixed content from memory, keyed by URL - for tests and embedded data
+type MemoryFetcher struct {
+	Files map[string][]byte
+}
+
+func (f *MemoryFetcher) Fetch(ctx context.Context, uri string) (io.ReadCloser, error) {
+	data, ok := f.Files[uri]
+	if !ok {
+		return nil, fmt.Errorf("%s: not found", uri)
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// type struct representing a Fetcher that hands http(s) URLs to one fetcher and everything else to another
+type SchemeFetcher struct {
+	HTTP Fetcher
+	File Fetcher
+}
+
+func (f *SchemeFetcher) pick(uri string) Fetcher {
+	if strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
+		return f.HTTP
+	}
+
+	return f.File
+}
+
+func (f *SchemeFetcher) Fetch(ctx context.Context, uri string) (io.ReadCloser, error) {
+	return f.pick(uri).Fetch(ctx, uri)
+}
+
+func (f *SchemeFetcher) FetchHead(ctx context.Context, uri string, n int) (io.ReadCloser, error) {
+	return fetchHeadWith(ctx, f.pick(uri), uri, n)
+}
+
+func (f *SchemeFetcher) FetchFrom(ctx context.Context, uri string, offset int64, validator string) (*fetchedBody, error) {
+	return fetchFromWith(ctx, f.pick(uri), uri, offset, validator)
+}
+
+// fetch the first n bytes of a URL, with a ranged request if the fetcher supports it
+func fetchHeadWith(ctx context.Context, f Fetcher, uri string, n int) (io.ReadCloser, error) {
+	if rf, ok := f.(rangeFetcher); ok {
+		return rf.FetchHead(ctx, uri, n)
+	}
+
+	body, err := f.Fetch(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return &limitedReadCloser{Reader: io.LimitReader(body, int64(n)), Closer: body}, nil
+}
+
+// fetch a URL from byte offset on if the fetcher can resume downloads, or else the whole file
+func fetchFromWith(ctx context.Context, f Fetcher, uri string, offset int64, validator string) (*fetchedBody, error) {
+	if rf, ok := f.(resumeFetcher); ok {
+		return rf.FetchFrom(ctx, uri, offset, validator)
+	}
+
+	body, err := f.Fetch(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fetchedBody{ReadCloser: body, Total: -1}, nil
+}
+
+// type struct representing a size-limited view of a body that still closes the underlying one
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// define the flags of the politeness, proxy and TLS settings - shared by the build and the commands reaching the same hosts (e.g. doctor)
+func fetchFlags(fs *flag.FlagSet, opts *fetchOptions) {
+	fs.Float64Var(&opts.Rate, "rate", 0, "maximum feed/image requests per second across all hosts (0 for no limit)")
+	fs.IntVar(&opts.PerHost, "per-host", 4, "maximum feed/image requests in flight to any one host (0 for no limit)")
+	fs.StringVar(&opts.UserAgent, "user-agent", defaultUserAgent, "User-Agent header sent with feed and image requests")
+	fs.StringVar(&opts.Proxy, "proxy", "", "proxy URL for feed and image requests (default: HTTP_PROXY/HTTPS_PROXY/NO_PROXY from the environment)")
+	fs.StringVar(&opts.CACert, "ca-cert", "", "PEM file of additional certificate authorities to trust (e.g. a corporate private CA)")
+	fs.StringVar(&opts.ClientCert, "client-cert", "", "PEM client certificate for servers that require mutual TLS")
+	fs.StringVar(&opts.ClientKey, "client-key", "", "PEM private key for --client-cert")
+	fs.BoolVar(&opts.InsecureSkipVerify, "insecure-skip-verify", false, "don't verify TLS certificates of the API and image hosts (testing only)")
+}
+
+// apply the given politeness, proxy and TLS settings to all subsequent remote fetches
+func configureFetching(opts fetchOptions) error {
+	if opts.UserAgent == "" {
+		opts.UserAgent = defaultUserAgent
+	}
+
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.Proxy = http.ProxyFromEnvironment
+
+	if opts.Proxy != "" {
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return fmt.Errorf("Error parsing proxy URL (%s): %v", opts.Proxy, err)
+		}
+		base.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+
+	if opts.CACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		pem, err := os.ReadFile(opts.CACert)
+		if err != nil {
+			return fmt.Errorf("Error reading CA bundle (%s): %v", opts.CACert, err)
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("Error: no PEM certificates found in CA bundle (%s)", opts.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.ClientCert != "" || opts.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return fmt.Errorf("Error loading client certificate (%s, %s): %v", opts.ClientCert, opts.ClientKey, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts.InsecureSkipVerify {
+		fmt.Fprintln(os.Stderr, "Warning: TLS certificate verification is disabled (--insecure-skip-verify)")
+	}
+
+	base.TLSClientConfig = tlsConfig
+	httpClient = &http.Client{Transport: newPoliteTransport(base, opts)}
+	downloadRetries = opts.Retries
+	return nil
+}
+
+// type struct representing an http.RoundTripper that throttles and labels the requests it sends
+type politeTransport struct {
+	base      http.RoundTripper
+	userAgent string
+	perHost   int
+
+	mu       sync.Mutex
+	interval time.Duration            // minimum time between request starts
+	next     time.Time                // earliest start time of the next request
+	hosts    map[string]chan struct{} // per-host semaphores
+}
+
+// create and return a pointer to a polite transport wrapping base
+func newPoliteTransport(base http.RoundTripper, opts fetchOptions) *politeTransport {
+	t := &politeTransport{base: base, userAgent: opts.UserAgent, perHost: opts.PerHost, hosts: map[string]chan struct{}{}}
+	if opts.Rate > 0 {
+		t.interval = time.Duration(float64(time.Second) / opts.Rate)
+	}
+
+	return t
+}
+
+func (t *politeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+
+	t.waitForTurn()
+
+	release := t.acquireHost(req.URL.Host)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	// the host slot stays taken until the caller is done reading the body
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// block until the rate limit allows another request to start
+func (t *politeTransport) waitForTurn() {
+	if t.interval == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	now := time.Now()
+	start := t.next
+	if start.Before(now) {
+		start = now
+	}
+	t.next = start.Add(t.interval)
+	t.mu.Unlock()
+
+	time.Sleep(time.Until(start))
+}
+
+// take one of the host's concurrency slots, blocking while all are in use, and return the function that gives it back
+func (t *politeTransport) acquireHost(host string) func() {
+	if t.perHost <= 0 {
+		return func() {}
+	}
+
+	t.mu.Lock()
+	slots, ok := t.hosts[host]
+	if !ok {
+		slots = make(chan struct{}, t.perHost)
+		t.hosts[host] = slots
+	}
+	t.mu.Unlock()
+
+	slots <- struct{}{}
+
+	var once sync.Once
+	return func() { once.Do(func() { <-slots }) }
+}
+
+// type struct representing a response body that frees its host slot when closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
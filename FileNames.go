@@ -0,0 +1,59 @@
+// file name safety: a site generated on Linux is often deployed to Windows/IIS hosts, whose file systems reject names Linux allows.
+// Page slugs are kept clear of the reserved device names (CON, PRN, AUX, NUL, COM1-9, LPT1-9 - with any extension) and cut to a
+// length that leaves room for the host's own directories under the 260 character path limit, and the files a build writes are
+// checked against that limit.
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// longest page slug - longer ones are cut and given a hash of the full slug so they stay unique
+const maxSlugLength = 100
+
+// longest path (relative to the output directory) of a generated file, leaving room for the directory it's deployed to under
+// Windows' 260 character limit
+const maxSitePathLength = 200
+
+// device names Windows reserves in every directory, whatever the extension
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// return the slug made safe as a file name on Windows as well as Linux: a reserved device name gets a trailing dash, and an
+// overlong slug is cut and ends in a hash of the full slug
+func safeFileName(slug string) string {
+	if len(slug) > maxSlugLength {
+		sum := sha1.Sum([]byte(slug))
+		slug = strings.TrimRight(slug[:maxSlugLength-9], "-") + "-" + hex.EncodeToString(sum[:4])
+	}
+
+	base, _, _ := strings.Cut(slug, ".")
+	if windowsReservedNames[strings.ToUpper(base)] {
+		slug = base + "-" + strings.TrimPrefix(slug, base)
+	}
+
+	return slug
+}
+
+// warn about written files whose paths are too long to deploy safely to Windows hosts
+func checkPathLengths(files []string) {
+	long := 0
+	for _, f := range files {
+		if len(f) > maxSitePathLength {
+			fmt.Fprintf(os.Stderr, "Path too long for Windows hosts (%d characters, at most %d): %s\n", len(f), maxSitePathLength, f)
+			long++
+		}
+	}
+
+	if long > 0 {
+		fmt.Fprintf(os.Stderr, "%d files have paths that may not deploy to Windows hosts - shorten the make/model names or the output layout.\n", long)
+	}
+}
@@ -0,0 +1,130 @@
+// flagged content: works the feed marks as sensitive - with <flagged>, or a <rating> the config lists - and works the overrides file
+// flags (flagged: true, or false to clear the feed's flag - see Overrides.go) are handled the way the config file's "flagged"
+// section says:
+//
+//	"flagged": {"mode": "section", "ratings": ["mature", "explicit", "r18"]}
+//
+// With blur (the default) they're shown like any other work, their images blurred until clicked. With exclude they're left out of
+// the site like the exclusion list's works (see Exclusions.go). With section they're taken out of every gallery, slideshow, feed
+// and listing and shown on a page of their own (flagged.html, linked from the homepage) behind a notice the visitor has to open -
+// their images blurred there too, and their work pages only linked from it.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// file name of the page of flagged works in section mode
+const flaggedPageFile = "flagged.html"
+
+// ratings flagging a work when the config lists none
+var defaultFlaggedRatings = []string{"mature", "explicit", "adult", "nsfw"}
+
+// type struct representing the config file's handling of flagged works
+type flaggedContent struct {
+	Mode    string   `json:"mode"`    // blur, exclude or section ("" for blur)
+	Ratings []string `json:"ratings"` // <rating>s that flag a work, matched case-insensitively (none for the default ones)
+}
+
+// return an error if the mode isn't one there is
+func (f flaggedContent) check() error {
+	switch f.Mode {
+	case "", "blur", "exclude", "section":
+		return nil
+	}
+
+	return fmt.Errorf("Error in flagged config: unknown mode %q (expected blur, exclude or section)", f.Mode)
+}
+
+// return whether a work's rating flags it
+func (f flaggedContent) flagsRating(rating string) bool {
+	ratings := f.Ratings
+	if len(ratings) == 0 {
+		ratings = defaultFlaggedRatings
+	}
+
+	for _, r := range ratings {
+		if strings.EqualFold(strings.TrimSpace(r), strings.TrimSpace(rating)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// work out which works are flagged - from the feed, their rating and the overrides file, which has the last word - and, in exclude
+// mode, remove them from the catalog, recording them for the build manifest
+func applyFlagged(catalog *Catalog, flagged flaggedContent, overrides *pageOverrides) {
+	var ids []string
+	for _, wk := range append([]*Work(nil), catalog.Works...) {
+		if wk == nil {
+			continue
+		}
+
+		rule := ""
+		switch {
+		case wk.Flagged:
+			rule = "flagged"
+		case wk.Rating != "" && flagged.flagsRating(wk.Rating):
+			rule = "rating " + wk.Rating
+		}
+		if overrides != nil {
+			if o, ok := overrides.Works[wk.ID]; ok && o.Flagged != nil {
+				rule = ""
+				if *o.Flagged {
+					rule = "flagged"
+				}
+			}
+		}
+
+		wk.Flagged = rule != ""
+		if !wk.Flagged {
+			continue
+		}
+		ids = append(ids, wk.ID.String())
+
+		if flagged.Mode == "exclude" {
+			catalog.removeWork(wk)
+			catalog.Excluded = append(catalog.Excluded, excludedWork{ID: wk.ID, Rule: rule})
+		}
+	}
+
+	if len(ids) == 0 {
+		return
+	}
+
+	switch flagged.Mode {
+	case "exclude":
+		fmt.Printf("Flagged: %d works excluded (ids %s).\n", len(ids), strings.Join(ids, ", "))
+	case "section":
+		fmt.Printf("Flagged: %d works moved to %s (ids %s).\n", len(ids), flaggedPageFile, strings.Join(ids, ", "))
+	default:
+		fmt.Printf("Flagged: %d works blurred (ids %s).\n", len(ids), strings.Join(ids, ", "))
+	}
+}
+
+// move the flagged works out of the catalog into its flagged section, so no gallery, slideshow, feed or listing shows them - done
+// once their images have been processed like the others'
+func (c *Catalog) separateFlagged() {
+	for _, wk := range append([]*Work(nil), c.Works...) {
+		if wk != nil && wk.Flagged {
+			c.removeWork(wk)
+			c.Flagged = append(c.Flagged, wk)
+		}
+	}
+}
+
+// write the page of the flagged section, if it has any works, returning whether it was written
+func generateFlaggedPage(site *siteWriter, catalog *Catalog, assets *siteAssets, layout pageLayout) bool {
+	if len(catalog.Flagged) == 0 {
+		return false
+	}
+
+	if err := site.writeGallery(flaggedPageFile, "flagged", catalog.Flagged, layout, &pageView{Title: "Sensitive photos", Assets: assets}); err != nil {
+		site.fail(fmt.Errorf("Error writing output to flagged works file (%s): %v", flaggedPageFile, err))
+	}
+
+	return true
+}
@@ -0,0 +1,301 @@
+// GraphQL works source: runs a configured query against a GraphQL endpoint (following pagination cursors) and maps fields of the
+// JSON response onto works, for CMS backends that don't expose an XML feed. Selected with the "graphql:" source, e.g.
+//
+//	>go run ImageProcessor --config site.json graphql: code/html/output
+//
+// with the endpoint, query and field mapping in the config file's "graphql" section:
+//
+//	"graphql": {
+//	  "endpoint": "https://cms.example.com/graphql",
+//	  "query": "query($after: String) { photos(first: 100, after: $after) { nodes { id file camera { make model } takenAt thumb } pageInfo { hasNextPage endCursor } } }",
+//	  "headers_env": {"Authorization": "CMS_TOKEN"},
+//	  "items": "photos.nodes",
+//	  "page_info": "photos.pageInfo",
+//	  "mapping": {"filename": "file", "make": "camera.make", "model": "camera.model", "date": "takenAt", "small": "thumb"}
+//	}
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerFeedSource("graphql", openGraphQLFeed)
+}
+
+// most pages followed in one read, in case an endpoint keeps reporting another page
+const graphQLMaxPages = 10000
+
+// GraphQL settings from the config file (nil if the config has no "graphql" section)
+var graphQLSource *graphQLConfig
+
+// type struct representing the "graphql" section of the config file
+type graphQLConfig struct {
+	Endpoint       string                 `json:"endpoint"`        // URL the query is POSTed to (a URL after "graphql:" overrides it)
+	Query          string                 `json:"query"`           // the query document
+	QueryFile      string                 `json:"query_file"`      // file to read the query from instead
+	Variables      map[string]interface{} `json:"variables"`       // variables sent with every request
+	Headers        map[string]string      `json:"headers"`         // extra request headers
+	HeadersEnv     map[string]string      `json:"headers_env"`     // extra request headers whose values come from these environment variables (e.g. tokens)
+	Items          string                 `json:"items"`           // dot path from the response's "data" to the list of works, e.g. "photos.nodes" or "photos.edges"
+	PageInfo       string                 `json:"page_info"`       // dot path to the Relay-style {hasNextPage, endCursor} object (omit for unpaginated queries)
+	CursorVariable string                 `json:"cursor_variable"` // query variable the end cursor is passed in (default "after")
+	Mapping        map[string]string      `json:"mapping"`         // work field -> dot path within each item (fields left out are read from the item field of the same name)
+}
+
+// work fields a GraphQL mapping can set
+var graphQLFields = []string{"id", "filename", "make", "model", "lens", "author", "date", "small", "medium", "large", "featured", "dominant_color"}
+
+// read every page of the configured query and return the works as an XML feed
+func openGraphQLFeed(location string) (io.ReadCloser, error) {
+	if graphQLSource == nil {
+		return nil, fmt.Errorf("the graphql: source needs a \"graphql\" section in the --config file")
+	}
+
+	cfg := *graphQLSource
+	if location != "" {
+		cfg.Endpoint = location
+	}
+
+	works, err := cfg.fetchWorks(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	catalog, err := catalogFromExport(works)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading GraphQL works: %v", err)
+	}
+
+	var feed bytes.Buffer
+	if err := writeWorksXML(&feed, catalog); err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(&feed), nil
+}
+
+// run the query once per page and map each item to a work
+func (cfg *graphQLConfig) fetchWorks(ctx context.Context) ([]exportWork, error) {
+	if cfg.Endpoint == "" || cfg.Items == "" {
+		return nil, fmt.Errorf("Error in graphql config: endpoint and items are required")
+	}
+
+	known := map[string]bool{}
+	for _, field := range graphQLFields {
+		known[field] = true
+	}
+	for field := range cfg.Mapping {
+		if !known[field] {
+			return nil, fmt.Errorf("Error in graphql config: unknown mapping field %q (expected %s)", field, strings.Join(graphQLFields, ", "))
+		}
+	}
+
+	query := cfg.Query
+	if cfg.QueryFile != "" {
+		data, err := os.ReadFile(cfg.QueryFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading GraphQL query file: %v", err)
+		}
+		query = string(data)
+	}
+	if strings.TrimSpace(query) == "" {
+		return nil, fmt.Errorf("Error in graphql config: no query (set query or query_file)")
+	}
+
+	cursorVariable := cfg.CursorVariable
+	if cursorVariable == "" {
+		cursorVariable = "after"
+	}
+
+	var works []exportWork
+	var cursor interface{} // nil for the first page
+
+	for page := 1; ; page++ {
+		variables := map[string]interface{}{}
+		for k, v := range cfg.Variables {
+			variables[k] = v
+		}
+		if cfg.PageInfo != "" {
+			variables[cursorVariable] = cursor
+		}
+
+		data, err := cfg.post(ctx, query, variables)
+		if err != nil {
+			return nil, fmt.Errorf("Error querying GraphQL endpoint %s (page %d): %v", cfg.Endpoint, page, err)
+		}
+
+		items, ok := jsonPath(data, cfg.Items).([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Error reading GraphQL response (page %d): %q is not a list", page, cfg.Items)
+		}
+
+		for i, item := range items {
+			w, err := cfg.mapWork(item)
+			if err != nil {
+				return nil, fmt.Errorf("Error reading GraphQL response (page %d, item %d): %v", page, i+1, err)
+			}
+			works = append(works, w)
+		}
+
+		if cfg.PageInfo == "" {
+			break
+		}
+
+		info, _ := jsonPath(data, cfg.PageInfo).(map[string]interface{})
+		if hasNext, _ := info["hasNextPage"].(bool); !hasNext {
+			break
+		}
+
+		next := info["endCursor"]
+		if next == nil || fmt.Sprint(next) == fmt.Sprint(cursor) {
+			return nil, fmt.Errorf("Error reading GraphQL response (page %d): hasNextPage is set but the end cursor didn't advance", page)
+		}
+		if page >= graphQLMaxPages {
+			return nil, fmt.Errorf("Error reading GraphQL response: more than %d pages", graphQLMaxPages)
+		}
+		cursor = next
+	}
+
+	return works, nil
+}
+
+// POST the query and return the response's "data" - GraphQL errors are returned as an error even when some data came back
+func (cfg *graphQLConfig) post(ctx context.Context, query string, variables map[string]interface{}) (interface{}, error) {
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	for name, value := range cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	for name, env := range cfg.HeadersEnv {
+		req.Header.Set(name, os.Getenv(env))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Data   interface{} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&result); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("HTTP status %s", resp.Status)
+		}
+		return nil, fmt.Errorf("unreadable response: %v", err)
+	}
+
+	if len(result.Errors) > 0 {
+		var messages []string
+		for _, e := range result.Errors {
+			messages = append(messages, e.Message)
+		}
+		return nil, fmt.Errorf("%s", strings.Join(messages, "; "))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP status %s", resp.Status)
+	}
+
+	return result.Data, nil
+}
+
+// map one item of the response to a work
+func (cfg *graphQLConfig) mapWork(item interface{}) (exportWork, error) {
+	value := func(field string) string {
+		p, ok := cfg.Mapping[field]
+		if !ok {
+			p = field
+		}
+
+		switch v := jsonPath(item, p).(type) {
+		case nil:
+			return ""
+		case string:
+			return strings.TrimSpace(v)
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+
+	w := exportWork{
+		FileName:      value("filename"),
+		Make:          value("make"),
+		Model:         value("model"),
+		Lens:          value("lens"),
+		Author:        value("author"),
+		URISmall:      value("small"),
+		URIMedium:     value("medium"),
+		URILarge:      value("large"),
+		DominantColor: value("dominant_color"),
+	}
+
+	id, err := parseWorkID(value("id"))
+	if err != nil {
+		return w, err
+	}
+	w.ID = id
+
+	if date := value("date"); date != "" {
+		if t, err := parseDate(date); err == nil {
+			w.Date = t.Format("2006-01-02T15:04:05")
+		} else {
+			fmt.Fprintf(os.Stderr, "Ignoring unreadable date (%s) of work %s\n", date, id)
+		}
+	}
+
+	w.Featured, _ = strconv.ParseBool(value("featured"))
+
+	return w, nil
+}
+
+// follow a dot-separated path of object keys and list indexes (e.g. "photos.edges" or "node.images.0.url") through decoded JSON -
+// nil if any step is missing
+func jsonPath(v interface{}, p string) interface{} {
+	if p == "" {
+		return v
+	}
+
+	for _, key := range strings.Split(p, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			v = node[i]
+		default:
+			return nil
+		}
+	}
+
+	return v
+}
@@ -0,0 +1,165 @@
+// HTML validation: an optional pass over the pages a build wrote, reporting unclosed and stray tags, duplicate ids and images
+// without alt text (--validate-html). With --strict any problem fails the build before the manifest is written and the
+// after-write hooks run, so a broken site isn't published. Pages are read with encoding/xml's lenient HTML mode (void elements,
+// unquoted attributes, HTML entities) and the nesting is checked here, since that mode would otherwise close tags silently.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// elements that never have an end tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true, "input": true,
+	"link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// type struct representing one problem found in a generated page
+type htmlIssue struct {
+	File         string // page file name, relative to the output directory
+	Line, Column int
+	Message      string
+}
+
+// type struct representing an element waiting for its end tag
+type openElement struct {
+	name         string
+	line, column int
+}
+
+// check every HTML file among the written files and return the problems found, in file order
+func validateHTML(outputFolderLocation string, written []string) ([]htmlIssue, int, error) {
+	var issues []htmlIssue
+	checked := 0
+
+	for _, file := range written {
+		if !strings.HasSuffix(file, ".html") {
+			continue
+		}
+
+		f, err := os.Open(filepath.Join("./"+outputFolderLocation, filepath.FromSlash(file)))
+		if err != nil {
+			return nil, checked, fmt.Errorf("Error reading page for HTML validation: %v", err)
+		}
+
+		issues = append(issues, validatePage(file, f)...)
+		f.Close()
+		checked++
+	}
+
+	return issues, checked, nil
+}
+
+// check one page's nesting, ids and image alt attributes
+func validatePage(file string, page io.Reader) []htmlIssue {
+	dec := xml.NewDecoder(page)
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	var issues []htmlIssue
+	var stack []openElement
+	ids := map[string]openElement{} // id -> the element that used it first
+
+	report := func(line, column int, format string, args ...interface{}) {
+		issues = append(issues, htmlIssue{File: file, Line: line, Column: column, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for {
+		line, column := dec.InputPos()
+		token, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			report(line, column, "unreadable HTML: %v", err)
+			return issues
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+
+			hasAlt := false
+			for _, attr := range t.Attr {
+				switch strings.ToLower(attr.Name.Local) {
+				case "id":
+					if first, ok := ids[attr.Value]; ok {
+						report(line, column, "duplicate id %q (first used at %d:%d)", attr.Value, first.line, first.column)
+					} else {
+						ids[attr.Value] = openElement{name: name, line: line, column: column}
+					}
+				case "alt":
+					hasAlt = true
+				}
+			}
+
+			if name == "img" && !hasAlt {
+				src := ""
+				for _, attr := range t.Attr {
+					if strings.ToLower(attr.Name.Local) == "src" {
+						src = attr.Value
+					}
+				}
+				report(line, column, "<img src=%q> has no alt attribute", src)
+			}
+
+			if !voidElements[name] {
+				stack = append(stack, openElement{name: name, line: line, column: column})
+			}
+
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			if voidElements[name] {
+				// the lenient decoder reports <img .../> as a start and an end - the start never went on the stack
+				continue
+			}
+
+			match := len(stack) - 1
+			for match >= 0 && stack[match].name != name {
+				match--
+			}
+
+			if match < 0 {
+				report(line, column, "stray </%s> with no matching start tag", name)
+				continue
+			}
+
+			for _, el := range stack[match+1:] {
+				report(el.line, el.column, "<%s> is not closed before </%s> at %d:%d", el.name, name, line, column)
+			}
+			stack = stack[:match]
+		}
+	}
+
+	for _, el := range stack {
+		report(el.line, el.column, "<%s> is never closed", el.name)
+	}
+
+	return issues
+}
+
+// validate the written pages and print the problems - in strict mode any problem is returned as an error
+func checkGeneratedHTML(outputFolderLocation string, written []string, strict bool) error {
+	issues, checked, err := validateHTML(outputFolderLocation, written)
+	if err != nil {
+		return err
+	}
+
+	for _, is := range issues {
+		fmt.Fprintf(os.Stderr, "%s:%d:%d: %s\n", is.File, is.Line, is.Column, is.Message)
+	}
+	fmt.Printf("HTML validation: %d pages checked, %d problems.\n", checked, len(issues))
+
+	if strict && len(issues) > 0 {
+		return fmt.Errorf("Error: HTML validation found %d problems in the generated pages (--strict)", len(issues))
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+// build hooks: lifecycle stages around the build pipeline where Go code compiled into the binary, or external commands given with
+// --hook-cmd, can enrich the catalog or post-process generated files without forking the generator.
+//
+// Go hooks are registered from an init function in any file added to the package, e.g.
+//
+//	func init() {
+//		onAfterParse(func(catalog *Catalog) error { ... })
+//	}
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// lifecycle stages hooks can be registered for
+const (
+	HookAfterParse       = "after-parse"        // the feed has been parsed - hooks may change the catalog
+	HookBeforeRenderPage = "before-render-page" // a page is about to be written - hooks may change its HTML
+	HookAfterWrite       = "after-write"        // every page has been written - hooks may post-process the files
+)
+
+// type struct representing the hooks registered for each build stage, run in registration order
+type buildHooks struct {
+	afterParse       []func(catalog *Catalog) error
+	beforeRenderPage []func(page *Page) error
+	afterWrite       []func(outputFolderLocation string, files []string) error
+}
+
+// hooks run by every build
+var hooks buildHooks
+
+// register a hook run with the catalog once the feed has been parsed
+func onAfterParse(fn func(catalog *Catalog) error) {
+	hooks.afterParse = append(hooks.afterParse, fn)
+}
+
+// register a hook run with each page just before it is written
+func onBeforeRenderPage(fn func(page *Page) error) {
+	hooks.beforeRenderPage = append(hooks.beforeRenderPage, fn)
+}
+
+// register a hook run with the output directory and the paths (relative to it) of every file the build wrote
+func onAfterWrite(fn func(outputFolderLocation string, files []string) error) {
+	hooks.afterWrite = append(hooks.afterWrite, fn)
+}
+
+func runAfterParseHooks(catalog *Catalog) error {
+	for _, fn := range hooks.afterParse {
+		if err := fn(catalog); err != nil {
+			return fmt.Errorf("Error in %s hook: %v", HookAfterParse, err)
+		}
+	}
+
+	return nil
+}
+
+func runBeforeRenderPageHooks(page *Page) error {
+	for _, fn := range hooks.beforeRenderPage {
+		if err := fn(page); err != nil {
+			return fmt.Errorf("Error in %s hook for %s: %v", HookBeforeRenderPage, page.Path, err)
+		}
+	}
+
+	return nil
+}
+
+func runAfterWriteHooks(outputFolderLocation string, files []string) error {
+	for _, fn := range hooks.afterWrite {
+		if err := fn(outputFolderLocation, files); err != nil {
+			return fmt.Errorf("Error in %s hook: %v", HookAfterWrite, err)
+		}
+	}
+
+	return nil
+}
+
+//----------------- external hook commands -------------------------------
+
+// type struct representing the --hook-cmd flag: repeatable stage=command pairs
+type hookCommandFlag []string
+
+func (f *hookCommandFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *hookCommandFlag) Set(value string) error {
+	stage, cmd, ok := strings.Cut(value, "=")
+	if !ok || strings.TrimSpace(cmd) == "" {
+		return fmt.Errorf("expected stage=command (e.g. %s=./enrich.sh)", HookAfterParse)
+	}
+
+	if err := registerHookCommand(stage, cmd); err != nil {
+		return err
+	}
+
+	*f = append(*f, value)
+	return nil
+}
+
+// register an external command as a hook for the given stage. The command is run through the shell with IMGPROC_HOOK set to the stage and:
+//   - after-parse: receives the catalog as JSON (in the export format) on stdin; if it prints JSON on stdout, that replaces the catalog
+//     (with the excluded works already left out, and before the overrides and popularity data are applied to it)
+//   - before-render-page: receives the page HTML on stdin (IMGPROC_PAGE and IMGPROC_PAGE_KIND name it); non-empty stdout replaces the HTML
+//   - after-write: receives the written file paths on stdin, one per line, relative to IMGPROC_OUTPUT_DIR
+func registerHookCommand(stage, cmd string) error {
+	switch stage {
+	case HookAfterParse:
+		onAfterParse(func(catalog *Catalog) error {
+			var in bytes.Buffer
+			if err := writeJSONExport(&in, catalog); err != nil {
+				return err
+			}
+
+			out, err := runHookCommand(cmd, stage, &in, nil)
+			if err != nil || len(bytes.TrimSpace(out)) == 0 {
+				return err
+			}
+
+			if err := replaceCatalog(catalog, out); err != nil {
+				return fmt.Errorf("reading catalog printed by %q: %v", cmd, err)
+			}
+			return nil
+		})
+
+	case HookBeforeRenderPage:
+		onBeforeRenderPage(func(page *Page) error {
+			out, err := runHookCommand(cmd, stage, strings.NewReader(page.HTML), []string{"IMGPROC_PAGE=" + page.Path, "IMGPROC_PAGE_KIND=" + page.Kind})
+			if err != nil || len(bytes.TrimSpace(out)) == 0 {
+				return err
+			}
+
+			page.HTML = string(out)
+			return nil
+		})
+
+	case HookAfterWrite:
+		onAfterWrite(func(outputFolderLocation string, files []string) error {
+			in := strings.NewReader(strings.Join(files, "\n") + "\n")
+			out, err := runHookCommand(cmd, stage, in, []string{"IMGPROC_OUTPUT_DIR=" + outputFolderLocation})
+			os.Stdout.Write(out)
+			return err
+		})
+
+	default:
+		return fmt.Errorf("unknown hook stage %q (expected %s, %s or %s)", stage, HookAfterParse, HookBeforeRenderPage, HookAfterWrite)
+	}
+
+	return nil
+}
+
+// run a hook command through the shell with the given stdin and extra environment and return its stdout (its stderr is passed through)
+func runHookCommand(command, stage string, stdin io.Reader, env []string) ([]byte, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+
+	cmd.Env = append(append(os.Environ(), "IMGPROC_HOOK="+stage), env...)
+	cmd.Stdin = stdin
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%q: %v", command, err)
+	}
+
+	return out, nil
+}
+
+// replace the catalog with the one in a JSON export (the after-parse hook commands' output), keeping the record of excluded works for
+// the build manifest
+func replaceCatalog(catalog *Catalog, data []byte) error {
+	var doc struct {
+		Works []exportWork `json:"works"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	replaced, err := catalogFromExport(doc.Works)
+	if err != nil {
+		return err
+	}
+
+	replaced.Excluded = catalog.Excluded
+	*catalog = *replaced
+	return nil
+}
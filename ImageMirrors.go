@@ -0,0 +1,84 @@
+// image mirrors: rules in the config file's "image_mirrors" section rewrite image URIs as pages and feeds are rendered, so a feed
+// pointing at an internal image host still publishes links to the public CDN (downloads, probes and link checks keep using the feed's
+// URIs, which the build machine can reach). Each rule replaces a URI prefix, or a regular expression match ($1 ... for its groups),
+// and the first rule matching a URI wins:
+//
+//	"image_mirrors": [
+//	  {"prefix": "http://images.corp.internal/", "replace": "https://cdn.example.com/"},
+//	  {"pattern": "^https?://img([0-9]+)\\.corp\\.internal/", "replace": "https://cdn.example.com/$1/"}
+//	]
+//
+// Rewritten URIs are then signed (see SignedURLs.go) for the host they now point to.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// type struct representing one rule in the config file's "image_mirrors" section
+type imageMirrorConfig struct {
+	Prefix  string `json:"prefix"`  // URI prefix to replace
+	Pattern string `json:"pattern"` // regular expression to replace instead of a prefix
+	Replace string `json:"replace"` // what replaces the prefix or match
+}
+
+// type struct representing a configured mirror rule
+type imageMirror struct {
+	prefix  string
+	pattern *regexp.Regexp
+	replace string
+}
+
+// mirror rules configured for this build, tried in config order
+var imageMirrors []imageMirror
+
+// set up the mirror rules described in the configuration
+func configureImageMirrors(configs []imageMirrorConfig) error {
+	imageMirrors = nil
+
+	for i, c := range configs {
+		m := imageMirror{prefix: c.Prefix, replace: c.Replace}
+		switch {
+		case c.Prefix != "" && c.Pattern != "":
+			return fmt.Errorf("Error in image_mirrors config entry %d: give either prefix or pattern, not both", i+1)
+		case c.Pattern != "":
+			re, err := regexp.Compile(c.Pattern)
+			if err != nil {
+				return fmt.Errorf("Error in image_mirrors config entry %d: bad pattern %q: %v", i+1, c.Pattern, err)
+			}
+			m.pattern = re
+		case c.Prefix == "":
+			return fmt.Errorf("Error in image_mirrors config entry %d: prefix or pattern is required", i+1)
+		}
+
+		imageMirrors = append(imageMirrors, m)
+	}
+
+	return nil
+}
+
+// return the URI rewritten by the first mirror rule matching it - URIs no rule matches are returned as they are
+func mirrorURL(uri string) string {
+	for _, m := range imageMirrors {
+		if m.pattern != nil {
+			if loc := m.pattern.FindStringSubmatchIndex(uri); loc != nil {
+				return uri[:loc[0]] + string(m.pattern.ExpandString(nil, m.replace, uri, loc)) + uri[loc[1]:]
+			}
+			continue
+		}
+
+		if strings.HasPrefix(uri, m.prefix) {
+			return m.replace + strings.TrimPrefix(uri, m.prefix)
+		}
+	}
+
+	return uri
+}
+
+// return the URI a rendered page or feed links an image under: rewritten to its mirror, then signed
+func publishedImageURL(uri string) string {
+	return signURL(mirrorURL(uri))
+}
@@ -4,41 +4,684 @@ package main
 
 // the import statement makes sure all the required packages to run this program are included
 import (
+	"bytes"
+	"context"
 	"encoding/xml"
+	"flag"
 	"fmt"
-	"html"
+	"html/template"
 	"io"
-	"net/http"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func main() {
+	// if the first command-line argument names a subcommand (e.g. doctor), hand over to it and exit with its status code
+	if len(os.Args) > 1 {
+		if cmd := findCommand(os.Args[1]); cmd != nil {
+			os.Exit(cmd.run(os.Args[2:]))
+		}
+	}
+
+	os.Exit(runBuild(os.Args[1:]))
+}
+
+// the default (build) command, run when the first command-line argument names no subcommand
+var buildCommand = &command{
+	Name:    "build",
+	Usage:   "[flags] <image API URL> <output directory> | --from-snapshot <file> [flags] <output directory>",
+	Summary: "read works data from the API and generate the static site, once or repeatedly in watch mode",
+	Setup:   setupBuild,
+}
+
+// run the default (build) command: read works data from the API and generate the static site, once or repeatedly in watch mode
+func runBuild(args []string) int {
 	fmt.Println("Image processor starting...")
 
-	// expecting two command-line arguments at invocation - API location for reading image data from and output directory for writing static site files
-	if len(os.Args) <= 2 {
-		fmt.Println("Error: please enter the image API URL and an output directory location as command-line arguments (e.g. >go run ImageProcessor http://localhost/test/api/v1/works.xml code/html/output)")
-		return
+	return buildCommand.run(args)
+}
+
+// define the build's flags and return the function building the site from the positional arguments
+func setupBuild(fs *flag.FlagSet) func(positional []string) int {
+	return setupBuildWith(fs, nil)
+}
+
+// define the build's flags and return the function building the site - with serve set, the configured build is handed to serve to
+// run (and watch) instead of being built once or with --watch (see Serve.go)
+func setupBuildWith(fs *flag.FlagSet, serve func(job watchJob) int) func(positional []string) int {
+	watch := fs.Bool("watch", false, "keep running, polling the works source and regenerating the site whenever its content changes")
+	watchInterval := fs.Duration("watch-interval", 2*time.Second, "how often the works source is polled in watch mode")
+	debounce := fs.Duration("debounce", time.Second, "how long the works source must stay unchanged before a rebuild is started in watch mode")
+	configPath := fs.String("config", "", "path to a JSON configuration file (e.g. notification providers)")
+
+	opts := newBuildOptions()
+	fs.BoolVar(&opts.DownloadImages, "download-images", false, "download each work's small/medium/large images into <output-dir>/images and reference the local copies")
+	fs.IntVar(&opts.DownloadJobs, "download-concurrency", opts.DownloadJobs, "number of image downloads in flight at once (--per-host still caps each host)")
+	fs.IntVar(&opts.Fetch.Retries, "download-retries", 3, "further attempts at an image download after a transient failure, resuming where the last one stopped")
+	fs.BoolVar(&opts.Thumbnails.Regenerate, "regenerate-thumbs", false, "generate thumbnails from the large image for every work, not just those without a small URI")
+	fs.IntVar(&opts.Thumbnails.Width, "thumb-width", opts.Thumbnails.Width, "width in pixels of generated thumbnails")
+	fs.IntVar(&opts.Thumbnails.Height, "thumb-height", opts.Thumbnails.Height, "height in pixels of generated thumbnails")
+	fs.IntVar(&opts.Thumbnails.Quality, "thumb-quality", opts.Thumbnails.Quality, "JPEG quality (1-100) of generated thumbnails")
+	fs.StringVar(&opts.ImageFormats, "image-formats", "", "comma-separated extra formats (webp, avif) to transcode local images to, served through <picture> elements")
+	fs.IntVar(&opts.VariantQuality, "variant-quality", opts.VariantQuality, "encoder quality (0-100) for --image-formats variants")
+	fs.StringVar(&opts.Watermark.Text, "watermark-text", "", "text to draw onto the downloaded medium and large images, e.g. \"(c) Jane Doe\" (needs --download-images)")
+	fs.StringVar(&opts.Watermark.Image, "watermark-image", "", "PNG to draw onto the downloaded medium and large images (needs --download-images)")
+	fs.StringVar(&opts.Watermark.Position, "watermark-position", opts.Watermark.Position, "where the watermark goes: top-left, top-right, bottom-left, bottom-right or center")
+	fs.Float64Var(&opts.Watermark.Opacity, "watermark-opacity", opts.Watermark.Opacity, "watermark opacity, from 0 (invisible) to 1 (opaque)")
+	fs.BoolVar(&opts.Exif, "exif", false, "read EXIF metadata from each work's large image to fill in make, model, lens, settings, capture date and GPS position")
+	fs.StringVar(&opts.ExifPrecedence, "exif-precedence", opts.ExifPrecedence, "which wins when the feed and EXIF data disagree: feed (EXIF only fills gaps) or exif")
+	fs.BoolVar(&opts.CheckLinks, "check-links", false, "send a HEAD request to every small/medium/large image URI and report dead and redirected links")
+	fs.IntVar(&opts.LinkCheck.Concurrency, "check-concurrency", opts.LinkCheck.Concurrency, "number of link check requests in flight at once")
+	fs.Float64Var(&opts.LinkCheck.Rate, "check-rate", opts.LinkCheck.Rate, "maximum link check requests per second (0 for no limit)")
+	fs.BoolVar(&opts.LinkCheck.ExcludeBroken, "exclude-broken", false, "leave works whose thumbnail link is dead out of the generated site (implies --check-links)")
+	fs.BoolVar(&opts.DominantColors, "dominant-colors", false, "compute each thumbnail's dominant color and show it behind the image while it loads")
+	fs.BoolVar(&opts.NearDuplicates.Enabled, "near-duplicates", false, "compare perceptual hashes of the thumbnails and report visually near-identical works (burst shots, re-exports) on the console and in the build manifest")
+	fs.IntVar(&opts.NearDuplicates.Distance, "near-duplicate-distance", opts.NearDuplicates.Distance, "most bits (of 64) two thumbnails' perceptual hashes may differ by for the works to count as near-duplicates")
+	fs.BoolVar(&opts.NearDuplicates.Collapse, "collapse-near-duplicates", false, "show only the first work of each group of near-duplicates on the gallery pages (implies --near-duplicates)")
+	fs.StringVar(&opts.Redirects, "redirects", "", "comma-separated formats to publish redirects from make/model pages that moved since the last build in: stubs (HTML pages at the old URLs), netlify (a _redirects file) and nginx (a map file)")
+	fs.BoolVar(&opts.Fingerprint, "fingerprint", false, "write the stylesheet, script and local images under content-hash file names (e.g. style.3fa9c2d1e0.css) so they can be cached forever")
+	fetchFlags(fs, &opts.Fetch)
+	fs.IntVar(&feedPageJobs, "page-concurrency", feedPageJobs, "number of pages of a paginated feed (a URL with {page} in it) fetched at once")
+	fs.StringVar(&opts.IndexSelection, "index-selection", opts.IndexSelection, "which works the homepage shows: first (feed order), recent (newest capture date), random, featured (works flagged <featured>) or popular (most viewed, see --popularity)")
+	fs.Int64Var(&opts.IndexSeed, "index-seed", 0, "random seed for --index-selection random, so the same selection is produced on every build (0 for a new selection each build)")
+	fs.BoolVar(&opts.CSP, "csp", false, "add a Content-Security-Policy meta tag and subresource integrity hashes to every page, and write _headers and nginx-headers.conf with matching security headers")
+	fs.StringVar(&opts.UnsafeURIs, "unsafe-uris", opts.UnsafeURIs, "what to do with image URIs that aren't http(s) or relative (javascript:, data:, file: ...): clear (drop the URI with a warning) or fail")
+	fs.StringVar(&opts.MissingImages, "missing-images", opts.MissingImages, "what to do with works that have no thumbnail: keep (a broken image), skip (leave them out), placeholder (show a placeholder image) or substitute (use the medium or large image)")
+	fs.StringVar(&opts.ContactSheets, "contact-sheets", "", "also write contact-sheets.pdf, a printable grid of the local thumbnails with captions, grouped by make or model")
+	fs.StringVar(&opts.BaseURL, "base-url", "", "public URL the site is served from (e.g. https://photos.example.com/) - pages then carry canonical links")
+	fs.StringVar(&opts.Theme, "theme", "", "theme directory (start one with the theme init command) whose templates.html, style.css and nav.js replace the defaults, or a CSS file appended to the default stylesheet")
+	fs.BoolVar(&opts.AtomFeeds, "atom-feeds", false, "write Atom feeds of the newest works - atom.xml for the whole site and one per make and model, listed in feeds.opml (needs --base-url)")
+	fs.IntVar(&opts.FeedEntries, "feed-entries", opts.FeedEntries, "most works in each Atom feed")
+	fs.BoolVar(&opts.ZipDownloads, "zip-downloads", false, "bundle the downloaded large images of each make and model into a zip archive under downloads/, linked from its page (needs --download-images)")
+	fs.BoolVar(&opts.Mobile, "mobile", false, "also write a lightweight variant of the gallery pages under m/ - no script, a tiny stylesheet and small thumbnails - for low-bandwidth visitors")
+	fs.IntVar(&opts.SlideshowInterval, "slideshow-interval", opts.SlideshowInterval, "seconds between slides while the make and model slideshows play (0 to only move on with the arrow keys or buttons)")
+	fs.BoolVar(&numericWorkIDs, "numeric-ids", false, "require whole-number work ids, written without leading zeros, for feeds and tools that expect numeric ids")
+	fs.BoolVar(&opts.TitleCase, "title-case", false, "show make and model names the feed writes in capitals title-cased (e.g. FUJIFILM as Fujifilm) - the config's display_names take precedence")
+	fs.BoolVar(&opts.Incremental, "incremental", false, "only re-render pages whose data changed since the last incremental build - a template or theme change re-renders every page")
+	fs.StringVar(&opts.ServerConfig.Dir, "server-config", "", "write nginx, Apache and Caddy configuration for serving the site (routes, cache headers, basic auth) into this directory, outside the output directory")
+	fs.StringVar(&opts.ServerConfig.BasicAuthUser, "basic-auth", "", "user name the --server-config configuration asks for with HTTP basic auth (the password is read from --basic-auth-env)")
+	fs.StringVar(&opts.ServerConfig.BasicAuthEnv, "basic-auth-env", opts.ServerConfig.BasicAuthEnv, "environment variable holding the --basic-auth password")
+	fs.StringVar(&opts.ProtectPages, "protect-pages", "", "comma-separated file name patterns of pages to encrypt with a passphrase, asked for in the browser (e.g. 'Family*.html,work-*.html', or '*' for every page)")
+	fs.StringVar(&opts.PassphraseEnv, "passphrase-env", opts.PassphraseEnv, "environment variable holding the passphrase for --protect-pages")
+	fs.BoolVar(&opts.ValidateHTML, "validate-html", false, "check the generated pages for unclosed tags, duplicate ids and images without alt text")
+	fs.BoolVar(&opts.Strict, "strict", false, "fail the build if HTML validation finds any problem, before the after-write hooks run (implies --validate-html)")
+	popularityPath := fs.String("popularity", "", "CSV of view counts per work (id,views[,recent_views]) from the server logs, for --index-selection popular, the homepage's trending section and popularity badges")
+	fs.IntVar(&opts.Trending, "trending", opts.Trending, "most works in the homepage's trending section, with --popularity (0 for no trending section)")
+	overridesPath := fs.String("overrides", "", "YAML file of custom titles and descriptions per make, model and work id, works to hide and featured flags to pin")
+	sites := fs.String("sites", "", "comma-separated names of the config's site profiles to build (default all of them)")
+	var hookCmds hookCommandFlag
+	deployTarget := fs.String("deploy", "", "publish the site once it's written: "+strings.Join(deployTargets, ", ")+" (settings in the config's deploy section)")
+	fs.Var(&hookCmds, "hook-cmd", "stage=command: run an external command at a build stage (after-parse, before-render-page or after-write) - may be repeated")
+	fs.StringVar(&cacheDirOverride, "cache-dir", "", "persistent cache directory for fetched feeds, downloaded images and image metadata (default $IMGPROC_CACHE_DIR or the user cache directory, e.g. ~/.cache/imgproc)")
+	fs.BoolVar(&cacheDisabled, "no-cache", false, "don't read or write the persistent cache (image metadata is then cached in the output directory)")
+	fs.BoolVar(&offline, "offline", false, "build from the persistent cache only: the feed and images as last fetched, without contacting the API or image hosts")
+	force := fs.Bool("force", false, "break the lock another build holds on the output directory - for a lock left behind by a build that was killed")
+	profile := fs.Bool("profile-phases", false, "print how long the fetch, parse, index, render and write phases took at the end of the run, and write the times to report.json in the output directory")
+	fs.StringVar(&opts.Snapshot, "snapshot", "", "also write the parsed catalog to this snapshot file, for later builds with --from-snapshot")
+	fromSnapshot := fs.String("from-snapshot", "", "build from a catalog snapshot written with --snapshot instead of fetching and parsing the feed (then give just the output directory)")
+	fs.IntVar(&limits.MaxWorks, "max-works", 0, "abort the build if the works feed has more than this many works (0 for no limit)")
+	fs.Var((*byteSizeFlag)(&limits.MaxMemory), "max-memory", "abort the build if the works feed, or the memory taken parsing it, is larger than this (e.g. 512MB)")
+	fs.BoolVar(&opts.ProbeRemote, "probe-remote", false, "fetch the first few KB of remote thumbnail and medium images to read their width/height (results are cached between builds)")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", fs.Name())
+		fs.PrintDefaults()
+		printExitCodes(fs.Output())
+	}
+
+	return func(positional []string) int {
+		if offline && (cacheDisabled || opts.CheckLinks || opts.LinkCheck.ExcludeBroken) {
+			fmt.Fprintln(os.Stderr, "Error: --offline can't be combined with --no-cache, --check-links or --exclude-broken")
+			return exitUsage
+		}
+
+		if opts.Trending < 0 {
+			fmt.Fprintln(os.Stderr, "Error: --trending can't be negative")
+			return exitUsage
+		}
+
+		if opts.NearDuplicates.Distance < 0 || opts.NearDuplicates.Distance > 64 {
+			fmt.Fprintln(os.Stderr, "Error: --near-duplicate-distance must be between 0 and 64")
+			return exitUsage
+		}
+		if opts.NearDuplicates.Collapse {
+			opts.NearDuplicates.Enabled = true
+		}
+		if err := checkRedirectFormats(opts.Redirects); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+
+		if opts.SlideshowInterval < 0 {
+			fmt.Fprintln(os.Stderr, "Error: --slideshow-interval can't be negative")
+			return exitUsage
+		}
+
+		if opts.ZipDownloads && !opts.DownloadImages {
+			fmt.Fprintln(os.Stderr, "Error: --zip-downloads needs --download-images")
+			return exitUsage
+		}
+
+		if opts.Watermark.enabled() {
+			if !opts.DownloadImages {
+				fmt.Fprintln(os.Stderr, "Error: --watermark-text and --watermark-image need --download-images")
+				return exitUsage
+			}
+			if err := opts.Watermark.check(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return exitUsage
+			}
+		}
+
+		if *fromSnapshot != "" && (*watch || opts.Snapshot != "") {
+			fmt.Fprintln(os.Stderr, "Error: --from-snapshot can't be combined with --watch or --snapshot")
+			return exitUsage
+		}
+		if *fromSnapshot != "" && serve != nil {
+			fmt.Fprintln(os.Stderr, "Error: serve watches the works source, so it can't build --from-snapshot")
+			return exitUsage
+		}
+
+		cfg, err := loadConfig(*configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+		if len(cfg.Sites) > 0 && serve != nil {
+			fmt.Fprintln(os.Stderr, "Error: serve previews a single site - use a config file without a sites section")
+			return exitUsage
+		}
+
+		// built from a snapshot, there's no API location to give
+		if *fromSnapshot != "" {
+			positional = append([]string{""}, positional...)
+			if len(cfg.Sites) == 0 && len(positional) < 2 {
+				fmt.Println("Error: please enter the output directory location as a command-line argument (e.g. >go run ImageProcessor --from-snapshot works.snap code/html/output)")
+				return exitUsage
+			}
+			if len(cfg.Sites) > 0 && len(positional) != 1 {
+				fmt.Println("Error: the config file defines sites, so please enter no arguments - each site's output directory is set in the config (e.g. >go run ImageProcessor --config sites.json --from-snapshot works.snap)")
+				return exitUsage
+			}
+		}
+
+		// expecting two command-line arguments at invocation - API location for reading image data from and output directory for writing static site files
+		// (just the API location when the config defines site profiles, which have output directories of their own)
+		if len(cfg.Sites) > 0 && len(positional) != 1 {
+			fmt.Println("Error: the config file defines sites, so please enter just the image API URL - each site's output directory is set in the config (e.g. >go run ImageProcessor --config sites.json http://localhost/test/api/v1/works.xml)")
+			return exitUsage
+		}
+		if len(cfg.Sites) == 0 && len(positional) < 2 {
+			fmt.Println("Error: please enter the image API URL and an output directory location as command-line arguments (e.g. >go run ImageProcessor http://localhost/test/api/v1/works.xml code/html/output)")
+			return exitUsage
+		}
+		if len(cfg.Sites) == 0 && *sites != "" {
+			fmt.Fprintln(os.Stderr, "Error: --sites needs a config file with a sites section")
+			return exitUsage
+		}
+
+		// read in command line arguments: API URL (or local XML file) and output directory
+		imageAPILocation := positional[0]
+		if *fromSnapshot == "" {
+			fmt.Printf("Accessing image API at %s\n", imageAPILocation)
+		}
+
+		if err := configureFetching(opts.Fetch); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+
+		graphQLSource = cfg.GraphQL
+		mergedSources = cfg.Sources
+		opts.DisplayNames = cfg.DisplayNames
+		opts.Exclude = cfg.Exclude
+		opts.Flagged = cfg.Flagged
+		opts.License = cfg.License
+		opts.Geocode = cfg.Geocode
+		opts.Compare = cfg.Compare
+		opts.HTMLPolicy = cfg.HTMLPolicy
+
+		if err := opts.Exclude.check(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+
+		if err := opts.Flagged.check(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+
+		if err := opts.Geocode.check(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+
+		if err := checkComparePairs(opts.Compare); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+
+		if err := opts.HTMLPolicy.check(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+
+		if opts.Overrides, err = loadOverrides(*overridesPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+
+		if opts.Popularity, err = loadPopularity(*popularityPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+
+		if err := configureURLSigning(cfg.SignedURLs); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+
+		if err := configureImageMirrors(cfg.ImageMirrors); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+
+		if err := configureComments(cfg.Comments); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+
+		if *deployTarget != "" {
+			if err := registerDeploy(*deployTarget, cfg.Deploy); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return exitConfig
+			}
+		}
+
+		if opts.ProtectPages != "" {
+			switch {
+			case opts.CSP:
+				fmt.Fprintln(os.Stderr, "Error: --protect-pages can't be combined with --csp (the passphrase prompt decrypts pages with an inline script)")
+				return exitUsage
+			case os.Getenv(opts.PassphraseEnv) == "":
+				fmt.Fprintf(os.Stderr, "Error: --protect-pages needs a passphrase in the %s environment variable\n", opts.PassphraseEnv)
+				return exitConfig
+			}
+		}
+
+		if opts.ServerConfig.BasicAuthUser != "" {
+			switch {
+			case opts.ServerConfig.Dir == "":
+				fmt.Fprintln(os.Stderr, "Error: --basic-auth needs --server-config, whose web server configuration asks for the password")
+				return exitUsage
+			case strings.Contains(opts.ServerConfig.BasicAuthUser, ":"):
+				fmt.Fprintln(os.Stderr, "Error: --basic-auth user names can't contain a colon")
+				return exitUsage
+			}
+			if opts.ServerConfig.BasicAuthPasswd = os.Getenv(opts.ServerConfig.BasicAuthEnv); opts.ServerConfig.BasicAuthPasswd == "" {
+				fmt.Fprintf(os.Stderr, "Error: --basic-auth needs a password in the %s environment variable\n", opts.ServerConfig.BasicAuthEnv)
+				return exitConfig
+			}
+		}
+
+		opts.CDNPurge = cfg.CDNPurge
+		if err := opts.CDNPurge.check(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+
+		if err := applyLayoutConfig(opts.Layouts, cfg.Layouts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+
+		notifiers, err := newNotifierSet(cfg.Notifications)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+
+		// one site written to the output directory given, or every selected site profile from the one parsed feed
+		var build func(feed io.Reader) error
+		var buildFrom func(catalog *Catalog) error // build from an already-read catalog (a snapshot)
+		var outputs []string                       // output directories, for the phase timing report
+		if len(cfg.Sites) > 0 {
+			var only []string
+			if *sites != "" {
+				only = strings.Split(*sites, ",")
+			}
+
+			siteBuilds, err := prepareSites(cfg.Sites, opts, cfg.Layouts, only)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return exitConfig
+			}
+
+			for _, s := range siteBuilds {
+				outputs = append(outputs, s.output)
+			}
+
+			build = func(feed io.Reader) error { return buildSites(feed, siteBuilds, opts.Snapshot) }
+			buildFrom = func(catalog *Catalog) error { return buildSiteCatalogs(catalog, siteBuilds) }
+		} else {
+			outputFolderLocation := positional[1]
+			fmt.Printf("Output files for static site will be written to <./%s>\n", outputFolderLocation)
+
+			outputs = []string{outputFolderLocation}
+
+			build = func(feed io.Reader) error { return buildSite(feed, outputFolderLocation, opts) }
+			buildFrom = func(catalog *Catalog) error { return buildCatalog(catalog, outputFolderLocation, opts) }
+		}
+
+		// one build at a time writes to an output directory (see Lock.go)
+		locks, err := lockOutputs(outputs, *force)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitLocked
+		}
+		defer locks.release()
+
+		if *watch || serve != nil {
+			job := watchJob{
+				Location:  imageAPILocation,
+				Theme:     opts.Theme,
+				Output:    outputs[0],
+				Build:     build,
+				Interval:  *watchInterval,
+				Debounce:  *debounce,
+				Notifiers: notifiers,
+				OnBuild:   func(error) { phases.finish(*profile, outputs) },
+			}
+			if serve != nil {
+				return serve(job)
+			}
+			return watchAndBuild(job)
+		}
+
+		if *fromSnapshot != "" {
+			// the snapshot stands in for fetching and parsing the feed
+			phases.reset("parse")
+			var catalog *Catalog
+			catalog, err = loadSnapshot(*fromSnapshot, os.Stdout)
+			if err != nil {
+				err = withExitCode(exitParse, err)
+			} else {
+				err = buildFrom(catalog)
+			}
+		} else {
+			// get XML data response from API location (read in full, so fetching is timed apart from parsing)
+			phases.reset("fetch")
+			var data []byte
+			data, err = readFeed(imageAPILocation)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error fetching XML works data from specified API URL (%s): %v\n", imageAPILocation, err)
+				notifiers.send(SeverityFail, "Static site build failed", fmt.Sprintf("Error fetching XML works data from %s: %v", imageAPILocation, err))
+				return exitFetch
+			}
+
+			err = build(bytes.NewReader(data))
+		}
+
+		phases.finish(*profile, outputs)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			notifiers.send(SeverityFail, "Static site build failed", err.Error())
+			return exitCodeOf(err)
+		}
+
+		return exitOK
+	}
+}
+
+// works sources other than XML feeds, keyed by the scheme that selects them (e.g. "graphql:") - each source file adds itself from an
+// init function, and returns its works rendered as an XML feed so builds, watch mode, export and diff all read it the same way
+var feedSources = map[string]func(location string) (io.ReadCloser, error){}
+
+func registerFeedSource(scheme string, open func(location string) (io.ReadCloser, error)) {
+	feedSources[scheme] = open
+}
+
+// open the works XML source for reading - a registered source scheme is read by its source, an http(s) URL is fetched from the API
+// through the configured fetcher (and kept in the persistent cache, see CacheDir.go) - a page at a time if it has a {page} placeholder
+// (see PagedFeeds.go) - and anything else is treated as a local file
+func openFeed(location string) (io.ReadCloser, error) {
+	if scheme, rest, ok := strings.Cut(location, ":"); ok {
+		if open, ok := feedSources[strings.ToLower(scheme)]; ok {
+			return open(rest)
+		}
+	}
+
+	if isPagedFeed(location) {
+		return openPagedFeed(location)
+	}
+
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return openRemoteFeed(location)
 	}
 
-	// read in command line arguments: API URL and output directory
-	imageAPILocation := os.Args[1]
-	outputFolderLocation := os.Args[2]
-	fmt.Printf("Accessing image API at %s\n", imageAPILocation)
-	fmt.Printf("Output files for static site will be written to <./%s>\n", outputFolderLocation)
+	return fetcher.Fetch(context.Background(), location)
+}
+
+// type struct representing the options that control a site build
+type buildOptions struct {
+	DownloadImages    bool             // localize remote images into the output directory (see Images.go)
+	DownloadJobs      int              // number of image downloads in flight at once
+	Watermark         watermarkOptions // watermark composited onto the local medium/large images (see Watermark.go)
+	Thumbnails        thumbnailOptions // local thumbnail generation (see Thumbnails.go)
+	ImageFormats      string           // comma-separated extra formats (webp, avif) to transcode local images to (see Variants.go)
+	VariantQuality    int              // encoder quality (0-100) for the extra formats
+	ProbeRemote       bool             // read the dimensions of remote thumbnail and medium images too (see Dimensions.go)
+	Exif              bool             // fill in missing make/model/lens/settings/date/position from the large images' EXIF data (see Exif.go)
+	ExifPrecedence    string           // "feed" (EXIF only fills gaps) or "exif" (EXIF overrides the feed)
+	CheckLinks        bool             // check every image URI before generating (see LinkCheck.go)
+	LinkCheck         linkCheckOptions
+	DominantColors    bool                  // compute each thumbnail's dominant color as a loading placeholder (see Colors.go)
+	Fingerprint       bool                  // write assets under content-hash file names (see Assets.go)
+	Fetch             fetchOptions          // politeness controls for feed and image requests (see Fetch.go)
+	IndexSelection    string                // which works the homepage shows: first, recent, random, featured or popular (see IndexSelection.go)
+	IndexSeed         int64                 // random seed for the "random" index selection (0 picks a new selection every build)
+	Layouts           map[string]pageLayout // gallery layout of each page type (see Layout.go)
+	CSP               bool                  // add a Content-Security-Policy and SRI hashes to pages and write _headers/nginx header config (see Security.go)
+	UnsafeURIs        string                // what to do with image URIs that aren't http(s) or relative: clear or fail (see URIValidation.go)
+	MissingImages     string                // what to do with works without a thumbnail: keep, skip, placeholder or substitute (see MissingImages.go)
+	ContactSheets     string                // write PDF contact sheets grouped by "make" or "model" ("" for none) (see ContactSheets.go)
+	BaseURL           string                // public URL of the site, for canonical links ("" for none)
+	Theme             string                // theme directory, or a CSS file appended to the default stylesheet ("" for the default theme - see Theme.go)
+	AtomFeeds         bool                  // write Atom feeds of the newest works, site-wide and per make/model (see Feeds.go)
+	FeedEntries       int                   // most works in each Atom feed
+	ZipDownloads      bool                  // bundle each make's and model's large images into a zip archive linked from its page (see Downloads.go)
+	Mobile            bool                  // also write lightweight gallery pages under m/ (see Mobile.go)
+	SlideshowInterval int                   // seconds between slides of the make and model slideshows (0 for no autoplay - see Slideshow.go)
+	DisplayNames      map[string]string     // names to show for makes and models, by feed name (see DisplayNames.go)
+	TitleCase         bool                  // title-case make and model names written in capitals
+	Popularity        *popularityData       // view counts per work, from the --popularity file (see Popularity.go)
+	Trending          int                   // most works in the homepage's trending section
+	Overrides         *pageOverrides        // titles, descriptions, hidden works and featured flags from the overrides file (see Overrides.go)
+	Exclude           exclusionList         // works that must never be published (see Exclusions.go)
+	Flagged           flaggedContent        // how works flagged as sensitive are shown (see Flagged.go)
+	License           string                // license of the works the feed gives none, from the config file (see License.go)
+	Compare           []comparePair         // pairs of makes or models to write comparison pages for (see Compare.go)
+	Geocode           *geocodeConfig        // reverse geocoding of the works' GPS positions into countries and cities (nil for none - see Places.go)
+	HTMLPolicy        *htmlPolicy           // elements and attributes the works' HTML captions may keep (nil for the default - see Sanitize.go)
+	ValidateHTML      bool                  // check the generated pages for unclosed tags, duplicate ids and missing alt text (see HTMLValidation.go)
+	Strict            bool                  // fail the build on HTML validation problems
+	Snapshot          string                // file to write the parsed catalog to ("" for none - see Snapshot.go)
+	Incremental       bool                  // only re-render pages whose data or theme changed since the last build (see Incremental.go)
+	CDNPurge          *cdnPurgeConfig       // CDN to purge changed files from once the after-write hooks have deployed the site (see CDNPurge.go)
+	ProtectPages      string                // comma-separated file name patterns of pages to encrypt with a passphrase (see Protect.go)
+	PassphraseEnv     string                // environment variable holding the passphrase of the protected pages
+	ServerConfig      serverConfigOptions   // nginx/Apache/Caddy configuration to write for the site (see ServerConfig.go)
+	NearDuplicates    nearDuplicateOptions  // reporting and collapsing of visually near-identical works (see NearDuplicates.go)
+	Redirects         string                // comma-separated formats to publish the redirects from moved pages in (see Redirects.go)
+}
+
+// create and return a pointer to build options holding the defaults
+func newBuildOptions() *buildOptions {
+	return &buildOptions{
+		Thumbnails:        thumbnailOptions{Width: 135, Height: 135, Quality: 75},
+		DownloadJobs:      4,
+		Watermark:         watermarkOptions{Position: "bottom-right", Opacity: 0.5},
+		VariantQuality:    70,
+		ExifPrecedence:    "feed",
+		LinkCheck:         linkCheckOptions{Concurrency: 8, Rate: 10},
+		IndexSelection:    "first",
+		Layouts:           defaultLayouts(),
+		UnsafeURIs:        "clear",
+		MissingImages:     "keep",
+		FeedEntries:       50,
+		SlideshowInterval: defaultSlideshowInterval,
+		Trending:          defaultTrendingWorks,
+		PassphraseEnv:     defaultPassphraseEnv,
+		ServerConfig:      serverConfigOptions{BasicAuthEnv: defaultBasicAuthEnv},
+		NearDuplicates:    nearDuplicateOptions{Distance: defaultNearDuplicateDistance},
+	}
+}
 
-	// get XML data response from API location
-	apiDataResp, err := http.Get(imageAPILocation)
+// parse works XML data from the given reader and write the static site files to the output directory
+func buildSite(feed io.Reader, outputFolderLocation string, opts *buildOptions) error {
+	catalog, err := parseFeed(feed, opts.Snapshot)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error fetching XML works data from specified API URL (%s): %v\n", imageAPILocation, err)
-		os.Exit(1)
+		return err
 	}
 
+	return buildCatalog(catalog, outputFolderLocation, opts)
+}
+
+// parse works XML data from the given reader, also writing the catalog to a snapshot file if a path is given (see Snapshot.go)
+func parseFeed(feed io.Reader, snapshot string) (*Catalog, error) {
+	phases.enter("parse")
+	catalog, err := parseWorks(feed)
+	if err != nil {
+		return nil, withExitCode(exitParse, err)
+	}
+
+	if snapshot != "" {
+		if err := saveSnapshot(catalog, snapshot); err != nil {
+			return nil, err
+		}
+	}
+
+	return catalog, nil
+}
+
+// write the static site files for an already-read catalog (from the works feed or an importer) to the output directory
+func buildCatalog(catalog *Catalog, outputFolderLocation string, opts *buildOptions) error {
+	phases.enter("index")
+	applyExclusions(catalog, opts.Exclude)
+
+	// the after-parse hooks run before the overrides and popularity data are applied - an external hook's catalog is rebuilt from the
+	// export format, which doesn't carry them
+	if err := runAfterParseHooks(catalog); err != nil {
+		return err
+	}
+
+	applyOverrides(catalog, opts.Overrides)
+	applyFlagged(catalog, opts.Flagged, opts.Overrides)
+	applyPopularity(catalog, opts.Popularity)
+	applyDefaultLicense(catalog, opts.License)
+
+	if err := validateImageURIs(catalog, opts.UnsafeURIs); err != nil {
+		return err
+	}
+
+	if err := checkMissingImagePolicy(opts.MissingImages); err != nil {
+		return withExitCode(exitConfig, err)
+	}
+
+	fmt.Println("XML data parsing complete - generating static site...")
+
+	if opts.CheckLinks || opts.LinkCheck.ExcludeBroken {
+		phases.enter("fetch")
+		checkLinks(catalog, opts.LinkCheck)
+		phases.enter("index")
+	}
+
+	cache := loadBuildCache(outputFolderLocation)
+
+	if opts.DownloadImages {
+		fmt.Println("Downloading images...")
+
+		phases.enter("fetch")
+		if err := localizeImages(catalog, outputFolderLocation, opts.DownloadJobs, opts.Watermark.enabled(), cache); err != nil {
+			return err
+		}
+		phases.enter("index")
+	}
+
+	if opts.Exif {
+		if err := applyExif(catalog, outputFolderLocation, opts.ExifPrecedence); err != nil {
+			return err
+		}
+	}
+
+	if err := generateThumbnails(catalog, outputFolderLocation, opts.Thumbnails); err != nil {
+		return err
+	}
+
+	if opts.Watermark.enabled() && opts.DownloadImages {
+		if err := watermarkImages(catalog, outputFolderLocation, opts.Watermark); err != nil {
+			return err
+		}
+	}
+
+	if opts.ImageFormats != "" {
+		if err := generateVariants(catalog, outputFolderLocation, opts.ImageFormats, opts.VariantQuality); err != nil {
+			return err
+		}
+	}
+
+	probeDimensions(catalog, outputFolderLocation, cache, opts.ProbeRemote)
+
+	if opts.DominantColors {
+		computed, failed := computeDominantColors(catalog, outputFolderLocation, cache)
+		fmt.Printf("Dominant colors: %d computed, %d failed.\n", computed, failed)
+	}
+
+	if opts.NearDuplicates.Enabled {
+		findNearDuplicates(catalog, outputFolderLocation, cache, opts.NearDuplicates.Distance)
+	}
+
+	if opts.Geocode != nil {
+		phases.enter("fetch")
+		if err := geocodeWorks(catalog, opts.Geocode, cache); err != nil {
+			return withExitCode(exitConfig, err)
+		}
+		phases.enter("index")
+	}
+
+	if err := cache.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving build cache: %v\n", err)
+	}
+
+	if err := applyMissingImagePolicy(catalog, outputFolderLocation, opts.MissingImages, opts.Thumbnails); err != nil {
+		return err
+	}
+
+	if opts.Fingerprint {
+		if err := fingerprintImages(catalog, outputFolderLocation); err != nil {
+			return err
+		}
+	}
+
+	if opts.Flagged.Mode == "section" {
+		catalog.separateFlagged()
+	}
+
+	if err := generateSite(catalog, outputFolderLocation, opts); err != nil {
+		return err
+	}
+
+	fmt.Println("Static site generation complete.")
+	return nil
+}
+
+// read and decode works XML data, building in-memory collections of works, makes and models
+func parseWorks(feed io.Reader) (*Catalog, error) {
 	// decode read XML data body
-	dec := xml.NewDecoder(apiDataResp.Body)
+	dec := xml.NewDecoder(limits.reader(feed))
 
 	// predefine the token literal values we're interested in for ease of comparison when processing the XML data body (e.g. <id>, <filename>, <work> etc)
 	ID := "id"
@@ -49,6 +692,25 @@ func main() {
 	URISMALL := "small"
 	URIMEDIUM := "medium"
 	URILARGE := "large"
+	DATE := "date"
+	FEATURED := "featured"
+	FLAGGED := "flagged"
+	RATING := "rating"
+	TAG := "tag"
+	CHECKSUM := "checksum"
+	MD5 := "md5"
+	COPYRIGHT := "copyright"
+	CAPTION := "caption"
+	LICENSE := "license"
+	AUTHOR := "author"
+	ARTIST := "artist"
+	LENS := "lens"
+	FOCALLENGTH := "focal_length"
+	APERTURE := "aperture"
+	FNUMBER := "fnumber"
+	ISO := "iso"
+	LATITUDE := "latitude"
+	LONGITUDE := "longitude"
 
 	// drclare in-memory collections for works and makes, and a stack to read in XML tag tokens
 	var stack []string  // we'll use a string slice as a stack data structure to pop on/off start/end elements as we read through the XML data body's tokens
@@ -56,6 +718,14 @@ func main() {
 	var makes []*Make   // collection of all makes detected
 	var worksSM []*Work // Works sans makes - if a work is found without a make speceifed, it'll go on this list and have a separate page generated for it to be diplayed
 
+	var authors []*Author // photographers of the works (see Authors.go)
+	var lenses []*Lens    // lenses the works were taken with (see Lenses.go)
+
+	// makes and models already recorded, by name, and the tag names read so far - so a large feed neither rescans the makes list for
+	// every work nor keeps a copy of the same tag for each work carrying it (see Interning.go)
+	lookup := newNameLookup()
+	names := nameInterner{}
+
 	var newWork *Work         // placeholder for the work currently being iterated through
 	newModelDetected := false // flag indicating if a new model was detected in the work being currently read (if so to be added to the make of this work)
 	newModel := ""            // name of new model detected (if indicated by newModelDetected flag above)
@@ -64,6 +734,10 @@ func main() {
 	thumbnailURI := false
 	mediumURI := false
 	largeURI := false
+	checksumImage := "" // image the <checksum> or <md5> being read is about
+
+	// GPS position of the work being read, set on it when the work ends and both are known (see Places.go)
+	latitude, longitude := "", ""
 
 	// iterate through the full decoded XML data body per detected token, until EOF
 	// we'll detect three types of main tokens: start tags, end tags and data - tags will be popped on to the stack when they open and popped off when closing.
@@ -77,8 +751,7 @@ func main() {
 			// reached end of data
 			break
 		} else if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading XML data body token: %v\n", err)
-			os.Exit(1)
+			return nil, fmt.Errorf("Error reading XML data body token: %v", err)
 		}
 
 		// switch statement to take selective action based on the current token (start, end or data)
@@ -91,6 +764,40 @@ func main() {
 				// start of a new <work> in XML data: create a new Work instance and pop in to the list of all works
 				newWork = createWork()
 				works = append(works, newWork)
+
+				// stop before an oversized feed runs the machine out of memory
+				if err := limits.checkWorks(len(works)); err != nil {
+					return nil, err
+				}
+			}
+
+			// a <featured> element flags the work for the homepage (an empty <featured/> counts as true)
+			if len(stack) > 0 && stack[len(stack)-1] == FEATURED && newWork != nil {
+				newWork.Featured = true
+			}
+
+			// a <flagged> element marks the work as sensitive, like <featured> (see Flagged.go)
+			if len(stack) > 0 && stack[len(stack)-1] == FLAGGED && newWork != nil {
+				newWork.Flagged = true
+			}
+
+			// a <checksum> or <md5> is about the large image unless its type attribute names another (see Checksums.go)
+			if len(stack) > 0 && (stack[len(stack)-1] == CHECKSUM || stack[len(stack)-1] == MD5) {
+				checksumImage = URILARGE
+				for _, attr := range token.Attr {
+					if attr.Name.Local == "type" {
+						checksumImage = strings.ToLower(strings.TrimSpace(attr.Value))
+					}
+				}
+			}
+
+			// a <license> can give the URL of the license text in a url attribute (see License.go)
+			if len(stack) > 0 && stack[len(stack)-1] == LICENSE && newWork != nil {
+				for _, attr := range token.Attr {
+					if attr.Name.Local == "url" || attr.Name.Local == "href" {
+						newWork.LicenseURL = strings.TrimSpace(attr.Value)
+					}
+				}
 			}
 
 			// if we're reading the URL tag of a work, set the appropriate flag depending on the the small, medium or large XML tag attribute
@@ -115,8 +822,7 @@ func main() {
 
 			// check if there are already XML opening tags stored in stack - if not, we've encountered a closing tag without an opening tag
 			if len(stack) <= 0 {
-				fmt.Fprintf(os.Stderr, "Attempting to pop an element(%s) without any on stack - possibly malformed XML\n", token.Name.Local)
-				os.Exit(1)
+				return nil, fmt.Errorf("Attempting to pop an element(%s) without any on stack - possibly malformed XML", token.Name.Local)
 			}
 
 			elementPopped := stack[len(stack)-1]
@@ -124,8 +830,7 @@ func main() {
 
 			// check for XML consistency - if every end element should have had a corresponding start element
 			if elementPopped != token.Name.Local {
-				fmt.Fprintf(os.Stderr, "Closing element %s without matching opener (%s) - possibly malformed XML\n", elementPopped, token.Name.Local)
-				os.Exit(1)
+				return nil, fmt.Errorf("Closing element %s without matching opener (%s) - possibly malformed XML", elementPopped, token.Name.Local)
 			}
 
 			if elementPopped == WORK {
@@ -136,6 +841,15 @@ func main() {
 				thisMake := newWork.WMake
 				thisModel := newWork.WModel
 
+				// a work's position needs both its latitude and longitude
+				if latitude != "" || longitude != "" {
+					if p, err := parseGeoPoint(latitude, longitude); err == nil {
+						thisWork.GPS = p
+					} else {
+						fmt.Fprintf(os.Stderr, "Ignoring unreadable position of work %s: %v\n", thisWork.ID, err)
+					}
+				}
+
 				if thisMake == nil {
 					// record works without a make specified separately
 					worksSM = append(worksSM, thisWork)
@@ -151,6 +865,7 @@ func main() {
 				newWork = nil
 				newModelDetected = false
 				newModel = ""
+				latitude, longitude = "", ""
 				thisWork, thisMake, thisModel = nil, nil, nil
 			}
 
@@ -159,19 +874,17 @@ func main() {
 
 			//Work ID
 			if len(stack) > 0 && stack[len(stack)-1] == ID {
-				IDData, err := strconv.Atoi(strings.TrimSpace(string(token)))
+				IDData, err := parseWorkID(string(token))
 
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error converting Work ID: %v\n", err)
-					os.Exit(1)
+					return nil, fmt.Errorf("Error reading Work ID: %v", err)
 				}
 
 				if newWork != nil {
 					newWork.ID = IDData
 
 				} else {
-					fmt.Fprintf(os.Stderr, "ID data(%d) detected without an active current Work struct instance. Possibly malformed XML.", IDData)
-					os.Exit(1)
+					return nil, fmt.Errorf("ID data(%s) detected without an active current Work struct instance. Possibly malformed XML.", IDData)
 				}
 			}
 
@@ -182,8 +895,141 @@ func main() {
 				if newWork != nil {
 					newWork.FileName = FileName
 				} else {
-					fmt.Fprintf(os.Stderr, "Filename(%s) detected without an active current Work struct instance. Possibly malformed XML.", FileName)
-					os.Exit(1)
+					return nil, fmt.Errorf("Filename(%s) detected without an active current Work struct instance. Possibly malformed XML.", FileName)
+				}
+			}
+
+			// Work featured flag - <featured>false</featured> (or 0/no) turns it back off
+			if len(stack) > 0 && stack[len(stack)-1] == FEATURED && newWork != nil {
+				switch strings.ToLower(strings.TrimSpace(string(token))) {
+				case "false", "0", "no":
+					newWork.Featured = false
+				}
+			}
+
+			// Work flagged as sensitive - <flagged>false</flagged> (or 0/no) turns it back off - and content rating (see Flagged.go)
+			if len(stack) > 0 && stack[len(stack)-1] == FLAGGED && newWork != nil {
+				switch strings.ToLower(strings.TrimSpace(string(token))) {
+				case "false", "0", "no":
+					newWork.Flagged = false
+				}
+			}
+			if len(stack) > 0 && stack[len(stack)-1] == RATING && newWork != nil {
+				newWork.Rating = strings.TrimSpace(newWork.Rating + string(token))
+			}
+
+			// Work tags (optional - <tags><tag>...</tag></tags>, used by the exclusion list)
+			if len(stack) > 0 && stack[len(stack)-1] == TAG && newWork != nil {
+				if tag := strings.TrimSpace(string(token)); tag != "" {
+					newWork.Tags = append(newWork.Tags, names.intern(tag))
+				}
+			}
+
+			// Work copyright notice and license (optional - see License.go)
+			if len(stack) > 0 && stack[len(stack)-1] == COPYRIGHT && newWork != nil {
+				newWork.Copyright = strings.TrimSpace(newWork.Copyright + string(token))
+			}
+			if len(stack) > 0 && stack[len(stack)-1] == LICENSE && newWork != nil {
+				newWork.License = strings.TrimSpace(newWork.License + string(token))
+			}
+
+			// Work caption (optional - an HTML fragment, escaped or in a CDATA section, see Sanitize.go)
+			if len(stack) > 0 && stack[len(stack)-1] == CAPTION && newWork != nil {
+				newWork.Caption = strings.TrimSpace(newWork.Caption + string(token))
+			}
+
+			// Work author (optional - <author> or <artist>, the first one a work has, see Authors.go)
+			if len(stack) > 0 && (stack[len(stack)-1] == AUTHOR || stack[len(stack)-1] == ARTIST) && newWork != nil && newWork.Author == nil {
+				if name := strings.TrimSpace(string(token)); name != "" {
+					author := lookup.findAuthor(name)
+					if author == nil {
+						author = createAuthor(name)
+						authors = append(authors, author)
+						lookup.addAuthor(author)
+					}
+					newWork.Author = author
+					author.Works = append(author.Works, newWork)
+				}
+			}
+
+			// Work lens (optional - see Lenses.go)
+			if len(stack) > 0 && stack[len(stack)-1] == LENS && newWork != nil && newWork.Lens == nil {
+				if name := strings.TrimSpace(string(token)); name != "" {
+					lens := lookup.findLens(name)
+					if lens == nil {
+						lens = createLens(name)
+						lenses = append(lenses, lens)
+						lookup.addLens(lens)
+					}
+					newWork.Lens = lens
+					lens.Works = append(lens.Works, newWork)
+				}
+			}
+
+			// Work focal length, aperture and ISO (optional - a setting that can't be read is reported and ignored, see Facets.go)
+			if len(stack) > 0 && stack[len(stack)-1] == FOCALLENGTH && newWork != nil {
+				if v, err := parseFocalLength(string(token)); err == nil {
+					newWork.FocalLength = v
+				} else {
+					fmt.Fprintf(os.Stderr, "Ignoring unreadable focal length of work %s: %v\n", newWork.ID, err)
+				}
+			}
+			if len(stack) > 0 && (stack[len(stack)-1] == APERTURE || stack[len(stack)-1] == FNUMBER) && newWork != nil {
+				if v, err := parseAperture(string(token)); err == nil {
+					newWork.Aperture = v
+				} else {
+					fmt.Fprintf(os.Stderr, "Ignoring unreadable aperture of work %s: %v\n", newWork.ID, err)
+				}
+			}
+			if len(stack) > 0 && stack[len(stack)-1] == ISO && newWork != nil {
+				if v, err := parseISO(string(token)); err == nil {
+					newWork.ISO = v
+				} else {
+					fmt.Fprintf(os.Stderr, "Ignoring unreadable ISO of work %s: %v\n", newWork.ID, err)
+				}
+			}
+
+			// Work GPS position (optional - <latitude> and <longitude> in decimal degrees)
+			if len(stack) > 0 && stack[len(stack)-1] == LATITUDE && newWork != nil {
+				latitude = strings.TrimSpace(latitude + string(token))
+			}
+			if len(stack) > 0 && stack[len(stack)-1] == LONGITUDE && newWork != nil {
+				longitude = strings.TrimSpace(longitude + string(token))
+			}
+
+			// Work capture date (optional - a date that can't be read is reported and ignored)
+			if len(stack) > 0 && stack[len(stack)-1] == DATE {
+				dateText := strings.TrimSpace(string(token))
+
+				if newWork == nil {
+					return nil, fmt.Errorf("Date(%s) detected without an active current Work struct instance. Possibly malformed XML.", dateText)
+				}
+
+				if date, err := parseDate(dateText); err == nil {
+					newWork.Date = date
+				} else {
+					fmt.Fprintf(os.Stderr, "Ignoring unreadable date (%s) of work %s\n", dateText, newWork.ID)
+				}
+			}
+
+			// Work image checksums (optional - a checksum that can't be read is reported and ignored)
+			if len(stack) > 0 && (stack[len(stack)-1] == CHECKSUM || stack[len(stack)-1] == MD5) && newWork != nil {
+				algorithm := ""
+				if stack[len(stack)-1] == MD5 {
+					algorithm = "md5"
+				}
+
+				sum, err := parseChecksum(string(token), algorithm)
+				if err == nil && checksumImage != URISMALL && checksumImage != URIMEDIUM && checksumImage != URILARGE {
+					err = fmt.Errorf("unknown image type %q (expected small, medium or large)", checksumImage)
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Ignoring unreadable checksum of work %s: %v\n", newWork.ID, err)
+				} else {
+					if newWork.Checksums == nil {
+						newWork.Checksums = map[string]string{}
+					}
+					newWork.Checksums[checksumImage] = sum
 				}
 			}
 
@@ -201,27 +1047,19 @@ func main() {
 					}
 
 					// check if this make is recorded in the global makes list
-					makeFound := false
-					for _, make := range makes {
-						if make != nil && make.Name == thisToken {
-							thisMake = make
-							makeFound = true
-							break
-						}
-					}
-
-					if makeFound {
+					if known := lookup.findMake(thisToken); known != nil {
 						// known make, populate work's make attribute with the make from the global list
+						thisMake = known
 						works[len(works)-1].WMake = thisMake
 					} else {
 						// new make: create and add to global makes list, and populate this work's make attribute with it
 						thisMake = createMake(thisToken)
 						makes = append(makes, thisMake)
+						lookup.addMake(thisMake)
 						works[len(works)-1].WMake = thisMake
 					}
 				} else {
-					fmt.Fprintf(os.Stderr, "Make (%s) detected with no active Work element - possibly malformed XML input", thisToken)
-					os.Exit(1)
+					return nil, fmt.Errorf("Make (%s) detected with no active Work element - possibly malformed XML input", thisToken)
 				}
 
 				// camera model detected for this work?
@@ -232,8 +1070,7 @@ func main() {
 					if len(works) > 0 {
 						thisWork = works[len(works)-1]
 					} else {
-						fmt.Fprintf(os.Stderr, "No works recorded, but already processing a camera model - malformed XML?")
-						os.Exit(1)
+						return nil, fmt.Errorf("No works recorded, but already processing a camera model - malformed XML?")
 					}
 
 					// if the model name is empty
@@ -242,24 +1079,15 @@ func main() {
 					}
 
 					// check if this model is recorded in this make's model list
-					modelFound := false
-					if thisWork.WMake != nil {
-						for _, model := range thisWork.WMake.Models {
-							if model != nil && model.Name == newModel {
-								thisModel = model
-								modelFound = true
-							}
-						}
-					}
-
-					if modelFound {
-						thisWork.WModel = thisModel
+					if known := lookup.findModel(thisMake, newModel); known != nil {
+						thisWork.WModel = known
 					} else {
 						thisModel = createModel(newModel, thisMake)
 
 						if thisWork.WMake != nil {
 							thisWork.WMake.Models = append(thisWork.WMake.Models, thisModel)
 							thisWork.WModel = thisModel
+							lookup.addModel(thisModel)
 						}
 
 						thisModel = nil
@@ -304,15 +1132,20 @@ func main() {
 		}
 	}
 
-	fmt.Println("XML data parsing complete - generating static site...")
+	return &Catalog{Works: works, Makes: makes, WorksSM: worksSM, Authors: authors, Lenses: lenses, lookup: lookup}, nil
+}
+
+// write the static site (index, make, model and generic works pages) for the given catalog to the output directory
+func generateSite(catalog *Catalog, outputFolderLocation string, opts *buildOptions) error {
+	works, makes, worksSM := catalog.Works, catalog.Makes, catalog.WorksSM
+	phases.enter("write")
 
 	// ------- Generate index.html -------------------
 	// check if the specified output directory exists - if not, create it
 	fileInPlace, e := fileExists("./" + outputFolderLocation)
 
 	if e != nil {
-		fmt.Fprintf(os.Stderr, "Error checking output directory placement: %v\n", e)
-		os.Exit(1)
+		return fmt.Errorf("Error checking output directory placement: %v", e)
 	}
 
 	if fileInPlace {
@@ -322,233 +1155,698 @@ func main() {
 		os.MkdirAll("./"+outputFolderLocation, 0755)
 	}
 
-	// open output file for writing
-	outFileName := "./" + outputFolderLocation + "/index.html"
-	f, err := os.Create(outFileName)
+	applyDisplayNames(catalog, opts.DisplayNames, opts.TitleCase)
 
+	theme, err := loadTheme(opts.Theme)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating index HTML file: %v\n", err)
-		os.Exit(1)
+		return withExitCode(exitConfig, err)
 	}
 
-	defer f.Close()
+	// every page goes through the site writer, which runs page hooks and keeps track of the files written
+	site := &siteWriter{outputFolderLocation: outputFolderLocation, baseURL: opts.BaseURL, templates: theme.Templates, overrides: theme}
+	if opts.NearDuplicates.Collapse {
+		site.nearDuplicates = catalog.nearDuplicateGroups()
+	}
+	protection := ""
+	if opts.ProtectPages != "" {
+		if site.protection, err = newPageProtection(opts.ProtectPages, opts.PassphraseEnv, outputFolderLocation); err != nil {
+			return withExitCode(exitConfig, err)
+		}
+		protection = site.protection.fingerprint()
+	}
+	if opts.Incremental {
+		site.incremental = newIncrementalBuild(outputFolderLocation, theme, protection, catalog)
+	}
 
-	// dropdown navigation to all camera makes
-	indexNavigation := `<select onchange="if (this.value) window.location.href=this.value"><option value="">-- select a camera make</option>`
-	for _, mk := range makes {
-		if mk != nil {
-			indexNavigation = indexNavigation + `<option value="` + html.EscapeString(mk.PageURL) + `.html">` + html.EscapeString(mk.Name) + `</option>`
+	// shared stylesheet and navigation script linked from every page
+	assets, assetFiles, err := writeSiteAssets(catalog, outputFolderLocation, opts, theme)
+	if err != nil {
+		return err
+	}
+	site.written = append(site.written, assetFiles...)
+
+	if opts.Mobile {
+		mobile, mobileFiles, err := newMobileVariant(outputFolderLocation, site.templates, assets.CSP, opts)
+		if err != nil {
+			return err
 		}
+		site.mobile = mobile
+		site.written = append(site.written, mobileFiles...)
 	}
 
-	// if any works with no makes are recorded, add a generic option in navigation to access the page for those
-	if len(worksSM) > 0 {
-		indexNavigation = indexNavigation + `<option value="nomake.html">(no make/generic)</option></select>`
-	} else {
-		indexNavigation = indexNavigation + `</select>`
+	if opts.CSP {
+		headerFiles, err := writeSecurityHeaders(catalog, outputFolderLocation)
+		if err != nil {
+			return err
+		}
+		site.written = append(site.written, headerFiles...)
 	}
 
-	// create thumbnails of first 10 works
-	indexContent := "" // holding variable for image HTML
-	imgCount := 0      // counter to ensure first 10 image works are shown at most on index page
+	if opts.ServerConfig.Dir != "" {
+		if err := writeServerConfig(catalog, outputFolderLocation, opts); err != nil {
+			return err
+		}
+	}
 
-	// create and append HTML to display each work (up to first ten)
-	for _, wk := range works {
-		indexContent = indexContent + `<img src=` + html.EscapeString(wk.URISmall) + `> `
+	// Atom feeds, linked from the index, make and model pages for autodiscovery
+	feeds := opts.AtomFeeds && opts.BaseURL != ""
+	if opts.AtomFeeds && !feeds {
+		fmt.Fprintln(os.Stderr, "Atom feeds skipped: feed readers need absolute links, so set the site's base URL (--base-url)")
+	}
+	feedLink := func(file string) string {
+		if !feeds {
+			return ""
+		}
+		return file
+	}
 
-		imgCount++
-		if imgCount >= 10 {
-			break
+	// makes offered in the homepage navigation
+	var navMakes []*Make
+	for _, mk := range makes {
+		if mk != nil {
+			navMakes = append(navMakes, mk)
+		}
+	}
+
+	// zip archives of each make's and model's images, linked from their pages
+	var downloads map[string]*downloadView
+	if opts.ZipDownloads {
+		var archiveFiles []string
+		if downloads, archiveFiles, err = writeDownloadArchives(makes, outputFolderLocation); err != nil {
+			return err
 		}
+		site.written = append(site.written, archiveFiles...)
 	}
 
-	// write the HTML structure of the index page containing navigation and image HTML to outout page
-	_, err = f.WriteString(`<!DOCTYPE html><html><head><title>Welcome to Phoots!</title><style type="text/css">nav { margin: 10px;	}</style></head><body><header><h1>Welcome to Photos!</h1><nav>` + indexNavigation + `</nav></header>` + indexContent + `</body></html>`)
+	// contact sheets of each year's works, linked from the homepage
+	hasArchive := generateArchive(site, works, assets)
+
+	// a gallery page per photographer, listed on the authors page linked from the homepage
+	hasAuthors := generateAuthorPages(site, catalog, assets, opts.Layouts["author"])
+
+	// a gallery page per lens, listed with their counts on the lenses page linked from the homepage
+	hasLenses := generateLensPages(site, catalog, assets, opts.Layouts["lens"])
 
+	// a gallery page per range of focal lengths, apertures and ISO speeds, listed on the facets page linked from the homepage
+	hasFacets := generateFacetPages(site, catalog, assets, opts.Layouts["facet"])
+
+	// side-by-side comparisons of the configured makes and models, listed on a page linked from the homepage
+	hasCompare := generateComparePages(site, catalog, opts.Compare, assets)
+
+	// a gallery page per country and city the works were taken in, listed on the places page linked from the homepage
+	hasPlaces := generatePlacePages(site, works, assets, opts.Layouts["place"])
+
+	// the flagged works, in section mode, on a page of their own behind a notice, linked from the homepage
+	hasFlagged := generateFlaggedPage(site, catalog, assets, opts.Layouts["flagged"])
+
+	// homepage gallery, in the order chosen by the index selection strategy
+	indexWorks, err := selectIndexWorks(works, opts.IndexSelection, opts.IndexSeed)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing output to disk file: %v\n", err)
-		os.Exit(1)
+		return err
 	}
 
-	f.Sync()
+	// write the index page containing navigation (with a generic option if any works with no makes are recorded) and the gallery
+	err = site.writeGallery("index.html", "index", indexWorks, opts.Layouts["index"], &pageView{Title: "Welcome to Phoots!", Assets: assets, Makes: navMakes, HasGeneric: len(worksSM) > 0, Feed: feedLink(siteFeedFile), Trending: galleryItems(trendingWorks(works, opts.Popularity, opts.Trending), "small"), HasArchive: hasArchive, HasAuthors: hasAuthors, HasLenses: hasLenses, HasPlaces: hasPlaces, HasFacets: hasFacets, HasCompare: hasCompare, HasFlagged: hasFlagged})
+
+	if err != nil {
+		site.fail(fmt.Errorf("Error writing output to disk file (index.html): %v", err))
+	}
 
 	// ------------- Generate individual pages for each of the camera makes ------------------
 
 	// for each make recorded
 	for _, mk := range makes {
 		if mk != nil {
-			// create HTML file
-			outFileName := "./" + outputFolderLocation + "/" + mk.PageURL + ".html"
-			f, err := os.Create(outFileName)
+			// write the make HTML page (with dropdown navigation to all camera models of this make) to file
+			view := &pageView{Title: titleOr(mk.Title, "All photos taken with a "+mk.DisplayName), Heading: mk.Title, Assets: assets, Make: mk, Feed: feedLink(feedFile(mk.PageURL)), Download: downloads[mk.PageURL]}
+			view.Description, view.DescriptionParts = catalog.renderDescription(mk.Description)
+			err := site.writeGallery(mk.PageURL+".html", "make", worksByMake(mk.Works, mk), opts.Layouts["make"], view)
 
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating camera make page: %v\n", err)
-				os.Exit(1)
+				site.fail(fmt.Errorf("Error writing output to make HTML file (%s.html): %v", mk.PageURL, err))
 			}
+		}
+	}
+
+	// ------------- Generate separate page for works without a make ------------------
+	// one page holding every work recorded without a camera make
+	if len(worksSM) > 0 {
+		// write to output file
+		err := site.writeGallery("nomake.html", "nomake", worksSM, opts.Layouts["nomake"], &pageView{Title: "Generic Photographic Works", Assets: assets})
 
-			defer f.Close()
+		if err != nil {
+			site.fail(fmt.Errorf("Error writing output to generic make works file (nomake.html): %v", err))
+		}
+	}
 
-			// dropdown navigation to all camera models of this make
-			modelNavigation := `<select onchange="if (this.value) window.location.href=this.value"><option value="">-- select a camera model</option>`
+	// ------------- Generate individual pages for each of the camera models ------------------
+	// for each make
+	for _, mk := range makes {
+		if mk != nil {
+			// for each model of this make
 			for _, md := range mk.Models {
 				if md != nil {
-					modelNavigation = modelNavigation + `<option value="` + html.EscapeString(md.PageURL) + `.html">` + html.EscapeString(md.Name) + `</option>`
+					// write HTML content to output file
+					view := &pageView{Title: titleOr(md.Title, "All photos taken with a "+md.DisplayName), Heading: md.Title, Assets: assets, Make: mk, Model: md, Feed: feedLink(feedFile(md.PageURL)), Download: downloads[md.PageURL]}
+					view.Description, view.DescriptionParts = catalog.renderDescription(md.Description)
+					err := site.writeGallery(md.PageURL+".html", "model", worksByMake(md.Works, mk), opts.Layouts["model"], view)
+
+					if err != nil {
+						site.fail(fmt.Errorf("Error writing output to model HTML file (%s.html): %v", md.PageURL, err))
+					}
 				}
 			}
+		}
+	}
 
-			modelNavigation = modelNavigation + `</select>`
+	// ------------- Generate a slideshow for each make and model ------------------
+	generateSlideshows(site, makes, assets, opts.SlideshowInterval)
 
-			// create thumbnail HTML for first 10 works by this make
-			makeContent := ""
-			imgCount := 0
+	// ------------- Generate a detail page for each work ------------------
+	generateWorkPages(site, catalog, assets, opts.HTMLPolicy)
+	writeWorkIndex(site, catalog) // see WorkIndex.go
 
-			for _, wk := range mk.Works {
-				if wk != nil && wk.WMake != nil && wk.WMake.Name == mk.Name {
-					makeContent = makeContent + `<img src=` + html.EscapeString(wk.URISmall) + `> `
+	// ------------- Generate Atom feeds ------------------
+	if feeds {
+		feedFiles, err := writeAtomFeeds(catalog, outputFolderLocation, opts.BaseURL, opts.FeedEntries, site.incremental)
+		if err != nil {
+			return err
+		}
+		site.written = append(site.written, feedFiles...)
 
-					imgCount++
-					if imgCount >= 10 {
-						break
-					}
-				}
-			}
+		// every feed in one OPML file, for feed readers to subscribe to them all at once
+		opml, err := catalogOPML(catalog, opts.BaseURL)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile("./"+outputFolderLocation+"/"+feedsOPMLFile, opml, 0644); err != nil {
+			return fmt.Errorf("Error writing feed outline (%s): %v", feedsOPMLFile, err)
+		}
+		site.written = append(site.written, feedsOPMLFile)
+	}
 
-			// write the make HTML page's output to file
-			_, err = f.WriteString(`<!DOCTYPE html><html><head><title>All photos taken with a ` + html.EscapeString(mk.Name) + `</title><style type="text/css">nav { margin: 10px;	}</style></head><body><header><h1>All photos taken with a <i>` + mk.Name + `</i> camera</h1><nav><a href="index.html">back to homepage</a> | ` + modelNavigation + `</nav></header>` + makeContent + `</body></html>`)
+	// ------------- Generate printable contact sheets ------------------
+	if opts.ContactSheets != "" {
+		if err := writeContactSheets(catalog, outputFolderLocation, opts.ContactSheets); err != nil {
+			return err
+		}
+		site.written = append(site.written, contactSheetsFile)
+	}
 
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error writing output to make HTML file: %v\n", err)
-				os.Exit(1)
-			}
+	// ------------- Validate the generated pages ------------------
+	if opts.ValidateHTML || opts.Strict {
+		if err := checkGeneratedHTML(outputFolderLocation, site.written, opts.Strict); err != nil {
+			return err
+		}
+	}
 
-			f.Sync()
+	checkPathLengths(site.written)
+
+	if site.incremental != nil {
+		if err := site.incremental.finish(); err != nil {
+			return err
 		}
+		site.written = append(site.written, incrementalStateFile)
 	}
 
-	// ------------- Generate separate page for works without a make ------------------
-	// for each work recorded without a camera make
-	if len(worksSM) > 0 {
-		for _, wk := range worksSM {
-			if wk != nil {
-				outFileName := "./" + outputFolderLocation + "/nomake.html"
-				f, err := os.Create(outFileName)
+	// redirects from the make and model pages that moved since the last build, to where they are now
+	prevManifest, _ := readBuildManifest("./" + outputFolderLocation)
+	redirects := pageRedirects(prevManifest, catalog, site.written)
+	if opts.Redirects != "" {
+		redirectFiles, err := writeRedirects(outputFolderLocation, redirects, opts.Redirects)
+		if err != nil {
+			return err
+		}
+		site.written = append(site.written, redirectFiles...)
+	}
 
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error creating generic works page: %v\n", err)
-					os.Exit(1)
-				}
+	// record what was published, for diffing against later builds and purging what changed from the CDN
+	manifest := newBuildManifest(catalog, site.written)
+	manifest.Redirects = redirects
+	manifest.Pages = site.provenance
+	manifest.hashFiles(outputFolderLocation)
+	manifest.Files = append(manifest.Files, buildManifestFile)
+	site.written = manifest.Files
+	if err := manifest.write(outputFolderLocation); err != nil {
+		return err
+	}
 
-				defer f.Close()
+	// a partial site isn't handed on to the after-write hooks (which may deploy it)
+	if len(site.failed) > 0 {
+		return site.failed
+	}
 
-				// create image thumbnails
-				genericContent := ""
+	if err := runAfterWriteHooks(outputFolderLocation, site.written); err != nil {
+		return withExitCode(exitDeploy, err)
+	}
 
-				if wk != nil {
-					genericContent = genericContent + `<img src=` + html.EscapeString(wk.URISmall) + `> `
-				}
+	return withExitCode(exitDeploy, purgeCDN(opts.CDNPurge, opts.BaseURL, changedFiles(prevManifest, manifest)))
+}
 
-				// write to output file
-				_, err = f.WriteString(`<!DOCTYPE html><html><head><title>Generic Photographic Works</title><style type="text/css">nav { margin: 10px;	}</style></head><body><header><h1>Generic Photos</h1><nav><a href="index.html">back to homepage</a> </nav></header>` + genericContent + `</body></html>`)
+// return the works from the list that were taken with the given make
+func worksByMake(works []*Work, mk *Make) []*Work {
+	var matching []*Work
+	for _, wk := range works {
+		if wk != nil && wk.WMake != nil && wk.WMake.Name == mk.Name {
+			matching = append(matching, wk)
+		}
+	}
 
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error writing output to generic make works file: %v\n", err)
-					os.Exit(1)
-				}
+	return matching
+}
 
-				f.Sync()
-			}
+// type struct representing a generated page on its way to disk
+type Page struct {
+	Path string // file name relative to the output directory, e.g. Canon.html
+	Kind string // index, make, model or nomake
+	HTML string
+}
+
+// type struct representing the writer that puts generated pages into the output directory
+type siteWriter struct {
+	outputFolderLocation string
+	baseURL              string             // public URL of the site ("" if unknown)
+	templates            *template.Template // page templates of the site's theme
+	overrides            *siteTheme         // theme with page templates of particular makes and models (nil for none - see Theme.go)
+	written              []string           // paths (relative to the output directory) of every file written so far
+	failed               pageErrors         // pages that couldn't be generated - the rest of the site still is
+	incremental          *incrementalBuild  // pages rendered by the last build, to skip unchanged ones (nil to render every page)
+	protection           *pageProtection    // encryption of the password-protected pages (nil if none are)
+	mobile               *mobileVariant     // lightweight variant of the gallery pages to write under m/ (nil for none)
+	nearDuplicates       map[*Work]int      // group of each near-duplicate work, for gallery pages to show one per group (nil to show all)
+	provenance           pageProvenance     // works each page written shows, for the build manifest (see Provenance.go)
+}
+
+// type representing the pages of a site that couldn't be generated
+type pageErrors []error
+
+func (e pageErrors) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Error: %d pages couldn't be generated (the rest of the site was written):", len(e))
+	for _, err := range e {
+		b.WriteString("\n  " + err.Error())
+	}
+
+	return b.String()
+}
+
+// record a page that couldn't be generated and carry on with the rest of the site
+func (s *siteWriter) fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	s.failed = append(s.failed, err)
+}
+
+// return the canonical URL of a page of the site ("" without a base URL)
+func (s *siteWriter) canonical(page string) string {
+	if s.baseURL == "" {
+		return ""
+	}
+
+	return strings.TrimSuffix(s.baseURL, "/") + "/" + page
+}
+
+// render the page template with the view and write the page - in an incremental build, a page whose view is unchanged is left as it is
+func (s *siteWriter) writeView(path, kind string, view *pageView) error {
+	s.recordProvenance(path, view)
+
+	var state outputState
+	if s.incremental != nil {
+		var render bool
+		if state, render = s.incremental.checkPage(path, view); !render {
+			s.written = append(s.written, path)
+			return nil
 		}
 	}
 
-	// ------------- Generate individual pages for each of the camera models ------------------
-	// for each make
-	for _, mk := range makes {
-		if mk != nil {
-			// for each model of this make
-			for _, md := range mk.Models {
-				if md != nil {
-					// create HTML file
-					outFileName := "./" + outputFolderLocation + "/" + md.PageURL + ".html"
-					f, err := os.Create(outFileName)
+	phases.enter("render")
+	templates := s.templates
+	if s.overrides != nil {
+		templates = s.overrides.templatesFor(kind, view)
+	}
+	pageHTML, err := renderPage(templates, kind, view)
+	phases.enter("write")
+	if err != nil {
+		return err
+	}
 
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "Error creating camera model page: %v\n", err)
-						os.Exit(1)
-					}
+	if err := s.writePage(&Page{Path: path, Kind: kind, HTML: pageHTML}); err != nil {
+		return err
+	}
 
-					defer f.Close()
+	if s.incremental != nil {
+		s.incremental.record(path, state)
+	}
+	return nil
+}
 
-					// create thumbnail HTML of first 10 works by this model
-					modelContent := ""
-					imgCount := 0
+// run the before-render-page hooks on a page and write it to the output directory (encrypted, if it's a protected page)
+func (s *siteWriter) writePage(page *Page) error {
+	if err := runBeforeRenderPageHooks(page); err != nil {
+		return err
+	}
 
-					for _, wk := range md.Works {
-						if wk != nil && wk.WMake != nil && wk.WMake.Name == mk.Name {
-							modelContent = modelContent + `<img src=` + html.EscapeString(wk.URISmall) + `> `
+	if s.protection != nil && s.protection.matches(page.Path) {
+		encrypted, err := s.protection.encrypt(page.HTML)
+		if err != nil {
+			return fmt.Errorf("Error encrypting %s: %v", page.Path, err)
+		}
+		page.HTML = encrypted
+	}
 
-							imgCount++
-							if imgCount >= 10 {
-								break
-							}
-						}
-					}
+	f, err := os.Create("./" + s.outputFolderLocation + "/" + page.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-					// write HTML content to output file
-					_, err = f.WriteString(`<!DOCTYPE html><html><head><title>All photos taken with a ` + html.EscapeString(md.Name) + `</title><style type="text/css">nav { margin: 10px;	}</style></head><body><header><h1>All photos taken with a <i>` + html.EscapeString(md.Name) + `</i> camera</h1><nav><a href="index.html">back to homepage</a> | <a href="` + html.EscapeString(mk.PageURL) + `.html">back to make</a></nav></header>` + modelContent + `</body></html>`)
-				}
-			}
+	if _, err := f.WriteString(page.HTML); err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	s.written = append(s.written, page.Path)
+	return nil
+}
+
+//----------------- subcommands -------------------------------
+
+// type struct representing a subcommand invoked by name as the first command-line argument (e.g. >go run ImageProcessor doctor ...)
+type command struct {
+	Name    string
+	Usage   string // argument synopsis printed in help output
+	Summary string // one-line description printed in help output
+
+	// defines the subcommand's flags and returns the function running it with the positional arguments, which returns the process
+	// exit code - the flags can be listed without running it (see Completion.go)
+	Setup func(fs *flag.FlagSet) func(positional []string) int
+}
+
+// return a new flag set holding the subcommand's flags, and the function running it
+func (c *command) flags() (*flag.FlagSet, func(positional []string) int) {
+	fs := flag.NewFlagSet(c.Name, flag.ContinueOnError)
+	return fs, c.Setup(fs)
+}
+
+// run the subcommand with the remaining command-line arguments and return the process exit code
+func (c *command) run(args []string) int {
+	fs, run := c.flags()
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+
+	return run(positional)
+}
+
+// registry of all known subcommands - each subcommand registers itself from an init() function in its own file
+var commands []*command
+
+// add a subcommand to the registry
+func registerCommand(c *command) {
+	commands = append(commands, c)
+}
+
+// return the registered subcommand with the given name, or nil if there isn't one
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.Name == name {
+			return c
 		}
 	}
 
-	fmt.Println("Static site generation complete.")
+	return nil
+}
+
+// parse flags that may appear before, between or after positional arguments (the flag package stops at the first positional one) and return the positional arguments in order
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
 }
 
 //----------------- custom data types -------------------------------
 
+// type struct representing everything read from the works feed
+type Catalog struct {
+	Works   []*Work
+	Makes   []*Make
+	WorksSM []*Work   // works without a make specified
+	Authors []*Author // photographers of the works (see Authors.go)
+	Lenses  []*Lens   // lenses the works were taken with (see Lenses.go)
+
+	Excluded  []excludedWork   // works left out by the exclusion list, for the build manifest (see Exclusions.go)
+	Checksums []checksumResult // downloaded images checked against their feed checksums, for the build manifest (see Checksums.go)
+	Flagged   []*Work          // flagged works shown only on the flagged page, in section mode (see Flagged.go)
+
+	Duplicates     []duplicateImage // downloaded images identical to another work's and stored once, for the build manifest (see Dedupe.go)
+	NearDuplicates [][]*Work        // groups of visually near-identical works, in feed order (see NearDuplicates.go)
+
+	lookup *nameLookup // makes, models, authors and lenses by name, built when first needed (see Interning.go)
+}
+
 // type struct representing a photographic work
 type Work struct {
-	ID        int
-	FileName  string
-	WMake     *Make
-	WModel    *Model
-	URISmall  string
-	URIMedium string
-	URILarge  string
+	ID          WorkID // see WorkID.go
+	FileName    string
+	Title       string // title set in the overrides file, shown instead of the file name (see Overrides.go)
+	Description string // text shown on the work page, from the overrides file
+	Caption     string // HTML fragment from <caption>, sanitized before it's shown (see Sanitize.go)
+	WMake       *Make
+	WModel      *Model
+	Lens        *Lens             // lens, from <lens> (nil if unknown - see Lenses.go)
+	FocalLength float64           // focal length in mm (0 if unknown - see Facets.go)
+	Aperture    float64           // aperture as an f-number (0 if unknown)
+	ISO         int               // ISO speed (0 if unknown)
+	Author      *Author           // photographer, from <author> or <artist> (nil if unknown - see Authors.go)
+	Date        time.Time         // capture date (zero if unknown)
+	Featured    bool              // flagged for the homepage with <featured>
+	Flagged     bool              // sensitive, from <flagged>, <rating> or the overrides file (see Flagged.go)
+	Rating      string            // content rating, from <rating>, e.g. "mature"
+	Views       int               // view count from the popularity dataset (see Popularity.go)
+	RecentViews int               // recent view count from the popularity dataset
+	Popular     bool              // among the most viewed works, shown with a badge
+	Tags        []string          // <tag>s of the work
+	Checksums   map[string]string // feed checksums of the images, by size, e.g. "large": "md5:9e10..." (see Checksums.go)
+	Copyright   string            // copyright notice, e.g. "© 2019 Jane Doe"
+	License     string            // license as the feed (or the config's default) gives it, e.g. CC-BY-SA-4.0 (see License.go)
+	LicenseURL  string            // URL of the license text, from the <license> url attribute
+	GPS         *geoPoint         // position the work was taken at, from <latitude> and <longitude> or EXIF (nil if unknown)
+	Country     string            // country the work was taken in, reverse geocoded from its position (see Places.go)
+	City        string            // city the work was taken in (empty if unknown)
+	URISmall    string
+	URIMedium   string
+	URILarge    string
+
+	// paths of downloaded copies of the small/medium/large images, relative to the output directory (empty if not downloaded)
+	LocalSmall  string
+	LocalMedium string
+	LocalLarge  string
+
+	Variants map[string][]imageVariant // WebP/AVIF encodings of the local renditions, keyed by size (small, medium, large)
+
+	// intrinsic pixel dimensions of the thumbnail and medium images (0 if unknown)
+	SmallWidth   int
+	SmallHeight  int
+	MediumWidth  int
+	MediumHeight int
+
+	DominantColor string // most common color of the thumbnail as #rrggbb (empty if not computed)
 }
 
 // type struct representing a camera make
 type Make struct {
-	ID      int
-	Name    string
-	Models  []*Model
-	Works   []*Work
-	PageURL string
+	ID          int
+	Name        string
+	DisplayName string // name shown on the pages (see DisplayNames.go)
+	Title       string // page title set in the overrides file, replacing the generated one (see Overrides.go)
+	Description string // text shown at the top of the page, from the overrides file
+	Models      []*Model
+	Works       []*Work
+	PageURL     string
 }
 
 // type struct representing a camera model
 type Model struct {
-	ID      int
-	MMake   *Make
-	Works   []*Work
-	Name    string
-	PageURL string
+	ID          int
+	MMake       *Make
+	Works       []*Work
+	Name        string
+	DisplayName string // name shown on the pages (see DisplayNames.go)
+	Title       string // page title set in the overrides file, replacing the generated one (see Overrides.go)
+	Description string // text shown at the top of the page, from the overrides file
+	PageURL     string
+}
+
+// return the image source to use for this work's thumbnail - the downloaded copy if there is one, otherwise the feed's small URI
+func (w *Work) smallSrc() string {
+	if w.LocalSmall != "" {
+		return w.LocalSmall
+	}
+
+	return w.URISmall
+}
+
+// return the image source to use for this work's medium image
+func (w *Work) mediumSrc() string {
+	if w.LocalMedium != "" {
+		return w.LocalMedium
+	}
+
+	return w.URIMedium
+}
+
+// return the image source to use for this work's large image
+func (w *Work) largeSrc() string {
+	if w.LocalLarge != "" {
+		return w.LocalLarge
+	}
+
+	return w.URILarge
+}
+
+// return the file name of this work's detail page
+func (w *Work) pageURL() string {
+	return "work-" + w.ID.String() + ".html"
+}
+
+// return the number of works shown on the make's page, for the counts in the navigation
+func (m *Make) WorkCount() int {
+	return len(worksByMake(m.Works, m))
+}
+
+// return the number of works shown on the model's page
+func (m *Model) WorkCount() int {
+	if m.MMake == nil {
+		return 0
+	}
+
+	return len(worksByMake(m.Works, m.MMake))
+}
+
+// return the make with the given name, creating it and adding it to the catalog if it isn't recorded yet
+func (c *Catalog) findOrCreateMake(name string) *Make {
+	if mk := c.names().findMake(name); mk != nil {
+		return mk
+	}
+
+	mk := createMake(name)
+	c.Makes = append(c.Makes, mk)
+	c.names().addMake(mk)
+	return mk
+}
+
+// return the make's model with the given name, creating it and adding it to the make if it isn't recorded yet
+func (c *Catalog) findOrCreateModel(mk *Make, name string) *Model {
+	if md := c.names().findModel(mk, name); md != nil {
+		return md
+	}
+
+	md := createModel(name, mk)
+	mk.Models = append(mk.Models, md)
+	c.names().addModel(md)
+	return md
+}
+
+// move a work to the given make and model (empty names mean the generic make/model), keeping the works lists of the old and new make and model,
+// and the list of works without a make, consistent
+func (c *Catalog) assignMakeModel(wk *Work, makeName, modelName string) {
+	if makeName == "" {
+		makeName = "(Generic make)"
+	}
+
+	if modelName == "" {
+		modelName = "(Generic model)"
+	}
+
+	if wk.WMake != nil {
+		wk.WMake.Works = removeWork(wk.WMake.Works, wk)
+	}
+
+	if wk.WModel != nil {
+		wk.WModel.Works = removeWork(wk.WModel.Works, wk)
+	}
+
+	c.WorksSM = removeWork(c.WorksSM, wk)
+
+	mk := c.findOrCreateMake(makeName)
+	md := c.findOrCreateModel(mk, modelName)
+
+	wk.WMake, wk.WModel = mk, md
+	mk.Works = append(mk.Works, wk)
+	md.Works = append(md.Works, wk)
+}
+
+// remove a work from the catalog entirely (works list, its make, model, author and lens, works without a make) - returns false if it wasn't in the catalog
+func (c *Catalog) removeWork(wk *Work) bool {
+	before := len(c.Works)
+	c.Works = removeWork(c.Works, wk)
+	if len(c.Works) == before {
+		return false
+	}
+
+	if wk.WMake != nil {
+		wk.WMake.Works = removeWork(wk.WMake.Works, wk)
+	}
+
+	if wk.WModel != nil {
+		wk.WModel.Works = removeWork(wk.WModel.Works, wk)
+	}
+
+	if wk.Author != nil {
+		wk.Author.Works = removeWork(wk.Author.Works, wk)
+	}
+
+	if wk.Lens != nil {
+		wk.Lens.Works = removeWork(wk.Lens.Works, wk)
+	}
+
+	c.WorksSM = removeWork(c.WorksSM, wk)
+	return true
+}
+
+// return the given works list without the given work
+func removeWork(works []*Work, wk *Work) []*Work {
+	kept := works[:0]
+	for _, w := range works {
+		if w != wk {
+			kept = append(kept, w)
+		}
+	}
+
+	return kept
 }
 
 //---------generator functions to create and return references to Works/Makes/Models ----------
 
+// pattern of the runs of characters stripped from make and model names to make their HTML file names
+var slugPattern = regexp.MustCompile("[^A-Za-z0-9]+")
+
+// return the HTML file name (without extension) of a make or model page: its name with every run of non-alphanumerics replaced by a dash
+// and other scripts romanized where they can be (see Transliterate.go), made safe for Windows hosts (see FileNames.go)
+func pageSlug(name string) string {
+	return safeFileName(transliterateSlug(name))
+}
+
 // create and return a pointer to a make with a given string name
 func createMake(name string) *Make {
 	var m Make
 	m.Name = name
-
-	// create the HTML filename for this make by stripping make name of all non-alphanumerics
-	reg, err := regexp.Compile("[^A-Za-z0-9]+")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error generating make HTML filename: %v\n", err)
-		os.Exit(1)
-	}
-
-	m.PageURL = reg.ReplaceAllString(name, "-")
+	m.PageURL = pageSlug(name)
 	return &m
 }
 
@@ -557,22 +1855,14 @@ func createModel(name string, make *Make) *Model {
 	var m Model
 	m.Name = name
 	m.MMake = make
-
-	// create the HTML filename for this model by stripping model name of all non-alphanumerics
-	reg, err := regexp.Compile("[^A-Za-z0-9]+")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error generating model HTML filename: %v\n", err)
-		os.Exit(1)
-	}
-
-	m.PageURL = reg.ReplaceAllString(name, "-")
+	m.PageURL = pageSlug(name)
 	return &m
 }
 
 // create and return a pointer to a work
 func createWork() *Work {
 	var w Work
-	w.ID = -1
+	w.ID = ""
 	w.FileName = ""
 	w.WMake = nil
 	w.WModel = nil
@@ -618,13 +1908,26 @@ func printWork(w *Work) {
 		wModelName = w.WModel.Name
 	}
 
-	fmt.Println("[" + strconv.Itoa(w.ID) + "| " + wMakeName + "| " + wModelName + "]")
+	fmt.Println("[" + w.ID.String() + "| " + wMakeName + "| " + wModelName + "]")
 	fmt.Println("\t Thumbnail: " + w.URISmall)
 	fmt.Println("\t Medium: " + w.URIMedium)
 	fmt.Println("\t Large: " + w.URILarge)
 	return
 }
 
+// parse a date in one of the formats feeds commonly use (RFC 3339, ISO date with or without time, EXIF date/time)
+func parseDate(text string) (time.Time, error) {
+	layouts := []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02", "2006:01:02 15:04:05"}
+
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unrecognized date format: %s", text)
+}
+
 // returns a boolean flag indicating whether the given file or directory exists or not, along with an error that may have occured while checking
 func fileExists(path string) (bool, error) {
 	_, err := os.Stat(path)
@@ -0,0 +1,393 @@
+// image localization: downloads the images referenced by each work into the output directory so the site is self-contained - several at
+// once, resuming interrupted downloads, retrying transient failures and checking each file against the size and checksums the server sends
+// (and the feed's checksums, see Checksums.go).
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sub-directory of the output directory that downloaded images are stored in
+const imagesDir = "images"
+
+// type struct representing one image rendition to localize
+type imageDownload struct {
+	wk     *Work
+	size   string
+	uri    string
+	target string
+	local  *string
+	want   map[string][]byte // the feed's checksum of the image (nil for none)
+	again  bool              // a copy was already there, but didn't match the feed's checksum
+}
+
+// download the small, medium and large images of every work into <output-dir>/images (skipping files already there from a previous run, except
+// the medium and large ones when refreshLarger is set)
+// with the given number of downloads in flight at once, and point the works' Local* fields at the copies - a failed download is
+// reported and the work keeps its remote URI
+func localizeImages(catalog *Catalog, outputFolderLocation string, concurrency int, refreshLarger bool, cache *buildCache) error {
+	dir := filepath.Join("./"+outputFolderLocation, imagesDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Error creating images directory (%s): %v", dir, err)
+	}
+
+	var downloads []imageDownload
+	skipped := 0
+
+	for _, wk := range catalog.Works {
+		if wk == nil {
+			continue
+		}
+
+		for _, r := range workRenditions(wk) {
+			// only remote images can be localized - relative URIs already point into the site
+			if !strings.HasPrefix(r.uri, "http://") && !strings.HasPrefix(r.uri, "https://") {
+				continue
+			}
+
+			name := localImageName(wk, r.size, r.uri)
+			target := filepath.Join(dir, name)
+
+			want := wk.checksumDigests(r.size)
+			again := false
+
+			// watermarked renditions are fetched again rather than reused, so the watermark goes onto the original
+			if info, err := os.Stat(target); err == nil && info.Size() > 0 && !(refreshLarger && r.size != "small") {
+				if err := verifyDigests(target, want, "the feed has"); err == nil {
+					*r.local = imagesDir + "/" + name
+					if want != nil {
+						catalog.Checksums = append(catalog.Checksums, checksumResult{ID: wk.ID, Image: r.size, Status: "verified"})
+					}
+					skipped++
+					continue
+				}
+
+				// the copy from an earlier build doesn't match the feed's checksum - download it again
+				fmt.Fprintf(os.Stderr, "The %s image of work %s doesn't match its checksum - downloading it again\n", r.size, wk.ID)
+				os.Remove(target)
+				again = true
+			}
+
+			downloads = append(downloads, imageDownload{wk: wk, size: r.size, uri: r.uri, target: target, local: r.local, want: want, again: again})
+		}
+	}
+
+	// identical copies already there are stored once, and known duplicates of them aren't downloaded (see Dedupe.go)
+	dedupe := newImageDeduper(outputFolderLocation, cache)
+	dedupe.dedupe(catalog)
+
+	var fetch []imageDownload
+	for _, d := range downloads {
+		if kept := dedupe.knownCopy(d.size, d.uri); kept != "" && verifyDigests(filepath.Join(dir, filepath.Base(kept)), d.want, "the feed has") == nil {
+			*d.local = kept
+			dedupe.reuse(d.wk, d.size, kept)
+			continue
+		}
+		fetch = append(fetch, d)
+	}
+	downloads = fetch
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	jobs := make(chan imageDownload)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	downloaded, failed := 0, 0
+	var checked []checksumResult
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range jobs {
+				err := downloadFile(d.uri, d.target, d.want)
+
+				mu.Lock()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error downloading %s image of work %s (%s): %v\n", d.size, d.wk.ID, d.uri, err)
+					failed++
+				} else {
+					*d.local = imagesDir + "/" + filepath.Base(d.target)
+					downloaded++
+				}
+				if d.want != nil {
+					status := "verified"
+					if err != nil {
+						status = "failed"
+					} else if d.again {
+						status = "redownloaded"
+					}
+					checked = append(checked, checksumResult{ID: d.wk.ID, Image: d.size, Status: status})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, d := range downloads {
+		jobs <- d
+	}
+	close(jobs)
+	wg.Wait()
+
+	// downloads finish in any order - the manifest lists them in catalog order
+	order := map[*Work]int{}
+	for i, wk := range catalog.Works {
+		order[wk] = i
+	}
+	sizes := map[string]int{"small": 0, "medium": 1, "large": 2}
+	byWork := map[WorkID]*Work{}
+	for _, d := range downloads {
+		byWork[d.wk.ID] = d.wk
+	}
+	sort.SliceStable(checked, func(i, j int) bool {
+		a, b := order[byWork[checked[i].ID]], order[byWork[checked[j].ID]]
+		return a < b || (a == b && sizes[checked[i].Image] < sizes[checked[j].Image])
+	})
+	catalog.Checksums = append(catalog.Checksums, checked...)
+
+	dedupe.dedupe(catalog)
+	catalog.Duplicates = dedupe.found
+
+	fmt.Printf("Images: %d downloaded, %d already present, %d failed.\n", downloaded, skipped, failed)
+	if len(catalog.Duplicates) > 0 {
+		var saved int64
+		for _, dup := range catalog.Duplicates {
+			saved += dup.Bytes
+		}
+		fmt.Printf("Duplicates: %d images identical to another work's stored once, %s saved.\n", len(catalog.Duplicates), formatSize(saved))
+	}
+	if len(catalog.Checksums) > 0 {
+		fmt.Printf("Checksums: %d images checked against the feed's checksums, %d failed.\n", len(catalog.Checksums), countChecksums(catalog.Checksums, "failed"))
+	}
+	return nil
+}
+
+// return the file name a work's image rendition is stored under: <work id>-<size><extension of the remote file>
+func localImageName(wk *Work, size, uri string) string {
+	ext := ".jpg"
+	if u, err := url.Parse(uri); err == nil {
+		if e := strings.ToLower(path.Ext(u.Path)); e != "" && len(e) <= 5 && !slugPattern.MatchString(e[1:]) {
+			ext = e
+		}
+	}
+
+	return wk.ID.String() + "-" + size + ext
+}
+
+// download the given URL to the target path, retrying transient failures (up to downloadRetries more attempts, with exponential backoff)
+// the body goes to <target>.part first and is only renamed into place once it's complete and matches the size and checksums the server
+// sent, so an interrupted download never leaves a partial image behind - and the next attempt (or the next build) resumes it where it
+// stopped. Images already in the persistent cache are copied from there, and new downloads are added to it. want holds the feed's
+// checksum of the image (nil for none): a copy that doesn't match it, cached or downloaded, isn't kept
+func downloadFile(uri, target string, want map[string][]byte) error {
+	if cached, ok := cachedCopy(cacheImagesDir, uri); ok {
+		if err := copyFileAtomic(cached, target); err != nil {
+			return err
+		}
+		err := verifyDigests(target, want, "the feed has")
+		if err == nil {
+			return nil
+		}
+		os.Remove(target)
+		if offline {
+			return fmt.Errorf("cached copy: %v", err)
+		}
+		// the cached copy is out of date - download it again (the download replaces it in the cache)
+	}
+
+	if offline {
+		return errNotCached
+	}
+
+	part := target + ".part"
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = downloadPart(uri, part, want); err == nil || attempt >= downloadRetries || !transientDownloadError(err) {
+			break
+		}
+
+		time.Sleep(downloadBackoff << attempt)
+	}
+	if err != nil {
+		return err
+	}
+
+	os.Remove(part + ".validator")
+	if err := os.Rename(part, target); err != nil {
+		return err
+	}
+
+	storeFileInCache(cacheImagesDir, uri, target)
+	return nil
+}
+
+// further attempts at a download after a transient failure (set from --download-retries by configureFetching)
+var downloadRetries = 3
+
+// wait before the first retry of a download - doubled for each one after
+const downloadBackoff = 500 * time.Millisecond
+
+// make one attempt at downloading uri into the partial file, resuming from the bytes already there - the ETag or Last-Modified date of
+// the download is kept in <part>.validator, so a file that changed on the server since is downloaded again from the start
+func downloadPart(uri, part string, want map[string][]byte) error {
+	var offset int64
+	if info, err := os.Stat(part); err == nil {
+		offset = info.Size()
+	}
+
+	validator := ""
+	if data, err := os.ReadFile(part + ".validator"); err == nil {
+		validator = string(data)
+	}
+
+	body, err := fetchFromWith(context.Background(), fetcher, signURL(uri), offset, validator)
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) && statusErr.Code == http.StatusRequestedRangeNotSatisfiable && offset > 0 {
+		// the partial file is no use to the server - start over
+		discardPart(part)
+		return fmt.Errorf("can't resume at byte %d: %v", offset, err)
+	}
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if body.Validator != "" {
+		os.WriteFile(part+".validator", []byte(body.Validator), 0644)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if body.Offset == 0 {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(f, body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	size := body.Offset + n
+	if body.Total >= 0 && size != body.Total {
+		if size > body.Total {
+			discardPart(part)
+		}
+		return fmt.Errorf("incomplete download: %d of %d bytes", size, body.Total)
+	}
+
+	if err := verifyDigests(part, body.Digests, "the server sent"); err != nil {
+		discardPart(part)
+		return err
+	}
+
+	if err := verifyDigests(part, want, "the feed has"); err != nil {
+		discardPart(part)
+		return err
+	}
+
+	return nil
+}
+
+// remove a partial download that can't be resumed, with its validator
+func discardPart(part string) {
+	os.Remove(part)
+	os.Remove(part + ".validator")
+}
+
+// check a downloaded file against checksums - from says where they came from, for the error ("the server sent", "the feed has")
+func verifyDigests(file string, digests map[string][]byte, from string) error {
+	if len(digests) == 0 {
+		return nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hashes := map[string]hash.Hash{"md5": md5.New(), "sha-256": sha256.New()}
+	var writers []io.Writer
+	for algorithm := range digests {
+		writers = append(writers, hashes[algorithm])
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), f); err != nil {
+		return err
+	}
+
+	for algorithm, want := range digests {
+		if got := hashes[algorithm].Sum(nil); !bytes.Equal(got, want) {
+			return fmt.Errorf("%s checksum mismatch: got %x, %s %x", algorithm, got, from, want)
+		}
+	}
+
+	return nil
+}
+
+// report whether a failed download is worth another attempt: connection and read errors, short or corrupt bodies, server errors and
+// rate limiting are - other client errors and local filesystem errors aren't
+func transientDownloadError(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= 500 || statusErr.Code == http.StatusTooManyRequests || statusErr.Code == http.StatusRequestTimeout
+	}
+
+	var pathErr *fs.PathError
+	return !errors.As(err, &pathErr)
+}
+
+// fetch the first n bytes of a remote file (with a ranged request where the fetcher supports it), or read them from the persistent
+// cache's copy if it has one
+func fetchHead(uri string, n int) ([]byte, error) {
+	if cached, ok := cachedCopy(cacheImagesDir, uri); ok {
+		f, err := os.Open(cached)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		return io.ReadAll(io.LimitReader(f, int64(n)))
+	}
+
+	if offline {
+		return nil, errNotCached
+	}
+
+	body, err := fetchHeadWith(context.Background(), fetcher, signURL(uri), n)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return io.ReadAll(io.LimitReader(body, int64(n)))
+}
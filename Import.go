@@ -0,0 +1,239 @@
+// import subcommand: converts catalogs from other photo platforms into a works feed, or straight into a generated site.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// type struct representing a source format the import subcommand can read
+type importer struct {
+	Name    string                                    // format name given on the command line
+	Summary string                                    // one-line description shown in the usage message
+	Load    func(source string) ([]exportWork, error) // read the works from the given export file or directory
+
+	// the works' image URIs are paths of local files, which are copied into the site's images directory when building
+	LocalFiles bool
+}
+
+// image types importers pick up from local files - raw files and videos can't be shown on the site
+var webImageExts = []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
+
+// registered importers, keyed by name - each importer file adds itself from an init function
+var importers = map[string]*importer{}
+
+func registerImporter(im *importer) {
+	importers[im.Name] = im
+}
+
+func init() {
+	registerCommand(&command{
+		Name:    "import",
+		Usage:   "[--feed file] <format> <source> [output-dir]",
+		Summary: "convert another platform's export into a works feed (--feed, or stdout) and/or a generated site",
+		Setup:   setupImport,
+	})
+}
+
+// define the import subcommand's flags and return its runner: read the source with the named importer, then write the works feed
+// and/or build the site
+func setupImport(fs *flag.FlagSet) func(positional []string) int {
+	feedPath := fs.String("feed", "", "file to write the imported works feed to (default stdout, unless an output directory is given)")
+
+	return func(positional []string) int {
+		if len(positional) < 2 {
+			fmt.Fprintf(os.Stderr, "Error: please enter the import format and source (e.g. >go run ImageProcessor import wordpress export.xml site-output)\nFormats:\n%s", importerList())
+			return 2
+		}
+
+		im, ok := importers[positional[0]]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Error: unknown import format %q\nFormats:\n%s", positional[0], importerList())
+			return 2
+		}
+
+		works, err := im.Load(positional[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		catalog, err := catalogFromExport(works)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error importing %s: %v\n", positional[1], err)
+			return 1
+		}
+
+		fmt.Fprintf(os.Stderr, "Imported %d works from %s.\n", len(catalog.Works), positional[1])
+
+		if *feedPath != "" || len(positional) < 3 {
+			var out io.Writer = os.Stdout
+			if *feedPath != "" {
+				f, err := os.Create(*feedPath)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error creating feed file: %v\n", err)
+					return 1
+				}
+				defer f.Close()
+				out = f
+			}
+
+			if err := writeWorksXML(out, catalog); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing works feed: %v\n", err)
+				return 1
+			}
+		}
+
+		if len(positional) >= 3 {
+			if im.LocalFiles {
+				if err := copyImportedImages(catalog, positional[2]); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					return 1
+				}
+			}
+
+			if err := buildCatalog(catalog, positional[2], newBuildOptions()); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+
+		return 0
+	}
+}
+
+// copy the local image files of imported works into <output-dir>/images and point the works at the copies
+// only the large image is copied - the medium image is the same file and thumbnails are generated from it
+func copyImportedImages(catalog *Catalog, outputFolderLocation string) error {
+	dir := filepath.Join("./"+outputFolderLocation, imagesDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Error creating images directory (%s): %v", dir, err)
+	}
+
+	copied := 0
+
+	for _, wk := range catalog.Works {
+		if wk == nil || wk.URILarge == "" {
+			continue
+		}
+
+		source := filepath.FromSlash(wk.URILarge)
+		name := localImageName(wk, "large", wk.URILarge)
+		target := filepath.Join(dir, name)
+
+		if info, err := os.Stat(target); err != nil || info.Size() == 0 {
+			if err := copyFile(source, target); err != nil {
+				return fmt.Errorf("Error copying image of work %s (%s): %v", wk.ID, source, err)
+			}
+			copied++
+		}
+
+		if wk.URIMedium == wk.URILarge {
+			wk.URIMedium = imagesDir + "/" + name
+		}
+		if wk.URISmall == wk.URILarge {
+			wk.URISmall = imagesDir + "/" + name
+		}
+		wk.URILarge = imagesDir + "/" + name
+	}
+
+	fmt.Printf("Images: %d copied into %s.\n", copied, dir)
+	return nil
+}
+
+// return whether a file name has one of the web image extensions
+func isWebImage(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range webImageExts {
+		if ext == e {
+			return true
+		}
+	}
+
+	return false
+}
+
+// return the web image next to the given file with the same base name (e.g. the JPEG of a RAW+JPEG pair, or an exported copy),
+// or the file itself if it's a web image - an empty string if there's none
+func webImageFor(file string) string {
+	if isWebImage(file) {
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+	}
+
+	stem := strings.TrimSuffix(file, filepath.Ext(file))
+	for _, ext := range webImageExts {
+		for _, candidate := range []string{stem + ext, stem + strings.ToUpper(ext)} {
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				return candidate
+			}
+		}
+	}
+
+	return ""
+}
+
+// fill in the make and model an export left out from the EXIF data of the image itself
+func fillCameraFromExif(w *exportWork, image string) {
+	f, err := os.Open(image)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	head, err := io.ReadAll(io.LimitReader(f, exifProbeBytes))
+	if err != nil {
+		return
+	}
+
+	exif, err := readExif(head)
+	if err != nil {
+		return
+	}
+
+	if w.Make == "" {
+		w.Make = exif.text(exifTagMake)
+	}
+	if w.Model == "" {
+		w.Model = exif.text(exifTagModel)
+	}
+}
+
+// return the local date and time of an ISO 8601 capture time ("2019-05-09T11:06:40.35+02:00" or just "2019-05-09") in feed format,
+// dropping fractional seconds and the zone - an empty string if it can't be read
+func captureTime(text string) string {
+	text = strings.TrimSpace(text)
+	if len(text) > 19 {
+		text = text[:19]
+	}
+
+	t, err := parseDate(text)
+	if err != nil {
+		return ""
+	}
+
+	return t.Format("2006-01-02T15:04:05")
+}
+
+// return the registered import formats, one per line
+func importerList() string {
+	var names []string
+	for name := range importers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %-12s %s\n", name, importers[name].Summary)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,321 @@
+// Lightroom and XMP importers: read a Lightroom Classic catalog (.lrcat) or a folder of XMP sidecars (written by Lightroom, Capture One,
+// darktable and others) and turn each photo into a work, with the camera and capture time from the catalog or sidecar.
+// Library metadata maps onto works (rejected photos are left out, flagged or 5-star ones are featured), but develop settings can't be
+// applied - the site shows image files as they are, so raw files need a JPEG next to them (a RAW+JPEG pair or an exported copy).
+// Capture One catalogs aren't read; turn on its XMP sidecar sync and import the folder instead.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerImporter(&importer{
+		Name:       "lightroom",
+		Summary:    "Lightroom Classic catalog (.lrcat, with Lightroom closed) or a folder of its XMP sidecars",
+		Load:       loadLightroom,
+		LocalFiles: true,
+	})
+
+	registerImporter(&importer{
+		Name:       "xmp",
+		Summary:    "folder of XMP sidecars (Capture One, darktable, Lightroom) next to their photos",
+		Load:       loadXMPSidecars,
+		LocalFiles: true,
+	})
+}
+
+// XMP namespaces of the properties the importer reads
+const (
+	xmpNamespaceTIFF      = "http://ns.adobe.com/tiff/1.0/"
+	xmpNamespaceEXIF      = "http://ns.adobe.com/exif/1.0/"
+	xmpNamespaceXMP       = "http://ns.adobe.com/xap/1.0/"
+	xmpNamespacePhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+)
+
+// read a Lightroom catalog file, or a folder of XMP sidecars
+func loadLightroom(source string) ([]exportWork, error) {
+	if info, err := os.Stat(source); err == nil && info.IsDir() {
+		return loadXMPSidecars(source)
+	}
+
+	return loadLightroomCatalog(source)
+}
+
+// type struct representing the location of a file in a Lightroom catalog
+type lrFile struct {
+	BaseName, Extension string
+	Folder              int64
+}
+
+// type struct representing a folder in a Lightroom catalog (a path below one of its root folders)
+type lrFolder struct {
+	PathFromRoot string
+	Root         int64
+}
+
+// read the photos of a Lightroom Classic catalog as works, with the catalog's image ids as work ids
+func loadLightroomCatalog(source string) ([]exportWork, error) {
+	db, err := openSQLite(source)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening Lightroom catalog: %v", err)
+	}
+	defer db.Close()
+
+	for _, table := range []string{"Adobe_images", "AgLibraryFile", "AgLibraryFolder", "AgLibraryRootFolder"} {
+		if !db.hasTable(table) {
+			return nil, fmt.Errorf("Error reading Lightroom catalog: %s has no %s table", source, table)
+		}
+	}
+
+	if _, err := os.Stat(source + "-wal"); err == nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s has a write-ahead log - close Lightroom so recent changes are written to the catalog.\n", source)
+	}
+
+	roots := map[int64]string{}
+	folders := map[int64]lrFolder{}
+	files := map[int64]lrFile{}
+	models := map[int64]string{}
+	imageModels := map[int64]int64{}
+
+	scans := []struct {
+		table string
+		fn    func(sqliteRow) error
+	}{
+		{"AgLibraryRootFolder", func(r sqliteRow) error {
+			roots[r.integer("id_local")] = r.text("absolutePath")
+			return nil
+		}},
+		{"AgLibraryFolder", func(r sqliteRow) error {
+			folders[r.integer("id_local")] = lrFolder{PathFromRoot: r.text("pathFromRoot"), Root: r.integer("rootFolder")}
+			return nil
+		}},
+		{"AgLibraryFile", func(r sqliteRow) error {
+			files[r.integer("id_local")] = lrFile{BaseName: r.text("baseName"), Extension: r.text("extension"), Folder: r.integer("folder")}
+			return nil
+		}},
+		{"AgInternedExifCameraModel", func(r sqliteRow) error {
+			models[r.integer("id_local")] = strings.TrimSpace(r.text("value"))
+			return nil
+		}},
+		{"AgHarvestedExifMetadata", func(r sqliteRow) error {
+			imageModels[r.integer("image")] = r.integer("cameraModelRef")
+			return nil
+		}},
+	}
+
+	for _, s := range scans {
+		// the EXIF tables are only there once Lightroom has read some photos' metadata
+		if !db.hasTable(s.table) {
+			continue
+		}
+		if err := db.scanTable(s.table, s.fn); err != nil {
+			return nil, fmt.Errorf("Error reading %s of Lightroom catalog: %v", s.table, err)
+		}
+	}
+
+	var works []exportWork
+	rejected, unsupported := 0, 0
+
+	err = db.scanTable("Adobe_images", func(r sqliteRow) error {
+		// virtual copies share their master's file, and rejected photos aren't meant to be published
+		if r["masterImage"] != nil {
+			return nil
+		}
+		if r.integer("pick") < 0 {
+			rejected++
+			return nil
+		}
+
+		file, ok := files[r.integer("rootFile")]
+		if !ok {
+			return nil
+		}
+		folder := folders[file.Folder]
+		original := filepath.FromSlash(roots[folder.Root] + folder.PathFromRoot + file.BaseName + "." + file.Extension)
+
+		image := webImageFor(original)
+		if image == "" {
+			unsupported++
+			return nil
+		}
+
+		w := exportWork{
+			ID:        workIDFromInt(int(r.integer("id_local"))),
+			FileName:  filepath.Base(image),
+			URIMedium: filepath.ToSlash(image),
+			URILarge:  filepath.ToSlash(image),
+			Date:      captureTime(r.text("captureTime")),
+			Featured:  r.integer("pick") > 0 || r.integer("rating") >= 5,
+		}
+
+		if ref, ok := imageModels[r.integer("id_local")]; ok {
+			w.Model = models[ref]
+		}
+		// Lightroom keeps the camera model but not its make
+		fillCameraFromExif(&w, image)
+		if w.Make == "" && w.Model != "" {
+			w.Make = cameraMake(w.Model)
+		}
+
+		works = append(works, w)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error reading images of Lightroom catalog: %v", err)
+	}
+
+	reportSkippedPhotos(rejected, unsupported)
+	return works, nil
+}
+
+// read the photos described by the XMP sidecars in a folder (and its sub-folders) as works, numbered in path order
+func loadXMPSidecars(source string) ([]exportWork, error) {
+	var sidecars []string
+	err := filepath.WalkDir(source, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.EqualFold(filepath.Ext(p), ".xmp") {
+			sidecars = append(sidecars, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error reading XMP sidecars: %v", err)
+	}
+	sort.Strings(sidecars)
+
+	var works []exportWork
+	rejected, unsupported := 0, 0
+
+	for _, p := range sidecars {
+		props, err := readXMPSidecar(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Ignoring unreadable XMP sidecar %s: %v\n", p, err)
+			continue
+		}
+
+		rating, _ := strconv.Atoi(props[xmpNamespaceXMP+"Rating"])
+		if rating < 0 {
+			rejected++
+			continue
+		}
+
+		// sidecars are named after the photo with (darktable: "IMG_1.CR2.xmp") or without (Lightroom, Capture One: "IMG_1.xmp") its extension
+		image := webImageFor(strings.TrimSuffix(p, filepath.Ext(p)))
+		if image == "" {
+			unsupported++
+			continue
+		}
+
+		abs, err := filepath.Abs(image)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading XMP sidecars: %v", err)
+		}
+
+		w := exportWork{
+			ID:        workIDFromInt(len(works) + 1),
+			FileName:  filepath.Base(image),
+			URIMedium: filepath.ToSlash(abs),
+			URILarge:  filepath.ToSlash(abs),
+			Make:      props[xmpNamespaceTIFF+"Make"],
+			Model:     props[xmpNamespaceTIFF+"Model"],
+			Featured:  rating >= 5,
+		}
+
+		for _, prop := range []string{xmpNamespaceEXIF + "DateTimeOriginal", xmpNamespacePhotoshop + "DateCreated", xmpNamespaceXMP + "CreateDate"} {
+			if date := captureTime(props[prop]); date != "" {
+				w.Date = date
+				break
+			}
+		}
+
+		if w.Make == "" || w.Model == "" {
+			fillCameraFromExif(&w, image)
+		}
+		if w.Make == "" && w.Model != "" {
+			w.Make = cameraMake(w.Model)
+		}
+
+		works = append(works, w)
+	}
+
+	reportSkippedPhotos(rejected, unsupported)
+	return works, nil
+}
+
+// return the simple properties of an XMP sidecar's rdf:Description elements, keyed by namespace URI + name - properties can be written
+// as attributes or as child elements
+func readXMPSidecar(p string) (map[string]string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	props := map[string]string{}
+	dec := xml.NewDecoder(f)
+
+	depth, description := 0, -1
+	var property string
+	var text strings.Builder
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return props, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if t.Name.Local == "Description" && description < 0 {
+				description = depth
+				for _, a := range t.Attr {
+					props[a.Name.Space+a.Name.Local] = strings.TrimSpace(a.Value)
+				}
+			} else if description >= 0 && depth == description+1 {
+				property = t.Name.Space + t.Name.Local
+				text.Reset()
+			}
+
+		case xml.CharData:
+			if property != "" {
+				text.Write(t)
+			}
+
+		case xml.EndElement:
+			if description >= 0 && depth == description+1 && property != "" {
+				if value := strings.TrimSpace(text.String()); value != "" {
+					props[property] = value
+				}
+				property = ""
+			} else if depth == description {
+				description = -1
+			}
+			depth--
+		}
+	}
+}
+
+// report the photos an import left out
+func reportSkippedPhotos(rejected, unsupported int) {
+	if rejected > 0 {
+		fmt.Fprintf(os.Stderr, "Skipped %d rejected photos.\n", rejected)
+	}
+	if unsupported > 0 {
+		fmt.Fprintf(os.Stderr, "Skipped %d photos without a JPEG, PNG, GIF or WebP copy next to them (export one to include them).\n", unsupported)
+	}
+}
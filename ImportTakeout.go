@@ -0,0 +1,248 @@
+// Google Takeout importer: reads a Google Photos Takeout export (an extracted directory or a single .zip) and turns each photo with a
+// JSON sidecar into a work, taking the capture time from the sidecar and the camera from the sidecar or, failing that, the photo's EXIF data.
+
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerImporter(&importer{
+		Name:       "takeout",
+		Summary:    "Google Photos Takeout export (directory or .zip) - one work per photo with a JSON sidecar",
+		Load:       loadTakeout,
+		LocalFiles: true,
+	})
+}
+
+// sidecar name of a duplicate file name: "IMG_1234.JPG(1).json" describes "IMG_1234(1).JPG"
+var takeoutDuplicateName = regexp.MustCompile(`^(.+)(\.[^.]+)\((\d+)\)$`)
+
+// type struct representing a Takeout JSON sidecar - the camera fields are only present in some exports (and in the Library API's
+// mediaMetadata form used by other tools writing the same layout)
+type takeoutSidecar struct {
+	Title          string      `json:"title"`
+	URL            string      `json:"url"`
+	PhotoTakenTime takeoutTime `json:"photoTakenTime"`
+	CreationTime   takeoutTime `json:"creationTime"`
+	CameraMake     string      `json:"cameraMake"`
+	CameraModel    string      `json:"cameraModel"`
+	MediaMetadata  struct {
+		Photo struct {
+			CameraMake  string `json:"cameraMake"`
+			CameraModel string `json:"cameraModel"`
+		} `json:"photo"`
+	} `json:"mediaMetadata"`
+}
+
+// type struct representing a sidecar timestamp (Unix seconds, as a string)
+type takeoutTime struct {
+	Timestamp string `json:"timestamp"`
+}
+
+// read the photos of a Takeout export as works - a .zip is extracted next to the archive first (into a directory of the same name)
+func loadTakeout(source string) ([]exportWork, error) {
+	dir := source
+	if strings.EqualFold(filepath.Ext(source), ".zip") {
+		dir = strings.TrimSuffix(source, filepath.Ext(source))
+		if err := extractTakeout(source, dir); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "Extracted %s to %s.\n", source, dir)
+	}
+
+	var sidecars []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.EqualFold(filepath.Ext(p), ".json") {
+			sidecars = append(sidecars, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error reading Takeout export: %v", err)
+	}
+	sort.Strings(sidecars)
+
+	var works []exportWork
+	seen := map[string]bool{}
+	unmatched := 0
+
+	for _, p := range sidecars {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading Takeout sidecar: %v", err)
+		}
+
+		var sc takeoutSidecar
+		if err := json.Unmarshal(data, &sc); err != nil {
+			fmt.Fprintf(os.Stderr, "Ignoring unreadable Takeout sidecar %s: %v\n", p, err)
+			continue
+		}
+
+		image := takeoutImage(p, sc.Title)
+		if image == "" {
+			// album metadata.json files and sidecars of videos have no image to go with them
+			if sc.PhotoTakenTime.Timestamp != "" && isWebImage(sc.Title) {
+				unmatched++
+			}
+			continue
+		}
+
+		// the same photo is exported once per album it's in, as well as in its year folder
+		key := sc.URL
+		if key == "" {
+			key = sc.Title + "@" + sc.PhotoTakenTime.Timestamp
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		abs, err := filepath.Abs(image)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading Takeout export: %v", err)
+		}
+
+		w := exportWork{
+			ID:        workIDFromInt(len(works) + 1),
+			FileName:  filepath.Base(image),
+			URIMedium: filepath.ToSlash(abs),
+			URILarge:  filepath.ToSlash(abs),
+		}
+
+		for _, t := range []takeoutTime{sc.PhotoTakenTime, sc.CreationTime} {
+			if ts, err := strconv.ParseInt(t.Timestamp, 10, 64); err == nil && ts > 0 {
+				w.Date = time.Unix(ts, 0).UTC().Format("2006-01-02T15:04:05")
+				break
+			}
+		}
+
+		w.Make, w.Model = strings.TrimSpace(sc.CameraMake), strings.TrimSpace(sc.CameraModel)
+		if w.Make == "" {
+			w.Make = strings.TrimSpace(sc.MediaMetadata.Photo.CameraMake)
+		}
+		if w.Model == "" {
+			w.Model = strings.TrimSpace(sc.MediaMetadata.Photo.CameraModel)
+		}
+		if w.Make == "" || w.Model == "" {
+			fillCameraFromExif(&w, image)
+		}
+		if w.Make == "" && w.Model != "" {
+			w.Make = cameraMake(w.Model)
+		}
+
+		works = append(works, w)
+	}
+
+	if unmatched > 0 {
+		fmt.Fprintf(os.Stderr, "Skipped %d Takeout sidecars whose photo isn't in the export (Takeout may have split it into another archive).\n", unmatched)
+	}
+
+	return works, nil
+}
+
+// return the path of the image a sidecar describes, or an empty string if it isn't an image or isn't there
+func takeoutImage(sidecar, title string) string {
+	dir := filepath.Dir(sidecar)
+	name := strings.TrimSuffix(filepath.Base(sidecar), filepath.Ext(sidecar))
+
+	// newer exports name sidecars "<image>.supplemental-metadata.json", truncated to fit the file name limit (".supplemental-me.json" etc.)
+	if i := strings.Index(strings.ToLower(name), ".supp"); i > 0 && strings.HasPrefix(".supplemental-metadata", strings.ToLower(name[i:])) {
+		name = name[:i]
+	}
+
+	candidates := []string{name}
+	if m := takeoutDuplicateName.FindStringSubmatch(name); m != nil {
+		candidates = append(candidates, m[1]+"("+m[3]+")"+m[2])
+	}
+	// long file names are truncated differently for the image and the sidecar, so the sidecar's title is tried as well
+	if title != "" {
+		candidates = append(candidates, title)
+	}
+
+	for _, c := range candidates {
+		if !isWebImage(c) {
+			continue
+		}
+
+		p := filepath.Join(dir, c)
+		if info, err := os.Stat(p); err == nil && !info.IsDir() {
+			return p
+		}
+	}
+
+	return ""
+}
+
+// extract the sidecars and images of a Takeout archive into dir, skipping files already extracted by a previous import
+func extractTakeout(archive, dir string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return fmt.Errorf("Error opening Takeout archive: %v", err)
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		ext := strings.ToLower(filepath.Ext(f.Name))
+		if f.FileInfo().IsDir() || (ext != ".json" && !isWebImage(f.Name)) {
+			continue
+		}
+
+		// entries must stay inside the target directory
+		name := filepath.FromSlash(f.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("Error extracting Takeout archive: unsafe entry name %q", f.Name)
+		}
+
+		target := filepath.Join(dir, name)
+		if info, err := os.Stat(target); err == nil && uint64(info.Size()) == f.UncompressedSize64 {
+			continue
+		}
+
+		if err := extractZipFile(f, target); err != nil {
+			return fmt.Errorf("Error extracting %s from Takeout archive: %v", f.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// write one archive entry to the target path
+func extractZipFile(f *zip.File, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	in, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,305 @@
+// WordPress importer: reads a WordPress export (WXR) file and turns its image attachments into works, taking camera and capture time
+// from the EXIF data WordPress keeps in each attachment's _wp_attachment_metadata.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerImporter(&importer{
+		Name:    "wordpress",
+		Summary: "WordPress export file (Tools > Export, WXR) - one work per image attachment",
+		Load:    loadWordPressExport,
+	})
+}
+
+// type struct representing the parts of a WXR file the importer reads
+type wxrExport struct {
+	Items []wxrItem `xml:"channel>item"`
+}
+
+// type struct representing an <item> (post, page or attachment) in a WXR file
+type wxrItem struct {
+	Title         string        `xml:"title"`
+	PostID        int           `xml:"post_id"`
+	PostDate      string        `xml:"post_date"`
+	PostType      string        `xml:"post_type"`
+	AttachmentURL string        `xml:"attachment_url"`
+	Meta          []wxrPostMeta `xml:"postmeta"`
+}
+
+// type struct representing a <wp:postmeta> key/value pair
+type wxrPostMeta struct {
+	Key   string `xml:"meta_key"`
+	Value string `xml:"meta_value"`
+}
+
+// camera model prefixes and the EXIF make they belong to - WordPress only keeps the model ("camera"), so the make is inferred from it
+var cameraMakePrefixes = []struct {
+	Prefix, Make string
+}{
+	{"canon", "Canon"},
+	{"nikon", "NIKON CORPORATION"},
+	{"x-", "FUJIFILM"},
+	{"finepix", "FUJIFILM"},
+	{"gfx", "FUJIFILM"},
+	{"ilce-", "SONY"},
+	{"dsc-", "SONY"},
+	{"slt-", "SONY"},
+	{"dmc-", "Panasonic"},
+	{"dc-", "Panasonic"},
+	{"e-m", "OLYMPUS CORPORATION"},
+	{"leica", "LEICA"},
+	{"pentax", "PENTAX"},
+	{"iphone", "Apple"},
+	{"ipad", "Apple"},
+	{"pixel", "Google"},
+}
+
+// read the image attachments of a WXR file as works
+func loadWordPressExport(source string) ([]exportWork, error) {
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening WordPress export: %v", err)
+	}
+	defer f.Close()
+
+	var doc wxrExport
+	if err := xml.NewDecoder(f).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("Error reading WordPress export (%s): %v", source, err)
+	}
+
+	var works []exportWork
+
+	for _, item := range doc.Items {
+		if item.PostType != "attachment" || item.AttachmentURL == "" {
+			continue
+		}
+
+		w := exportWork{
+			ID:        workIDFromInt(item.PostID),
+			FileName:  path.Base(item.AttachmentURL),
+			URISmall:  item.AttachmentURL,
+			URIMedium: item.AttachmentURL,
+			URILarge:  item.AttachmentURL,
+		}
+
+		if date, err := parseDate(item.PostDate); err == nil {
+			w.Date = date.Format("2006-01-02T15:04:05")
+		}
+
+		for _, m := range item.Meta {
+			if m.Key != "_wp_attachment_metadata" {
+				continue
+			}
+
+			meta, err := unserializePHP(m.Value)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Ignoring unreadable attachment metadata of WordPress item %d: %v\n", item.PostID, err)
+				continue
+			}
+
+			applyWordPressMetadata(&w, item.AttachmentURL, meta)
+		}
+
+		works = append(works, w)
+	}
+
+	return works, nil
+}
+
+// fill in a work's image sizes, camera and capture time from an attachment's unserialized _wp_attachment_metadata
+func applyWordPressMetadata(w *exportWork, attachmentURL string, meta interface{}) {
+	fields, ok := meta.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	// resized copies live next to the original, under the file names listed in "sizes"
+	dir := attachmentURL[:strings.LastIndex(attachmentURL, "/")+1]
+	if sizes, ok := fields["sizes"].(map[string]interface{}); ok {
+		sizeURI := func(names ...string) string {
+			for _, name := range names {
+				if size, ok := sizes[name].(map[string]interface{}); ok {
+					if file, ok := size["file"].(string); ok && file != "" {
+						return dir + file
+					}
+				}
+			}
+			return ""
+		}
+
+		if uri := sizeURI("thumbnail"); uri != "" {
+			w.URISmall = uri
+		}
+		if uri := sizeURI("medium_large", "medium"); uri != "" {
+			w.URIMedium = uri
+		}
+		if uri := sizeURI("large"); uri != "" {
+			w.URILarge = uri
+		}
+	}
+
+	imageMeta, ok := fields["image_meta"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	if camera, ok := imageMeta["camera"].(string); ok && strings.TrimSpace(camera) != "" {
+		w.Model = strings.TrimSpace(camera)
+		w.Make = cameraMake(w.Model)
+	}
+
+	// created_timestamp is the EXIF capture time, stored as if it were UTC
+	if ts, err := strconv.ParseInt(fmt.Sprint(imageMeta["created_timestamp"]), 10, 64); err == nil && ts > 0 {
+		w.Date = time.Unix(ts, 0).UTC().Format("2006-01-02T15:04:05")
+	}
+}
+
+// return the camera make for a model name, from the known prefixes or else the model's first word
+func cameraMake(model string) string {
+	lower := strings.ToLower(model)
+	for _, p := range cameraMakePrefixes {
+		if strings.HasPrefix(lower, p.Prefix) {
+			return p.Make
+		}
+	}
+
+	return strings.Fields(model)[0]
+}
+
+//----------------- PHP serialization -------------------------------
+
+// decode a PHP serialize() value: arrays become map[string]interface{} (keys as strings), integers int64, floats float64,
+// booleans bool, strings string and N; nil
+func unserializePHP(data string) (interface{}, error) {
+	d := &phpDecoder{data: data}
+	v, err := d.value()
+	if err != nil {
+		return nil, fmt.Errorf("PHP serialized data at byte %d: %v", d.pos, err)
+	}
+
+	return v, nil
+}
+
+// type struct representing the read position in a PHP serialized value
+type phpDecoder struct {
+	data string
+	pos  int
+}
+
+func (d *phpDecoder) value() (interface{}, error) {
+	if d.pos >= len(d.data) {
+		return nil, fmt.Errorf("unexpected end of data")
+	}
+
+	kind := d.data[d.pos]
+	if kind == 'N' {
+		return nil, d.expect("N;")
+	}
+
+	if err := d.expect(string(kind) + ":"); err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case 'i':
+		text, err := d.until(';')
+		if err != nil {
+			return nil, err
+		}
+		return strconv.ParseInt(text, 10, 64)
+
+	case 'd':
+		text, err := d.until(';')
+		if err != nil {
+			return nil, err
+		}
+		return strconv.ParseFloat(text, 64)
+
+	case 'b':
+		text, err := d.until(';')
+		return text == "1", err
+
+	case 's':
+		// s:<byte length>:"<bytes>";
+		text, err := d.until(':')
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := strconv.Atoi(text)
+		if err != nil || n < 0 || d.pos+n+3 > len(d.data) || d.data[d.pos] != '"' {
+			return nil, fmt.Errorf("bad string length %q", text)
+		}
+
+		s := d.data[d.pos+1 : d.pos+1+n]
+		d.pos += n + 1
+		return s, d.expect(`";`)
+
+	case 'a':
+		// a:<count>:{<key><value>...}
+		text, err := d.until(':')
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := strconv.Atoi(text)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("bad array length %q", text)
+		}
+
+		if err := d.expect("{"); err != nil {
+			return nil, err
+		}
+
+		array := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			key, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+
+			val, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+
+			array[fmt.Sprint(key)] = val
+		}
+
+		return array, d.expect("}")
+	}
+
+	return nil, fmt.Errorf("unsupported value type %q", kind)
+}
+
+// consume the given literal text
+func (d *phpDecoder) expect(text string) error {
+	if !strings.HasPrefix(d.data[d.pos:], text) {
+		return fmt.Errorf("expected %q", text)
+	}
+
+	d.pos += len(text)
+	return nil
+}
+
+// consume and return the text up to (not including) the given delimiter, skipping the delimiter itself
+func (d *phpDecoder) until(delim byte) (string, error) {
+	end := strings.IndexByte(d.data[d.pos:], delim)
+	if end < 0 {
+		return "", fmt.Errorf("expected %q", delim)
+	}
+
+	text := d.data[d.pos : d.pos+end]
+	d.pos += end + 1
+	return text, nil
+}
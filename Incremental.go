@@ -0,0 +1,255 @@
+// incremental builds: with --incremental, the build keeps a state file in the output directory holding a hash of the theme
+// (templates, stylesheet and script), a fingerprint of each work, make and model's own data, and for each page and Atom feed a
+// fingerprint of its own data along with the entities it shows (the dependency graph, see DependencyGraph.go). On the next build an
+// output is regenerated only if it's new, its own data changed (a title, gallery layout, pagination ...), an entity it shows changed,
+// or its file is gone, and a theme change regenerates every page. The build reports what caused outputs to be regenerated.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// name of the incremental build state, kept in the output directory
+const incrementalStateFile = ".incremental-state.json"
+
+// type struct representing what the last incremental build generated
+type incrementalState struct {
+	Theme      string                 `json:"theme"`                // hash of the templates, stylesheet and script
+	Protection string                 `json:"protection,omitempty"` // fingerprint of the protected pages' key (see Protect.go)
+	Entities   map[string]string      `json:"entities"`             // fingerprint of each work, make and model's own data, by key
+	Outputs    map[string]outputState `json:"outputs"`              // pages and feeds, by path
+}
+
+// type struct representing a generated page or feed in the incremental build state
+type outputState struct {
+	Data string   `json:"data"` // fingerprint of the output's own data (the entities it shows only as references)
+	Deps []string `json:"deps"` // keys of the entities it shows
+}
+
+// type struct representing an incremental build in progress
+type incrementalBuild struct {
+	outputFolderLocation string
+	prev, next           incrementalState
+	fullReason           string          // why every page is regenerated ("" if only affected outputs are)
+	changed              map[string]bool // entities whose data changed, appeared or disappeared since the last build
+	affected             map[string]bool // outputs of the last build showing a changed entity
+	regenerated          map[string]int  // outputs regenerated, by cause
+	unchanged            int
+}
+
+// create and return a pointer to an incremental build of the catalog into the output directory with the given theme and page
+// protection key fingerprint ("" if no pages are protected)
+func newIncrementalBuild(outputFolderLocation string, theme *siteTheme, protection string, catalog *Catalog) *incrementalBuild {
+	ib := &incrementalBuild{
+		outputFolderLocation: outputFolderLocation,
+		next:                 incrementalState{Theme: themeHash(theme), Protection: protection, Entities: entityFingerprints(catalog), Outputs: map[string]outputState{}},
+		changed:              map[string]bool{},
+		regenerated:          map[string]int{},
+	}
+
+	data, err := os.ReadFile(filepath.Join("./"+outputFolderLocation, incrementalStateFile))
+	switch {
+	case err != nil:
+		ib.fullReason = "no previous incremental build"
+	case json.Unmarshal(data, &ib.prev) != nil:
+		ib.fullReason = "unreadable incremental build state"
+	case ib.prev.Theme != ib.next.Theme:
+		ib.fullReason = "templates or theme changed"
+	case ib.prev.Protection != ib.next.Protection:
+		ib.fullReason = "page protection changed"
+	}
+
+	for key, fp := range ib.next.Entities {
+		if ib.prev.Entities[key] != fp {
+			ib.changed[key] = true
+		}
+	}
+	for key := range ib.prev.Entities {
+		if _, ok := ib.next.Entities[key]; !ok {
+			ib.changed[key] = true
+		}
+	}
+
+	graph := dependencyGraph{}
+	for path, out := range ib.prev.Outputs {
+		graph[path] = out.Deps
+	}
+	ib.affected = graph.dependents(ib.changed)
+
+	return ib
+}
+
+// return the state of a page with the given view, and whether it has to be rendered
+func (ib *incrementalBuild) checkPage(path string, view *pageView) (outputState, bool) {
+	h := sha256.New()
+	var deps []string
+	writeFingerprint(h, reflect.ValueOf(view), &deps)
+
+	out := outputState{Data: hex.EncodeToString(h.Sum(nil)), Deps: sortedKeys(deps)}
+	return out, ib.check(path, out, true)
+}
+
+// return whether an output with the given state has to be generated - themed outputs (pages) are all regenerated on a theme change
+func (ib *incrementalBuild) check(path string, out outputState, themed bool) bool {
+	cause := ""
+	old, ok := ib.prev.Outputs[path]
+	switch {
+	case themed && ib.fullReason != "":
+		cause = "theme"
+	case !ok:
+		cause = "new"
+	case ib.affected[path]:
+		cause = "entity changed"
+	case old.Data != out.Data:
+		cause = "page data changed"
+	}
+
+	if cause == "" {
+		if _, err := os.Stat(filepath.Join("./"+ib.outputFolderLocation, path)); err != nil {
+			cause = "file missing"
+		}
+	}
+
+	if cause == "" {
+		ib.next.Outputs[path] = out
+		ib.unchanged++
+		return false
+	}
+
+	ib.regenerated[cause]++
+	return true
+}
+
+// record an output that's been generated
+func (ib *incrementalBuild) record(path string, out outputState) {
+	ib.next.Outputs[path] = out
+}
+
+// save the state for the next build and print what was regenerated and why
+func (ib *incrementalBuild) finish() error {
+	data, err := json.MarshalIndent(ib.next, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error encoding incremental build state: %v", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join("./"+ib.outputFolderLocation, incrementalStateFile), data); err != nil {
+		return fmt.Errorf("Error writing incremental build state: %v", err)
+	}
+
+	causes := make([]string, 0, len(ib.regenerated))
+	total := 0
+	for cause, n := range ib.regenerated {
+		causes = append(causes, fmt.Sprintf("%d %s", n, cause))
+		total += n
+	}
+	sort.Strings(causes)
+
+	switch {
+	case ib.fullReason != "":
+		fmt.Printf("Incremental build: all %d pages and feeds generated (%s).\n", total, ib.fullReason)
+	case total == 0:
+		fmt.Printf("Incremental build: nothing regenerated, %d pages and feeds unchanged.\n", ib.unchanged)
+	default:
+		fmt.Printf("Incremental build: %d pages and feeds regenerated (%s), %d unchanged.\n", total, strings.Join(causes, ", "), ib.unchanged)
+	}
+
+	if len(ib.changed) > 0 && ib.fullReason == "" {
+		changed := make([]string, 0, len(ib.changed))
+		for key := range ib.changed {
+			changed = append(changed, key)
+		}
+		sort.Strings(changed)
+		fmt.Printf("Changed since the last build: %s.\n", strings.Join(changed, ", "))
+	}
+
+	return nil
+}
+
+// return a hash of everything in the theme that shapes the pages
+func themeHash(theme *siteTheme) string {
+	h := sha256.New()
+	for _, part := range []string{theme.Source, theme.CSS, theme.ExtraCSS, theme.JS, theme.OverridesSource} {
+		fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// write a deterministic encoding of the value to the hash - works, makes and models in it are written as references, and their keys
+// added to deps (if not nil)
+func writeFingerprint(h hash.Hash, v reflect.Value, deps *[]string) {
+	if key := entityKey(v); key != "" {
+		fmt.Fprintf(h, "%q;", key)
+		if deps != nil {
+			*deps = append(*deps, key)
+		}
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			h.Write([]byte("nil;"))
+			return
+		}
+		writeFingerprint(h, v.Elem(), deps)
+
+	case reflect.Struct:
+		if v.Type() == timeType && v.CanInterface() {
+			fmt.Fprintf(h, "%s;", v.Interface().(time.Time).Format(time.RFC3339Nano))
+			return
+		}
+
+		h.Write([]byte("{"))
+		for i := 0; i < v.NumField(); i++ {
+			fmt.Fprintf(h, "%s=", v.Type().Field(i).Name)
+			writeFingerprint(h, v.Field(i), deps)
+		}
+		h.Write([]byte("}"))
+
+	case reflect.Slice, reflect.Array:
+		fmt.Fprintf(h, "[%d:", v.Len())
+		for i := 0; i < v.Len(); i++ {
+			writeFingerprint(h, v.Index(i), deps)
+		}
+		h.Write([]byte("]"))
+
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+
+		fmt.Fprintf(h, "map%d:", len(keys))
+		for _, k := range keys {
+			fmt.Fprintf(h, "%q=", fmt.Sprint(k))
+			writeFingerprint(h, v.MapIndex(k), deps)
+		}
+
+	case reflect.String:
+		fmt.Fprintf(h, "%q;", v.String())
+
+	case reflect.Bool:
+		fmt.Fprintf(h, "%t;", v.Bool())
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		fmt.Fprintf(h, "%d;", v.Int())
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		fmt.Fprintf(h, "%d;", v.Uint())
+
+	case reflect.Float32, reflect.Float64:
+		fmt.Fprintf(h, "%g;", v.Float())
+	}
+}
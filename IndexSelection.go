@@ -0,0 +1,77 @@
+// index selection: strategies for choosing which works the homepage gallery shows.
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+// return the works in the order the homepage should show them, according to the given strategy:
+//   - first: feed order
+//   - recent: newest capture date first (works without a date last, in feed order)
+//   - random: shuffled with the given seed (0 uses the current time, so each build differs)
+//   - featured: only works flagged <featured>, in feed order - falls back to feed order if none are flagged
+//   - popular: most viewed first, by the --popularity dataset (see Popularity.go) - works with the same views keep feed order
+func selectIndexWorks(works []*Work, strategy string, seed int64) ([]*Work, error) {
+	var selected []*Work
+	for _, wk := range works {
+		if wk != nil {
+			selected = append(selected, wk)
+		}
+	}
+
+	switch strategy {
+	case "", "first":
+
+	case "recent":
+		sort.SliceStable(selected, func(i, j int) bool {
+			a, b := selected[i].Date, selected[j].Date
+			if a.IsZero() || b.IsZero() {
+				return !a.IsZero() && b.IsZero()
+			}
+			return a.After(b)
+		})
+
+	case "random":
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		rnd := rand.New(rand.NewSource(seed))
+		rnd.Shuffle(len(selected), func(i, j int) { selected[i], selected[j] = selected[j], selected[i] })
+
+	case "featured":
+		var featured []*Work
+		for _, wk := range selected {
+			if wk.Featured {
+				featured = append(featured, wk)
+			}
+		}
+
+		if len(featured) == 0 {
+			fmt.Fprintln(os.Stderr, "No works are flagged <featured> - the homepage shows works in feed order instead.")
+		} else {
+			selected = featured
+		}
+
+	case "popular":
+		viewed := false
+		for _, wk := range selected {
+			viewed = viewed || wk.Views > 0
+		}
+
+		if !viewed {
+			fmt.Fprintln(os.Stderr, "No works have views (see --popularity) - the homepage shows works in feed order instead.")
+		} else {
+			selected = mostViewed(selected)
+		}
+
+	default:
+		return nil, fmt.Errorf("Error: unknown index selection %q (expected first, recent, random, featured or popular)", strategy)
+	}
+
+	return selected, nil
+}
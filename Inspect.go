@@ -0,0 +1,324 @@
+// inspect subcommand: scans a feed of unknown shape and reports its structure - every element and attribute path, how often it
+// occurs, how many records have it and an example value - and suggests a field mapping for it, to help onboard a new source:
+//
+//	>go run ImageProcessor inspect https://cms.example.com/export/photos.xml
+//
+// The repeating record element (the <work> of a works feed, the <item> of RSS) is taken to be the shallowest element that occurs
+// more than once. The suggested mapping is in the format of the config file's graphql section (work field -> dot path within each
+// record - see GraphQL.go), guessed from element names, so it's a starting point to check against the report rather than a finished
+// config.
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+)
+
+func init() {
+	registerCommand(&command{
+		Name:    "inspect",
+		Usage:   "<api-url>",
+		Summary: "report the element structure of an unknown feed and suggest a field mapping for it",
+		Setup:   setupInspect,
+	})
+}
+
+// longest example value shown in the report
+const inspectExampleLength = 40
+
+// type struct representing an element or attribute path seen in the feed
+type feedPath struct {
+	Path    string // e.g. rss/channel/item/title, or rss/channel/item/enclosure/@url for an attribute
+	Depth   int
+	Count   int    // occurrences
+	Records int    // records it occurs in (paths inside the record element only)
+	Example string // first non-empty value
+	Leaf    bool   // whether it has text of its own (attributes always do)
+
+	within map[*feedPath]*ancestorCount // by enclosing path: how many of its elements this path occurs in
+}
+
+// type struct representing how many elements of an enclosing path a path occurs in
+type ancestorCount struct {
+	last int // occurrence of the enclosing path it was last counted in
+	n    int
+}
+
+// type struct representing the structure of a scanned feed
+type feedStructure struct {
+	Paths   []*feedPath // in the order first seen
+	Record  *feedPath   // the repeating record element (nil if no element repeats)
+	Records int
+}
+
+// names each work field is commonly given in other feeds, most telling first (compared in lower case without punctuation)
+var mappingCandidates = []struct {
+	Field string
+	Names []string
+}{
+	{"id", []string{"id", "identifier", "uuid", "guid", "key", "photoid", "imageid"}},
+	{"filename", []string{"filename", "file", "basename", "name", "title"}},
+	{"make", []string{"make", "manufacturer", "cameramake", "brand"}},
+	{"model", []string{"model", "cameramodel", "camera"}},
+	{"lens", []string{"lens", "lensmodel", "lensname", "objective"}},
+	{"author", []string{"author", "artist", "photographer", "creator", "byline"}},
+	{"date", []string{"date", "datetimeoriginal", "datetaken", "taken", "takenat", "created", "createdat", "datetime", "pubdate", "published"}},
+	{"small", []string{"small", "thumb", "thumbnail", "thumburl", "thumbnailurl", "preview"}},
+	{"medium", []string{"medium", "mediumurl", "display", "web"}},
+	{"large", []string{"large", "original", "full", "fullsize", "image", "imageurl", "url", "src", "link"}},
+	{"featured", []string{"featured", "pick", "starred", "favorite", "favourite"}},
+	{"dominant_color", []string{"dominantcolor", "color", "colour"}},
+}
+
+// define the inspect subcommand's flags and return its runner: scan the feed, print its structure and a suggested mapping, and
+// return 0 (1 if the feed can't be read, 2 on usage errors)
+func setupInspect(fs *flag.FlagSet) func(positional []string) int {
+	return func(positional []string) int {
+		if len(positional) < 1 {
+			fmt.Fprintln(os.Stderr, "Error: please enter the feed to inspect (e.g. >go run ImageProcessor inspect https://cms.example.com/export/photos.xml)")
+			return 2
+		}
+
+		feed, err := openFeed(positional[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error fetching XML data from specified API URL (%s): %v\n", positional[0], err)
+			return 1
+		}
+		defer feed.Close()
+
+		structure, err := scanFeedStructure(feed)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading feed (%s): %v\n", positional[0], err)
+			return 1
+		}
+
+		printFeedStructure(os.Stdout, structure)
+		return 0
+	}
+}
+
+// read the whole feed, counting every element and attribute path
+func scanFeedStructure(feed io.Reader) (*feedStructure, error) {
+	dec := xml.NewDecoder(feed)
+	dec.Strict = false
+	dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) { return input, nil }
+
+	byPath := map[string]*feedPath{}
+	structure := &feedStructure{}
+	var stack []*feedPath
+	var text []string // text of the open elements
+
+	see := func(p string, depth int) *feedPath {
+		fp := byPath[p]
+		if fp == nil {
+			fp = &feedPath{Path: p, Depth: depth, within: map[*feedPath]*ancestorCount{}}
+			byPath[p] = fp
+			structure.Paths = append(structure.Paths, fp)
+		}
+		fp.Count++
+
+		for _, anc := range stack {
+			c := fp.within[anc]
+			if c == nil {
+				c = &ancestorCount{}
+				fp.within[anc] = c
+			}
+			if c.last != anc.Count {
+				c.last = anc.Count
+				c.n++
+			}
+		}
+		return fp
+	}
+
+	setExample := func(fp *feedPath, value string) {
+		value = strings.Join(strings.Fields(value), " ")
+		if value == "" {
+			return
+		}
+		fp.Leaf = true
+		if fp.Example == "" {
+			fp.Example = value
+		}
+	}
+
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			line, _ := dec.InputPos()
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			p := t.Name.Local
+			if len(stack) > 0 {
+				p = stack[len(stack)-1].Path + "/" + p
+			}
+			fp := see(p, len(stack)+1)
+			stack = append(stack, fp)
+			text = append(text, "")
+
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+					continue
+				}
+				setExample(see(p+"/@"+attr.Name.Local, len(stack)+1), attr.Value)
+			}
+
+		case xml.CharData:
+			if len(text) > 0 {
+				text[len(text)-1] += string(t)
+			}
+
+		case xml.EndElement:
+			if len(stack) == 0 {
+				continue
+			}
+			setExample(stack[len(stack)-1], text[len(text)-1])
+			stack, text = stack[:len(stack)-1], text[:len(text)-1]
+		}
+	}
+
+	// the record element is the shallowest one that repeats (the most frequent, between equally deep ones)
+	for _, fp := range structure.Paths {
+		if fp.Count < 2 || strings.Contains(fp.Path, "/@") {
+			continue
+		}
+		if r := structure.Record; r == nil || fp.Depth < r.Depth || (fp.Depth == r.Depth && fp.Count > r.Count) {
+			structure.Record = fp
+		}
+	}
+
+	if structure.Record != nil {
+		structure.Records = structure.Record.Count
+		for _, fp := range structure.Paths {
+			if c := fp.within[structure.Record]; c != nil {
+				fp.Records = c.n
+			}
+		}
+	}
+
+	return structure, nil
+}
+
+// return the suggested mapping: work field -> dot path within a record, for the fields a record path looks like
+func suggestMapping(structure *feedStructure) map[string]string {
+	mapping := map[string]string{}
+	if structure.Record == nil {
+		return mapping
+	}
+
+	prefix := structure.Record.Path + "/"
+	used := map[*feedPath]bool{}
+
+	for _, c := range mappingCandidates {
+		var best *feedPath
+		bestRank := len(c.Names)
+
+		for _, fp := range structure.Paths {
+			if used[fp] || !strings.HasPrefix(fp.Path, prefix) || strings.Contains(fp.Path, "/@") || !(fp.Leaf || structure.empty(fp)) {
+				continue
+			}
+
+			name := normalizedFieldName(fp.Path[strings.LastIndex(fp.Path, "/")+1:])
+			for rank, candidate := range c.Names {
+				if name == candidate && (rank < bestRank || (rank == bestRank && fp.Records > best.Records)) {
+					best, bestRank = fp, rank
+				}
+			}
+		}
+
+		if best != nil {
+			used[best] = true
+			mapping[c.Field] = strings.ReplaceAll(strings.TrimPrefix(best.Path, prefix), "/", ".")
+		}
+	}
+
+	return mapping
+}
+
+// return whether a path is an element with neither text nor child elements anywhere in the feed (a flag, like <featured/>)
+func (structure *feedStructure) empty(fp *feedPath) bool {
+	if fp.Leaf {
+		return false
+	}
+
+	for _, other := range structure.Paths {
+		if strings.HasPrefix(other.Path, fp.Path+"/") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// return an element name in lower case without punctuation, e.g. Date_Taken -> datetaken
+func normalizedFieldName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// print the structure as a table, followed by the record element and the suggested mapping
+func printFeedStructure(out io.Writer, structure *feedStructure) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tCOUNT\tRECORDS\tEXAMPLE")
+	for _, fp := range structure.Paths {
+		records := "-"
+		if structure.Record != nil && strings.HasPrefix(fp.Path, structure.Record.Path+"/") {
+			records = fmt.Sprintf("%d%%", fp.Records*100/structure.Records)
+		}
+
+		example := fp.Example
+		if r := []rune(example); len(r) > inspectExampleLength {
+			example = string(r[:inspectExampleLength-3]) + "..."
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", fp.Path, fp.Count, records, example)
+	}
+	tw.Flush()
+	fmt.Fprintln(out)
+
+	if structure.Record == nil {
+		fmt.Fprintln(out, "No repeating element - the feed doesn't look like a list of records.")
+		return
+	}
+
+	fmt.Fprintf(out, "Records: %d <%s> elements (%s).\n", structure.Records, structure.Record.Path[strings.LastIndex(structure.Record.Path, "/")+1:], structure.Record.Path)
+
+	mapping := suggestMapping(structure)
+	if len(mapping) == 0 {
+		fmt.Fprintln(out, "No record field looks like a work field - write the mapping by hand from the paths above.")
+		return
+	}
+
+	var missing []string
+	for _, c := range mappingCandidates {
+		if _, ok := mapping[c.Field]; !ok {
+			missing = append(missing, c.Field)
+		}
+	}
+
+	data, _ := json.MarshalIndent(map[string]interface{}{"items": strings.ReplaceAll(structure.Record.Path, "/", "."), "mapping": mapping}, "", "  ")
+	fmt.Fprintf(out, "\nSuggested mapping (the config file's graphql section format - check it against the paths above):\n%s\n", data)
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		fmt.Fprintf(out, "No match for: %s.\n", strings.Join(missing, ", "))
+	}
+}
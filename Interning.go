@@ -0,0 +1,100 @@
+// interning and name lookups: while parsing a feed, strings that repeat from work to work (tag names) are kept once and shared, and
+// makes and models are found through maps keyed by their normalized name rather than by scanning the lists built so far - parsing a
+// million-work feed then takes memory and time in proportion to the works, not to the works times the makes. Names are normalized by
+// collapsing runs of whitespace, so "Canon  EOS 20D" and "Canon EOS 20D" are the same model (named as the feed first wrote it).
+
+package main
+
+import "strings"
+
+// type representing a set of strings read so far, each kept once
+type nameInterner map[string]string
+
+// return the kept copy of s, keeping s if it's new
+func (n nameInterner) intern(s string) string {
+	if kept, ok := n[s]; ok {
+		return kept
+	}
+
+	n[s] = s
+	return s
+}
+
+// type struct representing the key of a model in a name lookup - model names are only unique within their make
+type makeModel struct {
+	make *Make
+	name string
+}
+
+// type struct representing the makes, models, authors and lenses of a catalog by normalized name
+type nameLookup struct {
+	makes   map[string]*Make
+	models  map[makeModel]*Model
+	authors map[string]*Author // see Authors.go
+	lenses  map[string]*Lens   // see Lenses.go
+}
+
+// create and return a pointer to an empty name lookup
+func newNameLookup() *nameLookup {
+	return &nameLookup{makes: map[string]*Make{}, models: map[makeModel]*Model{}, authors: map[string]*Author{}, lenses: map[string]*Lens{}}
+}
+
+// return the name as makes and models are looked up by
+func lookupName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// return the make with the given name (nil if there's none)
+func (l *nameLookup) findMake(name string) *Make {
+	return l.makes[lookupName(name)]
+}
+
+// return the make's model with the given name (nil if there's none)
+func (l *nameLookup) findModel(mk *Make, name string) *Model {
+	return l.models[makeModel{mk, lookupName(name)}]
+}
+
+// record a make - the first make recorded under a name keeps it
+func (l *nameLookup) addMake(mk *Make) {
+	if key := lookupName(mk.Name); l.makes[key] == nil {
+		l.makes[key] = mk
+	}
+}
+
+// record a model of its make - the first model recorded under a name keeps it
+func (l *nameLookup) addModel(md *Model) {
+	if key := (makeModel{md.MMake, lookupName(md.Name)}); l.models[key] == nil {
+		l.models[key] = md
+	}
+}
+
+// return the catalog's name lookup, building it from the makes, models, authors and lenses the first time - catalogs put together directly (from a
+// snapshot, say) have none yet
+func (c *Catalog) names() *nameLookup {
+	if c.lookup == nil {
+		c.lookup = newNameLookup()
+		for _, mk := range c.Makes {
+			if mk == nil {
+				continue
+			}
+			c.lookup.addMake(mk)
+			for _, md := range mk.Models {
+				if md != nil {
+					c.lookup.addModel(md)
+				}
+			}
+		}
+		for _, a := range c.Authors {
+			if a != nil {
+				c.lookup.addAuthor(a)
+			}
+		}
+		for _, lens := range c.Lenses {
+			if lens != nil {
+				c.lookup.addLens(lens)
+			}
+		}
+	}
+
+	return c.lookup
+}
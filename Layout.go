@@ -0,0 +1,218 @@
+// gallery layout: per page type limits, grid columns, image size and pagination of the thumbnail galleries, and the print layout
+// for curators printing gallery pages from the browser - images of a fixed size with their captions under them, and optionally a
+// new printed page after every so many works:
+//
+//	"layouts": {"make": {"limit": 0, "print": true, "print_per_page": 12}}
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// page types with a gallery
+var galleryPageTypes = []string{"index", "make", "model", "nomake", "author", "lens", "place", "facet", "flagged"}
+
+// type struct representing how one page type lays out its gallery
+type pageLayout struct {
+	Limit     int    `json:"limit"`      // most works shown (0 for all of them)
+	PerPage   int    `json:"per_page"`   // split the gallery over numbered pages of this many works (0 for a single page)
+	Columns   int    `json:"columns"`    // lay the gallery out as a grid with this many columns (0 for the default inline flow)
+	ImageSize string `json:"image_size"` // which image the gallery shows: small or medium
+
+	Print        bool `json:"print"`          // lay the gallery out for printing: captions under fixed-size images
+	PrintPerPage int  `json:"print_per_page"` // with print, start a new printed page after this many works (0 to let the browser break pages)
+}
+
+// return the default layouts: the first 10 works on the index, make, model and lens pages and every work on the generic, author, place, facet and flagged pages
+func defaultLayouts() map[string]pageLayout {
+	return map[string]pageLayout{
+		"index":   {Limit: 10, ImageSize: "small"},
+		"make":    {Limit: 10, ImageSize: "small"},
+		"model":   {Limit: 10, ImageSize: "small"},
+		"nomake":  {ImageSize: "small"},
+		"author":  {ImageSize: "small"},
+		"lens":    {Limit: 10, ImageSize: "small"},
+		"place":   {ImageSize: "small"},
+		"facet":   {ImageSize: "small"},
+		"flagged": {ImageSize: "small"},
+	}
+}
+
+// apply the "layouts" section of the config file on top of the given layouts - settings left out keep their current value, e.g.
+//
+//	"layouts": {"make": {"limit": 0, "per_page": 24, "columns": 4}, "index": {"image_size": "medium"}}
+func applyLayoutConfig(layouts map[string]pageLayout, raw map[string]json.RawMessage) error {
+	for pageType, data := range raw {
+		l, ok := layouts[pageType]
+		if !ok {
+			return fmt.Errorf("Error in layouts config: unknown page type %q (expected %s)", pageType, strings.Join(galleryPageTypes, ", "))
+		}
+
+		if err := json.Unmarshal(data, &l); err != nil {
+			return fmt.Errorf("Error in layouts config for %s pages: %v", pageType, err)
+		}
+
+		if l.Limit < 0 || l.PerPage < 0 || l.Columns < 0 || l.PrintPerPage < 0 {
+			return fmt.Errorf("Error in layouts config for %s pages: limit, per_page, columns and print_per_page can't be negative", pageType)
+		}
+
+		if l.PrintPerPage > 0 && !l.Print {
+			return fmt.Errorf("Error in layouts config for %s pages: print_per_page needs print", pageType)
+		}
+
+		if l.ImageSize != "small" && l.ImageSize != "medium" {
+			return fmt.Errorf("Error in layouts config for %s pages: unknown image_size %q (expected small or medium)", pageType, l.ImageSize)
+		}
+
+		layouts[pageType] = l
+	}
+
+	return nil
+}
+
+// write a gallery page of the given works with the template named by kind, split over numbered pages when the layout paginates
+// file is the first page's file name (later pages get .page-2, .page-3, ... before the extension, see galleryPageFile); view holds
+// the rest of the page's content
+func (s *siteWriter) writeGallery(file, kind string, works []*Work, layout pageLayout, view *pageView) error {
+	var shown []*Work
+	collapsed := map[int]bool{} // near-duplicate groups with a work shown (see NearDuplicates.go)
+	for _, wk := range works {
+		if wk == nil {
+			continue
+		}
+		if g, ok := s.nearDuplicates[wk]; ok {
+			if collapsed[g] {
+				continue
+			}
+			collapsed[g] = true
+		}
+		shown = append(shown, wk)
+	}
+
+	if layout.Limit > 0 && len(shown) > layout.Limit {
+		shown = shown[:layout.Limit]
+	}
+
+	pages := [][]*Work{shown}
+	if layout.PerPage > 0 && len(shown) > layout.PerPage {
+		pages = nil
+		for start := 0; start < len(shown); start += layout.PerPage {
+			end := start + layout.PerPage
+			if end > len(shown) {
+				end = len(shown)
+			}
+			pages = append(pages, shown[start:end])
+		}
+	}
+
+	for i, pageWorks := range pages {
+		view.Gallery = galleryView{Columns: layout.Columns, Print: layout.Print}
+		for _, wk := range pageWorks {
+			item := galleryItem{Page: wk.pageURL(), Image: workImage(wk, layout.ImageSize), Work: wk}
+			item.Image.Alt = wk.FileName
+			if layout.Print {
+				item.Caption = printCaption(wk)
+			}
+			view.Gallery.Items = append(view.Gallery.Items, item)
+		}
+		if layout.Print {
+			view.Gallery.PrintPages = printPages(view.Gallery.Items, layout.PrintPerPage)
+		}
+
+		if len(pages) > 1 {
+			view.Gallery.Pagination = pagination(file, i+1, len(pages))
+		}
+
+		view.Canonical = s.canonical(galleryPageFile(file, i+1))
+		if s.mobile != nil {
+			view.Mobile = mobileDir + "/" + galleryPageFile(file, i+1)
+		}
+
+		if err := s.writeView(galleryPageFile(file, i+1), kind, view); err != nil {
+			return err
+		}
+
+		if s.mobile != nil {
+			if err := s.writeMobilePage(galleryPageFile(file, i+1), view); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// return the gallery items of the works, showing their small or medium image with the file name as its alt text
+func galleryItems(works []*Work, size string) []galleryItem {
+	var items []galleryItem
+	for _, wk := range works {
+		item := galleryItem{Page: wk.pageURL(), Image: workImage(wk, size), Work: wk}
+		item.Image.Alt = wk.FileName
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// return the caption printed under a work in the print layout: its title (or file name) and capture date
+func printCaption(wk *Work) string {
+	caption := titleOr(wk.Title, wk.FileName)
+	if !wk.Date.IsZero() {
+		caption += ", " + wk.Date.Format("2 January 2006")
+	}
+
+	return caption
+}
+
+// return the gallery's items split into printed pages of perPage items (a single page if perPage is 0)
+func printPages(items []galleryItem, perPage int) [][]galleryItem {
+	if perPage == 0 || len(items) <= perPage {
+		return [][]galleryItem{items}
+	}
+
+	var pages [][]galleryItem
+	for start := 0; start < len(items); start += perPage {
+		end := start + perPage
+		if end > len(items) {
+			end = len(items)
+		}
+		pages = append(pages, items[start:end])
+	}
+
+	return pages
+}
+
+// return the file name of the given page (counting from 1) of a paginated gallery, e.g. Canon.page-2.html - page slugs have no dots,
+// so it can't be the name of another make or model's page
+func galleryPageFile(file string, page int) string {
+	if page == 1 {
+		return file
+	}
+
+	ext := path.Ext(file)
+	return strings.TrimSuffix(file, ext) + ".page-" + strconv.Itoa(page) + ext
+}
+
+// return the links between the pages of a paginated gallery, as seen from the given page
+func pagination(file string, page, pages int) *paginationView {
+	p := &paginationView{}
+
+	if page > 1 {
+		p.Prev = galleryPageFile(file, page-1)
+	}
+
+	for n := 1; n <= pages; n++ {
+		p.Pages = append(p.Pages, paginationLink{Number: n, File: galleryPageFile(file, n), Current: n == page})
+	}
+
+	if page < pages {
+		p.Next = galleryPageFile(file, page+1)
+	}
+
+	return p
+}
@@ -0,0 +1,108 @@
+// lenses: the lens a work was taken with, read from its <lens> element (or, with --exif, the LensModel tag of its image), gets a
+// gallery page of the works taken with it (lens-<name>.html), listed with their counts on a lenses page (lenses.html) the homepage
+// links to, and the work pages link to their lens:
+//
+//	<work>
+//	  <exif>
+//	    <model>NIKON D80</model>
+//	    <make>NIKON CORPORATION</make>
+//	    <lens>35mm f/1.4</lens>
+//	    ...
+//
+// Lenses are told apart by name, whitespace collapsed (see Interning.go). Sites whose works have no lens get no lens pages.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// name of the page listing the lenses
+const lensesPageFile = "lenses.html"
+
+// type struct representing a lens works were taken with
+type Lens struct {
+	Name    string
+	PageURL string // the lens's gallery page, without extension
+	Works   []*Work
+}
+
+// create and return a pointer to a lens with a given name
+func createLens(name string) *Lens {
+	return &Lens{Name: name, PageURL: "lens-" + pageSlug(name)}
+}
+
+// return the number of works taken with the lens, for the counts on the lenses page
+func (l *Lens) WorkCount() int {
+	return len(l.Works)
+}
+
+// return the lens with the given name (nil if there's none)
+func (l *nameLookup) findLens(name string) *Lens {
+	return l.lenses[lookupName(name)]
+}
+
+// record a lens - the first lens recorded under a name keeps it
+func (l *nameLookup) addLens(lens *Lens) {
+	if key := lookupName(lens.Name); l.lenses[key] == nil {
+		l.lenses[key] = lens
+	}
+}
+
+// return the lens with the given name, creating it and adding it to the catalog if it isn't recorded yet
+func (c *Catalog) findOrCreateLens(name string) *Lens {
+	if lens := c.names().findLens(name); lens != nil {
+		return lens
+	}
+
+	lens := createLens(name)
+	c.Lenses = append(c.Lenses, lens)
+	c.names().addLens(lens)
+	return lens
+}
+
+// record a work as taken with the lens with the given name ("" for none), taking it off the works of its previous lens
+func (c *Catalog) assignLens(wk *Work, name string) {
+	if wk.Lens != nil {
+		wk.Lens.Works = removeWork(wk.Lens.Works, wk)
+		wk.Lens = nil
+	}
+
+	if lookupName(name) == "" {
+		return
+	}
+
+	wk.Lens = c.findOrCreateLens(name)
+	wk.Lens.Works = append(wk.Lens.Works, wk)
+}
+
+// write the lenses page and a gallery page per lens - pages that can't be written are recorded with the site writer
+// returns whether there are lens pages for the homepage to link to
+func generateLensPages(site *siteWriter, catalog *Catalog, assets *siteAssets, layout pageLayout) bool {
+	var lenses []*Lens
+	for _, lens := range catalog.Lenses {
+		if lens != nil && len(lens.Works) > 0 {
+			lenses = append(lenses, lens)
+		}
+	}
+
+	if len(lenses) == 0 {
+		return false
+	}
+
+	// the most used lenses first
+	sort.SliceStable(lenses, func(i, j int) bool { return len(lenses[i].Works) > len(lenses[j].Works) })
+
+	if err := site.writeView(lensesPageFile, "lenses", &pageView{Title: "Lenses", Assets: assets, Lenses: lenses, Canonical: site.canonical(lensesPageFile)}); err != nil {
+		site.fail(fmt.Errorf("Error writing output to lenses HTML file (%s): %v", lensesPageFile, err))
+	}
+
+	for _, lens := range lenses {
+		if err := site.writeGallery(lens.PageURL+".html", "lens", lens.Works, layout, &pageView{Title: "All photos taken with a " + lens.Name, Assets: assets, Lens: lens}); err != nil {
+			site.fail(fmt.Errorf("Error writing output to lens HTML file (%s.html): %v", lens.PageURL, err))
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,98 @@
+// copyright and license: a work's <copyright> notice and <license> are shown on its page - a Creative Commons license as a badge
+// linking to the license deed - and kept in the exports. The config file's "license" is the license of every work the feed gives none:
+//
+//	<work>
+//	  <copyright>© 2019 Jane Doe</copyright>
+//	  <license>CC-BY-SA-4.0</license>
+//	  ...
+//
+//	"license": "CC BY-NC 4.0"
+//
+// A license is read as an SPDX id (CC-BY-SA-4.0, CC0-1.0), a name (CC BY-SA 4.0, CC BY) or the URL of a Creative Commons deed - any other
+// text is shown as it is, linking to the <license> element's url attribute if it has one.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// type struct representing a work's license as shown on its page
+type licenseInfo struct {
+	ID    string // SPDX id of a Creative Commons license (empty for others)
+	Name  string // e.g. CC BY-SA 4.0
+	URL   string // the license deed or text ("" if unknown)
+	Badge string // short label for the badge, e.g. BY-SA (empty for licenses that aren't Creative Commons)
+}
+
+// Creative Commons licenses written as an SPDX id or a name: cc-by-sa-4.0, cc by-nc, cc0 1.0 ...
+var ccLicenseName = regexp.MustCompile(`^cc[\s_-]*(0|by(?:[\s_-]*(?:nc|nd|sa))*)(?:[\s_-]*(\d\.\d))?$`)
+
+// Creative Commons deed and legal code URLs
+var ccLicenseURL = regexp.MustCompile(`creativecommons\.org/(?:licenses/([a-z-]+)|publicdomain/(zero))/(\d\.\d)`)
+
+// return how the license text of a work reads - a Creative Commons license is recognized and given its canonical name and deed URL,
+// any other text is kept with the URL given for it
+func parseLicense(text, uri string) licenseInfo {
+	text = strings.Join(strings.Fields(text), " ")
+	lower := strings.ToLower(text)
+
+	var elements, version string
+	if m := ccLicenseName.FindStringSubmatch(lower); m != nil {
+		elements, version = m[1], m[2]
+	} else if m := ccLicenseURL.FindStringSubmatch(lower + " " + strings.ToLower(uri)); m != nil {
+		elements, version = m[1], m[3]
+		if m[2] != "" {
+			elements = "0"
+		}
+	} else {
+		return licenseInfo{Name: text, URL: uri}
+	}
+
+	if elements == "0" {
+		return licenseInfo{ID: "CC0-1.0", Name: "CC0 1.0", URL: "https://creativecommons.org/publicdomain/zero/1.0/", Badge: "CC0"}
+	}
+
+	// by, then nc, nd and sa in the order Creative Commons writes them (by-nc-sa, not by-sa-nc)
+	parts := []string{"by"}
+	for _, part := range []string{"nc", "nd", "sa"} {
+		if strings.Contains(elements, part) {
+			parts = append(parts, part)
+		}
+	}
+	if version == "" {
+		version = "4.0"
+	}
+
+	badge := strings.ToUpper(strings.Join(parts, "-"))
+	return licenseInfo{
+		ID:    "CC-" + badge + "-" + version,
+		Name:  "CC " + badge + " " + version,
+		URL:   "https://creativecommons.org/licenses/" + strings.Join(parts, "-") + "/" + version + "/",
+		Badge: badge,
+	}
+}
+
+// return the work's license (nil if it has none)
+func (w *Work) license() *licenseInfo {
+	if w.License == "" {
+		return nil
+	}
+
+	license := parseLicense(w.License, w.LicenseURL)
+	return &license
+}
+
+// give the site-wide license to every work the feed gives none
+func applyDefaultLicense(catalog *Catalog, license string) {
+	if license == "" {
+		return
+	}
+
+	for _, wk := range catalog.Works {
+		if wk != nil && wk.License == "" {
+			wk.License = license
+		}
+	}
+}
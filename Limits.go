@@ -0,0 +1,96 @@
+// feed limits: --max-works and --max-memory stop a build with a clear error when the works feed is bigger than the machine (or the
+// site) is meant to handle, rather than letting the process be OOM-killed halfway through parsing. The memory limit caps both the
+// size of the feed, which is read into memory in full, and the heap while it's parsed.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// how many works are parsed between checks of the heap size
+const memoryCheckInterval = 500
+
+// type struct representing the limits a works feed must stay within (0 for no limit)
+type feedLimits struct {
+	MaxWorks  int
+	MaxMemory int64 // bytes
+}
+
+// limits of the current build, set from --max-works and --max-memory
+var limits feedLimits
+
+// byte size flag (e.g. 512MB) - see parseSize
+type byteSizeFlag int64
+
+func (f *byteSizeFlag) String() string {
+	if *f == 0 {
+		return ""
+	}
+
+	return formatSize(int64(*f))
+}
+
+func (f *byteSizeFlag) Set(value string) error {
+	n, err := parseSize(value)
+	if err != nil {
+		return err
+	}
+
+	*f = byteSizeFlag(n)
+	return nil
+}
+
+// return a reader of the feed that fails once more than the memory limit has been read from it
+func (l feedLimits) reader(feed io.Reader) io.Reader {
+	if l.MaxMemory <= 0 {
+		return feed
+	}
+
+	return &limitedFeed{r: feed, limit: l.MaxMemory, remaining: l.MaxMemory}
+}
+
+// return an error if the number of works parsed so far, or the memory they take, is over the limits
+func (l feedLimits) checkWorks(count int) error {
+	if l.MaxWorks > 0 && count > l.MaxWorks {
+		return fmt.Errorf("Error: the works feed has more than %d works (--max-works) - raise the limit or split the feed", l.MaxWorks)
+	}
+
+	if l.MaxMemory > 0 && count%memoryCheckInterval == 0 {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		if int64(m.HeapAlloc) > l.MaxMemory {
+			return fmt.Errorf("Error: parsing the works feed took more than %s of memory after %d works (--max-memory) - raise the limit or split the feed", formatSize(l.MaxMemory), count)
+		}
+	}
+
+	return nil
+}
+
+// type struct representing a feed reader that stops at the memory limit
+type limitedFeed struct {
+	r         io.Reader
+	limit     int64
+	remaining int64
+}
+
+func (f *limitedFeed) Read(p []byte) (int, error) {
+	if f.remaining <= 0 {
+		// one byte past the limit tells a feed that's exactly at the limit from one over it
+		var probe [1]byte
+		if n, _ := f.r.Read(probe[:]); n > 0 {
+			return 0, fmt.Errorf("the works feed is larger than %s (--max-memory) - raise the limit or split the feed", formatSize(f.limit))
+		}
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > f.remaining {
+		p = p[:f.remaining]
+	}
+
+	n, err := f.r.Read(p)
+	f.remaining -= int64(n)
+	return n, err
+}
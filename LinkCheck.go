@@ -0,0 +1,202 @@
+// link checking: HEAD requests against every image URI in the catalog to find dead and redirected images before they're published.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// type struct representing the link checker settings
+type linkCheckOptions struct {
+	Concurrency   int     // number of requests in flight at once
+	Rate          float64 // maximum requests started per second (0 for no limit)
+	ExcludeBroken bool    // drop works whose thumbnail link is dead from the generated site
+}
+
+// type struct representing the outcome of checking one URI
+type linkStatus struct {
+	URI      string
+	Status   int    // HTTP status code (0 if the request failed)
+	Location string // redirect target, for 3xx responses
+	Err      error
+}
+
+// report whether the link couldn't be fetched or returned an error status
+func (s linkStatus) dead() bool {
+	return s.Err != nil || s.Status >= 400
+}
+
+// report whether the link redirects elsewhere
+func (s linkStatus) redirected() bool {
+	return s.Status >= 300 && s.Status < 400
+}
+
+// check every remote small/medium/large URI of the catalog, print the dead and redirected ones, and (if opts.ExcludeBroken is set)
+// remove works with a dead thumbnail from the catalog
+func checkLinks(catalog *Catalog, opts linkCheckOptions) {
+	// collect each distinct remote URI once, remembering which works use it
+	users := map[string][]*Work{}
+	var uris []string
+
+	for _, wk := range catalog.Works {
+		if wk == nil {
+			continue
+		}
+
+		for _, uri := range []string{wk.URISmall, wk.URIMedium, wk.URILarge} {
+			uri = strings.TrimSpace(uri)
+			if !strings.HasPrefix(uri, "http://") && !strings.HasPrefix(uri, "https://") {
+				continue
+			}
+
+			if _, seen := users[uri]; !seen {
+				uris = append(uris, uri)
+			}
+			users[uri] = append(users[uri], wk)
+		}
+	}
+
+	fmt.Printf("Checking %d image links...\n", len(uris))
+	results := checkURIs(uris, opts)
+
+	dead, redirected := 0, 0
+	for _, r := range results {
+		if r.dead() {
+			dead++
+			reason := "HTTP " + strconv.Itoa(r.Status)
+			if r.Err != nil {
+				reason = r.Err.Error()
+			}
+			fmt.Printf("  DEAD      %s (%s) - used by work(s) %s\n", r.URI, reason, workIDList(users[r.URI]))
+		} else if r.redirected() {
+			redirected++
+			fmt.Printf("  REDIRECT  %s -> %s - used by work(s) %s\n", r.URI, r.Location, workIDList(users[r.URI]))
+		}
+	}
+
+	fmt.Printf("Link check: %d links checked, %d dead, %d redirected.\n", len(results), dead, redirected)
+
+	if !opts.ExcludeBroken {
+		return
+	}
+
+	excluded := 0
+	for _, r := range results {
+		if !r.dead() {
+			continue
+		}
+
+		for _, wk := range users[r.URI] {
+			if strings.TrimSpace(wk.URISmall) == r.URI && catalog.removeWork(wk) {
+				excluded++
+			}
+		}
+	}
+
+	if excluded > 0 {
+		fmt.Printf("Excluded %d work(s) with a dead thumbnail link from generation.\n", excluded)
+	}
+}
+
+// issue a HEAD request (falling back to a one-byte GET for servers that reject HEAD) for each URI with bounded concurrency and rate,
+// returning the results sorted by URI
+func checkURIs(uris []string, opts linkCheckOptions) []linkStatus {
+	// redirects are reported rather than followed
+	client := &http.Client{
+		Transport: httpClient.Transport,
+		Timeout:   15 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var throttle <-chan time.Time
+	if opts.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	jobs := make(chan string)
+	results := make([]linkStatus, 0, len(uris))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for uri := range jobs {
+				r := checkURI(client, signURL(uri))
+				r.URI = uri
+				mu.Lock()
+				results = append(results, r)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, uri := range uris {
+		if throttle != nil {
+			<-throttle
+		}
+		jobs <- uri
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].URI < results[j].URI })
+	return results
+}
+
+// check a single URI
+func checkURI(client *http.Client, uri string) linkStatus {
+	r := linkStatus{URI: uri}
+
+	resp, err := client.Head(uri)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+
+		req, reqErr := http.NewRequest("GET", uri, nil)
+		if reqErr != nil {
+			r.Err = reqErr
+			return r
+		}
+		req.Header.Set("Range", "bytes=0-0")
+		resp, err = client.Do(req)
+	}
+
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	resp.Body.Close()
+
+	r.Status = resp.StatusCode
+	if resp.StatusCode == http.StatusPartialContent {
+		r.Status = http.StatusOK
+	}
+	r.Location = resp.Header.Get("Location")
+	return r
+}
+
+// return a comma-separated list of the given works' IDs
+func workIDList(works []*Work) string {
+	ids := make([]string, 0, len(works))
+	for _, wk := range works {
+		ids = append(ids, wk.ID.String())
+	}
+
+	return strings.Join(ids, ", ")
+}
@@ -0,0 +1,334 @@
+// lint subcommand: checks a works feed for data quality problems (missing filenames and image URIs, empty makes and models, bad ids,
+// page file names that collide) without generating the site, printing a table and optionally writing a JSON report for CI.
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+func init() {
+	registerCommand(&command{
+		Name:    "lint",
+		Usage:   "[--report file.json] [--strict] [--numeric-ids] <api-url>",
+		Summary: "check a works feed for missing or inconsistent data without generating the site",
+		Setup:   setupLint,
+	})
+}
+
+// lint severities, most serious first
+const (
+	lintError   = "error"   // the build fails or pages are lost or overwritten
+	lintWarning = "warning" // the site builds but shows less than the feed meant it to
+	lintInfo    = "info"    // worth knowing, nothing is lost
+)
+
+// file names of generated pages a make or model page must not take
+var reservedPagePattern = regexp.MustCompile(`^(index|nomake|work-\d+)$`)
+
+// type struct representing one problem found in the feed
+type lintIssue struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`             // short name of the check, e.g. "missing-filename"
+	WorkID   string `json:"work_id,omitempty"` // id of the work as written in the feed ("" for feed-wide problems)
+	Line     int    `json:"line,omitempty"`    // line of the feed the work starts on
+	Message  string `json:"message"`
+}
+
+// type struct representing the JSON lint report
+type lintReport struct {
+	Source  string         `json:"source"`
+	Works   int            `json:"works"`
+	Summary map[string]int `json:"summary"` // number of issues per severity
+	Issues  []lintIssue    `json:"issues"`
+}
+
+// type struct representing a work as written in the feed, read without the parser's conversions so nothing stops the checks
+type lintWork struct {
+	ID       *string `xml:"id"`
+	FileName *string `xml:"filename"`
+	URLs     []struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"urls>url"`
+	Make  *string `xml:"exif>make"`
+	Model *string `xml:"exif>model"`
+	Date  *string `xml:"exif>date"`
+
+	line int
+}
+
+// define the lint subcommand's flags and return its runner: lint the feed, print the issues and return 0 if there were no errors
+// (no warnings either with --strict), 1 otherwise, 2 on usage errors
+func setupLint(fs *flag.FlagSet) func(positional []string) int {
+	reportPath := fs.String("report", "", "also write the issues as JSON to this file (- for stdout, instead of the table)")
+	strict := fs.Bool("strict", false, "fail on warnings as well as errors")
+	fs.BoolVar(&numericWorkIDs, "numeric-ids", false, "check the ids are whole numbers, as a build with --numeric-ids requires")
+
+	return func(positional []string) int {
+		if len(positional) < 1 {
+			fmt.Fprintln(os.Stderr, "Error: please enter the works feed to check (e.g. >go run ImageProcessor lint http://localhost/test/api/v1/works.xml)")
+			return 2
+		}
+
+		feed, err := openFeed(positional[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error fetching XML works data from specified API URL (%s): %v\n", positional[0], err)
+			return 1
+		}
+		defer feed.Close()
+
+		works, issues := readLintWorks(feed)
+		issues = append(issues, lintFeed(works)...)
+
+		report := lintReport{Source: positional[0], Works: len(works), Summary: map[string]int{lintError: 0, lintWarning: 0, lintInfo: 0}, Issues: issues}
+		for _, is := range issues {
+			report.Summary[is.Severity]++
+		}
+
+		if *reportPath != "" {
+			data, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error encoding lint report: %v\n", err)
+				return 1
+			}
+			data = append(data, '\n')
+
+			if *reportPath == "-" {
+				os.Stdout.Write(data)
+			} else if err := os.WriteFile(*reportPath, data, 0644); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing lint report: %v\n", err)
+				return 1
+			}
+		}
+
+		if *reportPath != "-" {
+			printLintTable(os.Stdout, report)
+		}
+
+		if report.Summary[lintError] > 0 || (*strict && report.Summary[lintWarning] > 0) {
+			return 1
+		}
+
+		return 0
+	}
+}
+
+// read every <work> of the feed - a feed that isn't well-formed XML is reported as an error at the point reading stopped
+func readLintWorks(feed io.Reader) ([]*lintWork, []lintIssue) {
+	dec := xml.NewDecoder(feed)
+	var works []*lintWork
+
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			return works, nil
+		}
+		if err != nil {
+			line, _ := dec.InputPos()
+			return works, []lintIssue{{Severity: lintError, Check: "malformed-xml", Line: line, Message: err.Error()}}
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "work" {
+			continue
+		}
+
+		w := &lintWork{}
+		w.line, _ = dec.InputPos()
+		if err := dec.DecodeElement(w, &start); err != nil {
+			line, _ := dec.InputPos()
+			return works, []lintIssue{{Severity: lintError, Check: "malformed-xml", Line: line, Message: err.Error()}}
+		}
+
+		works = append(works, w)
+	}
+}
+
+// run every check over the works, returning the issues in feed order (feed-wide problems last)
+func lintFeed(works []*lintWork) []lintIssue {
+	var issues []lintIssue
+	ids := map[string]int{} // id -> line of the first work using it
+
+	for _, w := range works {
+		id := ""
+		if w.ID != nil {
+			id = strings.TrimSpace(*w.ID)
+		}
+
+		add := func(severity, check, message string) {
+			issues = append(issues, lintIssue{Severity: severity, Check: check, WorkID: id, Line: w.line, Message: message})
+		}
+
+		switch parsed, err := parseWorkID(id); {
+		case id == "":
+			add(lintError, "missing-id", "work has no id - the build stops here")
+		case err != nil:
+			add(lintError, "bad-id", err.Error()+" - the build stops here")
+		case ids[parsed.String()] != 0:
+			add(lintError, "duplicate-id", fmt.Sprintf("id is also used by the work on line %d - one work page overwrites the other", ids[parsed.String()]))
+		default:
+			ids[parsed.String()] = w.line
+		}
+
+		if w.FileName == nil || strings.TrimSpace(*w.FileName) == "" {
+			add(lintWarning, "missing-filename", "work has no filename - its page has no title")
+		}
+
+		uris := map[string]bool{}
+		for _, u := range w.URLs {
+			switch u.Type {
+			case "small", "medium", "large":
+				if strings.TrimSpace(u.Value) != "" {
+					uris[u.Type] = true
+				}
+			default:
+				add(lintWarning, "unknown-url-type", fmt.Sprintf("url type %q is ignored (expected small, medium or large)", u.Type))
+			}
+		}
+
+		switch {
+		case len(uris) == 0:
+			add(lintError, "missing-uris", "work has no image URIs - it shows up as a broken image")
+		default:
+			if !uris["small"] && uris["large"] {
+				add(lintInfo, "missing-uri", "no small URI - a thumbnail is generated from the large image")
+			} else if !uris["small"] {
+				add(lintWarning, "missing-uri", "no small URI and no large image to generate a thumbnail from")
+			}
+			if !uris["medium"] {
+				add(lintWarning, "missing-uri", "no medium URI - the work page has no image")
+			}
+			if !uris["large"] {
+				add(lintWarning, "missing-uri", "no large URI - the work page image links nowhere")
+			}
+		}
+
+		mk, md := "", ""
+		if w.Make != nil {
+			mk = strings.TrimSpace(*w.Make)
+			if mk == "" {
+				add(lintWarning, "empty-make", "make element is empty")
+			}
+		}
+		if w.Model != nil {
+			md = strings.TrimSpace(*w.Model)
+			if md == "" {
+				add(lintWarning, "empty-model", "model element is empty")
+			}
+		}
+
+		switch {
+		case mk == "" && md == "":
+			add(lintInfo, "no-camera", "no make or model - the work is listed on the generic works page")
+		case mk == "":
+			add(lintWarning, "model-without-make", fmt.Sprintf("model %q has no make - the work is listed on the generic works page", md))
+		case md == "":
+			add(lintWarning, "make-without-model", fmt.Sprintf("make %q has no model - the work isn't listed on any make or model page", mk))
+		}
+
+		if w.Date != nil && strings.TrimSpace(*w.Date) != "" {
+			if _, err := parseDate(strings.TrimSpace(*w.Date)); err != nil {
+				add(lintWarning, "bad-date", fmt.Sprintf("date %q can't be read - the work is treated as undated", strings.TrimSpace(*w.Date)))
+			}
+		}
+	}
+
+	return append(issues, lintSlugs(works)...)
+}
+
+// report make and model pages whose file names collide with each other or with the index, generic and work pages
+func lintSlugs(works []*lintWork) []lintIssue {
+	owners := map[string][]string{} // slug -> the makes/models whose page it is
+	seen := map[string]bool{}
+
+	claim := func(slug, owner string) {
+		if !seen[owner] {
+			seen[owner] = true
+			owners[slug] = append(owners[slug], owner)
+		}
+	}
+
+	for _, w := range works {
+		mk, md := "", ""
+		if w.Make != nil {
+			mk = strings.TrimSpace(*w.Make)
+		}
+		if w.Model != nil {
+			md = strings.TrimSpace(*w.Model)
+		}
+
+		if mk != "" {
+			claim(pageSlug(mk), fmt.Sprintf("make %q", mk))
+			if md != "" {
+				claim(pageSlug(md), fmt.Sprintf("model %q (%s)", md, mk))
+			}
+		}
+	}
+
+	slugs := make([]string, 0, len(owners))
+	for slug := range owners {
+		slugs = append(slugs, slug)
+	}
+	sort.Strings(slugs)
+
+	var issues []lintIssue
+	folded := map[string][]string{} // lowercased slug -> the slugs that fold to it
+	for _, slug := range slugs {
+		folded[strings.ToLower(slug)] = append(folded[strings.ToLower(slug)], slug)
+
+		if reservedPagePattern.MatchString(slug) {
+			issues = append(issues, lintIssue{Severity: lintError, Check: "reserved-slug",
+				Message: fmt.Sprintf("%s would be written to %s.html, overwriting a generated page", strings.Join(owners[slug], " and "), slug)})
+		} else if len(owners[slug]) > 1 {
+			issues = append(issues, lintIssue{Severity: lintError, Check: "duplicate-slug",
+				Message: fmt.Sprintf("%s share the page %s.html - only the last one written survives", strings.Join(owners[slug], ", "), slug)})
+		}
+	}
+
+	// Windows (and by default macOS) file systems don't tell Canon.html from CANON.html
+	for _, slug := range slugs {
+		if same := folded[strings.ToLower(slug)]; len(same) > 1 && same[0] == slug {
+			var names []string
+			for _, s := range same {
+				names = append(names, owners[s]...)
+			}
+			issues = append(issues, lintIssue{Severity: lintWarning, Check: "case-slug",
+				Message: fmt.Sprintf("%s have pages (%s.html) that differ only in case and collide on case-insensitive file systems such as Windows", strings.Join(names, ", "), strings.Join(same, ".html, "))})
+		}
+	}
+
+	return issues
+}
+
+// print the issues as a table followed by a one-line summary
+func printLintTable(out io.Writer, report lintReport) {
+	if len(report.Issues) > 0 {
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "SEVERITY\tWORK\tLINE\tCHECK\tMESSAGE")
+		for _, is := range report.Issues {
+			work, line := is.WorkID, ""
+			if work == "" {
+				work = "-"
+			}
+			if is.Line > 0 {
+				line = strconv.Itoa(is.Line)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", is.Severity, work, line, is.Check, is.Message)
+		}
+		tw.Flush()
+		fmt.Fprintln(out)
+	}
+
+	fmt.Fprintf(out, "%d works checked: %d errors, %d warnings, %d notices.\n", report.Works, report.Summary[lintError], report.Summary[lintWarning], report.Summary[lintInfo])
+}
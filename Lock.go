@@ -0,0 +1,94 @@
+// build lock: a build holds a lock file (.build.lock) in its output directory while it runs, so two runs scheduled at the same time
+// can't interleave their writes - the second stops with exit code 8, saying which run holds the lock. A build that was killed leaves
+// its lock behind; --force breaks it:
+//
+//	>go run ImageProcessor --force http://localhost/test/api/v1/works.xml code/html/output
+//
+// The lock file starts with a dot, so it's never deployed (see Deploy.go).
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// name of the lock file in the output directory
+const buildLockFile = ".build.lock"
+
+// type representing the locks a build holds, one per output directory
+type buildLocks []string
+
+// lock every output directory for this build, breaking existing locks if force is set - on failure, the locks already taken are given
+// back and the error names the run holding the lock
+func lockOutputs(outputs []string, force bool) (buildLocks, error) {
+	var locks buildLocks
+
+	for _, dir := range outputs {
+		path, err := lockOutput(dir, force)
+		if err != nil {
+			locks.release()
+			return nil, err
+		}
+		locks = append(locks, path)
+	}
+
+	return locks, nil
+}
+
+// create the lock file in an output directory (creating the directory if need be) and return its path
+func lockOutput(outputFolderLocation string, force bool) (string, error) {
+	dir := "./" + outputFolderLocation
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("Error creating output directory (%s): %v", dir, err)
+	}
+
+	path := filepath.Join(dir, buildLockFile)
+	host, _ := os.Hostname()
+	holder := fmt.Sprintf("pid %d on %s, started %s\n", os.Getpid(), host, time.Now().Format(time.RFC3339))
+
+	for attempt := 0; ; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.WriteString(holder)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return "", fmt.Errorf("Error writing build lock (%s): %v", path, err)
+			}
+			return path, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", fmt.Errorf("Error creating build lock (%s): %v", path, err)
+		}
+
+		other := "another build"
+		if data, err := os.ReadFile(path); err == nil && strings.TrimSpace(string(data)) != "" {
+			other = "another build (" + strings.TrimSpace(string(data)) + ")"
+		}
+
+		if !force || attempt > 0 {
+			return "", fmt.Errorf("Error: %s is writing to %s - wait for it to finish, or if it was stopped without cleaning up, run again with --force to break its lock (%s)", other, dir, path)
+		}
+
+		fmt.Fprintf(os.Stderr, "Breaking the build lock of %s on %s (--force).\n", other, dir)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("Error breaking build lock (%s): %v", path, err)
+		}
+	}
+}
+
+// remove the lock files
+func (locks buildLocks) release() {
+	for _, path := range locks {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Error removing build lock (%s): %v\n", path, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// build manifest: a machine-readable record of what a build published (works, make/model pages and files), written next to the site.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// name of the build manifest, kept in the output directory
+const buildManifestFile = ".build-manifest.json"
+
+// type struct representing everything a build published
+type buildManifest struct {
+	Works []exportWork   `json:"works"`
+	Makes []manifestMake `json:"makes"`
+	Files []string       `json:"files"` // paths relative to the output directory
+
+	Hashes map[string]string `json:"hashes,omitempty"` // SHA-256 of each file's content, for telling which files a later build changed
+
+	Pages pageProvenance `json:"pages,omitempty"` // works each generated page shows, by path (see Provenance.go)
+
+	Excluded []excludedWork `json:"excluded,omitempty"` // works the exclusion list kept out of the site
+	Flagged  []WorkID       `json:"flagged,omitempty"`  // works published only on the flagged page, in section mode (see Flagged.go)
+
+	Checksums []checksumResult `json:"checksums,omitempty"` // downloaded images checked against the feed's checksums
+
+	Duplicates     []duplicateImage `json:"duplicates,omitempty"`      // downloaded images stored once for several works (see Dedupe.go)
+	NearDuplicates [][]WorkID       `json:"near_duplicates,omitempty"` // groups of visually near-identical works (see NearDuplicates.go)
+
+	Redirects map[string]string `json:"redirects,omitempty"` // pages that moved since earlier builds, to where they are now (see Redirects.go)
+}
+
+// type struct representing a make page in the manifest
+type manifestMake struct {
+	Name   string          `json:"name"`
+	Page   string          `json:"page"`
+	Models []manifestModel `json:"models"`
+}
+
+// type struct representing a model page in the manifest
+type manifestModel struct {
+	Name string `json:"name"`
+	Page string `json:"page"`
+}
+
+// create and return a pointer to the manifest of a build of the given catalog that wrote the given files
+func newBuildManifest(catalog *Catalog, files []string) *buildManifest {
+	m := &buildManifest{Works: exportWorks(catalog), Files: files, Excluded: catalog.Excluded, Checksums: catalog.Checksums, Duplicates: catalog.Duplicates}
+
+	for _, wk := range catalog.Flagged {
+		m.Flagged = append(m.Flagged, wk.ID)
+	}
+
+	for _, group := range catalog.NearDuplicates {
+		var ids []WorkID
+		for _, wk := range group {
+			ids = append(ids, wk.ID)
+		}
+		m.NearDuplicates = append(m.NearDuplicates, ids)
+	}
+
+	for _, mk := range catalog.Makes {
+		if mk == nil {
+			continue
+		}
+
+		mm := manifestMake{Name: mk.Name, Page: mk.PageURL + ".html"}
+		for _, md := range mk.Models {
+			if md != nil {
+				mm.Models = append(mm.Models, manifestModel{Name: md.Name, Page: md.PageURL + ".html"})
+			}
+		}
+
+		m.Makes = append(m.Makes, mm)
+	}
+
+	return m
+}
+
+// record a hash of each file's content (files that can't be read, such as the manifest itself, are left out)
+func (m *buildManifest) hashFiles(outputFolderLocation string) {
+	m.Hashes = map[string]string{}
+	for _, file := range m.Files {
+		data, err := os.ReadFile(filepath.Join("./"+outputFolderLocation, file))
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		m.Hashes[file] = hex.EncodeToString(sum[:])
+	}
+}
+
+// write the manifest into the output directory
+func (m *buildManifest) write(outputFolderLocation string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join("./"+outputFolderLocation, buildManifestFile), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("Error writing build manifest: %v", err)
+	}
+
+	return nil
+}
+
+// read a build manifest - path may be the manifest file itself or the output directory holding it
+func readBuildManifest(path string) (*buildManifest, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, buildManifestFile)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m buildManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("Error reading build manifest (%s): %v", path, err)
+	}
+
+	return &m, nil
+}
@@ -0,0 +1,273 @@
+// description Markdown: the descriptions of make, model and work pages (see Overrides.go) are written in Markdown and rendered to
+// HTML, with their shortcodes expanded (see Shortcodes.go). Only a safe subset is rendered - everything else is shown as text:
+//
+//	paragraphs, separated by a blank line     # headings (shown a level below the page's own heading)
+//	- and 1. lists                             > quotes
+//	**bold**, *italic* and `code`              ``` fenced code blocks
+//	[links](https://example.com)               --- rules
+//
+// HTML written in a description is escaped rather than passed through, and links go only to http(s), mailto and relative URLs -
+// others are shown as their text. In the overrides file, a double-quoted description can span paragraphs with \n.
+
+package main
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// type struct representing a run of a rendered description - its HTML and the thumbnails of the shortcode that follows it
+type descriptionPart struct {
+	HTML  template.HTML
+	Items []galleryItem // the works of the shortcode after the HTML (none for the last run)
+}
+
+// HTML tags, to take out of a rendered description for its plain text - block tags are replaced by a space, the others by nothing
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+var blockTagPattern = regexp.MustCompile(`</?(p|h[1-6]|ul|ol|li|blockquote|pre|hr)>`)
+
+// ordered list items (1. or 1))
+var orderedItemPattern = regexp.MustCompile(`^\d{1,9}[.)] `)
+
+// return a description rendered from Markdown with its shortcodes expanded, in runs for the page, and as plain text for the meta
+// description - an empty description has neither
+func (c *Catalog) renderDescription(description string) (string, []descriptionPart) {
+	if strings.TrimSpace(description) == "" {
+		return "", nil
+	}
+
+	text, expanded := c.expandShortcodes(strings.ReplaceAll(description, shortcodeMark, ""))
+	rendered := renderMarkdown(text)
+
+	var parts []descriptionPart
+	for i, run := range strings.Split(rendered, shortcodeMark) {
+		part := descriptionPart{HTML: template.HTML(run)}
+		if i < len(expanded) {
+			part.Items = expanded[i]
+		}
+		parts = append(parts, part)
+	}
+
+	plain := blockTagPattern.ReplaceAllString(strings.ReplaceAll(rendered, shortcodeMark, " "), " ")
+	plain = html.UnescapeString(htmlTagPattern.ReplaceAllString(plain, ""))
+	return strings.Join(strings.Fields(plain), " "), parts
+}
+
+// return the HTML of Markdown text - every character of the text is escaped, so the only markup is the renderer's own
+func renderMarkdown(text string) string {
+	var b strings.Builder
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) > 0 {
+			b.WriteString("<p>" + renderInline(strings.Join(paragraph, "\n")) + "</p>\n")
+			paragraph = nil
+		}
+	}
+
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		trimmed := strings.TrimLeft(line, " ")
+
+		switch {
+		case trimmed == "":
+			flush()
+
+		case strings.HasPrefix(trimmed, "```"):
+			flush()
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			b.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+
+		case markdownHeading(trimmed) > 0:
+			flush()
+			level := markdownHeading(trimmed)
+			heading := strings.TrimSpace(strings.TrimRight(trimmed[level:], "# "))
+			if level++; level > 6 {
+				level = 6
+			}
+			tag := "h" + strconv.Itoa(level)
+			b.WriteString("<" + tag + ">" + renderInline(heading) + "</" + tag + ">\n")
+
+		case markdownRule(trimmed):
+			flush()
+			b.WriteString("<hr>\n")
+
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				q := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quote = append(quote, strings.TrimPrefix(q, " "))
+			}
+			i--
+			b.WriteString("<blockquote>\n" + renderMarkdown(strings.Join(quote, "\n")) + "</blockquote>\n")
+
+		case markdownListItem(trimmed) != "":
+			flush()
+			kind := markdownListItem(trimmed)
+			var items []string
+			for ; i < len(lines); i++ {
+				l := strings.TrimSpace(lines[i])
+				if l == "" || (markdownListItem(l) != kind && !strings.HasPrefix(lines[i], " ")) {
+					break
+				}
+				if markdownListItem(l) == kind {
+					items = append(items, strings.TrimLeft(l[strings.Index(l, " "):], " "))
+				} else {
+					items[len(items)-1] += "\n" + l // a continuation line of the item
+				}
+			}
+			i--
+			b.WriteString("<" + kind + ">\n")
+			for _, item := range items {
+				b.WriteString("<li>" + renderInline(item) + "</li>\n")
+			}
+			b.WriteString("</" + kind + ">\n")
+
+		default:
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	flush()
+
+	return b.String()
+}
+
+// return the level of a Markdown heading line (# to ######), or 0 if the line isn't one
+func markdownHeading(line string) int {
+	level := len(line) - len(strings.TrimLeft(line, "#"))
+	if level == 0 || level > 6 || (len(line) > level && line[level] != ' ') {
+		return 0
+	}
+
+	return level
+}
+
+// return whether a line is a Markdown rule (---, *** or ___, spaces allowed)
+func markdownRule(line string) bool {
+	s := strings.ReplaceAll(line, " ", "")
+	return len(s) >= 3 && (strings.Trim(s, "-") == "" || strings.Trim(s, "*") == "" || strings.Trim(s, "_") == "")
+}
+
+// return the list a Markdown line is an item of - ul, ol or "" if it's no list item
+func markdownListItem(line string) string {
+	switch {
+	case strings.HasPrefix(line, "- ") || strings.HasPrefix(line, "* ") || strings.HasPrefix(line, "+ "):
+		return "ul"
+	case orderedItemPattern.MatchString(line):
+		return "ol"
+	}
+
+	return ""
+}
+
+// return the HTML of a run of Markdown text: emphasis, code spans, links and backslash escapes
+func renderInline(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		rest := text[i:]
+
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte("\\`*_[]()#+-.!>", text[i+1]) >= 0:
+			b.WriteString(html.EscapeString(text[i+1 : i+2]))
+			i++
+			continue
+
+		case c == '`':
+			if end := strings.IndexByte(text[i+1:], '`'); end >= 0 {
+				b.WriteString("<code>" + html.EscapeString(text[i+1:i+1+end]) + "</code>")
+				i += end + 1
+				continue
+			}
+
+		case strings.HasPrefix(rest, "**") || strings.HasPrefix(rest, "__"):
+			if end := strings.Index(text[i+2:], rest[:2]); end > 0 {
+				b.WriteString("<strong>" + renderInline(text[i+2:i+2+end]) + "</strong>")
+				i += end + 3
+				continue
+			}
+
+		case c == '*' || (c == '_' && (i == 0 || !isWordByte(text[i-1]))):
+			if end := strings.IndexByte(text[i+1:], c); end > 0 && text[i+1] != ' ' {
+				b.WriteString("<em>" + renderInline(text[i+1:i+1+end]) + "</em>")
+				i += end + 1
+				continue
+			}
+
+		case c == '[':
+			if label, target, n := markdownLink(rest); n > 0 {
+				if href, ok := safeLinkURL(target); ok {
+					b.WriteString(`<a href="` + html.EscapeString(href) + `">` + renderInline(label) + "</a>")
+				} else {
+					b.WriteString(renderInline(label))
+				}
+				i += n - 1
+				continue
+			}
+		}
+
+		b.WriteString(html.EscapeString(text[i : i+1]))
+	}
+
+	return b.String()
+}
+
+// return the label and target of a Markdown link at the start of text ([label](target)) and its length - 0 if there's none
+func markdownLink(text string) (string, string, int) {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth > 0 {
+				continue
+			}
+			if !strings.HasPrefix(text[i+1:], "(") {
+				return "", "", 0
+			}
+			// the target ends at the parenthesis closing the one it starts with
+			parens := 0
+			for j := i + 1; j < len(text); j++ {
+				switch text[j] {
+				case '(':
+					parens++
+				case ')':
+					if parens--; parens == 0 {
+						return text[1:i], strings.TrimSpace(text[i+2 : j]), j + 1
+					}
+				}
+			}
+			return "", "", 0
+		}
+	}
+
+	return "", "", 0
+}
+
+// return a link target as it can go in a page - only http(s), mailto and relative URLs can (see URIValidation.go)
+func safeLinkURL(target string) (string, bool) {
+	if target == "" {
+		return "", false
+	}
+	if strings.HasPrefix(strings.ToLower(target), "mailto:") && !strings.ContainsAny(target, " <>\"") {
+		return target, true
+	}
+	if err := checkImageURI(target); err != nil || strings.ContainsAny(target, " \t\n") {
+		return "", false
+	}
+
+	return target, true
+}
+
+// return whether a byte is part of a word (an underscore in one doesn't start emphasis, e.g. in file_name)
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
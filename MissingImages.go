@@ -0,0 +1,96 @@
+// missing thumbnails: works that end up with no small image (no small URI in the feed and no large image to generate one from)
+// would render as broken <img src=""> tags in the galleries. --missing-images picks what happens to them instead: keep them as they
+// are, skip them, show a placeholder image, or substitute their medium or large image.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// file name of the placeholder thumbnail in the images directory
+const placeholderImage = "placeholder.svg"
+
+// placeholder thumbnail: a grey square with a crossed-out picture frame
+const placeholderSVG = `<svg xmlns="http://www.w3.org/2000/svg" width="135" height="135" viewBox="0 0 135 135">` +
+	`<rect width="135" height="135" fill="#e4e4e4"/>` +
+	`<rect x="37" y="42" width="61" height="51" rx="3" fill="none" stroke="#a0a0a0" stroke-width="4"/>` +
+	`<path d="M37 93 L98 42" stroke="#a0a0a0" stroke-width="4"/>` +
+	`</svg>
+`
+
+// return an error unless the policy is one applyMissingImagePolicy knows - checked before the build starts downloading
+func checkMissingImagePolicy(policy string) error {
+	switch policy {
+	case "keep", "skip", "placeholder", "substitute":
+		return nil
+	}
+
+	return fmt.Errorf("Error: unknown missing image policy %q (expected keep, skip, placeholder or substitute)", policy)
+}
+
+// handle works without a thumbnail according to policy: "keep" leaves them as they are, "skip" leaves them out of the site,
+// "placeholder" shows placeholder.svg and "substitute" uses the medium image (the large one if there's no medium either)
+// works that can't be substituted are kept as they are - the counts are printed as part of the build summary
+func applyMissingImagePolicy(catalog *Catalog, outputFolderLocation, policy string, thumbs thumbnailOptions) error {
+	var missing []*Work
+	for _, wk := range catalog.Works {
+		if wk != nil && strings.TrimSpace(wk.smallSrc()) == "" {
+			missing = append(missing, wk)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	switch policy {
+	case "keep":
+		fmt.Printf("Missing thumbnails: %d works kept without an image.\n", len(missing))
+
+	case "skip":
+		for _, wk := range missing {
+			catalog.removeWork(wk)
+		}
+		fmt.Printf("Missing thumbnails: %d works skipped.\n", len(missing))
+
+	case "placeholder":
+		dir := filepath.Join("./"+outputFolderLocation, imagesDir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("Error creating images directory (%s): %v", dir, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, placeholderImage), []byte(placeholderSVG), 0644); err != nil {
+			return fmt.Errorf("Error writing placeholder image: %v", err)
+		}
+
+		for _, wk := range missing {
+			wk.LocalSmall = imagesDir + "/" + placeholderImage
+			wk.SmallWidth, wk.SmallHeight = thumbs.Width, thumbs.Height
+		}
+		fmt.Printf("Missing thumbnails: %d works given a placeholder.\n", len(missing))
+
+	case "substitute":
+		substituted := 0
+		for _, wk := range missing {
+			switch {
+			case wk.LocalMedium != "":
+				wk.LocalSmall = wk.LocalMedium
+			case strings.TrimSpace(wk.URIMedium) != "":
+				wk.URISmall = wk.URIMedium
+			case wk.LocalLarge != "":
+				wk.LocalSmall = wk.LocalLarge
+			case strings.TrimSpace(wk.URILarge) != "":
+				wk.URISmall = wk.URILarge
+			default:
+				continue
+			}
+			substituted++
+		}
+		fmt.Printf("Missing thumbnails: %d works substituted with a larger image, %d kept without an image.\n", substituted, len(missing)-substituted)
+	}
+
+	return nil
+}
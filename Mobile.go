@@ -0,0 +1,78 @@
+// mobile pages: --mobile also writes a lightweight variant of every gallery page under m/ (m/index.html, m/Canon.html ...) for very
+// low-bandwidth audiences - no script, a stylesheet of a few hundred bytes, small thumbnails without WebP/AVIF alternatives and plain
+// links instead of the navigation dropdowns:
+//
+//	>go run ImageProcessor --mobile http://localhost/test/api/v1/works.xml code/html/output
+//
+// Each full gallery page points to its mobile variant with <link rel="alternate" media="only screen and (max-width: 640px)">, and each mobile page links back to the
+// full page (and names it as canonical when the site has a base URL). The thumbnails link to the full site's work pages.
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// directory (in the output directory) the mobile pages are written to
+const mobileDir = "m"
+
+// stylesheet of the mobile pages
+const mobileCSS = `body{margin:8px;font:16px/1.4 sans-serif}h1{font-size:1.3em}nav,p{margin:8px 0}img{max-width:100%;height:auto;vertical-align:top}.gallery a,body>a{display:inline-block;margin:2px}
+`
+
+// type struct representing the mobile variant of a site's gallery pages
+type mobileVariant struct {
+	assets *siteAssets // the mobile stylesheet (and the site's Content-Security-Policy with --csp)
+}
+
+// create the mobile pages' directory and write their stylesheet, for pages with the site's Content-Security-Policy (if any) - returns
+// the variant and the files written, or nil if the theme has no "mobile" template
+func newMobileVariant(outputFolderLocation string, templates *template.Template, csp string, opts *buildOptions) (*mobileVariant, []string, error) {
+	if templates.Lookup("mobile") == nil {
+		fmt.Fprintln(os.Stderr, "Mobile pages skipped: the theme has no \"mobile\" template")
+		return nil, nil, nil
+	}
+
+	if err := os.MkdirAll(filepath.Join("./"+outputFolderLocation, mobileDir), 0755); err != nil {
+		return nil, nil, fmt.Errorf("Error creating mobile page directory: %v", err)
+	}
+
+	stylesheet, err := writeAsset(outputFolderLocation+"/"+mobileDir, "mobile.css", []byte(mobileCSS), opts.Fingerprint)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error writing mobile stylesheet: %v", err)
+	}
+
+	assets := &siteAssets{Stylesheet: stylesheet, CSP: csp}
+	if opts.CSP {
+		assets.StylesheetIntegrity = subresourceIntegrity([]byte(mobileCSS))
+	}
+
+	return &mobileVariant{assets: assets}, []string{mobileDir + "/" + stylesheet}, nil
+}
+
+// write the mobile variant of the gallery page just written as file from view
+func (s *siteWriter) writeMobilePage(file string, view *pageView) error {
+	mv := &pageView{Title: view.Title, Heading: view.Heading, Description: view.Description, Assets: s.mobile.assets, Makes: view.Makes, HasGeneric: view.HasGeneric, Make: view.Make, Model: view.Model, Desktop: "../" + file, Canonical: s.canonical(file)}
+
+	mv.Gallery = galleryView{Pagination: view.Gallery.Pagination}
+	for _, item := range view.Gallery.Items {
+		image := workImage(item.Work, "small")
+		mv.Gallery.Items = append(mv.Gallery.Items, galleryItem{Page: "../" + item.Page, Image: imageView{Src: rootRelative("../", image.Src), Alt: item.Work.FileName, Width: image.Width, Height: image.Height}, Work: item.Work})
+	}
+
+	return s.writeView(mobileDir+"/"+file, "mobile", mv)
+}
+
+// return the URI as seen from a page in a subdirectory, root being the way back up to the site root (e.g. "../") - relative URIs get
+// root in front of them, others are returned as they are
+func rootRelative(root, uri string) string {
+	if uri == "" || strings.Contains(uri, "://") || strings.HasPrefix(uri, "/") || strings.HasPrefix(uri, "data:") {
+		return uri
+	}
+
+	return root + uri
+}
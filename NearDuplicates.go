@@ -0,0 +1,172 @@
+// near-duplicate detection: with --near-duplicates, a perceptual hash (a difference hash: whether each pixel of a tiny grayscale copy
+// is brighter than the next) is computed from every work's thumbnail, and works whose hashes differ in no more than a few bits - burst
+// shots, re-exports and re-uploads of the same photo - are reported in groups, on the console and in the build manifest, so curators
+// can clean up the catalog. With --collapse-near-duplicates, a gallery page shows only the first work of each group it has.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// type struct representing the options of near-duplicate detection
+type nearDuplicateOptions struct {
+	Enabled  bool // report visually near-identical works
+	Distance int  // most bits their perceptual hashes may differ by
+	Collapse bool // show one work of each group on the gallery pages
+}
+
+// default number of bits two perceptual hashes may differ by for their works to count as near-duplicates
+const defaultNearDuplicateDistance = 6
+
+// return the 64-bit difference hash of an image
+func perceptualHash(img image.Image) uint64 {
+	sample := resizeImage(img, 9, 8)
+
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if luminance(sample, x, y) > luminance(sample, x+1, y) {
+				h |= 1 << uint(y*8+x)
+			}
+		}
+	}
+
+	return h
+}
+
+// return the brightness of a pixel of an image, from 0 to 255
+func luminance(img *image.RGBA, x, y int) int {
+	i := img.PixOffset(x, y)
+	return (299*int(img.Pix[i]) + 587*int(img.Pix[i+1]) + 114*int(img.Pix[i+2])) / 1000
+}
+
+// work out the perceptual hash of every work's thumbnail (the downloaded/generated copy if there is one, otherwise the remote small
+// image) - remote results are remembered in the build cache
+func computePerceptualHashes(catalog *Catalog, outputFolderLocation string, cache *buildCache) map[*Work]uint64 {
+	hashes := map[*Work]uint64{}
+	failed := 0
+
+	for _, wk := range catalog.Works {
+		if wk == nil {
+			continue
+		}
+
+		src := strings.TrimSpace(wk.smallSrc())
+		if src == "" {
+			continue
+		}
+
+		remote := strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+		if remote {
+			if h, err := strconv.ParseUint(cache.PerceptualHashes[src], 16, 64); err == nil {
+				hashes[wk] = h
+				continue
+			}
+		}
+
+		img, err := loadImage(src, outputFolderLocation)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading thumbnail of work %s for near-duplicate detection (%s): %v\n", wk.ID, src, err)
+			failed++
+			continue
+		}
+
+		hashes[wk] = perceptualHash(img)
+		if remote {
+			cache.setPerceptualHash(src, strconv.FormatUint(hashes[wk], 16))
+		}
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "Near duplicates: %d thumbnails couldn't be read and weren't compared\n", failed)
+	}
+
+	return hashes
+}
+
+// find the groups of works whose thumbnails are visually near-identical - a work is in a group when its hash is within the given
+// number of bits of any other work's in it - and record them in the catalog, in feed order
+func findNearDuplicates(catalog *Catalog, outputFolderLocation string, cache *buildCache, distance int) {
+	hashes := computePerceptualHashes(catalog, outputFolderLocation, cache)
+
+	var works []*Work
+	for _, wk := range catalog.Works {
+		if _, ok := hashes[wk]; ok {
+			works = append(works, wk)
+		}
+	}
+
+	// union-find over every pair within the distance
+	parent := make([]int, len(works))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range works {
+		for j := i + 1; j < len(works); j++ {
+			if bits.OnesCount64(hashes[works[i]]^hashes[works[j]]) <= distance {
+				if a, b := root(i), root(j); a != b {
+					parent[b] = a
+				}
+			}
+		}
+	}
+
+	size := map[int]int{}
+	for i := range works {
+		size[root(i)]++
+	}
+
+	groups := map[int]int{} // root -> index in catalog.NearDuplicates
+	catalog.NearDuplicates = nil
+	for i, wk := range works {
+		r := root(i)
+		if size[r] == 1 {
+			continue // a work on its own
+		}
+		g, ok := groups[r]
+		if !ok {
+			g = len(catalog.NearDuplicates)
+			groups[r] = g
+			catalog.NearDuplicates = append(catalog.NearDuplicates, nil)
+		}
+		catalog.NearDuplicates[g] = append(catalog.NearDuplicates[g], wk)
+	}
+
+	count := 0
+	for _, group := range catalog.NearDuplicates {
+		count += len(group)
+	}
+	fmt.Printf("Near duplicates: %d groups of visually near-identical works (%d works).\n", len(catalog.NearDuplicates), count)
+	for _, group := range catalog.NearDuplicates {
+		var ids []string
+		for _, wk := range group {
+			ids = append(ids, wk.ID.String())
+		}
+		fmt.Printf("  works %s\n", strings.Join(ids, ", "))
+	}
+}
+
+// return the group of each near-duplicate work, for gallery pages to show one work per group
+func (c *Catalog) nearDuplicateGroups() map[*Work]int {
+	groups := map[*Work]int{}
+	for g, group := range c.NearDuplicates {
+		for _, wk := range group {
+			groups[wk] = g
+		}
+	}
+
+	return groups
+}
@@ -0,0 +1,219 @@
+// failure notifications: pluggable providers (webhook, Slack, PagerDuty, email) routed by event severity.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// event severities, in increasing order of urgency
+const (
+	SeverityWarn = "warn" // something went wrong but the site is still being served/rebuilt (e.g. a transient fetch error in watch mode)
+	SeverityFail = "fail" // site generation failed
+)
+
+// type struct representing something worth telling a human about
+type Event struct {
+	Severity string
+	Title    string
+	Message  string
+	Time     time.Time
+}
+
+// a notification provider delivers events to one destination
+type Notifier interface {
+	Notify(ev Event) error
+}
+
+// type struct representing one notification provider entry in the config file
+type NotifierConfig struct {
+	Type        string `json:"type"`         // webhook, slack, pagerduty or email
+	MinSeverity string `json:"min_severity"` // lowest severity sent to this provider: warn (default) or fail
+
+	URL string `json:"url"` // webhook and slack: URL to POST events to
+
+	RoutingKey    string `json:"routing_key"`     // pagerduty: Events API v2 integration key
+	RoutingKeyEnv string `json:"routing_key_env"` // pagerduty: environment variable holding the integration key instead
+
+	SMTPHost    string   `json:"smtp_host"` // email: SMTP server host name
+	SMTPPort    int      `json:"smtp_port"` // email: SMTP server port (default 587)
+	Username    string   `json:"username"`
+	Password    string   `json:"password"`
+	PasswordEnv string   `json:"password_env"` // email: environment variable holding the SMTP password instead
+	From        string   `json:"from"`
+	To          []string `json:"to"`
+}
+
+// type struct representing a notifier together with the lowest severity it should receive
+type routedNotifier struct {
+	notifier    Notifier
+	minSeverity string
+	name        string
+}
+
+// set of configured notifiers - the zero value sends nothing
+type notifierSet []routedNotifier
+
+// create the notifiers described in the configuration
+func newNotifierSet(configs []NotifierConfig) (notifierSet, error) {
+	var set notifierSet
+
+	for i, c := range configs {
+		var n Notifier
+
+		switch c.Type {
+		case "webhook":
+			n = &webhookNotifier{url: c.URL}
+		case "slack":
+			n = &slackNotifier{url: c.URL}
+		case "pagerduty":
+			n = &pagerDutyNotifier{routingKey: secretValue(c.RoutingKey, c.RoutingKeyEnv)}
+		case "email":
+			port := c.SMTPPort
+			if port == 0 {
+				port = 587
+			}
+			n = &emailNotifier{host: c.SMTPHost, port: port, username: c.Username, password: secretValue(c.Password, c.PasswordEnv), from: c.From, to: c.To}
+		default:
+			return nil, fmt.Errorf("Error in notifications config entry %d: unknown type %q (expected webhook, slack, pagerduty or email)", i+1, c.Type)
+		}
+
+		minSeverity := c.MinSeverity
+		if minSeverity == "" {
+			minSeverity = SeverityWarn
+		}
+
+		if minSeverity != SeverityWarn && minSeverity != SeverityFail {
+			return nil, fmt.Errorf("Error in notifications config entry %d: unknown min_severity %q (expected warn or fail)", i+1, c.MinSeverity)
+		}
+
+		set = append(set, routedNotifier{notifier: n, minSeverity: minSeverity, name: c.Type})
+	}
+
+	return set, nil
+}
+
+// send an event to every notifier routed to receive its severity - delivery errors are reported on stderr but never stop the build
+func (set notifierSet) send(severity, title, message string) {
+	ev := Event{Severity: severity, Title: title, Message: message, Time: time.Now()}
+
+	for _, rn := range set {
+		if rn.minSeverity == SeverityFail && severity != SeverityFail {
+			continue
+		}
+
+		if err := rn.notifier.Notify(ev); err != nil {
+			fmt.Fprintf(os.Stderr, "Error sending %s notification: %v\n", rn.name, err)
+		}
+	}
+}
+
+// POST a JSON body to the given URL and treat any non-2xx response as an error
+func postJSON(url string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned HTTP %s", url, resp.Status)
+	}
+
+	return nil
+}
+
+// generic webhook: POSTs the event as JSON
+type webhookNotifier struct {
+	url string
+}
+
+func (n *webhookNotifier) Notify(ev Event) error {
+	return postJSON(n.url, map[string]string{
+		"severity": ev.Severity,
+		"title":    ev.Title,
+		"message":  ev.Message,
+		"time":     ev.Time.Format(time.RFC3339),
+	})
+}
+
+// Slack incoming webhook
+type slackNotifier struct {
+	url string
+}
+
+func (n *slackNotifier) Notify(ev Event) error {
+	icon := ":warning:"
+	if ev.Severity == SeverityFail {
+		icon = ":rotating_light:"
+	}
+
+	return postJSON(n.url, map[string]string{"text": icon + " *" + ev.Title + "*\n" + ev.Message})
+}
+
+// PagerDuty Events API v2
+type pagerDutyNotifier struct {
+	routingKey string
+}
+
+// PagerDuty Events API v2 endpoint
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+func (n *pagerDutyNotifier) Notify(ev Event) error {
+	severity := "warning"
+	if ev.Severity == SeverityFail {
+		severity = "error"
+	}
+
+	source, _ := os.Hostname()
+
+	return postJSON(pagerDutyEventsURL, map[string]interface{}{
+		"routing_key":  n.routingKey,
+		"event_action": "trigger",
+		"payload": map[string]string{
+			"summary":   ev.Title + ": " + ev.Message,
+			"source":    source,
+			"severity":  severity,
+			"timestamp": ev.Time.Format(time.RFC3339),
+		},
+	})
+}
+
+// email via SMTP (with PLAIN auth when a username is configured)
+type emailNotifier struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func (n *emailNotifier) Notify(ev Event) error {
+	var auth smtp.Auth
+	if n.username != "" {
+		auth = smtp.PlainAuth("", n.username, n.password, n.host)
+	}
+
+	msg := "From: " + n.from + "\r\n" +
+		"To: " + strings.Join(n.to, ", ") + "\r\n" +
+		"Subject: [" + strings.ToUpper(ev.Severity) + "] " + ev.Title + "\r\n" +
+		"Date: " + ev.Time.Format(time.RFC1123Z) + "\r\n" +
+		"\r\n" + ev.Message + "\r\n"
+
+	return smtp.SendMail(n.host+":"+strconv.Itoa(n.port), auth, n.from, n.to, []byte(msg))
+}
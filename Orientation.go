@@ -0,0 +1,135 @@
+// EXIF orientation: cameras store portrait shots as landscape pixels plus an Orientation tag saying how to turn them. Browsers
+// honour the tag when showing the original JPEG, but anything decoded and re-encoded here (thumbnails, watermarked renditions,
+// WebP/AVIF variants, contact sheets) loses it, so the pixels are turned upright first. Probed dimensions are corrected the same
+// way, and the orientation is kept with them in the build cache.
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+)
+
+// return the EXIF orientation (1-8) of the image data, 1 (upright) if it has none
+func imageOrientation(data []byte) int {
+	e, err := readExif(data)
+	if err != nil {
+		return 1
+	}
+
+	return e.orientation()
+}
+
+// return the EXIF orientation of the image file, read from its first bytes
+func fileOrientation(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 1
+	}
+	defer f.Close()
+
+	head, err := io.ReadAll(io.LimitReader(f, exifProbeBytes))
+	if err != nil {
+		return 1
+	}
+
+	return imageOrientation(head)
+}
+
+// return whether the orientation swaps width and height (the quarter turns and transpositions)
+func orientationSwapsAxes(orientation int) bool {
+	return orientation >= 5 && orientation <= 8
+}
+
+// decode the image file and turn it upright according to its EXIF orientation
+func decodeImageFile(path string) (image.Image, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return orientImage(img, imageOrientation(data)), format, nil
+}
+
+// return the image transformed from the given EXIF orientation to upright
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	dw, dh := w, h
+	if orientationSwapsAxes(orientation) {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored upside down
+				dx, dy = x, h-1-y
+			case 5: // mirrored, turned a quarter anticlockwise
+				dx, dy = y, x
+			case 6: // turned a quarter anticlockwise - rotate clockwise
+				dx, dy = h-1-y, x
+			case 7: // mirrored, turned a quarter clockwise
+				dx, dy = h-1-y, w-1-x
+			case 8: // turned a quarter clockwise - rotate anticlockwise
+				dx, dy = y, w-1-x
+			}
+
+			dst.SetRGBA(dx, dy, src.RGBAAt(x, y))
+		}
+	}
+
+	return dst
+}
+
+// return a path to an upright copy of the image file for tools that ignore EXIF orientation - the file itself if it's upright
+// already, otherwise a temporary PNG the caller removes with the returned cleanup function
+func uprightImageFile(path string) (string, func(), error) {
+	if fileOrientation(path) == 1 {
+		return path, func() {}, nil
+	}
+
+	img, _, err := decodeImageFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	tmp, err := os.CreateTemp("", "imgproc-upright-*.png")
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = png.Encode(tmp, img)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", nil, err
+	}
+
+	return tmp.Name(), func() { os.Remove(tmp.Name()) }, nil
+}
@@ -0,0 +1,140 @@
+// catalog outline: the make -> model tree as OPML, for feed readers and outliners, or as a plain JSON tree for other tools. The
+// export command writes either:
+//
+//	>go run ImageProcessor export --format opml --base-url https://photos.example.com/ http://localhost/test/api/v1/works.xml
+//	>go run ImageProcessor export --format tree http://localhost/test/api/v1/works.xml
+//
+// With a base URL, each outline links to the page and the Atom feed of its make or model (see Feeds.go), so importing the OPML into a
+// feed reader subscribes to every model. A build with --atom-feeds writes the same OPML next to the feeds, as feeds.opml.
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// name of the OPML file of every feed written next to the Atom feeds
+const feedsOPMLFile = "feeds.opml"
+
+// type struct representing an OPML document
+type opmlDocument struct {
+	XMLName xml.Name      `xml:"opml"`
+	Version string        `xml:"version,attr"`
+	Title   string        `xml:"head>title"`
+	Body    []opmlOutline `xml:"body>outline"`
+}
+
+// type struct representing an outline of an OPML document - a feed when it has an xmlUrl, otherwise a folder of the outlines in it
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr,omitempty"`
+	Type     string        `xml:"type,attr,omitempty"`
+	XMLURL   string        `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string        `xml:"htmlUrl,attr,omitempty"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+// type struct representing a make or model in the JSON tree
+type treeNode struct {
+	Name        string     `json:"name"`
+	DisplayName string     `json:"display_name"`
+	Page        string     `json:"page"`
+	Feed        string     `json:"feed,omitempty"` // absolute Atom feed URL (only with a base URL)
+	Works       int        `json:"works"`
+	Models      []treeNode `json:"models,omitempty"`
+}
+
+// return the OPML outline of the catalog's makes and models - every make is a folder holding its own feed and its models' feeds
+// without a base URL there are no feed links, and the outlines only name the makes and models
+func catalogOPML(catalog *Catalog, baseURL string) ([]byte, error) {
+	site := ""
+	if baseURL != "" {
+		site = strings.TrimSuffix(baseURL, "/") + "/"
+	}
+
+	feed := func(text, pageURL string) opmlOutline {
+		o := opmlOutline{Text: text, Title: text}
+		if site != "" {
+			o.Type, o.XMLURL, o.HTMLURL = "rss", site+feedFile(pageURL), site+pageURL+".html"
+		}
+		return o
+	}
+
+	doc := opmlDocument{Version: "2.0", Title: "Photos by camera"}
+	if site != "" {
+		doc.Body = append(doc.Body, opmlOutline{Text: "All photos", Title: "All photos", Type: "rss", XMLURL: site + siteFeedFile, HTMLURL: site + "index.html"})
+	}
+
+	for _, mk := range catalog.Makes {
+		if mk == nil {
+			continue
+		}
+
+		folder := opmlOutline{Text: mk.DisplayName, Title: mk.DisplayName}
+		folder.Outlines = append(folder.Outlines, feed("All "+mk.DisplayName+" photos", mk.PageURL))
+		for _, md := range mk.Models {
+			if md != nil {
+				folder.Outlines = append(folder.Outlines, feed(md.DisplayName, md.PageURL))
+			}
+		}
+		doc.Body = append(doc.Body, folder)
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// write the catalog's make -> model tree as OPML
+func writeOPMLExport(out io.Writer, catalog *Catalog, baseURL string) error {
+	data, err := catalogOPML(catalog, baseURL)
+	if err != nil {
+		return err
+	}
+
+	_, err = out.Write(data)
+	return err
+}
+
+// write the catalog's make -> model tree as an indented JSON document, with the number of works without a make
+func writeTreeExport(out io.Writer, catalog *Catalog, baseURL string) error {
+	node := func(name, displayName, pageURL string, works int) treeNode {
+		n := treeNode{Name: name, DisplayName: displayName, Page: pageURL + ".html", Works: works}
+		if baseURL != "" {
+			n.Feed = strings.TrimSuffix(baseURL, "/") + "/" + feedFile(pageURL)
+		}
+		return n
+	}
+
+	makes := []treeNode{}
+	for _, mk := range catalog.Makes {
+		if mk == nil {
+			continue
+		}
+
+		n := node(mk.Name, mk.DisplayName, mk.PageURL, mk.WorkCount())
+		for _, md := range mk.Models {
+			if md != nil {
+				n.Models = append(n.Models, node(md.Name, md.DisplayName, md.PageURL, md.WorkCount()))
+			}
+		}
+		makes = append(makes, n)
+	}
+
+	generic := 0
+	for _, wk := range catalog.WorksSM {
+		if wk != nil {
+			generic++
+		}
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{"makes": makes, "generic_works": generic})
+}
@@ -0,0 +1,408 @@
+// page overrides: an optional file (--overrides) of hand-written changes merged into the catalog after parsing - custom titles and
+// descriptions for make, model and work pages, works hidden from the site, and featured and sensitive flags pinned on or off - so a
+// site can be curated without editing the feed. The file is YAML keyed by make name, model name and work id:
+//
+//	makes:
+//	  Canon:
+//	    title: Canon cameras
+//	    description: Everything shot on Canon bodies.
+//	models:
+//	  "EOS 5D Mark II":
+//	    description: The workhorse.
+//	works:
+//	  12:
+//	    title: Sunset over the bay
+//	    featured: true
+//	  31:
+//	    hidden: true
+//	  40:
+//	    flagged: true
+//
+// Only this much YAML is read: nested mappings of plain or quoted scalars, and # comments. Descriptions are Markdown (see Markdown.go) and
+// can show works within the text with shortcodes such as {{work 12}} (see Shortcodes.go).
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// type struct representing the overrides of one make, model or work page
+type pageOverride struct {
+	Title       string
+	Description string
+	Hidden      *bool // works only: leave the work out of the site
+	Featured    *bool // works only: pin the <featured> flag on or off
+	Flagged     *bool // works only: pin the work's sensitive flag on or off (see Flagged.go)
+}
+
+// type struct representing the contents of an overrides file
+type pageOverrides struct {
+	Makes  map[string]pageOverride // by make name (case-insensitive)
+	Models map[string]pageOverride // by model name (case-insensitive)
+	Works  map[WorkID]pageOverride // by work id
+}
+
+// read and decode the overrides file at the given path - an empty path returns no overrides
+func loadOverrides(path string) (*pageOverrides, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading overrides file (%s): %v", path, err)
+	}
+
+	return parseOverrides(data, path)
+}
+
+// decode the contents of an overrides file, named by path in errors
+func parseOverrides(data []byte, path string) (*pageOverrides, error) {
+	doc, err := parseSimpleYAML(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing overrides file (%s): %v", path, err)
+	}
+
+	overrides := &pageOverrides{Makes: map[string]pageOverride{}, Models: map[string]pageOverride{}, Works: map[WorkID]pageOverride{}}
+
+	for section, value := range doc {
+		entries, ok := value.(map[string]interface{})
+		if value == "" {
+			continue // a section with nothing in it
+		}
+		if !ok {
+			return nil, fmt.Errorf("Error in overrides file (%s): %s should be a mapping", path, section)
+		}
+
+		for key, fields := range entries {
+			o, err := decodePageOverride(section, fields)
+			if err != nil {
+				return nil, fmt.Errorf("Error in overrides file (%s): %s %s: %v", path, section, key, err)
+			}
+
+			switch section {
+			case "makes":
+				overrides.Makes[strings.ToLower(key)] = o
+			case "models":
+				overrides.Models[strings.ToLower(key)] = o
+			case "works":
+				id, err := parseWorkID(key)
+				if err != nil {
+					return nil, fmt.Errorf("Error in overrides file (%s): %v", path, err)
+				}
+				overrides.Works[id] = o
+			default:
+				return nil, fmt.Errorf("Error in overrides file (%s): unknown section %q (expected makes, models or works)", path, section)
+			}
+		}
+	}
+
+	return overrides, nil
+}
+
+// decode the fields of one entry - hidden, featured and flagged are only allowed on works
+func decodePageOverride(section string, value interface{}) (pageOverride, error) {
+	var o pageOverride
+
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return o, fmt.Errorf("should be a mapping of fields (title, description, and for works hidden, featured and flagged)")
+	}
+
+	for name, v := range fields {
+		s, ok := v.(string)
+		if !ok {
+			return o, fmt.Errorf("%s should be a single value", name)
+		}
+
+		switch {
+		case name == "title":
+			o.Title = s
+		case name == "description":
+			o.Description = s
+		case (name == "hidden" || name == "featured" || name == "flagged") && section == "works":
+			b, err := parseYAMLBool(s)
+			if err != nil {
+				return o, fmt.Errorf("%s: %v", name, err)
+			}
+			switch name {
+			case "hidden":
+				o.Hidden = &b
+			case "featured":
+				o.Featured = &b
+			default:
+				o.Flagged = &b
+			}
+		default:
+			return o, fmt.Errorf("unknown field %q", name)
+		}
+	}
+
+	return o, nil
+}
+
+// merge the overrides into the catalog, warning about makes, models and works the feed doesn't have
+func applyOverrides(catalog *Catalog, overrides *pageOverrides) {
+	if overrides == nil {
+		return
+	}
+
+	usedMakes, usedModels := map[string]bool{}, map[string]bool{}
+
+	for _, mk := range catalog.Makes {
+		if mk == nil {
+			continue
+		}
+
+		if o, ok := overrides.Makes[strings.ToLower(mk.Name)]; ok {
+			mk.Title, mk.Description = o.Title, o.Description
+			usedMakes[strings.ToLower(mk.Name)] = true
+		}
+
+		for _, md := range mk.Models {
+			if md == nil {
+				continue
+			}
+
+			if o, ok := overrides.Models[strings.ToLower(md.Name)]; ok {
+				md.Title, md.Description = o.Title, o.Description
+				usedModels[strings.ToLower(md.Name)] = true
+			}
+		}
+	}
+
+	hidden := 0
+	usedWorks := map[WorkID]bool{}
+
+	for _, wk := range append([]*Work(nil), catalog.Works...) {
+		if wk == nil {
+			continue
+		}
+
+		o, ok := overrides.Works[wk.ID]
+		if !ok {
+			continue
+		}
+		usedWorks[wk.ID] = true
+
+		if o.Hidden != nil && *o.Hidden {
+			catalog.removeWork(wk)
+			hidden++
+			continue
+		}
+
+		if o.Title != "" {
+			wk.Title = o.Title
+		}
+		wk.Description = o.Description
+		if o.Featured != nil {
+			wk.Featured = *o.Featured
+		}
+	}
+
+	for name := range overrides.Makes {
+		if !usedMakes[name] {
+			fmt.Fprintf(os.Stderr, "Overrides: no make named %q in the works data\n", name)
+		}
+	}
+	for name := range overrides.Models {
+		if !usedModels[name] {
+			fmt.Fprintf(os.Stderr, "Overrides: no model named %q in the works data\n", name)
+		}
+	}
+	for id := range overrides.Works {
+		if !usedWorks[id] {
+			fmt.Fprintf(os.Stderr, "Overrides: no work with id %s in the works data\n", id)
+		}
+	}
+
+	if hidden > 0 {
+		fmt.Printf("Overrides: %d works hidden.\n", hidden)
+	}
+}
+
+// return the overridden title if there is one, otherwise the generated one
+func titleOr(override, generated string) string {
+	if override != "" {
+		return override
+	}
+
+	return generated
+}
+
+// type struct representing a mapping being filled in while parsing YAML
+type yamlFrame struct {
+	indent int
+	values map[string]interface{}
+}
+
+// parse the subset of YAML overrides files use: nested mappings whose values are scalars (returned as strings) or further mappings
+func parseSimpleYAML(text string) (map[string]interface{}, error) {
+	root := map[string]interface{}{}
+	stack := []yamlFrame{{indent: 0, values: root}}
+
+	// a key ending its line opens a mapping, filled by the more indented lines after it
+	var openKey string
+	var openIn map[string]interface{}
+	openIndent := -1
+
+	for n, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		lineNo := n + 1
+
+		content := strings.TrimLeft(line, " ")
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("line %d: indent with spaces, not tabs", lineNo)
+		}
+		if content == "" || strings.HasPrefix(content, "#") || content == "---" {
+			continue
+		}
+		indent := len(line) - len(content)
+
+		if openIn != nil {
+			if indent > openIndent {
+				child := map[string]interface{}{}
+				openIn[openKey] = child
+				stack = append(stack, yamlFrame{indent: indent, values: child})
+			} else {
+				openIn[openKey] = ""
+			}
+			openIn = nil
+		}
+
+		for len(stack) > 1 && indent < stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+		if indent != stack[len(stack)-1].indent {
+			return nil, fmt.Errorf("line %d: indentation doesn't match any mapping above it", lineNo)
+		}
+
+		if strings.HasPrefix(content, "- ") || content == "-" {
+			return nil, fmt.Errorf("line %d: lists aren't supported", lineNo)
+		}
+
+		key, rest, err := yamlKey(content)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+
+		values := stack[len(stack)-1].values
+		if _, dup := values[key]; dup {
+			return nil, fmt.Errorf("line %d: %q is given twice", lineNo, key)
+		}
+
+		value, err := yamlScalar(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+
+		if value == nil {
+			openKey, openIn, openIndent = key, values, indent
+			values[key] = ""
+			continue
+		}
+		values[key] = *value
+	}
+
+	return root, nil
+}
+
+// split a "key: value" line into the key and the rest of the line after the colon
+func yamlKey(content string) (string, string, error) {
+	if content[0] == '"' || content[0] == '\'' {
+		key, rest, err := yamlQuoted(content)
+		if err != nil {
+			return "", "", err
+		}
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", fmt.Errorf("expected a colon after %q", key)
+		}
+		return key, rest[1:], nil
+	}
+
+	i := strings.Index(content+" ", ": ")
+	if i < 0 {
+		return "", "", fmt.Errorf("expected key: value")
+	}
+
+	return strings.TrimSpace(content[:i]), content[i+1:], nil
+}
+
+// return the scalar value after a key, or nil if the key has no value on its line (a mapping follows)
+func yamlScalar(rest string) (*string, error) {
+	rest = strings.TrimSpace(rest)
+
+	switch {
+	case rest == "" || strings.HasPrefix(rest, "#"):
+		return nil, nil
+	case rest == "|" || rest == ">" || strings.HasPrefix(rest, "| ") || strings.HasPrefix(rest, "> "):
+		return nil, fmt.Errorf("block scalars aren't supported - write the text on one line, quoted if need be")
+	case rest[0] == '[' || rest[0] == '{':
+		return nil, fmt.Errorf("flow collections aren't supported")
+	case rest[0] == '"' || rest[0] == '\'':
+		value, after, err := yamlQuoted(rest)
+		if err != nil {
+			return nil, err
+		}
+		if after = strings.TrimSpace(after); after != "" && !strings.HasPrefix(after, "#") {
+			return nil, fmt.Errorf("unexpected %q after quoted value", after)
+		}
+		return &value, nil
+	}
+
+	if i := strings.Index(rest, " #"); i >= 0 {
+		rest = strings.TrimSpace(rest[:i])
+	}
+
+	return &rest, nil
+}
+
+// read a double- or single-quoted string from the start of s, returning it and what follows the closing quote
+func yamlQuoted(s string) (string, string, error) {
+	if s[0] == '\'' {
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				b.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			return b.String(), s[i+1:], nil
+		}
+		return "", "", fmt.Errorf("unterminated quoted string")
+	}
+
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			value, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", "", fmt.Errorf("bad quoted string %s: %v", s[:i+1], err)
+			}
+			return value, s[i+1:], nil
+		}
+	}
+
+	return "", "", fmt.Errorf("unterminated quoted string")
+}
+
+// read a YAML boolean (true/false, yes/no, on/off)
+func parseYAMLBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off":
+		return false, nil
+	}
+
+	return false, fmt.Errorf("%q isn't true or false", s)
+}
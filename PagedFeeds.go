@@ -0,0 +1,209 @@
+// paginated works feeds: an API that serves its works a page at a time is read by putting {page} in the feed URL where the page
+// number goes (pages are numbered from 1):
+//
+//	>go run ImageProcessor "http://localhost/test/api/v1/works.xml?page={page}" code/html/output
+//
+// If the first page's root element says how many pages there are (<works pages="200">), the rest are all fetched at once, at most
+// --page-concurrency at a time; otherwise pages are fetched in rounds of that many until one comes back without works. The pages'
+// works are joined in page order into a single feed, and each page is kept in the persistent cache like any other feed.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// placeholder for the page number in a paginated feed URL
+const feedPagePlaceholder = "{page}"
+
+// most pages read from one paginated feed, in case an API keeps serving pages
+const feedMaxPages = 10000
+
+// number of feed pages fetched at once (--page-concurrency) - --per-host still caps requests to the API's host
+var feedPageJobs = 8
+
+// type struct representing a fetched page of a works feed
+type feedPage struct {
+	works int    // number of <work> elements on the page
+	pages int    // number of pages the root element says the feed has (0 if it doesn't say)
+	body  []byte // the page's XML between the root element's tags
+}
+
+// return whether the feed location is a paginated feed URL
+func isPagedFeed(location string) bool {
+	return (strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")) && strings.Contains(location, feedPagePlaceholder)
+}
+
+// fetch every page of a paginated feed and return their works joined in page order as one feed
+func openPagedFeed(location string) (io.ReadCloser, error) {
+	first, err := fetchFeedPage(location, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := []*feedPage{first}
+	switch {
+	case first.works == 0:
+		// an empty feed
+
+	case first.pages > 0:
+		if first.pages > feedMaxPages {
+			return nil, fmt.Errorf("Error reading paginated feed %s: it has %d pages, at most %d are read", location, first.pages, feedMaxPages)
+		}
+
+		rest, err := fetchFeedPages(location, 2, first.pages)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, rest...)
+
+	default:
+		// the page count isn't known - fetch a round of pages at a time until one has no works
+		jobs := feedPageJobs
+		if jobs < 1 {
+			jobs = 1
+		}
+
+		for next := 2; ; next += jobs {
+			if next > feedMaxPages {
+				return nil, fmt.Errorf("Error reading paginated feed %s: still more works after %d pages", location, feedMaxPages)
+			}
+
+			round, err := fetchFeedPages(location, next, next+jobs-1)
+			if err != nil {
+				return nil, err
+			}
+
+			done := false
+			for _, page := range round {
+				if page.works == 0 {
+					done = true
+					break
+				}
+				pages = append(pages, page)
+			}
+			if done {
+				break
+			}
+		}
+	}
+
+	var feed bytes.Buffer
+	feed.WriteString(xml.Header + "<works>\n")
+	for _, page := range pages {
+		feed.Write(page.body)
+		feed.WriteString("\n")
+	}
+	feed.WriteString("</works>\n")
+
+	fmt.Printf("Read %d pages of the paginated feed.\n", len(pages))
+	return io.NopCloser(&feed), nil
+}
+
+// fetch pages from to last (inclusive) of a paginated feed, at most feedPageJobs at a time, and return them in order
+func fetchFeedPages(location string, from, last int) ([]*feedPage, error) {
+	if last < from {
+		return nil, nil
+	}
+
+	jobs := feedPageJobs
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	pages := make([]*feedPage, last-from+1)
+	errs := make([]error, len(pages))
+	slots := make(chan struct{}, jobs)
+
+	var wg sync.WaitGroup
+	for i := range pages {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			pages[i], errs[i] = fetchFeedPage(location, from+i)
+		}(i)
+	}
+	wg.Wait()
+
+	// report the first failed page, so the error is the same whichever request failed first
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pages, nil
+}
+
+// fetch one page of a paginated feed
+func fetchFeedPage(location string, page int) (*feedPage, error) {
+	uri := strings.ReplaceAll(location, feedPagePlaceholder, strconv.Itoa(page))
+
+	body, err := openRemoteFeed(uri)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching page %d of the feed (%s): %v", page, uri, err)
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(limits.reader(body))
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching page %d of the feed (%s): %v", page, uri, err)
+	}
+
+	parsed, err := splitFeedPage(data)
+	if err != nil {
+		return nil, withExitCode(exitParse, fmt.Errorf("Error reading page %d of the feed (%s): %v", page, uri, err))
+	}
+
+	return parsed, nil
+}
+
+// return the works between a feed page's root tags, along with how many there are and the page count the root element gives
+func splitFeedPage(data []byte) (*feedPage, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	page := &feedPage{}
+
+	depth, start := 0, int64(-1)
+	for {
+		offset := dec.InputOffset()
+		token, err := dec.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no root element")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				start = dec.InputOffset()
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "pages" {
+						if page.pages, err = strconv.Atoi(strings.TrimSpace(attr.Value)); err != nil || page.pages < 0 {
+							return nil, fmt.Errorf("unreadable page count %q", attr.Value)
+						}
+					}
+				}
+			} else if depth == 1 && t.Name.Local == "work" {
+				page.works++
+			}
+			depth++
+
+		case xml.EndElement:
+			depth--
+			if depth == 0 {
+				page.body = data[start:offset]
+				return page, nil
+			}
+		}
+	}
+}
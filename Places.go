@@ -0,0 +1,505 @@
+// places: a work's GPS position - from <latitude> and <longitude> elements in the feed, or the GPS tags of its image with --exif - can
+// be reverse geocoded to a country and city, and the site then gets a page per country and per city (place-<country>.html,
+// place-<country>-<city>.html) listed on a places page (places.html) the homepage links to. Geocoding is set up in the config file's
+// "geocode" section, with either an offline dataset or a reverse geocoding service:
+//
+//	"geocode": {"dataset": "cities15000.txt", "countries": "countryInfo.txt", "max_distance_km": 30}
+//	"geocode": {"url": "https://nominatim.openstreetmap.org/reverse?format=jsonv2&lat={lat}&lon={lon}",
+//	            "country": "address.country", "city": "address.city,address.town,address.village"}
+//
+// A dataset is a GeoNames cities file (https://download.geonames.org/export/dump/) - each work gets the nearest of its places, if
+// one is within max_distance_km (default 50) - or a CSV file with latitude, longitude, city and country columns. GeoNames files name
+// countries by code; the GeoNames countryInfo.txt given as "countries" turns the codes into names. A service is sent a GET request
+// per position, {lat} and {lon} in the URL filled in, and the country and city read from the JSON response by dot path (see
+// GraphQL.go) - the first of several comma-separated paths that has a value. Its answers are kept in the build cache, so a position
+// is only looked up once, and requests go through the --rate and --per-host limits.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// name of the page listing the places
+const placesPageFile = "places.html"
+
+// default farthest distance, in kilometres, of a dataset place from the works it's given to
+const defaultPlaceDistance = 50
+
+// mean radius of the Earth, in kilometres
+const earthRadiusKm = 6371
+
+// type struct representing a GPS position, in decimal degrees
+type geoPoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// type struct representing the place a position is in
+type geoPlace struct {
+	Country string `json:"country"`
+	City    string `json:"city,omitempty"`
+}
+
+// type struct representing the config file's "geocode" section
+type geocodeConfig struct {
+	Dataset     string  `json:"dataset"`         // offline dataset: a GeoNames cities file or a CSV file
+	Countries   string  `json:"countries"`       // GeoNames countryInfo.txt, naming the country codes of a GeoNames dataset
+	MaxDistance float64 `json:"max_distance_km"` // dataset: farthest a place may be from a work (default 50)
+
+	URL     string `json:"url"`     // reverse geocoding service, with {lat} and {lon} in it
+	Country string `json:"country"` // service: dot paths of the country in the response, comma-separated
+	City    string `json:"city"`    // service: dot paths of the city in the response, comma-separated
+}
+
+// a reverseGeocoder finds the place a position is in - ok is false if it knows of none
+type reverseGeocoder interface {
+	place(p geoPoint) (place geoPlace, ok bool, err error)
+}
+
+// check the geocode section is complete
+func (c *geocodeConfig) check() error {
+	if c == nil {
+		return nil
+	}
+
+	if (c.Dataset == "") == (c.URL == "") {
+		return fmt.Errorf("Error in geocode config: give either a dataset or a service url")
+	}
+	if c.URL != "" && (!strings.Contains(c.URL, "{lat}") || !strings.Contains(c.URL, "{lon}")) {
+		return fmt.Errorf("Error in geocode config: the service url needs {lat} and {lon} in it")
+	}
+	if c.URL != "" && c.Country == "" {
+		return fmt.Errorf("Error in geocode config: the service needs the path of the country in its response")
+	}
+	if c.MaxDistance < 0 {
+		return fmt.Errorf("Error in geocode config: max_distance_km can't be negative")
+	}
+
+	return nil
+}
+
+// return the geocoder the section sets up
+func (c *geocodeConfig) geocoder(cache *buildCache) (reverseGeocoder, error) {
+	if c.URL != "" {
+		return &serviceGeocoder{config: c, cache: cache}, nil
+	}
+
+	return loadPlaceDataset(c.Dataset, c.Countries, c.MaxDistance)
+}
+
+// return the position as read from the feed's latitude and longitude text
+func parseGeoPoint(lat, lon string) (*geoPoint, error) {
+	p := &geoPoint{}
+	var err error
+
+	if p.Lat, err = strconv.ParseFloat(strings.TrimSpace(lat), 64); err != nil || p.Lat < -90 || p.Lat > 90 {
+		return nil, fmt.Errorf("latitude %q isn't a number of degrees between -90 and 90", lat)
+	}
+	if p.Lon, err = strconv.ParseFloat(strings.TrimSpace(lon), 64); err != nil || p.Lon < -180 || p.Lon > 180 {
+		return nil, fmt.Errorf("longitude %q isn't a number of degrees between -180 and 180", lon)
+	}
+
+	return p, nil
+}
+
+// return the position as latitude,longitude ("" for none)
+func (p *geoPoint) String() string {
+	if p == nil {
+		return ""
+	}
+
+	return strconv.FormatFloat(p.Lat, 'f', -1, 64) + "," + strconv.FormatFloat(p.Lon, 'f', -1, 64)
+}
+
+// return the distance between two positions in kilometres
+func (p geoPoint) distance(q geoPoint) float64 {
+	lat1, lat2 := p.Lat*math.Pi/180, q.Lat*math.Pi/180
+	dLat, dLon := lat2-lat1, (q.Lon-p.Lon)*math.Pi/180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// give every work with a GPS position the country and city it was taken in
+func geocodeWorks(catalog *Catalog, cfg *geocodeConfig, cache *buildCache) error {
+	geocoder, err := cfg.geocoder(cache)
+	if err != nil {
+		return err
+	}
+
+	placed, unplaced, failed := 0, 0, 0
+	for _, wk := range catalog.Works {
+		if wk == nil || wk.GPS == nil {
+			continue
+		}
+
+		place, ok, err := geocoder.place(*wk.GPS)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error geocoding the position of work %s: %v\n", wk.ID, err)
+			failed++
+			continue
+		}
+		if !ok {
+			unplaced++
+			continue
+		}
+
+		wk.Country, wk.City = place.Country, place.City
+		placed++
+	}
+
+	fmt.Printf("Places: %d works placed, %d in no known place, %d failed.\n", placed, unplaced, failed)
+	return nil
+}
+
+//----------------- offline dataset -------------------------------
+
+// type struct representing the places of an offline dataset, indexed by the whole degrees of their position
+type placeDataset struct {
+	places      []datasetPlace
+	cells       map[[2]int][]int // indexes into places, by whole degrees of latitude and longitude
+	maxDistance float64
+}
+
+// type struct representing a place of an offline dataset
+type datasetPlace struct {
+	geoPoint
+	geoPlace
+}
+
+// read an offline dataset - a GeoNames cities file, or a CSV file with a header row
+func loadPlaceDataset(path, countriesPath string, maxDistance float64) (*placeDataset, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading places dataset: %v", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	first, _ := r.Peek(4096)
+	line, _, _ := strings.Cut(string(first), "\n")
+
+	var places []datasetPlace
+	if strings.Count(line, "\t") >= 8 {
+		countries := map[string]string{}
+		if countriesPath != "" {
+			if countries, err = loadCountryNames(countriesPath); err != nil {
+				return nil, err
+			}
+		}
+		places, err = readGeoNamesPlaces(r, countries)
+	} else {
+		places, err = readCSVPlaces(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading places dataset (%s): %v", path, err)
+	}
+
+	if maxDistance == 0 {
+		maxDistance = defaultPlaceDistance
+	}
+	d := &placeDataset{places: places, cells: map[[2]int][]int{}, maxDistance: maxDistance}
+	for i, p := range places {
+		cell := geoCell(p.geoPoint)
+		d.cells[cell] = append(d.cells[cell], i)
+	}
+
+	return d, nil
+}
+
+// read the places of a GeoNames file: tab-separated, with the name second, the latitude and longitude fifth and sixth and the country
+// code ninth
+func readGeoNamesPlaces(r io.Reader, countries map[string]string) ([]datasetPlace, error) {
+	var places []datasetPlace
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 9 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		p, err := parseGeoPoint(fields[4], fields[5])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+
+		country := fields[8]
+		if name := countries[country]; name != "" {
+			country = name
+		}
+		places = append(places, datasetPlace{*p, geoPlace{Country: country, City: fields[1]}})
+	}
+
+	return places, scanner.Err()
+}
+
+// read the country names of a GeoNames countryInfo.txt, by ISO code
+func loadCountryNames(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading country names: %v", err)
+	}
+
+	names := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) > 4 && !strings.HasPrefix(line, "#") {
+			names[fields[0]] = fields[4]
+		}
+	}
+
+	return names, nil
+}
+
+// read the places of a CSV file whose header row names latitude, longitude, city and country columns (in any order)
+func readCSVPlaces(r io.Reader) ([]datasetPlace, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"latitude", "longitude", "city", "country"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("no %s column (the header row needs latitude, longitude, city and country)", name)
+		}
+	}
+
+	var places []datasetPlace
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		field := func(name string) string {
+			if i := columns[name]; i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		p, err := parseGeoPoint(field("latitude"), field("longitude"))
+		if err != nil {
+			line, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		places = append(places, datasetPlace{*p, geoPlace{Country: field("country"), City: field("city")}})
+	}
+
+	return places, nil
+}
+
+// return the whole degrees cell of the dataset index a position is in
+func geoCell(p geoPoint) [2]int {
+	return [2]int{int(math.Floor(p.Lat)), int(math.Floor(p.Lon))}
+}
+
+// return the nearest place within the dataset's distance limit
+func (d *placeDataset) place(p geoPoint) (geoPlace, bool, error) {
+	// a degree of latitude is about 111 km, a degree of longitude that times the cosine of the latitude
+	dLat := int(math.Ceil(d.maxDistance / 111))
+	dLon := int(math.Ceil(d.maxDistance / (111 * math.Max(math.Cos(p.Lat*math.Pi/180), 0.01))))
+	if dLon > 180 {
+		dLon = 180
+	}
+
+	best, bestDistance := -1, d.maxDistance
+	center := geoCell(p)
+	for lat := center[0] - dLat; lat <= center[0]+dLat; lat++ {
+		for lon := center[1] - dLon; lon <= center[1]+dLon; lon++ {
+			// cells past the antimeridian wrap around
+			wrapped := (lon+180)%360 - 180
+			if wrapped < -180 {
+				wrapped += 360
+			}
+
+			for _, i := range d.cells[[2]int{lat, wrapped}] {
+				if dist := p.distance(d.places[i].geoPoint); dist <= bestDistance {
+					best, bestDistance = i, dist
+				}
+			}
+		}
+	}
+
+	if best < 0 {
+		return geoPlace{}, false, nil
+	}
+
+	return d.places[best].geoPlace, true, nil
+}
+
+//----------------- service -------------------------------
+
+// type struct representing a reverse geocoding service, with its answers kept in the build cache
+type serviceGeocoder struct {
+	config *geocodeConfig
+	cache  *buildCache
+}
+
+// return the place the service says the position is in
+func (g *serviceGeocoder) place(p geoPoint) (geoPlace, bool, error) {
+	// positions are looked up to about 100 m, so works taken close together share a request
+	lat, lon := strconv.FormatFloat(p.Lat, 'f', 3, 64), strconv.FormatFloat(p.Lon, 'f', 3, 64)
+	uri := strings.NewReplacer("{lat}", lat, "{lon}", lon).Replace(g.config.URL)
+
+	if place, ok := g.cache.Places[uri]; ok {
+		return place, place.Country != "", nil
+	}
+	if offline {
+		return geoPlace{}, false, errNotCached
+	}
+
+	resp, err := httpClient.Get(uri)
+	if err != nil {
+		return geoPlace{}, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return geoPlace{}, false, fmt.Errorf("GET %s: HTTP %s", uri, resp.Status)
+	}
+
+	var answer interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&answer); err != nil {
+		return geoPlace{}, false, fmt.Errorf("GET %s: %v", uri, err)
+	}
+
+	// an answer without a country (a position at sea, say) is kept too, so it isn't asked again
+	place := geoPlace{Country: firstJSONText(answer, g.config.Country), City: firstJSONText(answer, g.config.City)}
+	g.cache.setPlace(uri, place)
+
+	return place, place.Country != "", nil
+}
+
+// return the text at the first of the comma-separated dot paths that has a value ("" if none has)
+func firstJSONText(v interface{}, paths string) string {
+	for _, p := range strings.Split(paths, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if s, ok := jsonPath(v, p).(string); ok && strings.TrimSpace(s) != "" {
+			return strings.TrimSpace(s)
+		}
+	}
+
+	return ""
+}
+
+//----------------- pages -------------------------------
+
+// type struct representing a country or city as listed and linked to
+type placeLink struct {
+	Name   string
+	Href   string
+	Works  int
+	Cities []placeLink // countries on the places page: their cities
+}
+
+// type struct representing the place a place page is about
+type placeView struct {
+	Name    string
+	Country *placeLink  // city pages: the country the city is in
+	Cities  []placeLink // country pages: the cities with works
+}
+
+// return the page (without extension) of a country, or of a city in it
+func placePageURL(country, city string) string {
+	if city == "" {
+		return "place-" + pageSlug(country)
+	}
+
+	return "place-" + pageSlug(country) + "-" + pageSlug(city)
+}
+
+// return the links to a work's city and country pages, city first (nil if it has no place)
+func workLocation(wk *Work) []placeLink {
+	if wk.Country == "" {
+		return nil
+	}
+
+	var links []placeLink
+	if wk.City != "" {
+		links = append(links, placeLink{Name: wk.City, Href: placePageURL(wk.Country, wk.City) + ".html"})
+	}
+
+	return append(links, placeLink{Name: wk.Country, Href: placePageURL(wk.Country, "") + ".html"})
+}
+
+// write the places page and a gallery page per country and city - pages that can't be written are recorded with the site writer
+// returns whether there are place pages for the homepage to link to
+func generatePlacePages(site *siteWriter, works []*Work, assets *siteAssets, layout pageLayout) bool {
+	byCountry := map[string][]*Work{}
+	byCity := map[string]map[string][]*Work{}
+	for _, wk := range works {
+		if wk == nil || wk.Country == "" {
+			continue
+		}
+		byCountry[wk.Country] = append(byCountry[wk.Country], wk)
+		if wk.City != "" {
+			if byCity[wk.Country] == nil {
+				byCity[wk.Country] = map[string][]*Work{}
+			}
+			byCity[wk.Country][wk.City] = append(byCity[wk.Country][wk.City], wk)
+		}
+	}
+
+	if len(byCountry) == 0 {
+		return false
+	}
+
+	var countries []placeLink
+	for country, countryWorks := range byCountry {
+		link := placeLink{Name: country, Href: placePageURL(country, "") + ".html", Works: len(countryWorks)}
+		for city, cityWorks := range byCity[country] {
+			link.Cities = append(link.Cities, placeLink{Name: city, Href: placePageURL(country, city) + ".html", Works: len(cityWorks)})
+		}
+		sort.Slice(link.Cities, func(i, j int) bool { return link.Cities[i].Name < link.Cities[j].Name })
+		countries = append(countries, link)
+	}
+	sort.Slice(countries, func(i, j int) bool { return countries[i].Name < countries[j].Name })
+
+	if err := site.writeView(placesPageFile, "places", &pageView{Title: "Places", Assets: assets, Places: countries, Canonical: site.canonical(placesPageFile)}); err != nil {
+		site.fail(fmt.Errorf("Error writing output to places HTML file (%s): %v", placesPageFile, err))
+	}
+
+	for _, country := range countries {
+		file := placePageURL(country.Name, "") + ".html"
+		view := &pageView{Title: "Photos taken in " + country.Name, Assets: assets, Place: &placeView{Name: country.Name, Cities: country.Cities}}
+		if err := site.writeGallery(file, "place", byCountry[country.Name], layout, view); err != nil {
+			site.fail(fmt.Errorf("Error writing output to place HTML file (%s): %v", file, err))
+		}
+
+		for _, city := range country.Cities {
+			file := placePageURL(country.Name, city.Name) + ".html"
+			in := placeLink{Name: country.Name, Href: country.Href}
+			view := &pageView{Title: "Photos taken in " + city.Name + ", " + country.Name, Assets: assets, Place: &placeView{Name: city.Name, Country: &in}}
+			if err := site.writeGallery(file, "place", byCity[country.Name][city.Name], layout, view); err != nil {
+				site.fail(fmt.Errorf("Error writing output to place HTML file (%s): %v", file, err))
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,161 @@
+// popularity: --popularity reads a CSV of view counts per work, exported from the web server's access logs (or any analytics), so
+// the site can show what visitors look at without a tracking script:
+//
+//	id,views,recent_views
+//	101,5120,48
+//	102,812,130
+//
+// The recent_views column (e.g. views in the last week) is optional, and a first row that isn't numbers is taken as the heading. With
+// the counts, --index-selection popular shows the most viewed works first on the homepage, the homepage leads with a trending section
+// of the --trending works with the most recent views (or views, without that column), and the most viewed tenth of the works carry a
+// "popular" badge in the galleries:
+//
+//	>go run ImageProcessor --popularity views.csv --index-selection popular http://localhost/test/api/v1/works.xml code/html/output
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// default number of works in the homepage's trending section (--trending)
+const defaultTrendingWorks = 6
+
+// type struct representing the view counts of the popularity dataset
+type popularityData struct {
+	Views      map[WorkID]int // views of each work, by id
+	Recent     map[WorkID]int // recent views of each work, by id (empty without a recent_views column)
+	HaveRecent bool           // whether the dataset has a recent_views column
+}
+
+// read the popularity dataset at path ("" for none)
+func loadPopularity(path string) (*popularityData, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading popularity file (%s): %v", path, err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	data := &popularityData{Views: map[WorkID]int{}, Recent: map[WorkID]int{}}
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing popularity file (%s): %v", path, err)
+		}
+
+		if len(record) < 2 || len(record) > 3 {
+			return nil, fmt.Errorf("Error in popularity file (%s) line %d: expected id,views[,recent_views]", path, line)
+		}
+
+		counts := make([]int, len(record))
+		numeric := true
+		for i, field := range record[1:] {
+			n, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || n < 0 {
+				numeric = false
+				break
+			}
+			counts[i+1] = n
+		}
+		if !numeric {
+			if line == 1 {
+				data.HaveRecent = len(record) == 3
+				continue // heading
+			}
+			return nil, fmt.Errorf("Error in popularity file (%s) line %d: counts must be whole numbers, not negative", path, line)
+		}
+
+		id, err := parseWorkID(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("Error in popularity file (%s) line %d: %v", path, line, err)
+		}
+
+		data.Views[id] += counts[1]
+		if len(counts) == 3 {
+			data.Recent[id] += counts[2]
+			data.HaveRecent = true
+		}
+	}
+
+	return data, nil
+}
+
+// give the works their view counts, and flag the most viewed tenth of them (at least one work) as popular
+func applyPopularity(catalog *Catalog, data *popularityData) {
+	if data == nil {
+		return
+	}
+
+	var viewed []*Work
+	for _, wk := range catalog.Works {
+		if wk == nil {
+			continue
+		}
+
+		wk.Views, wk.RecentViews = data.Views[wk.ID], data.Recent[wk.ID]
+		if wk.Views > 0 {
+			viewed = append(viewed, wk)
+		}
+	}
+
+	sort.SliceStable(viewed, func(i, j int) bool { return viewed[i].Views > viewed[j].Views })
+
+	popular := (len(viewed) + 9) / 10
+	for i, wk := range viewed {
+		// works tied with the last popular one are popular too
+		wk.Popular = i < popular || wk.Views == viewed[popular-1].Views
+	}
+}
+
+// return the works sorted by views, most viewed first (works with the same number keep their order)
+func mostViewed(works []*Work) []*Work {
+	sorted := append([]*Work(nil), works...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Views > sorted[j].Views })
+	return sorted
+}
+
+// return at most n of the works with the most recent views (or views, when the dataset has no recent_views column) - works no one
+// has viewed are left out
+func trendingWorks(works []*Work, data *popularityData, n int) []*Work {
+	if data == nil || n <= 0 {
+		return nil
+	}
+
+	count := func(wk *Work) int {
+		if data.HaveRecent {
+			return wk.RecentViews
+		}
+		return wk.Views
+	}
+
+	var trending []*Work
+	for _, wk := range works {
+		if wk != nil && count(wk) > 0 {
+			trending = append(trending, wk)
+		}
+	}
+
+	sort.SliceStable(trending, func(i, j int) bool { return count(trending[i]) > count(trending[j]) })
+	if len(trending) > n {
+		trending = trending[:n]
+	}
+
+	return trending
+}
@@ -0,0 +1,132 @@
+// build phase timing: every run is split into five phases, switched as the build moves from one to the next, so the time of a
+// large build can be put down to the right part of it:
+//
+//	fetch    reading the works feed, link checks and image downloads
+//	parse    decoding the feed into works, makes and models
+//	index    preparing the catalog - exclusions, overrides, hooks, EXIF, thumbnails, dimensions, colors and fingerprints
+//	render   executing the page templates
+//	write    writing pages, assets, feeds and the manifest, validating the pages and running the after-write hooks
+//
+// With --profile-phases the times are printed as a table at the end of the run and written to report.json in the output directory.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+// name of the build report, written to the output directory with --profile-phases
+const buildReportFile = "report.json"
+
+// the build phases, in the order they're reported
+var buildPhaseNames = []string{"fetch", "parse", "index", "render", "write"}
+
+// type struct representing the time spent in each phase of a run
+type phaseTimer struct {
+	totals  map[string]time.Duration
+	current string    // phase running now ("" between runs)
+	since   time.Time // when the current phase was entered
+}
+
+// timer of the current run (reset at the start of each build, and of each poll in watch mode)
+var phases = &phaseTimer{totals: map[string]time.Duration{}}
+
+// type struct representing the JSON build report
+type buildReport struct {
+	Phases       []phaseReport `json:"phases"`
+	TotalSeconds float64       `json:"total_seconds"`
+}
+
+// type struct representing one phase in the build report
+type phaseReport struct {
+	Phase   string  `json:"phase"`
+	Seconds float64 `json:"seconds"`
+}
+
+// clear the times of the previous run and start timing the given phase
+func (p *phaseTimer) reset(phase string) {
+	p.totals = map[string]time.Duration{}
+	p.current = ""
+	p.enter(phase)
+}
+
+// end the current phase and start timing the given one - entering the phase already running carries on timing it
+func (p *phaseTimer) enter(phase string) {
+	now := time.Now()
+	if p.current != "" {
+		p.totals[p.current] += now.Sub(p.since)
+	}
+
+	p.current, p.since = phase, now
+}
+
+// end the current phase, so the totals cover the whole run
+func (p *phaseTimer) stop() {
+	p.enter("")
+}
+
+// return the report of the times recorded
+func (p *phaseTimer) report() buildReport {
+	var r buildReport
+	var total time.Duration
+
+	for _, name := range buildPhaseNames {
+		r.Phases = append(r.Phases, phaseReport{Phase: name, Seconds: p.totals[name].Seconds()})
+		total += p.totals[name]
+	}
+	r.TotalSeconds = total.Seconds()
+
+	return r
+}
+
+// print the times recorded as a table of phases with their share of the run
+func (p *phaseTimer) print(out io.Writer) {
+	r := p.report()
+
+	fmt.Fprintln(out, "Build phases:")
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, ph := range r.Phases {
+		share := 0.0
+		if r.TotalSeconds > 0 {
+			share = ph.Seconds / r.TotalSeconds * 100
+		}
+		fmt.Fprintf(tw, "  %s\t%.3fs\t%.1f%%\t\n", ph.Phase, ph.Seconds, share)
+	}
+	fmt.Fprintf(tw, "  total\t%.3fs\t\t\n", r.TotalSeconds)
+	tw.Flush()
+}
+
+// write the report of the times recorded to report.json in the output directory
+func (p *phaseTimer) writeReport(outputFolderLocation string) error {
+	data, err := json.MarshalIndent(p.report(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error encoding build report: %v", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join("./"+outputFolderLocation, buildReportFile), append(data, '\n')); err != nil {
+		return fmt.Errorf("Error writing build report: %v", err)
+	}
+
+	return nil
+}
+
+// finish timing the run and, with --profile-phases, print the table and write the report to each output directory
+func (p *phaseTimer) finish(profile bool, outputs []string) {
+	p.stop()
+	if !profile {
+		return
+	}
+
+	p.print(os.Stdout)
+	for _, out := range outputs {
+		if err := p.writeReport(out); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
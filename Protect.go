@@ -0,0 +1,202 @@
+// password-protected pages: --protect-pages encrypts the pages whose file names match its patterns ("*" for the whole site) with a
+// passphrase, so private galleries can go on public static hosting. Each protected page is written as a small page asking for the
+// passphrase; the browser derives the key from it (PBKDF2-SHA256) and decrypts the real page (AES-256-GCM) in place. A visitor who
+// has entered the passphrase once isn't asked again on the site's other protected pages until the browser tab is closed.
+//
+//	>IMGPROC_PASSPHRASE='correct horse' go run ImageProcessor --protect-pages 'index.html,Family*.html,work-*.html' works.xml out
+//
+// Only the pages' HTML is encrypted: images, Atom feeds and the stylesheet and script stay public, and the site has to be served
+// over HTTPS (or from localhost) for browsers to decrypt. The key's salt is kept in the output directory, so rebuilt pages share it.
+
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// name of the file holding the key's salt, kept in the output directory
+const protectSaltFile = ".protect-salt"
+
+// PBKDF2 iterations deriving the key from the passphrase
+const protectIterations = 600000
+
+// default environment variable holding the passphrase (--passphrase-env)
+const defaultPassphraseEnv = "IMGPROC_PASSPHRASE"
+
+// type struct representing the encryption of the protected pages of a site
+type pageProtection struct {
+	patterns []string // file name patterns of the pages to protect
+	salt     []byte
+	key      []byte
+}
+
+// create and return a pointer to the protection of the pages matching the comma-separated patterns, with the passphrase in the given
+// environment variable - the salt is read from the output directory, or made and saved there by the first protected build
+func newPageProtection(patterns, passphraseEnv, outputFolderLocation string) (*pageProtection, error) {
+	passphrase := os.Getenv(passphraseEnv)
+	if passphrase == "" {
+		return nil, fmt.Errorf("Error: --protect-pages needs a passphrase in the %s environment variable", passphraseEnv)
+	}
+
+	p := &pageProtection{}
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Error: bad --protect-pages pattern %q: %v", pattern, err)
+		}
+		p.patterns = append(p.patterns, pattern)
+	}
+
+	saltPath := filepath.Join("./"+outputFolderLocation, protectSaltFile)
+	if data, err := os.ReadFile(saltPath); err == nil {
+		p.salt, _ = hex.DecodeString(strings.TrimSpace(string(data)))
+	}
+	if len(p.salt) != 16 {
+		p.salt = make([]byte, 16)
+		if _, err := rand.Read(p.salt); err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(saltPath, []byte(hex.EncodeToString(p.salt)+"\n")); err != nil {
+			return nil, fmt.Errorf("Error saving page protection salt: %v", err)
+		}
+	}
+
+	key, err := pbkdf2.Key(sha256.New, passphrase, p.salt, protectIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	p.key = key
+
+	return p, nil
+}
+
+// return whether the page with the given path is protected - a mobile page is protected with the full page it's a variant of
+func (p *pageProtection) matches(pagePath string) bool {
+	pagePath = strings.TrimPrefix(pagePath, mobileDir+"/")
+	for _, pattern := range p.patterns {
+		// "*" is the whole site, pages in subdirectories (the year archive ...) included
+		if ok, _ := path.Match(pattern, pagePath); ok || pattern == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// return a fingerprint of the key and patterns, which changes with the passphrase or the pages protected (for incremental builds to
+// write every page again)
+func (p *pageProtection) fingerprint() string {
+	h := sha256.New()
+	h.Write(p.salt)
+	h.Write(p.key)
+	fmt.Fprintf(h, "%q", p.patterns)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// return the page asking for the passphrase that decrypts the given page
+func (p *pageProtection) encrypt(pageHTML string) (string, error) {
+	block, err := aes.NewCipher(p.key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"salt":       hex.EncodeToString(p.salt),
+		"iterations": protectIterations,
+		"iv":         base64.StdEncoding.EncodeToString(nonce),
+		"data":       base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, []byte(pageHTML), nil)),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(protectedPageHTML, data), nil
+}
+
+// page standing in for a protected page - %s is the JSON with the encrypted page
+const protectedPageHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Protected page</title>
+<style>
+body { font-family: sans-serif; display: flex; justify-content: center; margin-top: 20vh; }
+form { display: flex; flex-direction: column; gap: 0.5em; min-width: 16em; }
+#protected-error { color: #b00020; }
+</style>
+</head>
+<body>
+<form id="protected-form">
+<label for="protected-passphrase">This page is private. Enter the passphrase to view it:</label>
+<input id="protected-passphrase" type="password" autocomplete="current-password" autofocus required>
+<button type="submit">View</button>
+<p id="protected-error" hidden>Wrong passphrase.</p>
+<noscript><p>Viewing this page needs JavaScript.</p></noscript>
+</form>
+<script type="application/json" id="protected-data">%s</script>
+<script>
+(function () {
+  var page = JSON.parse(document.getElementById("protected-data").textContent);
+  var remembered = "imgproc-key-" + page.salt;
+
+  function fromHex(s) { var a = new Uint8Array(s.length / 2); for (var i = 0; i < a.length; i++) a[i] = parseInt(s.substr(2 * i, 2), 16); return a; }
+  function toHex(a) { return Array.prototype.map.call(a, function (b) { return ("0" + b.toString(16)).slice(-2); }).join(""); }
+  function fromBase64(s) { return Uint8Array.from(atob(s), function (c) { return c.charCodeAt(0); }); }
+
+  function decrypt(raw) {
+    return crypto.subtle.importKey("raw", raw, "AES-GCM", false, ["decrypt"]).then(function (key) {
+      return crypto.subtle.decrypt({ name: "AES-GCM", iv: fromBase64(page.iv) }, key, fromBase64(page.data));
+    }).then(function (plain) {
+      try { sessionStorage.setItem(remembered, toHex(raw)); } catch (e) {}
+      document.open();
+      document.write(new TextDecoder().decode(plain));
+      document.close();
+    });
+  }
+
+  var saved = null;
+  try { saved = sessionStorage.getItem(remembered); } catch (e) {}
+  if (saved) decrypt(fromHex(saved)).catch(function () { sessionStorage.removeItem(remembered); });
+
+  document.getElementById("protected-form").addEventListener("submit", function (e) {
+    e.preventDefault();
+    var passphrase = new TextEncoder().encode(document.getElementById("protected-passphrase").value);
+    crypto.subtle.importKey("raw", passphrase, "PBKDF2", false, ["deriveBits"]).then(function (key) {
+      return crypto.subtle.deriveBits({ name: "PBKDF2", hash: "SHA-256", salt: fromHex(page.salt), iterations: page.iterations }, key, 256);
+    }).then(function (bits) {
+      return decrypt(new Uint8Array(bits));
+    }).catch(function () {
+      document.getElementById("protected-error").hidden = false;
+    });
+  });
+})();
+</script>
+</body>
+</html>
+`
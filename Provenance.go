@@ -0,0 +1,131 @@
+// page provenance: the build manifest records the works each generated page shows - its gallery, slideshow, trending strip and
+// description shortcodes, or the work itself - so a change to some works can be traced to the pages it touches. The diff subcommand
+// lists the pages the works it reports appear on, and the pages subcommand answers which pages a work appears on:
+//
+//	>go run ImageProcessor pages out 123 207
+//	>go run ImageProcessor pages out            (every page and the works it shows)
+
+package main
+
+import (
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+func init() {
+	registerCommand(&command{
+		Name:    "pages",
+		Usage:   "<build> [work id ...]",
+		Summary: "list the generated pages each work appears on, from a build's manifest (an output directory or manifest file)",
+		Setup:   setupPages,
+	})
+}
+
+// type representing the works each generated page shows, by path
+type pageProvenance map[string][]WorkID
+
+// return the ids of the works a page view shows, in id order
+func viewWorkIDs(view *pageView) []WorkID {
+	var keys []string
+	writeFingerprint(sha256.New(), reflect.ValueOf(view), &keys) // see Incremental.go
+
+	var ids []WorkID
+	for _, key := range sortedKeys(keys) {
+		if !strings.HasPrefix(key, "work ") {
+			continue
+		}
+		if id, err := parseWorkID(strings.TrimPrefix(key, "work ")); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Less(ids[j]) })
+
+	return ids
+}
+
+// record the works a page shows, for the build manifest
+func (s *siteWriter) recordProvenance(path string, view *pageView) {
+	if s.provenance == nil {
+		s.provenance = pageProvenance{}
+	}
+
+	s.provenance[path] = viewWorkIDs(view)
+}
+
+// return the pages showing any of the given works, sorted
+func (p pageProvenance) showing(ids map[WorkID]bool) []string {
+	var found []string
+	for page, works := range p {
+		for _, id := range works {
+			if ids[id] {
+				found = append(found, page)
+				break
+			}
+		}
+	}
+	sort.Strings(found)
+
+	return found
+}
+
+// define the pages subcommand's flags and return its runner: print the pages of a build each of the given works appears on - or,
+// with no works given, every page and its works - and return 0, 1 if a work appears on no page, and 2 on errors
+func setupPages(fs *flag.FlagSet) func(positional []string) int {
+	return func(positional []string) int {
+		if len(positional) < 1 {
+			fmt.Fprintln(os.Stderr, "Error: please enter the build (output directory or build manifest) to look the works up in (e.g. >go run ImageProcessor pages out 123)")
+			return 2
+		}
+
+		m, err := readBuildManifest(positional[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		if len(m.Pages) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: the build manifest of %s has no page provenance - rebuild the site to record it\n", positional[0])
+			return 2
+		}
+
+		if len(positional) == 1 {
+			var pages []string
+			for page := range m.Pages {
+				pages = append(pages, page)
+			}
+			sort.Strings(pages)
+
+			for _, page := range pages {
+				var ids []string
+				for _, id := range m.Pages[page] {
+					ids = append(ids, id.String())
+				}
+				fmt.Printf("%s: %s\n", page, strings.Join(ids, ", "))
+			}
+			return 0
+		}
+
+		status := 0
+		for _, arg := range positional[1:] {
+			id, err := parseWorkID(arg)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+
+			pages := m.Pages.showing(map[WorkID]bool{id: true})
+			if len(pages) == 0 {
+				fmt.Printf("work %s: on no page\n", id)
+				status = 1
+				continue
+			}
+			fmt.Printf("work %s: %s\n", id, strings.Join(pages, ", "))
+		}
+
+		return status
+	}
+}
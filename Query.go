@@ -0,0 +1,538 @@
+// catalog queries: the query subcommand reads a feed (or a catalog snapshot - see Snapshot.go) and prints the works matching a filter
+// expression, as a table or as JSON in the export's shape (see Export.go):
+//
+//	>go run ImageProcessor query "make=Canon AND model~5D" http://localhost/test/api/v1/works.xml
+//	>go run ImageProcessor query --from-snapshot works.snap --format json "(tag=night OR iso>=3200) AND NOT flagged=true"
+//
+// An expression compares fields of a work with values, joined by AND, OR and NOT (AND binds tighter than OR) and grouped with
+// parentheses. The comparisons are = and != (case-insensitive), ~ and !~ (contains, case-insensitive) and <, <=, > and >=; a value
+// with spaces or parentheses in it is quoted ("5D Mark II"). The fields are id, filename, make, model, lens, author, date, year,
+// tag (matching any of a work's tags), featured, flagged, rating, copyright, license, country, city, caption and the numeric
+// focal_length, aperture and iso - a work without a numeric field never has it = or < or > anything. date=2021-06 matches the
+// dates starting with the value, and date<2021 compares as many characters of the date as the value has.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+)
+
+func init() {
+	registerCommand(&command{
+		Name:    "query",
+		Usage:   "[--format table|json] <expression> <image API URL> | --from-snapshot <file> <expression>",
+		Summary: "print the works of a feed or catalog snapshot matching a filter expression (e.g. \"make=Canon AND model~5D\")",
+		Setup:   setupQuery,
+	})
+}
+
+// type representing a parsed filter expression
+type filterExpr interface {
+	matches(ew *exportWork) bool
+}
+
+// type struct representing the AND or OR of two filter expressions
+type filterBinary struct {
+	or          bool
+	left, right filterExpr
+}
+
+func (f *filterBinary) matches(ew *exportWork) bool {
+	if f.or {
+		return f.left.matches(ew) || f.right.matches(ew)
+	}
+	return f.left.matches(ew) && f.right.matches(ew)
+}
+
+// type struct representing the NOT of a filter expression
+type filterNot struct {
+	expr filterExpr
+}
+
+func (f *filterNot) matches(ew *exportWork) bool {
+	return !f.expr.matches(ew)
+}
+
+// type struct representing the comparison of a field of a work with a value - the negated comparisons (!= and !~) are the NOT of
+// the others
+type filterCompare struct {
+	field string
+	op    string // =, ~, <, <=, > or >=
+	value string
+	num   float64 // the value, for the numeric fields
+}
+
+// kinds of the fields a filter expression can compare, by name
+var filterFields = map[string]string{
+	"id": "id", "filename": "text", "make": "text", "model": "text", "lens": "text", "author": "text", "rating": "text",
+	"copyright": "text", "license": "text", "country": "text", "city": "text", "caption": "text", "tag": "text",
+	"date": "date", "year": "number", "focal_length": "number", "aperture": "number", "iso": "number",
+	"featured": "bool", "flagged": "bool",
+}
+
+// return the text values of a field of a work (none if it hasn't got the field)
+func filterTextValues(ew *exportWork, field string) []string {
+	var v string
+	switch field {
+	case "tag":
+		return ew.Tags
+	case "filename":
+		v = ew.FileName
+	case "make":
+		v = ew.Make
+	case "model":
+		v = ew.Model
+	case "lens":
+		v = ew.Lens
+	case "author":
+		v = ew.Author
+	case "rating":
+		v = ew.Rating
+	case "copyright":
+		v = ew.Copyright
+	case "license":
+		v = ew.License
+	case "country":
+		v = ew.Country
+	case "city":
+		v = ew.City
+	case "caption":
+		v = ew.Caption
+	}
+
+	if v == "" {
+		return nil
+	}
+	return []string{v}
+}
+
+// return the value of a numeric field of a work, and whether it has it
+func filterNumber(ew *exportWork, field string) (float64, bool) {
+	switch field {
+	case "year":
+		if len(ew.Date) >= 4 {
+			if y, err := strconv.Atoi(ew.Date[:4]); err == nil {
+				return float64(y), true
+			}
+		}
+	case "focal_length":
+		return ew.FocalLength, ew.FocalLength > 0
+	case "aperture":
+		return ew.Aperture, ew.Aperture > 0
+	case "iso":
+		return float64(ew.ISO), ew.ISO > 0
+	}
+
+	return 0, false
+}
+
+// return whether the result of comparing two values (negative, 0 or positive) satisfies an ordering comparison
+func compareResult(op string, c int) bool {
+	switch op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+
+	return c == 0
+}
+
+func (f *filterCompare) matches(ew *exportWork) bool {
+	switch filterFields[f.field] {
+	case "bool":
+		v := ew.Featured
+		if f.field == "flagged" {
+			v = ew.Flagged
+		}
+		return v == (f.num != 0)
+
+	case "number":
+		n, ok := filterNumber(ew, f.field)
+		if !ok {
+			return false
+		}
+		c := 0
+		if n < f.num {
+			c = -1
+		} else if n > f.num {
+			c = 1
+		}
+		return compareResult(f.op, c)
+
+	case "id":
+		id := ew.ID.String()
+		switch f.op {
+		case "=":
+			return strings.EqualFold(id, f.value)
+		case "~":
+			return strings.Contains(strings.ToLower(id), strings.ToLower(f.value))
+		}
+		c := 0
+		if ew.ID.Less(WorkID(f.value)) {
+			c = -1
+		} else if WorkID(f.value).Less(ew.ID) {
+			c = 1
+		}
+		return compareResult(f.op, c)
+
+	case "date":
+		if ew.Date == "" {
+			return false
+		}
+		switch f.op {
+		case "=":
+			return strings.HasPrefix(ew.Date, f.value)
+		case "~":
+			return strings.Contains(ew.Date, f.value)
+		}
+		date := ew.Date
+		if len(date) > len(f.value) {
+			date = date[:len(f.value)]
+		}
+		return compareResult(f.op, strings.Compare(date, f.value))
+	}
+
+	for _, v := range filterTextValues(ew, f.field) {
+		switch f.op {
+		case "=":
+			if strings.EqualFold(v, f.value) {
+				return true
+			}
+		case "~":
+			if strings.Contains(strings.ToLower(v), strings.ToLower(f.value)) {
+				return true
+			}
+		default:
+			if compareResult(f.op, strings.Compare(strings.ToLower(v), strings.ToLower(f.value))) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// comparison operators of a filter expression, longest first so <= isn't read as <
+var filterOperators = []string{"!=", "!~", "<=", ">=", "=", "~", "<", ">"}
+
+// type struct representing a token of a filter expression
+type filterToken struct {
+	text   string
+	quoted bool // a quoted value, never a keyword or operator
+}
+
+// split a filter expression into words, quoted values, parentheses and operators
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+
+	for i := 0; i < len(expr); {
+		r := rune(expr[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(' || r == ')':
+			tokens = append(tokens, filterToken{text: string(r)})
+			i++
+
+		case r == '"':
+			end := strings.IndexByte(expr[i+1:], expr[i])
+			if end < 0 {
+				return nil, fmt.Errorf("Error in filter expression: unterminated quote at %q", expr[i:])
+			}
+			tokens = append(tokens, filterToken{text: expr[i+1 : i+1+end], quoted: true})
+			i += end + 2
+
+		default:
+			op := ""
+			for _, o := range filterOperators {
+				if strings.HasPrefix(expr[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op != "" {
+				tokens = append(tokens, filterToken{text: op})
+				i += len(op)
+				continue
+			}
+
+			start := i
+			for i < len(expr) && !unicode.IsSpace(rune(expr[i])) && !strings.ContainsRune("()\"=!~<>", rune(expr[i])) {
+				i++
+			}
+			if i == start {
+				i++ // a lone ! - left for the parser to report
+			}
+			tokens = append(tokens, filterToken{text: expr[start:i]})
+		}
+	}
+
+	return tokens, nil
+}
+
+// type struct representing the state of parsing a filter expression
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// return whether the next token is the given keyword or punctuation (keywords in any case), consuming it if it is
+func (p *filterParser) accept(text string) bool {
+	if p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && strings.EqualFold(p.tokens[p.pos].text, text) {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+// return the next token, or an error if the expression ends where one of the described thing was expected
+func (p *filterParser) next(expected string) (filterToken, error) {
+	if p.pos >= len(p.tokens) {
+		return filterToken{}, fmt.Errorf("Error in filter expression: expected %s at the end", expected)
+	}
+	p.pos++
+
+	return p.tokens[p.pos-1], nil
+}
+
+// parse a filter expression, returning an error naming what's wrong with it
+func parseFilterExpr(expr string) (filterExpr, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("Error in filter expression: it's empty")
+	}
+
+	p := &filterParser{tokens: tokens}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("Error in filter expression: unexpected %q (join comparisons with AND or OR)", p.tokens[p.pos].text)
+	}
+
+	return f, nil
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("OR") {
+		var right filterExpr
+		if right, err = p.parseAnd(); err == nil {
+			left = &filterBinary{or: true, left: left, right: right}
+		}
+	}
+
+	return left, err
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseNot()
+	for err == nil && p.accept("AND") {
+		var right filterExpr
+		if right, err = p.parseNot(); err == nil {
+			left = &filterBinary{left: left, right: right}
+		}
+	}
+
+	return left, err
+}
+
+func (p *filterParser) parseNot() (filterExpr, error) {
+	if p.accept("NOT") {
+		f, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &filterNot{f}, nil
+	}
+
+	if p.accept("(") {
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("Error in filter expression: missing )")
+		}
+		return f, nil
+	}
+
+	return p.parseCompare()
+}
+
+// parse a comparison of a field with a value
+func (p *filterParser) parseCompare() (filterExpr, error) {
+	tok, err := p.next("a field")
+	if err != nil {
+		return nil, err
+	}
+	field := strings.ToLower(tok.text)
+	kind, ok := filterFields[field]
+	if !ok || tok.quoted {
+		return nil, fmt.Errorf("Error in filter expression: unknown field %q", tok.text)
+	}
+
+	tok, err = p.next("a comparison after " + field)
+	if err != nil {
+		return nil, err
+	}
+	op := tok.text
+	known := false
+	for _, o := range filterOperators {
+		known = known || (o == op && !tok.quoted)
+	}
+	if !known {
+		return nil, fmt.Errorf("Error in filter expression: expected a comparison (=, !=, ~, !~, <, <=, > or >=) after %s, found %q", field, op)
+	}
+
+	tok, err = p.next("a value after " + field + op)
+	if err != nil {
+		return nil, err
+	}
+	if !tok.quoted && (tok.text == "(" || tok.text == ")" || strings.ContainsAny(tok.text, "=!~<>")) {
+		return nil, fmt.Errorf("Error in filter expression: expected a value after %s%s, found %q", field, op, tok.text)
+	}
+
+	negated := op == "!=" || op == "!~"
+	f := &filterCompare{field: field, op: strings.TrimPrefix(op, "!"), value: tok.text}
+
+	switch kind {
+	case "number":
+		if f.op == "~" {
+			return nil, fmt.Errorf("Error in filter expression: %s is a number and can't be matched with %s", field, op)
+		}
+		if f.num, err = strconv.ParseFloat(strings.TrimSpace(f.value), 64); err != nil {
+			return nil, fmt.Errorf("Error in filter expression: %s%s%s isn't a number", field, op, f.value)
+		}
+
+	case "bool":
+		if f.op != "=" {
+			return nil, fmt.Errorf("Error in filter expression: %s is true or false and can only be compared with = or !=", field)
+		}
+		switch strings.ToLower(f.value) {
+		case "true", "yes", "1":
+			f.num = 1
+		case "false", "no", "0":
+		default:
+			return nil, fmt.Errorf("Error in filter expression: %s%s%s isn't true or false", field, op, f.value)
+		}
+	}
+
+	if negated {
+		return &filterNot{f}, nil
+	}
+	return f, nil
+}
+
+// return the works matching a filter expression
+func filterWorks(works []exportWork, f filterExpr) []exportWork {
+	matched := []exportWork{}
+	for i := range works {
+		if f.matches(&works[i]) {
+			matched = append(matched, works[i])
+		}
+	}
+
+	return matched
+}
+
+// print works as a table, one line each
+func printWorksTable(out io.Writer, works []exportWork) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tMAKE\tMODEL\tDATE\tFILENAME\tTAGS")
+	for _, ew := range works {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", ew.ID, ew.Make, ew.Model, ew.Date, ew.FileName, strings.Join(ew.Tags, ", "))
+	}
+	tw.Flush()
+}
+
+// define the query subcommand's flags and return its runner: print the works of a feed or snapshot matching a filter expression,
+// returning 0, 1 if none match, and 2 on errors
+func setupQuery(fs *flag.FlagSet) func(positional []string) int {
+	format := fs.String("format", "table", "output format: table, or json (the export's shape)")
+	fromSnapshot := fs.String("from-snapshot", "", "query a catalog snapshot instead of a feed (see --snapshot)")
+
+	return func(positional []string) int {
+		if *format != "table" && *format != "json" {
+			fmt.Fprintf(os.Stderr, "Error: unknown query format %q (expected table or json)\n", *format)
+			return 2
+		}
+
+		want := 2
+		if *fromSnapshot != "" {
+			want = 1
+		}
+		if len(positional) != want {
+			fmt.Fprintln(os.Stderr, "Error: please enter the filter expression and the image API URL (e.g. >go run ImageProcessor query \"make=Canon AND model~5D\" http://localhost/test/api/v1/works.xml)")
+			return 2
+		}
+
+		f, err := parseFilterExpr(positional[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+
+		var catalog *Catalog
+		if *fromSnapshot != "" {
+			if catalog, err = loadSnapshot(*fromSnapshot, os.Stderr); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+		} else {
+			feed, err := openFeed(positional[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error fetching XML works data from specified API URL (%s): %v\n", positional[1], err)
+				return 2
+			}
+			defer feed.Close()
+
+			if catalog, err = parseWorks(feed); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+		}
+
+		// makes and models are matched by their display names, as the pages show them
+		applyDisplayNames(catalog, nil, false)
+
+		works := exportWorks(catalog)
+		matched := filterWorks(works, f)
+
+		if *format == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(map[string]interface{}{"works": matched}); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing query results: %v\n", err)
+				return 2
+			}
+		} else {
+			printWorksTable(os.Stdout, matched)
+			fmt.Printf("%d of %s match.\n", len(matched), pluralize("work", "works", len(works)))
+		}
+
+		if len(matched) == 0 {
+			return 1
+		}
+		return 0
+	}
+}
@@ -0,0 +1,239 @@
+// page redirects: when the page of a make or model moves between builds - it's been renamed, its slug or display name changed, or
+// an alias merged it into another (see DisplayNames.go) - the build works out where it went from the previous build manifest and
+// records old -> new in the new one, so links into the old site keep working. A make or model still in the catalog under the same
+// name moved to its new page; one that's gone moved to the page most of its works are on now. Redirects are kept from build to
+// build (following chains, and dropped once the old page exists again), and --redirects publishes them in any of:
+//
+//	stubs    an HTML page at each old URL, sending the browser on to the new one
+//	netlify  a _redirects file (Netlify and Cloudflare Pages) of permanent redirects
+//	nginx    .redirects.nginx.map, for an nginx map: map $uri $redirect_uri { include .../.redirects.nginx.map; }
+//
+// A page's slideshow and Atom feed move with it.
+
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// formats --redirects can publish the redirects in
+var redirectFormats = map[string]bool{"stubs": true, "netlify": true, "nginx": true}
+
+// name of the redirects file for Netlify and Cloudflare Pages, kept in the output directory
+const netlifyRedirectsFile = "_redirects"
+
+// name of the nginx map file of the redirects, kept in the output directory
+const nginxRedirectsFile = ".redirects.nginx.map"
+
+// files that move along with a make or model page, by the suffix after its slug
+var redirectSuffixes = []string{".html", ".slideshow.html", ".atom.xml"}
+
+// return an error if the comma-separated --redirects formats aren't all known
+func checkRedirectFormats(formats string) error {
+	for _, f := range strings.Split(formats, ",") {
+		if f = strings.TrimSpace(f); f != "" && !redirectFormats[f] {
+			return fmt.Errorf("Error: unknown --redirects format %q (expected stubs, netlify or nginx)", f)
+		}
+	}
+
+	return nil
+}
+
+// return the redirects from the pages of the previous build that moved to the build's written files - those found now, and those
+// kept from earlier builds that still lead somewhere
+func pageRedirects(prev *buildManifest, catalog *Catalog, written []string) map[string]string {
+	if prev == nil {
+		return nil
+	}
+
+	exists := map[string]bool{}
+	for _, file := range written {
+		exists[file] = true
+	}
+
+	// where each make, model and work is now
+	makePages, modelPages := map[string]string{}, map[string]string{}
+	for _, mk := range catalog.Makes {
+		if mk == nil {
+			continue
+		}
+		makePages[strings.ToLower(mk.Name)] = mk.PageURL
+		for _, md := range mk.Models {
+			if md != nil {
+				modelPages[strings.ToLower(mk.Name+"\n"+md.Name)] = md.PageURL
+			}
+		}
+	}
+	works := map[WorkID]*Work{}
+	for _, wk := range catalog.Works {
+		if wk != nil {
+			works[wk.ID] = wk
+		}
+	}
+
+	redirects := map[string]string{}
+	moved := func(oldPage, newSlug string) {
+		if newSlug == "" {
+			return
+		}
+		oldSlug := strings.TrimSuffix(oldPage, ".html")
+		for _, suffix := range redirectSuffixes {
+			from, to := oldSlug+suffix, newSlug+suffix
+			if from != to && !exists[from] && exists[to] {
+				redirects[from] = to
+			}
+		}
+	}
+
+	for _, mm := range prev.Makes {
+		if !exists[mm.Page] {
+			slug, ok := makePages[strings.ToLower(mm.Name)]
+			if !ok {
+				slug = mostCommonMakePage(prev.Works, works, mm.Name)
+			}
+			moved(mm.Page, slug)
+		}
+
+		for _, md := range mm.Models {
+			if exists[md.Page] {
+				continue
+			}
+			slug, ok := modelPages[strings.ToLower(mm.Name+"\n"+md.Name)]
+			if !ok {
+				slug = mostCommonModelPage(prev.Works, works, mm.Name, md.Name)
+			}
+			moved(md.Page, slug)
+		}
+	}
+
+	// the earlier builds' redirects, following them on to where their pages are now
+	for from, to := range prev.Redirects {
+		if exists[from] || redirects[from] != "" {
+			continue
+		}
+		for seen := 0; !exists[to] && redirects[to] != "" && seen < len(redirects); seen++ {
+			to = redirects[to]
+		}
+		if exists[to] && to != from {
+			redirects[from] = to
+		}
+	}
+
+	return redirects
+}
+
+// return the slug of the make page most of the previous build's works of a make are on now ("" if none of them are left)
+func mostCommonMakePage(previous []exportWork, works map[WorkID]*Work, makeName string) string {
+	counts := map[string]int{}
+	for _, ew := range previous {
+		if wk, ok := works[ew.ID]; ok && ew.Make == makeName && wk.WMake != nil {
+			counts[wk.WMake.PageURL]++
+		}
+	}
+
+	return mostCounted(counts)
+}
+
+// return the slug of the model page most of the previous build's works of a make and model are on now ("" if none of them are left)
+func mostCommonModelPage(previous []exportWork, works map[WorkID]*Work, makeName, modelName string) string {
+	counts := map[string]int{}
+	for _, ew := range previous {
+		if wk, ok := works[ew.ID]; ok && ew.Make == makeName && ew.Model == modelName && wk.WModel != nil {
+			counts[wk.WModel.PageURL]++
+		}
+	}
+
+	return mostCounted(counts)
+}
+
+// return the key with the highest count - the first in sort order on a tie, so builds are repeatable
+func mostCounted(counts map[string]int) string {
+	best := ""
+	for key, n := range counts {
+		if n > counts[best] || (n == counts[best] && key < best) {
+			best = key
+		}
+	}
+
+	return best
+}
+
+// publish the redirects in the comma-separated formats, returning the files written (relative to the output directory)
+func writeRedirects(outputFolderLocation string, redirects map[string]string, formats string) ([]string, error) {
+	var from []string
+	for f := range redirects {
+		from = append(from, f)
+	}
+	sort.Strings(from)
+
+	if len(from) > 0 {
+		fmt.Printf("Redirects: %d moved pages.\n", len(from))
+		for _, f := range from {
+			fmt.Printf("  %s -> %s\n", f, redirects[f])
+		}
+	}
+
+	var written []string
+	write := func(file, content string) error {
+		if err := os.WriteFile(filepath.Join("./"+outputFolderLocation, filepath.FromSlash(file)), []byte(content), 0644); err != nil {
+			return fmt.Errorf("Error writing redirects (%s): %v", file, err)
+		}
+		written = append(written, file)
+		return nil
+	}
+
+	for _, format := range strings.Split(formats, ",") {
+		var b strings.Builder
+		switch strings.TrimSpace(format) {
+		case "stubs":
+			for _, f := range from {
+				if !strings.HasSuffix(f, ".html") {
+					continue // a stub can't stand in for a feed
+				}
+				if err := write(f, redirectStub(redirects[f])); err != nil {
+					return nil, err
+				}
+			}
+
+		case "netlify":
+			b.WriteString("# pages that moved between builds of the gallery\n")
+			for _, f := range from {
+				fmt.Fprintf(&b, "/%s /%s 301\n", escapePath(f), escapePath(redirects[f]))
+				if strings.HasSuffix(f, ".html") {
+					fmt.Fprintf(&b, "/%s /%s 301\n", escapePath(strings.TrimSuffix(f, ".html")), escapePath(strings.TrimSuffix(redirects[f], ".html")))
+				}
+			}
+			if err := write(netlifyRedirectsFile, b.String()); err != nil {
+				return nil, err
+			}
+
+		case "nginx":
+			b.WriteString("# pages that moved between builds of the gallery - include in a map block of the http block, and redirect with\n")
+			b.WriteString("#   if ($redirect_uri) { return 301 $redirect_uri; }\n")
+			for _, f := range from {
+				fmt.Fprintf(&b, "/%s /%s;\n", escapePath(f), escapePath(redirects[f]))
+				if strings.HasSuffix(f, ".html") {
+					fmt.Fprintf(&b, "/%s /%s;\n", escapePath(strings.TrimSuffix(f, ".html")), escapePath(strings.TrimSuffix(redirects[f], ".html")))
+				}
+			}
+			if err := write(nginxRedirectsFile, b.String()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// return the page left at an old URL, sending the browser on to the new one
+func redirectStub(target string) string {
+	href := html.EscapeString(escapePath(target))
+	return "<!DOCTYPE html><html><head><meta charset=\"utf-8\"><title>Moved</title><link rel=\"canonical\" href=\"" + href + "\">" +
+		"<meta http-equiv=\"refresh\" content=\"0; url=" + href + "\"><meta name=\"robots\" content=\"noindex\"></head>" +
+		"<body><p>This page has moved to <a href=\"" + href + "\">" + html.EscapeString(target) + "</a>.</p></body></html>\n"
+}
@@ -0,0 +1,404 @@
+// SQLite reading: a minimal read-only reader for SQLite 3 database files, enough to scan whole tables of a Lightroom catalog
+// without a database driver. Only UTF-8 databases are supported, and changes still in a write-ahead log (-wal file) aren't seen.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// b-tree page types
+const (
+	sqliteInteriorTable = 0x05
+	sqliteLeafTable     = 0x0D
+)
+
+// type struct representing an open SQLite database file
+type sqliteDB struct {
+	f        *os.File
+	pageSize int
+	usable   int                    // page size less the reserved bytes at the end of each page
+	tables   map[string]sqliteTable // by lower-case name
+}
+
+// type struct representing a table from the schema: its root page and column names
+type sqliteTable struct {
+	RootPage int
+	Columns  []string
+	RowidCol int // index of the INTEGER PRIMARY KEY column (stored as the rowid), or -1
+}
+
+// a table row, keyed by column name - values are int64, float64, string, []byte or nil
+type sqliteRow map[string]interface{}
+
+// open a database file and read its schema
+func openSQLite(path string) (*sqliteDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 100)
+	if _, err := f.ReadAt(header, 0); err != nil || !bytes.HasPrefix(header, []byte("SQLite format 3\x00")) {
+		f.Close()
+		return nil, fmt.Errorf("%s is not an SQLite 3 database", path)
+	}
+
+	db := &sqliteDB{f: f, pageSize: int(binary.BigEndian.Uint16(header[16:18]))}
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	db.usable = db.pageSize - int(header[20])
+
+	if enc := binary.BigEndian.Uint32(header[56:60]); enc > 1 {
+		f.Close()
+		return nil, fmt.Errorf("%s uses a UTF-16 text encoding, which isn't supported", path)
+	}
+
+	// the schema table (sqlite_master) is rooted at page 1: type, name, tbl_name, rootpage, sql
+	db.tables = map[string]sqliteTable{}
+	master := sqliteTable{RootPage: 1, Columns: []string{"type", "name", "tbl_name", "rootpage", "sql"}, RowidCol: -1}
+	err = db.scan(master, func(row sqliteRow) error {
+		if row.text("type") != "table" {
+			return nil
+		}
+
+		columns, rowidCol := sqliteColumns(row.text("sql"))
+		db.tables[strings.ToLower(row.text("name"))] = sqliteTable{RootPage: int(row.integer("rootpage")), Columns: columns, RowidCol: rowidCol}
+		return nil
+	})
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Error reading schema of %s: %v", path, err)
+	}
+
+	return db, nil
+}
+
+func (db *sqliteDB) Close() error {
+	return db.f.Close()
+}
+
+// return whether the database has the named table
+func (db *sqliteDB) hasTable(name string) bool {
+	_, ok := db.tables[strings.ToLower(name)]
+	return ok
+}
+
+// call fn for each row of the named table, in rowid order
+func (db *sqliteDB) scanTable(name string, fn func(sqliteRow) error) error {
+	t, ok := db.tables[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("no table %s", name)
+	}
+
+	return db.scan(t, fn)
+}
+
+func (db *sqliteDB) scan(t sqliteTable, fn func(sqliteRow) error) error {
+	return db.walk(t, t.RootPage, fn, 0)
+}
+
+// visit the rows of the table b-tree rooted at the given page
+func (db *sqliteDB) walk(t sqliteTable, pageNo int, fn func(sqliteRow) error, depth int) error {
+	if depth > 64 {
+		return fmt.Errorf("b-tree too deep at page %d", pageNo)
+	}
+
+	page, err := db.page(pageNo)
+	if err != nil {
+		return err
+	}
+
+	// page 1 starts with the 100 byte file header
+	hdr := 0
+	if pageNo == 1 {
+		hdr = 100
+	}
+
+	kind := page[hdr]
+	cells := int(binary.BigEndian.Uint16(page[hdr+3 : hdr+5]))
+
+	switch kind {
+	case sqliteInteriorTable:
+		pointers := hdr + 12
+		for i := 0; i < cells; i++ {
+			cell := int(binary.BigEndian.Uint16(page[pointers+2*i:]))
+			if cell+4 > len(page) {
+				return fmt.Errorf("bad cell pointer on page %d", pageNo)
+			}
+			if err := db.walk(t, int(binary.BigEndian.Uint32(page[cell:])), fn, depth+1); err != nil {
+				return err
+			}
+		}
+		return db.walk(t, int(binary.BigEndian.Uint32(page[hdr+8:])), fn, depth+1)
+
+	case sqliteLeafTable:
+		pointers := hdr + 8
+		for i := 0; i < cells; i++ {
+			cell := int(binary.BigEndian.Uint16(page[pointers+2*i:]))
+			if cell >= len(page) {
+				return fmt.Errorf("bad cell pointer on page %d", pageNo)
+			}
+
+			payloadSize, n := sqliteVarint(page[cell:])
+			rowid, m := sqliteVarint(page[cell+n:])
+
+			payload, err := db.payload(page, cell+n+m, int(payloadSize))
+			if err != nil {
+				return fmt.Errorf("page %d: %v", pageNo, err)
+			}
+
+			values, err := sqliteRecord(payload)
+			if err != nil {
+				return fmt.Errorf("page %d: %v", pageNo, err)
+			}
+
+			row := sqliteRow{}
+			for c, name := range t.Columns {
+				if c < len(values) {
+					row[name] = values[c]
+				} else {
+					// columns added with ALTER TABLE are missing from older rows
+					row[name] = nil
+				}
+			}
+			if t.RowidCol >= 0 {
+				row[t.Columns[t.RowidCol]] = int64(rowid)
+			}
+
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("page %d is not a table b-tree page (type %#x)", pageNo, kind)
+}
+
+// read a page (numbered from 1)
+func (db *sqliteDB) page(n int) ([]byte, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("bad page number %d", n)
+	}
+
+	page := make([]byte, db.pageSize)
+	if _, err := db.f.ReadAt(page, int64(n-1)*int64(db.pageSize)); err != nil {
+		return nil, fmt.Errorf("reading page %d: %v", n, err)
+	}
+
+	return page, nil
+}
+
+// return a cell's payload, following its overflow pages when it doesn't fit on the page
+func (db *sqliteDB) payload(page []byte, start, size int) ([]byte, error) {
+	maxLocal := db.usable - 35
+	local := size
+	if size > maxLocal {
+		minLocal := (db.usable-12)*32/255 - 23
+		local = minLocal + (size-minLocal)%(db.usable-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+
+	if start+local > len(page) || (local < size && start+local+4 > len(page)) {
+		return nil, fmt.Errorf("cell overruns its page")
+	}
+
+	data := append([]byte(nil), page[start:start+local]...)
+	if local == size {
+		return data, nil
+	}
+
+	next := int(binary.BigEndian.Uint32(page[start+local:]))
+	for len(data) < size {
+		if next == 0 {
+			return nil, fmt.Errorf("overflow chain ends early")
+		}
+
+		overflow, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+
+		chunk := overflow[4:db.usable]
+		if rest := size - len(data); len(chunk) > rest {
+			chunk = chunk[:rest]
+		}
+		data = append(data, chunk...)
+		next = int(binary.BigEndian.Uint32(overflow))
+	}
+
+	return data, nil
+}
+
+// decode a record: a header of serial types followed by the values
+func sqliteRecord(data []byte) ([]interface{}, error) {
+	headerSize, n := sqliteVarint(data)
+	if int(headerSize) > len(data) || n == 0 {
+		return nil, fmt.Errorf("bad record header")
+	}
+
+	var types []uint64
+	for pos := n; pos < int(headerSize); {
+		t, m := sqliteVarint(data[pos:])
+		if m == 0 {
+			return nil, fmt.Errorf("bad record header")
+		}
+		types = append(types, t)
+		pos += m
+	}
+
+	values := make([]interface{}, 0, len(types))
+	body := data[headerSize:]
+
+	for _, t := range types {
+		var size int
+		switch {
+		case t == 0 || t == 8 || t == 9:
+			size = 0
+		case t <= 4:
+			size = int(t)
+		case t == 5:
+			size = 6
+		case t == 6 || t == 7:
+			size = 8
+		case t >= 12:
+			size = int(t-12) / 2
+		default:
+			return nil, fmt.Errorf("unknown serial type %d", t)
+		}
+
+		if size > len(body) {
+			return nil, fmt.Errorf("record overruns its payload")
+		}
+		v := body[:size]
+		body = body[size:]
+
+		switch {
+		case t == 0:
+			values = append(values, nil)
+		case t == 8:
+			values = append(values, int64(0))
+		case t == 9:
+			values = append(values, int64(1))
+		case t <= 6:
+			// big-endian two's complement integer of 1-8 bytes
+			var i int64
+			if v[0]&0x80 != 0 {
+				i = -1
+			}
+			for _, b := range v {
+				i = i<<8 | int64(b)
+			}
+			values = append(values, i)
+		case t == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(v)))
+		case t%2 == 0:
+			values = append(values, append([]byte(nil), v...))
+		default:
+			values = append(values, string(v))
+		}
+	}
+
+	return values, nil
+}
+
+// decode a big-endian base-128 varint (at most 9 bytes, the last contributing all 8 bits), returning the value and its length
+func sqliteVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+
+		v = v<<7 | uint64(b[i]&0x7F)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+
+	return 0, 0
+}
+
+// return the column names of a CREATE TABLE statement, and the index of its INTEGER PRIMARY KEY column (or -1)
+func sqliteColumns(sql string) ([]string, int) {
+	open, end := strings.Index(sql, "("), strings.LastIndex(sql, ")")
+	if open < 0 || end < open {
+		return nil, -1
+	}
+
+	// split the definitions on top-level commas
+	var defs []string
+	depth, start := 0, open+1
+	for i := open + 1; i < end; i++ {
+		switch sql[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				defs = append(defs, sql[start:i])
+				start = i + 1
+			}
+		}
+	}
+	defs = append(defs, sql[start:end])
+
+	var columns []string
+	rowidCol := -1
+
+	for _, def := range defs {
+		fields := strings.Fields(def)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "PRIMARY", "UNIQUE", "CHECK", "FOREIGN", "CONSTRAINT":
+			continue
+		}
+
+		name := strings.Trim(fields[0], "\"'`[]")
+		if upper := strings.ToUpper(strings.Join(fields[1:], " ")); strings.HasPrefix(upper, "INTEGER PRIMARY KEY") {
+			rowidCol = len(columns)
+		}
+		columns = append(columns, name)
+	}
+
+	return columns, rowidCol
+}
+
+// return a column value as text (numbers are formatted, NULL is empty)
+func (r sqliteRow) text(column string) string {
+	switch v := r[column].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// return a column value as an integer (0 for NULL and text)
+func (r sqliteRow) integer(column string) int64 {
+	switch v := r[column].(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+
+	return 0
+}
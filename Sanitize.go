@@ -0,0 +1,230 @@
+// HTML sanitization: a work's <caption> can hold an HTML fragment (escaped, or in a CDATA section), shown on its page once it's been
+// through an allowlist policy - elements the policy doesn't list are dropped but keep their text (script and style elements go with
+// their content), attributes it doesn't list are dropped, links and image sources go only to http(s), mailto and relative URLs, and
+// links get rel="nofollow noopener". The config file's "html_policy" section replaces the default allowlist:
+//
+//	"html_policy": {
+//	  "elements": {"a": ["href", "title"], "b": [], "i": [], "br": [], "p": [], "img": ["src", "alt"]},
+//	  "link_rel": "nofollow"
+//	}
+//
+// Elements that run code or load documents (script, style, iframe, object, embed, form, ...) and event handler and style
+// attributes can't be allowed. A "<" that doesn't start a tag is shown as text.
+
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// type struct representing the elements and attributes feed HTML may keep
+type htmlPolicy struct {
+	Elements map[string][]string `json:"elements"` // allowed elements and, for each, its allowed attributes
+	LinkRel  string              `json:"link_rel"` // rel attribute of every link ("" for nofollow noopener)
+}
+
+// the policy of sites whose config doesn't set one: text formatting, paragraphs, lists, quotes and links
+var defaultHTMLPolicy = &htmlPolicy{Elements: map[string][]string{
+	"a": {"href", "title"}, "b": {}, "strong": {}, "i": {}, "em": {}, "u": {}, "s": {}, "small": {}, "sub": {}, "sup": {}, "code": {},
+	"br": {}, "p": {}, "span": {}, "blockquote": {"cite"}, "ul": {}, "ol": {}, "li": {},
+}}
+
+// elements a policy can't allow, and those whose content goes with them
+var forbiddenElements = map[string]bool{"script": true, "style": true, "iframe": true, "frame": true, "frameset": true, "object": true, "embed": true, "applet": true,
+	"form": true, "input": true, "button": true, "textarea": true, "select": true, "base": true, "meta": true, "link": true, "svg": true, "math": true, "template": true}
+var droppedContent = map[string]bool{"script": true, "style": true, "textarea": true, "template": true, "svg": true, "math": true}
+
+// attributes holding a URL
+var urlAttributes = map[string]bool{"href": true, "src": true, "cite": true}
+
+// names of elements and attributes a policy can list
+var htmlNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// check that a policy from the config file only allows what can't run code - a nil policy is the default one
+func (p *htmlPolicy) check() error {
+	if p == nil {
+		return nil
+	}
+
+	for element, attrs := range p.Elements {
+		if !htmlNamePattern.MatchString(element) || forbiddenElements[element] {
+			return fmt.Errorf("Error in html_policy config: element %q can't be allowed", element)
+		}
+		for _, attr := range attrs {
+			if !htmlNamePattern.MatchString(attr) || strings.HasPrefix(attr, "on") || attr == "style" || attr == "srcdoc" || attr == "formaction" {
+				return fmt.Errorf("Error in html_policy config: attribute %q of %s can't be allowed", attr, element)
+			}
+		}
+	}
+
+	return nil
+}
+
+// type struct representing a start or end tag of an HTML fragment
+type htmlTag struct {
+	name  string // lowercased ("" for a comment or declaration)
+	end   bool
+	attrs [][2]string // names (lowercased) and values (entities decoded) of a start tag's attributes
+}
+
+// return the text of an HTML fragment up to its next tag, the tag (nil if there's none) and the rest of the fragment after it - a
+// "<" that doesn't start a tag is text
+func nextHTMLTag(s string) (string, *htmlTag, string) {
+	for from := 0; ; {
+		i := strings.IndexByte(s[from:], '<')
+		if i < 0 {
+			return s, nil, ""
+		}
+		i += from
+		rest := s[i+1:]
+
+		if strings.HasPrefix(rest, "!--") {
+			end := strings.Index(rest, "-->")
+			if end < 0 {
+				return s[:i], &htmlTag{}, ""
+			}
+			return s[:i], &htmlTag{}, rest[end+3:]
+		}
+		if strings.HasPrefix(rest, "!") || strings.HasPrefix(rest, "?") {
+			end := strings.IndexByte(rest, '>')
+			if end < 0 {
+				return s[:i], &htmlTag{}, ""
+			}
+			return s[:i], &htmlTag{}, rest[end+1:]
+		}
+
+		tag := &htmlTag{}
+		if strings.HasPrefix(rest, "/") {
+			tag.end, rest = true, rest[1:]
+		}
+		n := 0
+		for n < len(rest) && (isWordByte(rest[n]) || rest[n] == '-') {
+			n++
+		}
+		if n == 0 || !(rest[0] >= 'a' && rest[0] <= 'z' || rest[0] >= 'A' && rest[0] <= 'Z') {
+			from = i + 1
+			continue
+		}
+		tag.name, rest = strings.ToLower(rest[:n]), rest[n:]
+
+		// attributes, up to the closing >
+		for {
+			rest = strings.TrimLeft(rest, " \t\r\n/")
+			if rest == "" {
+				return s, nil, "" // no closing >: the rest is text
+			}
+			if rest[0] == '>' {
+				return s[:i], tag, rest[1:]
+			}
+
+			n := strings.IndexAny(rest, " \t\r\n/>=")
+			if n < 0 {
+				return s, nil, ""
+			}
+			name, value := strings.ToLower(rest[:n]), ""
+			rest = strings.TrimLeft(rest[n:], " \t\r\n")
+			if strings.HasPrefix(rest, "=") {
+				rest = strings.TrimLeft(rest[1:], " \t\r\n")
+				if rest != "" && (rest[0] == '"' || rest[0] == '\'') {
+					end := strings.IndexByte(rest[1:], rest[0])
+					if end < 0 {
+						return s, nil, ""
+					}
+					value, rest = rest[1:end+1], rest[end+2:]
+				} else {
+					end := strings.IndexAny(rest, " \t\r\n>")
+					if end < 0 {
+						end = len(rest)
+					}
+					value, rest = rest[:end], rest[end:]
+				}
+			}
+			tag.attrs = append(tag.attrs, [2]string{name, html.UnescapeString(value)})
+		}
+	}
+}
+
+// return an HTML fragment from the feed with everything the policy doesn't allow taken out
+func (p *htmlPolicy) sanitize(fragment string) template.HTML {
+	if p == nil {
+		p = defaultHTMLPolicy
+	}
+
+	var b strings.Builder
+	var open []string // allowed elements written and not closed yet
+	for rest := fragment; rest != ""; {
+		var text string
+		var tag *htmlTag
+		text, tag, rest = nextHTMLTag(rest)
+		b.WriteString(html.EscapeString(html.UnescapeString(text)))
+		if tag == nil || tag.name == "" {
+			continue
+		}
+
+		if droppedContent[tag.name] && !tag.end {
+			// the element goes with its content, up to its end tag
+			end := strings.Index(strings.ToLower(rest), "</"+tag.name)
+			if end < 0 {
+				break
+			}
+			_, _, rest = nextHTMLTag(rest[end:])
+			continue
+		}
+
+		attrs, ok := p.Elements[tag.name]
+		if !ok {
+			continue
+		}
+
+		if tag.end {
+			// close the element, and any left open inside it
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == tag.name {
+					for len(open) > i {
+						b.WriteString("</" + open[len(open)-1] + ">")
+						open = open[:len(open)-1]
+					}
+					break
+				}
+			}
+			continue
+		}
+
+		b.WriteString("<" + tag.name)
+		for _, a := range tag.attrs {
+			allowed := false
+			for _, name := range attrs {
+				allowed = allowed || name == a[0]
+			}
+			value := a[1]
+			if allowed && urlAttributes[a[0]] {
+				value, allowed = safeLinkURL(strings.TrimSpace(value))
+			}
+			if allowed {
+				b.WriteString(" " + a[0] + `="` + html.EscapeString(value) + `"`)
+			}
+		}
+		if tag.name == "a" {
+			rel := p.LinkRel
+			if rel == "" {
+				rel = "nofollow noopener"
+			}
+			b.WriteString(` rel="` + html.EscapeString(rel) + `"`)
+		}
+		b.WriteString(">")
+
+		if !voidElements[tag.name] { // see HTMLValidation.go
+			open = append(open, tag.name)
+		}
+	}
+
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i] + ">")
+	}
+
+	return template.HTML(b.String())
+}
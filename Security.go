@@ -0,0 +1,112 @@
+// security headers: the Content-Security-Policy for the generated pages, as a <meta> tag and as deployable header configuration
+// (a Netlify/Cloudflare Pages _headers file and an nginx snippet) for serving the gallery with a strict security posture.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// names of the header configuration files written with --csp
+const (
+	headersFile      = "_headers"
+	nginxHeadersFile = "nginx-headers.conf"
+)
+
+// return the Content-Security-Policy for the site: only its own stylesheet and script, and images from itself and the image hosts
+// used in the catalog - forHeader adds the directives that are only honoured in an HTTP header, not a <meta> tag
+func contentSecurityPolicy(catalog *Catalog, forHeader bool) string {
+	imgSrc := append([]string{"'self'"}, imageOrigins(catalog)...)
+
+	// a comment system on the work pages brings its own script, and frames or API requests (see Comments.go)
+	scriptSrc, frameSrc, connectSrc := commentsCSP()
+
+	directives := []string{
+		"default-src 'none'",
+		"img-src " + strings.Join(imgSrc, " "),
+		"style-src 'self'",
+		"script-src " + strings.Join(append([]string{"'self'"}, scriptSrc...), " "),
+		"base-uri 'none'",
+		"form-action 'none'",
+	}
+	if len(frameSrc) > 0 {
+		directives = append(directives, "frame-src "+strings.Join(frameSrc, " "))
+	}
+	if len(connectSrc) > 0 {
+		directives = append(directives, "connect-src "+strings.Join(connectSrc, " "))
+	}
+
+	if forHeader {
+		directives = append(directives, "frame-ancestors 'none'")
+	}
+
+	return strings.Join(directives, "; ")
+}
+
+// return the distinct origins (scheme://host) of the absolute image URLs the catalog's pages use - after mirroring and signing (see
+// ImageMirrors.go) - sorted
+func imageOrigins(catalog *Catalog) []string {
+	seen := map[string]bool{}
+
+	for _, wk := range append(append([]*Work(nil), catalog.Works...), catalog.Flagged...) {
+		if wk == nil {
+			continue
+		}
+
+		for _, src := range []string{wk.smallSrc(), wk.mediumSrc(), wk.largeSrc()} {
+			u, err := url.Parse(strings.TrimSpace(publishedImageURL(src)))
+			if err != nil || u.Host == "" {
+				continue
+			}
+
+			origin := u.Host
+			if u.Scheme != "" {
+				origin = strings.ToLower(u.Scheme) + "://" + u.Host
+			}
+			seen[origin] = true
+		}
+	}
+
+	var origins []string
+	for origin := range seen {
+		origins = append(origins, origin)
+	}
+
+	sort.Strings(origins)
+	return origins
+}
+
+// return the security headers to serve every page with
+func securityHeaders(catalog *Catalog) [][2]string {
+	return [][2]string{
+		{"Content-Security-Policy", contentSecurityPolicy(catalog, true)},
+		{"X-Content-Type-Options", "nosniff"},
+		{"Referrer-Policy", "strict-origin-when-cross-origin"},
+		{"X-Frame-Options", "DENY"},
+	}
+}
+
+// write the _headers file and nginx snippet into the output directory and return their names
+func writeSecurityHeaders(catalog *Catalog, outputFolderLocation string) ([]string, error) {
+	headers := securityHeaders(catalog)
+
+	netlify := "/*\n"
+	nginx := "# security headers for the generated gallery - include from the server or location block serving it\n"
+	for _, h := range headers {
+		netlify = netlify + "  " + h[0] + ": " + h[1] + "\n"
+		nginx = nginx + fmt.Sprintf("add_header %s \"%s\" always;\n", h[0], h[1])
+	}
+
+	for name, content := range map[string]string{headersFile: netlify, nginxHeadersFile: nginx} {
+		if err := os.WriteFile(filepath.Join("./"+outputFolderLocation, name), []byte(content), 0644); err != nil {
+			return nil, fmt.Errorf("Error writing security headers: %v", err)
+		}
+	}
+
+	return []string{headersFile, nginxHeadersFile}, nil
+}
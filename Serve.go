@@ -0,0 +1,188 @@
+// serve subcommand: a local preview server for the generated site that rebuilds on change and live-reloads open pages. It takes every
+// build flag (--theme, --config, ...) and also rebuilds when a file of the --theme changes, so theme authors see their edits at once:
+//
+//	>go run ImageProcessor serve --theme mytheme http://localhost/test/api/v1/works.xml code/html/output
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	registerCommand(&command{
+		Name:    "serve",
+		Usage:   "[--addr host:port] [build flags, e.g. --theme dir --config file] <api-url> <output-dir>",
+		Summary: "build the site, serve it on localhost and live-reload pages whenever the works data or theme changes",
+		Setup:   setupServe,
+	})
+}
+
+// URL path of the server-sent events stream that pages listen on for reload notifications
+const liveReloadPath = "/__livereload"
+
+// script injected before </body> of every served HTML page - reloads the page when the server announces a rebuild
+const liveReloadScript = `<script>new EventSource("` + liveReloadPath + `").onmessage = function() { window.location.reload(); };</script>`
+
+// define the serve subcommand's flags - the build's, and the server's address - and return its runner: build the site as the build
+// command would, then serve the output directory while rebuilding in the background whenever the works source or the theme changes
+func setupServe(fs *flag.FlagSet) func(positional []string) int {
+	addr := fs.String("addr", "localhost:8000", "address for the preview server to listen on")
+
+	return setupBuildWith(fs, func(job watchJob) int {
+		reloads := newReloadBroadcaster()
+
+		// the watch loop performs the initial build too, and tells connected pages to reload after each successful rebuild
+		onBuild := job.OnBuild
+		job.OnBuild = func(err error) {
+			if onBuild != nil {
+				onBuild(err)
+			}
+			if err == nil {
+				reloads.notify()
+			}
+		}
+
+		// Ctrl+C stops the watcher and the server together
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		defer signal.Stop(interrupt)
+
+		stop, watched := make(chan struct{}), make(chan struct{})
+		go func() {
+			watchUntil(stop, job)
+			close(watched)
+		}()
+
+		mux := http.NewServeMux()
+		mux.Handle(liveReloadPath, reloads)
+		mux.Handle("/", &liveReloadFileServer{root: "./" + job.Output})
+
+		server := &http.Server{Addr: *addr, Handler: mux}
+		server.RegisterOnShutdown(reloads.close) // the event streams would otherwise keep the shutdown waiting
+
+		served := make(chan error, 1)
+		go func() { served <- server.ListenAndServe() }()
+
+		fmt.Printf("Serving ./%s at http://%s/ with live reload (press Ctrl+C to stop)\n", job.Output, *addr)
+
+		status := 0
+		select {
+		case err := <-served:
+			fmt.Fprintf(os.Stderr, "Error running preview server: %v\n", err)
+			status = 1
+		case <-interrupt:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := server.Shutdown(ctx); err != nil {
+				server.Close()
+			}
+		}
+
+		close(stop)
+		<-watched
+		fmt.Println("Preview server stopped.")
+
+		return status
+	})
+}
+
+// type struct representing a file server for the output directory that injects the live-reload script into HTML pages
+type liveReloadFileServer struct {
+	root string
+}
+
+func (s *liveReloadFileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	urlPath := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(urlPath, "/") {
+		urlPath += "index.html"
+	}
+
+	// non-HTML files are served as they are
+	if !strings.HasSuffix(urlPath, ".html") {
+		http.FileServer(http.Dir(s.root)).ServeHTTP(w, r)
+		return
+	}
+
+	page, err := os.ReadFile(filepath.Join(s.root, filepath.FromSlash(urlPath)))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if i := bytes.LastIndex(page, []byte("</body>")); i >= 0 {
+		page = append(page[:i:i], append([]byte(liveReloadScript), page[i:]...)...)
+	} else {
+		page = append(page, liveReloadScript...)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(page)
+}
+
+// type struct representing the set of browser pages waiting to be told that the site was rebuilt
+type reloadBroadcaster struct {
+	mu      sync.Mutex
+	rebuilt chan struct{} // closed (and replaced) on every rebuild to wake up all waiting event streams
+	done    chan struct{} // closed when the server shuts down, ending every event stream
+	once    sync.Once
+}
+
+// create and return a pointer to a reload broadcaster with no waiting pages
+func newReloadBroadcaster() *reloadBroadcaster {
+	return &reloadBroadcaster{rebuilt: make(chan struct{}), done: make(chan struct{})}
+}
+
+// end every event stream, for the server to shut down
+func (b *reloadBroadcaster) close() {
+	b.once.Do(func() { close(b.done) })
+}
+
+// wake up every page currently waiting for a rebuild
+func (b *reloadBroadcaster) notify() {
+	b.mu.Lock()
+	close(b.rebuilt)
+	b.rebuilt = make(chan struct{})
+	b.mu.Unlock()
+}
+
+// serve a server-sent events stream that emits a message every time the site is rebuilt
+func (b *reloadBroadcaster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	flusher.Flush()
+
+	for {
+		b.mu.Lock()
+		rebuilt := b.rebuilt
+		b.mu.Unlock()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-b.done:
+			return
+		case <-rebuilt:
+			fmt.Fprint(w, "data: reload\n\n")
+			flusher.Flush()
+		}
+	}
+}
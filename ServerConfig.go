@@ -0,0 +1,288 @@
+// web server configuration: --server-config writes nginx, Apache and Caddy configuration for serving the generated site from a
+// traditional web server - the document root, clean page URLs, cache headers (long-lived for fingerprinted assets and images,
+// revalidated for pages and feeds), the Atom feeds' content type, the security headers with --csp, no access to the build's own
+// files, and optionally HTTP basic auth:
+//
+//	>IMGPROC_BASIC_AUTH_PASSWORD=secret go run ImageProcessor --server-config /etc/imgproc --basic-auth family works.xml out
+//
+// writes out.nginx.conf, out.apache.conf, out.caddy and (for nginx and Apache) out.htpasswd into /etc/imgproc - named after the
+// output directory, so the sites of a multi-site build don't overwrite each other's. The directory should be outside the site, so
+// the password file is never served.
+
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// default environment variable holding the basic auth password (--basic-auth-env)
+const defaultBasicAuthEnv = "IMGPROC_BASIC_AUTH_PASSWORD"
+
+// how long browsers and proxies may cache images and fingerprinted assets (a year), in seconds
+const longCacheSeconds = 31536000
+
+// type struct representing the settings of the server configuration written with --server-config
+type serverConfigOptions struct {
+	Dir             string // directory to write the configuration into ("" for none)
+	BasicAuthUser   string // user name for HTTP basic auth ("" for none)
+	BasicAuthEnv    string // environment variable holding the password
+	BasicAuthPasswd string // the password, read from BasicAuthEnv
+}
+
+// type struct representing what a server configuration has to describe
+type serverSite struct {
+	root       string      // absolute path of the output directory
+	host       string      // host name from the base URL ("" if unknown)
+	name       string      // output directory's name, which the configuration files are named after
+	headers    [][2]string // security headers to send with every response (none without --csp)
+	authFile   string      // absolute path of the htpasswd file ("" without basic auth)
+	authUser   string
+	assetRegex string // regular expression matching fingerprinted stylesheet/script file names
+}
+
+// write the nginx, Apache and Caddy configuration (and the htpasswd file) for the site
+func writeServerConfig(catalog *Catalog, outputFolderLocation string, opts *buildOptions) error {
+	sc := opts.ServerConfig
+
+	root, err := filepath.Abs("./" + outputFolderLocation)
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(sc.Dir)
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(root, dir); err == nil && !strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("Error: the --server-config directory (%s) must be outside the output directory, or the site would serve it", sc.Dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Error creating server config directory: %v", err)
+	}
+
+	site := serverSite{root: root, name: filepath.Base(root), assetRegex: `\.[0-9a-f]{10}\.(css|js)$`}
+	if u, err := url.Parse(opts.BaseURL); err == nil {
+		site.host = u.Hostname()
+	}
+	if opts.CSP {
+		site.headers = securityHeaders(catalog)
+	}
+
+	files := map[string]string{}
+	if sc.BasicAuthUser != "" {
+		site.authUser = sc.BasicAuthUser
+		site.authFile = filepath.Join(dir, site.name+".htpasswd")
+
+		hash, err := apr1Hash(sc.BasicAuthPasswd)
+		if err != nil {
+			return err
+		}
+		files[site.authFile] = sc.BasicAuthUser + ":" + hash + "\n"
+	}
+
+	files[filepath.Join(dir, site.name+".nginx.conf")] = site.nginx()
+	files[filepath.Join(dir, site.name+".apache.conf")] = site.apache()
+	files[filepath.Join(dir, site.name+".caddy")] = site.caddy()
+
+	for path, content := range files {
+		mode := os.FileMode(0644)
+		if path == site.authFile {
+			mode = 0640
+		}
+		if err := os.WriteFile(path, []byte(content), mode); err != nil {
+			return fmt.Errorf("Error writing server config: %v", err)
+		}
+	}
+
+	fmt.Printf("Server configuration for nginx, Apache and Caddy written to %s.\n", dir)
+	return nil
+}
+
+// return the nginx server block
+func (s serverSite) nginx() string {
+	var b strings.Builder
+	serverName := s.host
+	if serverName == "" {
+		serverName = "_"
+	}
+
+	fmt.Fprintf(&b, "# nginx configuration for the generated gallery - include from the http block\nserver {\n")
+	fmt.Fprintf(&b, "    listen 80;\n    server_name %s;\n    root %s;\n    index index.html;\n\n", serverName, s.root)
+	fmt.Fprintf(&b, "    types {\n        application/atom+xml atom.xml;\n    }\n\n")
+	if s.authUser != "" {
+		fmt.Fprintf(&b, "    auth_basic \"Private gallery\";\n    auth_basic_user_file %s;\n\n", s.authFile)
+	}
+	for _, h := range s.headers {
+		fmt.Fprintf(&b, "    add_header %s \"%s\" always;\n", h[0], h[1])
+	}
+	if len(s.headers) > 0 {
+		b.WriteString("\n")
+	}
+
+	fmt.Fprintf(&b, "    # the build's own files (manifest, incremental state ...)\n    location ~ /\\. {\n        deny all;\n    }\n\n")
+	fmt.Fprintf(&b, "    location / {\n        try_files $uri $uri.html $uri/ =404;\n        add_header Cache-Control \"no-cache\";\n%s    }\n\n", s.nginxHeaders())
+	fmt.Fprintf(&b, "    location ~ %s {\n        add_header Cache-Control \"public, max-age=%d, immutable\";\n%s    }\n\n", s.assetRegex, longCacheSeconds, s.nginxHeaders())
+	fmt.Fprintf(&b, "    location /%s/ {\n        add_header Cache-Control \"public, max-age=%d\";\n%s    }\n}\n", imagesDir, longCacheSeconds, s.nginxHeaders())
+
+	return b.String()
+}
+
+// return the security headers again for an nginx location block (add_header in a location replaces the server's)
+func (s serverSite) nginxHeaders() string {
+	var b strings.Builder
+	for _, h := range s.headers {
+		fmt.Fprintf(&b, "        add_header %s \"%s\" always;\n", h[0], h[1])
+	}
+
+	return b.String()
+}
+
+// return the Apache virtual host
+func (s serverSite) apache() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# Apache configuration for the generated gallery (needs mod_headers and mod_rewrite)\n<VirtualHost *:80>\n")
+	if s.host != "" {
+		fmt.Fprintf(&b, "    ServerName %s\n", s.host)
+	}
+	fmt.Fprintf(&b, "    DocumentRoot \"%s\"\n    DirectoryIndex index.html\n    AddType application/atom+xml .atom.xml\n\n", s.root)
+
+	fmt.Fprintf(&b, "    <Directory \"%s\">\n        Options -Indexes\n        AllowOverride None\n", s.root)
+	if s.authUser != "" {
+		fmt.Fprintf(&b, "        AuthType Basic\n        AuthName \"Private gallery\"\n        AuthUserFile \"%s\"\n        Require valid-user\n", s.authFile)
+	} else {
+		b.WriteString("        Require all granted\n")
+	}
+	b.WriteString("\n        # clean page URLs: /Canon serves Canon.html\n        RewriteEngine On\n")
+	b.WriteString("        RewriteCond %{REQUEST_FILENAME} !-f\n        RewriteCond %{REQUEST_FILENAME}.html -f\n        RewriteRule ^(.+)$ $1.html [L]\n")
+	b.WriteString("    </Directory>\n\n")
+
+	b.WriteString("    # the build's own files (manifest, incremental state ...)\n    <FilesMatch \"^\\.\">\n        Require all denied\n    </FilesMatch>\n\n")
+	for _, h := range s.headers {
+		fmt.Fprintf(&b, "    Header always set %s \"%s\"\n", h[0], h[1])
+	}
+	fmt.Fprintf(&b, "    Header set Cache-Control \"no-cache\"\n")
+	fmt.Fprintf(&b, "    <FilesMatch \"%s\">\n        Header set Cache-Control \"public, max-age=%d, immutable\"\n    </FilesMatch>\n", s.assetRegex, longCacheSeconds)
+	fmt.Fprintf(&b, "    <LocationMatch \"^/%s/\">\n        Header set Cache-Control \"public, max-age=%d\"\n    </LocationMatch>\n", imagesDir, longCacheSeconds)
+	b.WriteString("</VirtualHost>\n")
+
+	return b.String()
+}
+
+// return the Caddy site block
+func (s serverSite) caddy() string {
+	var b strings.Builder
+	address := s.host
+	if address == "" {
+		address = ":80"
+	}
+
+	fmt.Fprintf(&b, "# Caddy configuration for the generated gallery - import from the Caddyfile\n%s {\n    root * %s\n", address, s.root)
+	fmt.Fprintf(&b, "    try_files {path} {path}.html {path}/\n    file_server\n\n")
+	if s.authUser != "" {
+		// Caddy only takes bcrypt hashes, which the build can't make - the hash comes from the environment
+		fmt.Fprintf(&b, "    # set IMGPROC_CADDY_PASSWORD_HASH to the output of: caddy hash-password\n")
+		fmt.Fprintf(&b, "    basic_auth {\n        %s {$IMGPROC_CADDY_PASSWORD_HASH}\n    }\n\n", s.authUser)
+	}
+
+	fmt.Fprintf(&b, "    # the build's own files (manifest, incremental state ...)\n    @hidden path */.*\n    respond @hidden 404\n\n")
+	fmt.Fprintf(&b, "    @feeds path *.atom.xml\n    header @feeds Content-Type application/atom+xml\n\n")
+	if len(s.headers) > 0 {
+		b.WriteString("    header {\n")
+		for _, h := range s.headers {
+			fmt.Fprintf(&b, "        %s \"%s\"\n", h[0], h[1])
+		}
+		b.WriteString("    }\n")
+	}
+	fmt.Fprintf(&b, "    header Cache-Control \"no-cache\"\n")
+	fmt.Fprintf(&b, "    @immutable path_regexp %s\n    header @immutable Cache-Control \"public, max-age=%d, immutable\"\n", s.assetRegex, longCacheSeconds)
+	fmt.Fprintf(&b, "    @images path /%s/*\n    header @images Cache-Control \"public, max-age=%d\"\n}\n", imagesDir, longCacheSeconds)
+
+	return b.String()
+}
+
+//----------------- htpasswd -------------------------------
+
+// alphabet of crypt's base-64 encoding
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// return the password hashed with Apache's MD5 crypt ($apr1$), which both Apache and nginx accept in htpasswd files
+func apr1Hash(password string) (string, error) {
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	salt := make([]byte, 8)
+	for i, c := range raw {
+		salt[i] = cryptAlphabet[int(c)%len(cryptAlphabet)]
+	}
+
+	return apr1Crypt(password, string(salt)), nil
+}
+
+// return the $apr1$ hash of the password with the given salt
+func apr1Crypt(password, salt string) string {
+	const magic = "$apr1$"
+	pw := []byte(password)
+
+	alt := md5.Sum([]byte(password + salt + password))
+
+	ctx := md5.New()
+	ctx.Write([]byte(password + magic + salt))
+	for n := len(pw); n > 0; n -= 16 {
+		if n > 16 {
+			ctx.Write(alt[:])
+		} else {
+			ctx.Write(alt[:n])
+		}
+	}
+	for n := len(pw); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	sum := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(sum)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 != 0 {
+			round.Write(sum)
+		} else {
+			round.Write(pw)
+		}
+		sum = round.Sum(nil)
+	}
+
+	var b strings.Builder
+	b.WriteString(magic + salt + "$")
+	encode := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			b.WriteByte(cryptAlphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint32(sum[g[0]])<<16|uint32(sum[g[1]])<<8|uint32(sum[g[2]]), 4)
+	}
+	encode(uint32(sum[11]), 2)
+
+	return b.String()
+}
@@ -0,0 +1,166 @@
+// description shortcodes: the descriptions of the overrides file (see Overrides.go) can embed works with a small shortcode syntax,
+// expanded when the page is generated into linked thumbnails within the text (see Markdown.go):
+//
+//	{{work 123}}                        the work with id 123
+//	{{gallery tag=street}}              the works tagged street, 12 at most
+//	{{gallery make=Canon limit=4}}      the first 4 works taken with a Canon
+//	{{gallery tag="street art" author="Jane Doe"}}
+//
+// A gallery takes any of tag, make, model, lens and author (a work has to match all of them, names compared without case) and a
+// limit. Shortcodes naming a work or filter the catalog doesn't have are left out with a warning, and the meta description of the
+// page gets the text without any. Text in double braces that isn't a work or gallery shortcode is shown as it is.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// default number of works a gallery shortcode shows
+const defaultShortcodeLimit = 12
+
+// a shortcode: its name and its arguments, bare or key=value with the value optionally in double quotes
+var shortcodePattern = regexp.MustCompile(`\{\{\s*([a-z]+)((?:\s+(?:[a-z]+=)?(?:"[^"]*"|[^\s"{}]+))*)\s*\}\}`)
+
+// one argument of a shortcode
+var shortcodeArgPattern = regexp.MustCompile(`(?:([a-z]+)=)?("[^"]*"|[^\s"]+)`)
+
+// stands in for an expanded shortcode in the description text while its Markdown is rendered (a private use character)
+const shortcodeMark = "\uE000"
+
+// type struct representing the arguments of a shortcode
+type shortcodeArgs struct {
+	positional []string
+	named      map[string]string
+}
+
+// return the arguments of a shortcode, from the text after its name
+func parseShortcodeArgs(text string) shortcodeArgs {
+	args := shortcodeArgs{named: map[string]string{}}
+	for _, m := range shortcodeArgPattern.FindAllStringSubmatch(text, -1) {
+		value := strings.Trim(m[2], `"`)
+		if m[1] == "" {
+			args.positional = append(args.positional, value)
+		} else {
+			args.named[m[1]] = value
+		}
+	}
+
+	return args
+}
+
+// return a description with each of its shortcodes replaced by shortcodeMark, and the thumbnails each of them expanded into -
+// shortcodes that can't be expanded are left out
+func (c *Catalog) expandShortcodes(description string) (string, [][]galleryItem) {
+	var expanded [][]galleryItem
+	text := shortcodePattern.ReplaceAllStringFunc(description, func(code string) string {
+		m := shortcodePattern.FindStringSubmatch(code)
+		if m[1] != "work" && m[1] != "gallery" {
+			return code
+		}
+
+		works, err := c.shortcodeWorks(m[1], parseShortcodeArgs(m[2]))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Leaving out description shortcode %s: %v\n", code, err)
+			return ""
+		}
+
+		var items []galleryItem
+		for _, wk := range works {
+			image := workImage(wk, "small")
+			image.Alt = wk.FileName
+			items = append(items, galleryItem{Page: wk.pageURL(), Image: image, Work: wk})
+		}
+		expanded = append(expanded, items)
+		return shortcodeMark
+	})
+
+	return text, expanded
+}
+
+// return the works a work or gallery shortcode shows
+func (c *Catalog) shortcodeWorks(name string, args shortcodeArgs) ([]*Work, error) {
+	if name == "work" {
+		if len(args.positional) != 1 || len(args.named) > 0 {
+			return nil, fmt.Errorf("it takes a work id")
+		}
+
+		id, err := parseWorkID(args.positional[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, wk := range c.Works {
+			if wk != nil && wk.ID == id {
+				return []*Work{wk}, nil
+			}
+		}
+
+		return nil, fmt.Errorf("there's no work %s", id)
+	}
+
+	if len(args.positional) > 0 {
+		return nil, fmt.Errorf("its arguments are key=value pairs")
+	}
+
+	limit := defaultShortcodeLimit
+	for key, value := range args.named {
+		switch key {
+		case "limit":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("limit %q isn't a positive number", value)
+			}
+			limit = n
+		case "tag", "make", "model", "lens", "author":
+		default:
+			return nil, fmt.Errorf("there's no gallery filter %q", key)
+		}
+	}
+
+	var works []*Work
+	for _, wk := range c.Works {
+		if wk != nil && len(works) < limit && shortcodeMatches(wk, args.named) {
+			works = append(works, wk)
+		}
+	}
+	if len(works) == 0 {
+		return nil, fmt.Errorf("no work matches it")
+	}
+
+	return works, nil
+}
+
+// return whether a work matches every filter of a gallery shortcode
+func shortcodeMatches(wk *Work, filters map[string]string) bool {
+	same := func(a, b string) bool { return strings.EqualFold(lookupName(a), lookupName(b)) }
+
+	for key, value := range filters {
+		ok := false
+		switch key {
+		case "tag":
+			for _, tag := range wk.Tags {
+				ok = ok || same(tag, value)
+			}
+		case "make":
+			ok = wk.WMake != nil && (same(wk.WMake.Name, value) || same(wk.WMake.DisplayName, value))
+		case "model":
+			ok = wk.WModel != nil && (same(wk.WModel.Name, value) || same(wk.WModel.DisplayName, value))
+		case "lens":
+			ok = wk.Lens != nil && same(wk.Lens.Name, value)
+		case "author":
+			ok = wk.Author != nil && same(wk.Author.Name, value)
+		default:
+			ok = true // limit
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,436 @@
+// signed URLs: image URIs on protected hosts (private S3 buckets, CloudFront distributions with trusted signers, CDNs checking an
+// HMAC token) are signed at build time, so the static pages can reference them and image downloads/probes can fetch them. Signers are
+// configured per host pattern in the config file's "signed_urls" section, e.g.
+//
+//	"signed_urls": [
+//	  {"hosts": "photos-private.s3.eu-west-1.amazonaws.com", "type": "s3", "region": "eu-west-1",
+//	   "access_key_id_env": "AWS_ACCESS_KEY_ID", "secret_access_key_env": "AWS_SECRET_ACCESS_KEY", "expires": "168h"},
+//	  {"hosts": "d111111abcdef8.cloudfront.net", "type": "cloudfront", "key_pair_id": "K2JCJMDEHXQW5F", "private_key_file": "cf-key.pem"},
+//	  {"hosts": "*.img.example.com", "type": "hmac", "secret_env": "IMG_TOKEN_SECRET", "signature_param": "token"}
+//	]
+//
+// Signatures are computed from the start of the current hour, so a page links an image under the same URL however often it's
+// rebuilt within the hour (and browsers can cache it) - a link stays valid for "expires" (default 24h) less up to an hour.
+
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how long signed links stay valid unless a signer sets "expires"
+const defaultSignedURLExpiry = 24 * time.Hour
+
+// longest validity S3 accepts for a presigned URL
+const s3MaxExpiry = 7 * 24 * time.Hour
+
+// type struct representing one signer entry in the config file
+type signedURLConfig struct {
+	Hosts   string `json:"hosts"`   // host name pattern the signer applies to (* matches within a name label, e.g. "*.example.com")
+	Type    string `json:"type"`    // s3, cloudfront or hmac
+	Expires string `json:"expires"` // how long links stay valid (a Go duration such as "24h" - the default)
+
+	// s3: SigV4 presigned GET URLs
+	Region             string `json:"region"` // bucket region (default us-east-1)
+	AccessKeyID        string `json:"access_key_id"`
+	AccessKeyIDEnv     string `json:"access_key_id_env"` // environment variable holding the access key id instead
+	SecretAccessKey    string `json:"secret_access_key"`
+	SecretAccessKeyEnv string `json:"secret_access_key_env"` // environment variable holding the secret key instead
+	SessionTokenEnv    string `json:"session_token_env"`     // environment variable holding a session token (temporary credentials)
+
+	// cloudfront: canned-policy signed URLs
+	KeyPairID      string `json:"key_pair_id"`      // id of the public key registered with the distribution's key group
+	PrivateKeyFile string `json:"private_key_file"` // PEM RSA private key of the key pair
+	PrivateKeyEnv  string `json:"private_key_env"`  // environment variable holding the PEM private key instead
+
+	// hmac: an HMAC of the path and sorted query string (expiry included), appended as a query parameter
+	Secret         string `json:"secret"`
+	SecretEnv      string `json:"secret_env"`      // environment variable holding the secret instead
+	Algorithm      string `json:"algorithm"`       // sha256 (default), sha1 or sha512
+	Encoding       string `json:"encoding"`        // hex (default) or base64url
+	ExpiresParam   string `json:"expires_param"`   // query parameter carrying the expiry as a Unix time (default "expires")
+	SignatureParam string `json:"signature_param"` // query parameter carrying the signature (default "signature")
+	KeyID          string `json:"key_id"`          // optional key id sent along (and signed), for hosts that rotate secrets
+	KeyIDParam     string `json:"key_id_param"`    // query parameter carrying the key id (default "key")
+}
+
+// a urlSigner adds a signature (and expiry) to a URL on its host
+type urlSigner interface {
+	sign(u *url.URL, signedAt time.Time) (string, error)
+}
+
+// type struct representing a configured signer together with the hosts it applies to
+type hostSigner struct {
+	hosts  string
+	signer urlSigner
+}
+
+// signers configured for this build, tried in config order
+var urlSigners []hostSigner
+
+// set up the signers described in the configuration
+func configureURLSigning(configs []signedURLConfig) error {
+	urlSigners = nil
+
+	for i, c := range configs {
+		if c.Hosts == "" {
+			return fmt.Errorf("Error in signed_urls config entry %d: hosts is required", i+1)
+		}
+		if _, err := path.Match(strings.ToLower(c.Hosts), ""); err != nil {
+			return fmt.Errorf("Error in signed_urls config entry %d: bad hosts pattern %q: %v", i+1, c.Hosts, err)
+		}
+
+		expiry := defaultSignedURLExpiry
+		if c.Expires != "" {
+			d, err := time.ParseDuration(c.Expires)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("Error in signed_urls config entry %d: bad expires %q (e.g. 24h)", i+1, c.Expires)
+			}
+			expiry = d
+		}
+
+		var s urlSigner
+		switch c.Type {
+		case "s3":
+			signer := &s3Signer{
+				region:       c.Region,
+				accessKeyID:  secretValue(c.AccessKeyID, c.AccessKeyIDEnv),
+				secretKey:    secretValue(c.SecretAccessKey, c.SecretAccessKeyEnv),
+				sessionToken: secretValue("", c.SessionTokenEnv),
+				expiry:       expiry,
+			}
+			if signer.region == "" {
+				signer.region = "us-east-1"
+			}
+			if signer.accessKeyID == "" || signer.secretKey == "" {
+				return fmt.Errorf("Error in signed_urls config entry %d: s3 signing needs an access key id and secret access key", i+1)
+			}
+			if expiry > s3MaxExpiry {
+				return fmt.Errorf("Error in signed_urls config entry %d: S3 presigned URLs can't be valid for more than %s", i+1, s3MaxExpiry)
+			}
+			s = signer
+
+		case "cloudfront":
+			pemData := []byte(secretValue("", c.PrivateKeyEnv))
+			if c.PrivateKeyFile != "" {
+				data, err := os.ReadFile(c.PrivateKeyFile)
+				if err != nil {
+					return fmt.Errorf("Error in signed_urls config entry %d: %v", i+1, err)
+				}
+				pemData = data
+			}
+
+			key, err := parseRSAPrivateKey(pemData)
+			if err != nil {
+				return fmt.Errorf("Error in signed_urls config entry %d: %v", i+1, err)
+			}
+			if c.KeyPairID == "" {
+				return fmt.Errorf("Error in signed_urls config entry %d: cloudfront signing needs key_pair_id", i+1)
+			}
+			s = &cloudFrontSigner{keyPairID: c.KeyPairID, key: key, expiry: expiry}
+
+		case "hmac":
+			signer := &hmacSigner{
+				secret:         []byte(secretValue(c.Secret, c.SecretEnv)),
+				encoding:       c.Encoding,
+				expiresParam:   c.ExpiresParam,
+				signatureParam: c.SignatureParam,
+				keyID:          c.KeyID,
+				keyIDParam:     c.KeyIDParam,
+				expiry:         expiry,
+			}
+			if len(signer.secret) == 0 {
+				return fmt.Errorf("Error in signed_urls config entry %d: hmac signing needs a secret", i+1)
+			}
+
+			switch c.Algorithm {
+			case "", "sha256":
+				signer.hash = sha256.New
+			case "sha1":
+				signer.hash = sha1.New
+			case "sha512":
+				signer.hash = sha512.New
+			default:
+				return fmt.Errorf("Error in signed_urls config entry %d: unknown algorithm %q (expected sha256, sha1 or sha512)", i+1, c.Algorithm)
+			}
+			if signer.encoding == "" {
+				signer.encoding = "hex"
+			}
+			if signer.encoding != "hex" && signer.encoding != "base64url" {
+				return fmt.Errorf("Error in signed_urls config entry %d: unknown encoding %q (expected hex or base64url)", i+1, c.Encoding)
+			}
+			if signer.expiresParam == "" {
+				signer.expiresParam = "expires"
+			}
+			if signer.signatureParam == "" {
+				signer.signatureParam = "signature"
+			}
+			if signer.keyIDParam == "" {
+				signer.keyIDParam = "key"
+			}
+			s = signer
+
+		default:
+			return fmt.Errorf("Error in signed_urls config entry %d: unknown type %q (expected s3, cloudfront or hmac)", i+1, c.Type)
+		}
+
+		urlSigners = append(urlSigners, hostSigner{hosts: strings.ToLower(c.Hosts), signer: s})
+	}
+
+	return nil
+}
+
+// return the URI signed by the first signer configured for its host - URIs on other hosts (and relative ones) are returned as they are,
+// and so is a URI that can't be signed, with a warning
+func signURL(uri string) string {
+	if len(urlSigners) == 0 || (!strings.HasPrefix(uri, "http://") && !strings.HasPrefix(uri, "https://")) {
+		return uri
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, hs := range urlSigners {
+		if ok, _ := path.Match(hs.hosts, host); !ok {
+			continue
+		}
+
+		signed, err := hs.signer.sign(u, time.Now().UTC().Truncate(time.Hour))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error signing %s: %v\n", uri, err)
+			return uri
+		}
+
+		return signed
+	}
+
+	return uri
+}
+
+//----------------- S3 -------------------------------
+
+// type struct representing an AWS Signature Version 4 query-string signer for S3 GET requests
+type s3Signer struct {
+	region       string
+	accessKeyID  string
+	secretKey    string
+	sessionToken string
+	expiry       time.Duration
+}
+
+func (s *s3Signer) sign(u *url.URL, signedAt time.Time) (string, error) {
+	amzDate := signedAt.Format("20060102T150405Z")
+	day := signedAt.Format("20060102")
+	scope := day + "/" + s.region + "/s3/aws4_request"
+
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.accessKeyID+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(s.expiry/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if s.sessionToken != "" {
+		query.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	canonicalQuery := awsQueryEncode(query)
+	canonicalRequest := strings.Join([]string{
+		"GET",
+		awsPathEncode(u.EscapedPath()),
+		canonicalQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSum(sha256.New, []byte("AWS4"+s.secretKey), day)
+	key = hmacSum(sha256.New, key, s.region)
+	key = hmacSum(sha256.New, key, "s3")
+	key = hmacSum(sha256.New, key, "aws4_request")
+	signature := hex.EncodeToString(hmacSum(sha256.New, key, stringToSign))
+
+	signed := *u
+	signed.RawQuery = canonicalQuery + "&X-Amz-Signature=" + signature
+	return signed.String(), nil
+}
+
+// return the canonical (RFC 3986, sorted) query string SigV4 signs
+func awsQueryEncode(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// return the canonical URI path SigV4 signs: each segment decoded and re-encoded with RFC 3986 rules (S3 paths aren't normalized)
+func awsPathEncode(escaped string) string {
+	if escaped == "" {
+		return "/"
+	}
+
+	segments := strings.Split(escaped, "/")
+	for i, seg := range segments {
+		if raw, err := url.PathUnescape(seg); err == nil {
+			seg = raw
+		}
+		segments[i] = awsEscape(seg)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// percent-encode everything but the RFC 3986 unreserved characters
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func hmacSum(h func() hash.Hash, key []byte, message string) []byte {
+	mac := hmac.New(h, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+//----------------- CloudFront -------------------------------
+
+// type struct representing a CloudFront canned-policy URL signer
+type cloudFrontSigner struct {
+	keyPairID string
+	key       *rsa.PrivateKey
+	expiry    time.Duration
+}
+
+func (s *cloudFrontSigner) sign(u *url.URL, signedAt time.Time) (string, error) {
+	expires := strconv.FormatInt(signedAt.Add(s.expiry).Unix(), 10)
+	resource := u.String()
+
+	// the canned policy has to be exactly this JSON, without whitespace, for CloudFront to rebuild and check it
+	policy := `{"Statement":[{"Resource":"` + resource + `","Condition":{"DateLessThan":{"AWS:EpochTime":` + expires + `}}}]}`
+
+	digest := sha1.Sum([]byte(policy))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA1, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	// CloudFront's URL-safe base64: + becomes -, = becomes _ and / becomes ~
+	encoded := strings.NewReplacer("+", "-", "=", "_", "/", "~").Replace(base64.StdEncoding.EncodeToString(sig))
+
+	separator := "?"
+	if u.RawQuery != "" {
+		separator = "&"
+	}
+
+	return resource + separator + "Expires=" + expires + "&Signature=" + encoded + "&Key-Pair-Id=" + url.QueryEscape(s.keyPairID), nil
+}
+
+// parse a PEM RSA private key in PKCS #1 or PKCS #8 form
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM private key found (set private_key_file or private_key_env)")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unreadable private key: %v", err)
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("CloudFront signing needs an RSA private key")
+	}
+
+	return key, nil
+}
+
+//----------------- HMAC -------------------------------
+
+// type struct representing a generic HMAC query-string signer
+type hmacSigner struct {
+	secret         []byte
+	hash           func() hash.Hash
+	encoding       string
+	expiresParam   string
+	signatureParam string
+	keyID          string
+	keyIDParam     string
+	expiry         time.Duration
+}
+
+// the signed message is the URL path, "?" and the query string sorted by parameter name (expiry and key id included), e.g.
+// "/photos/1.jpg?expires=1700000000&w=800"
+func (s *hmacSigner) sign(u *url.URL, signedAt time.Time) (string, error) {
+	query := u.Query()
+	query.Set(s.expiresParam, strconv.FormatInt(signedAt.Add(s.expiry).Unix(), 10))
+	if s.keyID != "" {
+		query.Set(s.keyIDParam, s.keyID)
+	}
+
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+
+	encodedQuery := query.Encode()
+	mac := hmacSum(s.hash, s.secret, p+"?"+encodedQuery)
+
+	signature := hex.EncodeToString(mac)
+	if s.encoding == "base64url" {
+		signature = base64.RawURLEncoding.EncodeToString(mac)
+	}
+
+	signed := *u
+	signed.RawQuery = encodedQuery + "&" + url.QueryEscape(s.signatureParam) + "=" + signature
+	return signed.String(), nil
+}
@@ -0,0 +1,216 @@
+// site profiles: several sites generated in one run from a single parsed catalog, each with its own selection of works, stylesheet
+// theme, base URL, layouts and output directory - e.g. a curated public site next to an internal archive of everything. Profiles are
+// listed in the config file's "sites" section, and the build then only takes the works source:
+//
+//	"sites": [
+//	  {"name": "public", "output": "out/public", "base_url": "https://photos.example.com/", "theme": "themes/light.css",
+//	   "index_selection": "featured", "filter": {"featured_only": true, "exclude_makes": ["Sony"]}},
+//	  {"name": "archive", "output": "out/archive", "layouts": {"make": {"limit": 0, "per_page": 48}}}
+//	]
+//
+//	>go run ImageProcessor --config sites.json http://localhost/test/api/v1/works.xml
+//
+// --sites public,archive builds only the named profiles.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// type struct representing one site profile in the config file - settings left out are taken from the command line
+type siteProfile struct {
+	Name           string                     `json:"name"`
+	Output         string                     `json:"output"`          // output directory of the site
+	BaseURL        string                     `json:"base_url"`        // public URL the site is served from, for canonical links
+	Theme          string                     `json:"theme"`           // theme directory or CSS file (see Theme.go)
+	IndexSelection string                     `json:"index_selection"` // which works the homepage shows: first, recent, random, featured or popular
+	Layouts        map[string]json.RawMessage `json:"layouts"`         // gallery layout overrides, on top of the top-level "layouts"
+	Filter         siteFilter                 `json:"filter"`          // which works the site shows (all of them if empty)
+}
+
+// type struct representing the works a site profile shows - a work has to pass every condition that's set
+type siteFilter struct {
+	Makes          []string `json:"makes"`           // only works by these makes (case-insensitive)
+	Models         []string `json:"models"`          // only works by these models
+	ExcludeMakes   []string `json:"exclude_makes"`   // no works by these makes
+	ExcludeModels  []string `json:"exclude_models"`  // no works by these models
+	ExcludeIDs     []WorkID `json:"exclude_ids"`     // no works with these ids
+	ExcludeGeneric bool     `json:"exclude_generic"` // no works without a make
+	FeaturedOnly   bool     `json:"featured_only"`   // only works flagged <featured>
+	From           string   `json:"from"`            // only works captured on or after this date (works without a date are left out)
+	Until          string   `json:"until"`           // only works captured on or before this date
+}
+
+// type struct representing a site profile ready to build
+type siteBuild struct {
+	name   string
+	output string
+	opts   *buildOptions
+	keep   func(ew exportWork) bool
+}
+
+// return the builds of the named profiles (all of them if only is empty), with their options derived from base
+func prepareSites(profiles []siteProfile, base *buildOptions, topLayouts map[string]json.RawMessage, only []string) ([]siteBuild, error) {
+	wanted := map[string]bool{}
+	for _, name := range only {
+		wanted[name] = true
+	}
+
+	var builds []siteBuild
+	names, outputs := map[string]bool{}, map[string]string{}
+
+	for i, p := range profiles {
+		if p.Name == "" || p.Output == "" {
+			return nil, fmt.Errorf("Error in sites config entry %d: name and output are required", i+1)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("Error in sites config: more than one site is named %q", p.Name)
+		}
+		if other, ok := outputs[p.Output]; ok {
+			return nil, fmt.Errorf("Error in sites config: sites %q and %q both write to %s", other, p.Name, p.Output)
+		}
+		names[p.Name] = true
+		outputs[p.Output] = p.Name
+
+		if len(wanted) > 0 && !wanted[p.Name] {
+			continue
+		}
+
+		opts := *base
+		opts.Layouts = defaultLayouts()
+		if err := applyLayoutConfig(opts.Layouts, topLayouts); err != nil {
+			return nil, err
+		}
+		if err := applyLayoutConfig(opts.Layouts, p.Layouts); err != nil {
+			return nil, fmt.Errorf("Error in site %s: %v", p.Name, err)
+		}
+
+		if p.BaseURL != "" {
+			opts.BaseURL = p.BaseURL
+		}
+		if p.Theme != "" {
+			opts.Theme = p.Theme
+		}
+		if p.IndexSelection != "" {
+			opts.IndexSelection = p.IndexSelection
+		}
+
+		keep, err := p.Filter.compile()
+		if err != nil {
+			return nil, fmt.Errorf("Error in site %s: %v", p.Name, err)
+		}
+
+		builds = append(builds, siteBuild{name: p.Name, output: p.Output, opts: &opts, keep: keep})
+	}
+
+	for _, name := range only {
+		if !names[name] {
+			return nil, fmt.Errorf("Error: no site named %q in the config's sites section", name)
+		}
+	}
+
+	return builds, nil
+}
+
+// parse the works feed once and build every site from it
+func buildSites(feed io.Reader, sites []siteBuild, snapshot string) error {
+	catalog, err := parseFeed(feed, snapshot)
+	if err != nil {
+		return err
+	}
+
+	return buildSiteCatalogs(catalog, sites)
+}
+
+// build every site from the catalog - each site gets its own copy of the catalog holding the works its filter lets through, since the
+// build stages fill in local image paths relative to the site's output directory
+func buildSiteCatalogs(catalog *Catalog, sites []siteBuild) error {
+	works := exportWorks(catalog)
+
+	for _, s := range sites {
+		var selected []exportWork
+		for _, ew := range works {
+			if s.keep(ew) {
+				selected = append(selected, ew)
+			}
+		}
+
+		fmt.Printf("Building site %s (%d of %d works) into <./%s>\n", s.name, len(selected), len(works), s.output)
+
+		siteCatalog, err := catalogFromExport(selected)
+		if err != nil {
+			return fmt.Errorf("Error building site %s: %v", s.name, err)
+		}
+
+		if err := buildCatalog(siteCatalog, s.output, s.opts); err != nil {
+			return fmt.Errorf("Error building site %s: %w", s.name, err)
+		}
+	}
+
+	return nil
+}
+
+// return the filter as a predicate on works
+func (f siteFilter) compile() (func(ew exportWork) bool, error) {
+	var from, until time.Time
+	var err error
+
+	if f.From != "" {
+		if from, err = parseDate(f.From); err != nil {
+			return nil, fmt.Errorf("bad filter date %q: %v", f.From, err)
+		}
+	}
+	if f.Until != "" {
+		if until, err = parseDate(f.Until); err != nil {
+			return nil, fmt.Errorf("bad filter date %q: %v", f.Until, err)
+		}
+		// a date without a time of day takes in the whole day
+		if len(strings.TrimSpace(f.Until)) == len("2006-01-02") {
+			until = until.Add(24*time.Hour - time.Nanosecond)
+		}
+	}
+
+	makes, models := foldedSet(f.Makes), foldedSet(f.Models)
+	excludeMakes, excludeModels := foldedSet(f.ExcludeMakes), foldedSet(f.ExcludeModels)
+	excludeIDs := map[WorkID]bool{}
+	for _, id := range f.ExcludeIDs {
+		excludeIDs[id] = true
+	}
+
+	return func(ew exportWork) bool {
+		mk, md := strings.ToLower(ew.Make), strings.ToLower(ew.Model)
+
+		switch {
+		case len(makes) > 0 && !makes[mk], len(models) > 0 && !models[md]:
+			return false
+		case excludeMakes[mk] && mk != "", excludeModels[md] && md != "", excludeIDs[ew.ID]:
+			return false
+		case f.ExcludeGeneric && ew.Make == "", f.FeaturedOnly && !ew.Featured:
+			return false
+		}
+
+		if !from.IsZero() || !until.IsZero() {
+			date, err := parseDate(ew.Date)
+			if err != nil || (!from.IsZero() && date.Before(from)) || (!until.IsZero() && date.After(until)) {
+				return false
+			}
+		}
+
+		return true
+	}, nil
+}
+
+// return the set of the given names, lowercased
+func foldedSet(names []string) map[string]bool {
+	set := map[string]bool{}
+	for _, name := range names {
+		set[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+
+	return set
+}
@@ -0,0 +1,65 @@
+// slideshows: beside each make and model gallery page, a <page>.slideshow.html (e.g. Canon.slideshow.html) shows the page's works
+// one medium image at a time, for kiosk-style browsing. It plays by itself, moving on every --slideshow-interval seconds and
+// starting over after the last work; the arrow keys (or the buttons below the image) step back and forth, Home and End jump to the
+// first and last work, and the space bar pauses and resumes:
+//
+//	>go run ImageProcessor --slideshow-interval 8 http://localhost/test/api/v1/works.xml code/html/output
+//
+// --slideshow-interval 0 leaves the slideshow still until a key or button moves it. Themes without a "slideshow" template (made
+// before slideshows were added) get no slideshow pages.
+
+package main
+
+import "fmt"
+
+// default seconds between slides while a slideshow plays (--slideshow-interval)
+const defaultSlideshowInterval = 5
+
+// return the file name of the slideshow of a make or model page
+func slideshowFile(pageURL string) string {
+	return pageURL + ".slideshow.html"
+}
+
+// write the slideshow of every make and model - pages that can't be written are recorded with the site writer
+func generateSlideshows(site *siteWriter, makes []*Make, assets *siteAssets, interval int) {
+	if site.templates.Lookup("slideshow") == nil {
+		return
+	}
+
+	for _, mk := range makes {
+		if mk == nil {
+			continue
+		}
+
+		site.writeSlideshow(mk.PageURL, worksByMake(mk.Works, mk), &pageView{Title: "Slideshow: " + titleOr(mk.Title, "All photos taken with a "+mk.DisplayName), Assets: assets, Make: mk, SlideInterval: interval})
+
+		for _, md := range mk.Models {
+			if md != nil {
+				site.writeSlideshow(md.PageURL, worksByMake(md.Works, mk), &pageView{Title: "Slideshow: " + titleOr(md.Title, "All photos taken with a "+md.DisplayName), Assets: assets, Make: mk, Model: md, SlideInterval: interval})
+			}
+		}
+	}
+}
+
+// write the slideshow of the works of a make or model page, one slide per work with its medium image
+func (s *siteWriter) writeSlideshow(pageURL string, works []*Work, view *pageView) {
+	for _, wk := range works {
+		if wk == nil {
+			continue
+		}
+
+		image := workImage(wk, "medium")
+		image.Alt = wk.FileName
+		if len(view.Slides) > 0 {
+			image.Loading = "lazy" // hidden until shown, so the browser only fetches the slides it gets to
+		}
+		view.Slides = append(view.Slides, galleryItem{Page: wk.pageURL(), Image: image, Work: wk})
+	}
+
+	file := slideshowFile(pageURL)
+	view.Canonical = s.canonical(file)
+
+	if err := s.writeView(file, "slideshow", view); err != nil {
+		s.fail(fmt.Errorf("Error writing output to slideshow HTML file (%s): %v", file, err))
+	}
+}
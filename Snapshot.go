@@ -0,0 +1,254 @@
+// catalog snapshots: --snapshot writes the catalog, as parsed from the feed, to a compact gob file; a later build with
+// --from-snapshot reads it back instead of fetching and parsing the feed, so re-rendering after a template or theme change is quick:
+//
+//	>go run ImageProcessor --snapshot works.snap http://localhost/test/api/v1/works.xml code/html/output
+//	>go run ImageProcessor --from-snapshot works.snap --theme mytheme code/html/output
+//
+// The snapshot holds the feed's data only - exclusions, overrides, downloads and the rest of the build are applied to it again.
+
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// version of the snapshot format, bumped when a change makes older snapshots unreadable
+const snapshotVersion = 2
+
+// type struct representing a catalog snapshot file - the catalog's pointers are stored as indexes, so the make/model tree comes back
+// exactly as the feed built it
+type catalogSnapshot struct {
+	Version int
+	Created time.Time
+	Works   []snapshotWork
+	Makes   []snapshotMake
+	WorksSM []int // indexes into Works
+}
+
+// type struct representing a work in a snapshot
+type snapshotWork struct {
+	ID         WorkID
+	FileName   string
+	Make       int    // index into the snapshot's Makes (-1 for none)
+	Model      int    // index into the make's Models (-1 for none)
+	Author     string // name of the work's author ("" for none - see Authors.go)
+	Lens       string // name of the work's lens ("" for none - see Lenses.go)
+	Date       time.Time
+	Featured   bool
+	Flagged    bool // see Flagged.go
+	Rating     string
+	Tags       []string
+	Checksums  map[string]string
+	Copyright  string
+	License    string
+	LicenseURL string
+	GPS        *geoPoint // see Places.go
+	Country    string
+	City       string
+	Caption    string
+	URISmall   string
+	URIMedium  string
+	URILarge   string
+
+	// focal length, aperture and ISO (see Facets.go)
+	FocalLength float64
+	Aperture    float64
+	ISO         int
+}
+
+// type struct representing a make in a snapshot
+type snapshotMake struct {
+	Name   string
+	Models []snapshotModel
+	Works  []int // indexes into the snapshot's Works
+}
+
+// type struct representing a model in a snapshot
+type snapshotModel struct {
+	Name  string
+	Works []int
+}
+
+// return the snapshot of the catalog
+func newCatalogSnapshot(catalog *Catalog) *catalogSnapshot {
+	snap := &catalogSnapshot{Version: snapshotVersion, Created: time.Now()}
+
+	workIndex := map[*Work]int{}
+	indexes := func(works []*Work) []int {
+		var list []int
+		for _, wk := range works {
+			if i, ok := workIndex[wk]; ok {
+				list = append(list, i)
+			}
+		}
+		return list
+	}
+
+	makeIndex, modelIndex := map[*Make]int{}, map[*Model]int{}
+	for _, mk := range catalog.Makes {
+		if mk == nil {
+			continue
+		}
+		makeIndex[mk] = len(makeIndex)
+		for i, md := range mk.Models {
+			modelIndex[md] = i
+		}
+	}
+
+	for _, wk := range catalog.Works {
+		if wk == nil {
+			continue
+		}
+		workIndex[wk] = len(snap.Works)
+
+		sw := snapshotWork{ID: wk.ID, FileName: wk.FileName, Make: -1, Model: -1, Date: wk.Date, Featured: wk.Featured, Flagged: wk.Flagged, Rating: wk.Rating, Tags: wk.Tags, Checksums: wk.Checksums, Copyright: wk.Copyright, License: wk.License, LicenseURL: wk.LicenseURL,
+			FocalLength: wk.FocalLength, Aperture: wk.Aperture, ISO: wk.ISO, GPS: wk.GPS, Country: wk.Country, City: wk.City, Caption: wk.Caption, URISmall: wk.URISmall, URIMedium: wk.URIMedium, URILarge: wk.URILarge}
+		if wk.Author != nil {
+			sw.Author = wk.Author.Name
+		}
+		if wk.Lens != nil {
+			sw.Lens = wk.Lens.Name
+		}
+		if i, ok := makeIndex[wk.WMake]; ok {
+			sw.Make = i
+			if j, ok := modelIndex[wk.WModel]; ok {
+				sw.Model = j
+			}
+		}
+
+		snap.Works = append(snap.Works, sw)
+	}
+
+	for _, mk := range catalog.Makes {
+		if mk == nil {
+			continue
+		}
+
+		sm := snapshotMake{Name: mk.Name, Works: indexes(mk.Works)}
+		for _, md := range mk.Models {
+			if md != nil {
+				sm.Models = append(sm.Models, snapshotModel{Name: md.Name, Works: indexes(md.Works)})
+			}
+		}
+		snap.Makes = append(snap.Makes, sm)
+	}
+	snap.WorksSM = indexes(catalog.WorksSM)
+
+	return snap
+}
+
+// return the catalog the snapshot holds
+func (snap *catalogSnapshot) catalog() (*Catalog, error) {
+	catalog := &Catalog{}
+
+	for _, sw := range snap.Works {
+		wk := createWork()
+		wk.ID, wk.FileName, wk.Date, wk.Featured, wk.Tags, wk.Checksums = sw.ID, sw.FileName, sw.Date, sw.Featured, sw.Tags, sw.Checksums
+		wk.Copyright, wk.License, wk.LicenseURL = sw.Copyright, sw.License, sw.LicenseURL
+		wk.GPS, wk.Country, wk.City = sw.GPS, sw.Country, sw.City
+		wk.Caption = sw.Caption
+		wk.Flagged, wk.Rating = sw.Flagged, sw.Rating
+		wk.FocalLength, wk.Aperture, wk.ISO = sw.FocalLength, sw.Aperture, sw.ISO
+		wk.URISmall, wk.URIMedium, wk.URILarge = sw.URISmall, sw.URIMedium, sw.URILarge
+		catalog.Works = append(catalog.Works, wk)
+	}
+
+	works := func(indexes []int) ([]*Work, error) {
+		var list []*Work
+		for _, i := range indexes {
+			if i < 0 || i >= len(catalog.Works) {
+				return nil, fmt.Errorf("work index %d out of range", i)
+			}
+			list = append(list, catalog.Works[i])
+		}
+		return list, nil
+	}
+
+	var err error
+	for _, sm := range snap.Makes {
+		mk := createMake(sm.Name)
+		if mk.Works, err = works(sm.Works); err != nil {
+			return nil, err
+		}
+
+		for _, smd := range sm.Models {
+			md := createModel(smd.Name, mk)
+			if md.Works, err = works(smd.Works); err != nil {
+				return nil, err
+			}
+			mk.Models = append(mk.Models, md)
+		}
+
+		catalog.Makes = append(catalog.Makes, mk)
+	}
+
+	if catalog.WorksSM, err = works(snap.WorksSM); err != nil {
+		return nil, err
+	}
+
+	for i, sw := range snap.Works {
+		catalog.assignAuthor(catalog.Works[i], sw.Author)
+		catalog.assignLens(catalog.Works[i], sw.Lens)
+
+		if sw.Make < 0 {
+			continue
+		}
+		if sw.Make >= len(catalog.Makes) {
+			return nil, fmt.Errorf("make index %d of work %s out of range", sw.Make, sw.ID)
+		}
+
+		mk := catalog.Makes[sw.Make]
+		catalog.Works[i].WMake = mk
+		if sw.Model >= 0 && sw.Model < len(mk.Models) {
+			catalog.Works[i].WModel = mk.Models[sw.Model]
+		}
+	}
+
+	return catalog, nil
+}
+
+// write the catalog to a snapshot file
+func saveSnapshot(catalog *Catalog, path string) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(newCatalogSnapshot(catalog)); err != nil {
+		return fmt.Errorf("Error encoding catalog snapshot: %v", err)
+	}
+
+	if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+		return fmt.Errorf("Error writing catalog snapshot (%s): %v", path, err)
+	}
+
+	fmt.Printf("Catalog snapshot written to %s.\n", path)
+	return nil
+}
+
+// read the catalog back from a snapshot file, noting where it came from on the given writer
+func loadSnapshot(path string, log io.Writer) (*Catalog, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading catalog snapshot: %v", err)
+	}
+	defer f.Close()
+
+	var snap catalogSnapshot
+	if err := gob.NewDecoder(f).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("Error decoding catalog snapshot (%s): %v", path, err)
+	}
+
+	if snap.Version != snapshotVersion {
+		return nil, fmt.Errorf("Error: catalog snapshot %s has format version %d, this build reads version %d - write it again with --snapshot", path, snap.Version, snapshotVersion)
+	}
+
+	catalog, err := snap.catalog()
+	if err != nil {
+		return nil, fmt.Errorf("Error in catalog snapshot (%s): %v", path, err)
+	}
+
+	fmt.Fprintf(log, "Catalog read from snapshot %s (%d works, taken %s).\n", path, len(catalog.Works), snap.Created.Format("2006-01-02 15:04:05"))
+	return catalog, nil
+}
@@ -0,0 +1,171 @@
+// merged works sources: the "merge:" source reads every source listed in the config file's "sources" section - works feeds, or any
+// other source such as "graphql:" or "oai:..." - and builds the site from all their works together:
+//
+//	"sources": ["http://localhost/test/api/v1/works.xml", "archive/2019-works.xml", "graphql:"]
+//
+//	>go run ImageProcessor --config site.json merge: code/html/output
+//
+// The sources are fetched and parsed at the same time, each into its own catalog, and merged in the order they're listed, so the site
+// comes out the same however long each one took. A work whose id an earlier source already has is dropped.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+func init() {
+	registerFeedSource("merge", openMergedFeed)
+}
+
+// sources from the config file merged by the "merge:" source (see Config.go)
+var mergedSources []string
+
+// type struct representing the catalogs of several sources being read at the same time, merged in source order once all are in -
+// safe to add to from several goroutines
+type catalogBuilder struct {
+	mu       sync.Mutex
+	catalogs []*Catalog // by source, nil until the source has been read
+}
+
+// create and return a pointer to a catalog builder for the given number of sources
+func newCatalogBuilder(sources int) *catalogBuilder {
+	return &catalogBuilder{catalogs: make([]*Catalog, sources)}
+}
+
+// record the catalog read from the source at the given position in the list
+func (b *catalogBuilder) add(source int, catalog *Catalog) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.catalogs[source] = catalog
+}
+
+// return the catalogs merged in source order - works keep their order within each source, makes, models, authors and lenses are merged by name, and
+// works whose id an earlier work already has are dropped (their number is returned)
+func (b *catalogBuilder) merge() (*Catalog, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	merged := &Catalog{}
+	seen := map[WorkID]bool{}
+	dropped := 0
+
+	for _, catalog := range b.catalogs {
+		if catalog == nil {
+			continue
+		}
+
+		for _, wk := range catalog.Works {
+			if wk == nil {
+				continue
+			}
+			if seen[wk.ID] {
+				dropped++
+				continue
+			}
+			seen[wk.ID] = true
+			merged.Works = append(merged.Works, wk)
+
+			// credit the work to the merged catalog's author of the same name
+			if wk.Author != nil {
+				name := wk.Author.Name
+				wk.Author = nil
+				merged.assignAuthor(wk, name)
+			}
+			if wk.Lens != nil {
+				name := wk.Lens.Name
+				wk.Lens = nil
+				merged.assignLens(wk, name)
+			}
+
+			if wk.WMake == nil {
+				merged.WorksSM = append(merged.WorksSM, wk)
+				continue
+			}
+
+			// move the work over to the merged catalog's make and model of the same name
+			mk := merged.findOrCreateMake(wk.WMake.Name)
+			wk.WMake = mk
+			if wk.WModel != nil {
+				md := merged.findOrCreateModel(mk, wk.WModel.Name)
+				wk.WModel = md
+				mk.Works = append(mk.Works, wk)
+				md.Works = append(md.Works, wk)
+			}
+		}
+	}
+
+	return merged, dropped
+}
+
+// read every configured source at the same time and return their works merged as an XML feed
+func openMergedFeed(location string) (io.ReadCloser, error) {
+	if location != "" {
+		return nil, fmt.Errorf("the merge: source takes no location - list the sources in the config file's \"sources\" section")
+	}
+	if len(mergedSources) == 0 {
+		return nil, fmt.Errorf("the merge: source needs a \"sources\" section in the --config file")
+	}
+
+	for _, source := range mergedSources {
+		if scheme, _, _ := strings.Cut(source, ":"); strings.EqualFold(scheme, "merge") {
+			return nil, fmt.Errorf("Error in config sources: the merge: source can't be one of the sources it merges")
+		}
+	}
+
+	builder := newCatalogBuilder(len(mergedSources))
+	errs := make([]error, len(mergedSources))
+
+	var wg sync.WaitGroup
+	for i, source := range mergedSources {
+		wg.Add(1)
+		go func(i int, source string) {
+			defer wg.Done()
+
+			data, err := readFeed(source)
+			if err != nil {
+				errs[i] = fmt.Errorf("Error fetching works source %s: %v", source, err)
+				return
+			}
+
+			catalog, err := parseWorks(bytes.NewReader(data))
+			if err != nil {
+				errs[i] = fmt.Errorf("Error parsing works source %s: %v", source, err)
+				return
+			}
+
+			builder.add(i, catalog)
+		}(i, source)
+	}
+	wg.Wait()
+
+	// report the first failing source in the order they're listed, so the same failure gives the same error
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	catalog, dropped := builder.merge()
+	if err := limits.checkWorks(len(catalog.Works)); err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Merged %s from %s.\n", pluralize("work", "works", len(catalog.Works)), pluralize("source", "sources", len(mergedSources)))
+	if dropped > 0 {
+		fmt.Fprintf(os.Stderr, "Dropped %s whose id an earlier source already has.\n", pluralize("work", "works", dropped))
+	}
+
+	var feed bytes.Buffer
+	if err := writeWorksXML(&feed, catalog); err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(&feed), nil
+}
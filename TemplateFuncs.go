@@ -0,0 +1,98 @@
+// template functions: helpers available to every page template, so theme authors writing their own templates don't have to
+// rebuild them out of the view data. Arguments are ordered so the value being worked on comes last and can be piped in:
+//
+//	slugify NAME                 file name slug of a make/model name, as used for its page ({{slugify .Make.Name}}.html)
+//	formatDate LAYOUT TIME       capture date in a Go time layout, "" for undated works ({{.Work.Date | formatDate "2 Jan 2006"}})
+//	pluralize ONE MANY N         N with the singular or plural noun ({{len .Make.Works | pluralize "photo" "photos"}} -> "3 photos")
+//	thumbnailURL SIZE WORK       src of a work's small, medium or large image, local copy first and signed if its host needs it
+//	                             ({{.Work | thumbnailURL "large"}})
+//	paginate PAGE PERPAGE LIST   the PAGE-th (from 1) run of PERPAGE items of any list, empty past the end ({{range paginate 1 12 .Make.Works}})
+//	truncate N TEXT              TEXT cut to at most N characters, ending in "…" when cut ({{.Work.FileName | truncate 30}})
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// functions registered with the page templates
+var templateFuncs = template.FuncMap{
+	"slugify":      pageSlug,
+	"formatDate":   formatDate,
+	"pluralize":    pluralize,
+	"thumbnailURL": thumbnailURL,
+	"paginate":     paginate,
+	"truncate":     truncate,
+}
+
+// return the time in the given layout, or "" for the zero time (works without a capture date)
+func formatDate(layout string, t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(layout)
+}
+
+// return n followed by the singular or plural noun
+func pluralize(one, many string, n int) string {
+	if n == 1 {
+		return "1 " + one
+	}
+
+	return strconv.Itoa(n) + " " + many
+}
+
+// return the src of a work's small, medium or large image
+func thumbnailURL(size string, wk *Work) (string, error) {
+	if wk == nil {
+		return "", nil
+	}
+
+	switch size {
+	case "small":
+		return publishedImageURL(wk.smallSrc()), nil
+	case "medium":
+		return publishedImageURL(wk.mediumSrc()), nil
+	case "large":
+		return publishedImageURL(wk.largeSrc()), nil
+	}
+
+	return "", fmt.Errorf("unknown image size %q (expected small, medium or large)", size)
+}
+
+// return the page-th (counting from 1) run of perPage items of a slice or array
+func paginate(page, perPage int, list interface{}) (interface{}, error) {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("paginate: can't paginate a %T", list)
+	}
+	if page < 1 || perPage < 1 {
+		return nil, fmt.Errorf("paginate: page and items per page must be positive (got %d and %d)", page, perPage)
+	}
+
+	start := (page - 1) * perPage
+	if start > v.Len() {
+		start = v.Len()
+	}
+	end := start + perPage
+	if end > v.Len() {
+		end = v.Len()
+	}
+
+	return v.Slice(start, end).Interface(), nil
+}
+
+// return the text cut to at most n characters, the last of them an ellipsis if anything was cut
+func truncate(n int, text string) string {
+	r := []rune(text)
+	if n < 1 || len(r) <= n {
+		return text
+	}
+
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,151 @@
+// page templates: the HTML of every generated page, rendered with html/template so each value is escaped for the context
+// (element text, attribute, URL or CSS) it ends up in. The templates themselves are in theme/templates.html (see Theme.go).
+
+package main
+
+import (
+	"html/template"
+	"strings"
+)
+
+// default page templates (theme/templates.html), with the helper functions of TemplateFuncs.go
+var siteTemplates = template.Must(template.New("site").Funcs(templateFuncs).Parse(defaultThemeFile(themeTemplatesFile)))
+
+// type struct representing everything a page template can show - each page type uses the fields it needs
+type pageView struct {
+	Title       string
+	Assets      *siteAssets
+	Canonical   string // absolute URL of the page ("" without a base URL)
+	Heading     string // make and model pages: heading replacing the generated one ("" for the generated one)
+	Description string // description as plain text, for the meta description and pages without DescriptionParts ("" for none)
+	Feed        string // Atom feed of the page's works, for autodiscovery ("" for none)
+	Mobile      string // gallery pages: the lightweight mobile variant ("" for none - see Mobile.go)
+	Root        string // pages in subdirectories: the way back up to the site root (e.g. "../../")
+	Desktop     string // mobile pages: the full page they're a variant of
+
+	DescriptionParts []descriptionPart // the description rendered from Markdown, shortcodes expanded (see Markdown.go)
+
+	Makes      []*Make       // index: the makes to offer in the navigation
+	HasGeneric bool          // index: whether there's a page of works without a make
+	Trending   []galleryItem // index: the works with the most recent views (see Popularity.go)
+	HasArchive bool          // index: whether there's a year archive to link to
+	HasAuthors bool          // index: whether there are author pages to link to
+	HasLenses  bool          // index: whether there are lens pages to link to
+	HasPlaces  bool          // index: whether there are place pages to link to
+	HasFacets  bool          // index: whether there are focal length, aperture and ISO pages to link to
+	HasCompare bool          // index: whether there are comparison pages to link to
+	HasFlagged bool          // index: whether there's a page of flagged works to link to (see Flagged.go)
+	Make       *Make         // make and model pages
+	Model      *Model        // model pages
+	Author     *Author       // author pages, and work pages: the work's author (see Authors.go)
+	Authors    []*Author     // authors page: every author with works
+	Lens       *Lens         // lens pages, and work pages: the work's lens (see Lenses.go)
+	Lenses     []*Lens       // lenses page: every lens with works, the most used first
+	Place      *placeView    // place pages (see Places.go)
+	Places     []placeLink   // places page: every country with works, and its cities
+	Facet      *facetGroup   // facet pages: the page's facet and its ranges (see Facets.go)
+	Facets     []facetGroup  // facets page: every facet with works
+	Gallery    galleryView
+	Download   *downloadView // make and model pages: zip archive of the page's images (nil for none - see Downloads.go)
+
+	Work     *Work           // work pages
+	Image    imageView       // work pages: the medium image
+	Large    string          // work pages: where the medium image links to
+	Details  string          // work pages: camera and capture date
+	Pagers   []pagerView     // work pages: previous/next links in each browsing order
+	Comments *commentsConfig // work pages: comment system to embed (nil for none - see Comments.go)
+	License  *licenseInfo    // work pages: the work's license (nil for none - see License.go)
+	Location []placeLink     // work pages: the city and country the work was taken in (see Places.go)
+	Caption  template.HTML   // work pages: the work's caption, sanitized (see Sanitize.go)
+	Settings []facetLink     // work pages: the focal length, aperture and ISO, linked to their facet pages (see Facets.go)
+
+	Archive *archiveView // year archive pages (see Archive.go)
+	Compare *compareView // comparison pages (see Compare.go)
+
+	Slides        []galleryItem // slideshows: one slide per work, with its medium image
+	SlideInterval int           // slideshows: seconds between slides while playing (0 for no autoplay)
+}
+
+// type struct representing a gallery of linked work images
+type galleryView struct {
+	Items      []galleryItem
+	Columns    int             // lay out as a grid with this many columns (0 for inline)
+	Pagination *paginationView // nil on single-page galleries
+	Print      bool            // print layout: captions under fixed-size images (see Layout.go)
+	PrintPages [][]galleryItem // print layout: the items grouped into printed pages
+}
+
+// type struct representing one work in a gallery
+type galleryItem struct {
+	Page    string // the work's detail page
+	Image   imageView
+	Work    *Work
+	Caption string // print layout: text under the image
+}
+
+// type struct representing an image, with the WebP/AVIF variants to offer through a <picture> element
+type imageView struct {
+	Src           string
+	Alt           string
+	Width, Height int    // 0 if unknown
+	Class         string // class giving the image its dominant color as a placeholder background
+	Loading       string // "lazy" to load the image only when it's about to be shown ("" for the browser's default)
+	Sources       []imageVariant
+}
+
+// type struct representing the links between the pages of a paginated gallery
+type paginationView struct {
+	Prev, Next string
+	Pages      []paginationLink
+}
+
+// type struct representing a link to one page of a paginated gallery
+type paginationLink struct {
+	Number  int
+	File    string
+	Current bool
+}
+
+// type struct representing the previous/next links of a work in one browsing order
+type pagerView struct {
+	Label      string
+	Prev, Next string
+}
+
+// render the named page template
+func renderPage(templates *template.Template, name string, view *pageView) (string, error) {
+	var b strings.Builder
+	if err := templates.ExecuteTemplate(&b, name, view); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// return the template view of a work's small or medium image - the dominant color placeholder is only known for the small image
+// WebP/AVIF variants are offered when the image has been downloaded and transcoded
+func workImage(wk *Work, size string) imageView {
+	if size == "medium" {
+		v := imageView{Src: publishedImageURL(wk.mediumSrc()), Width: wk.MediumWidth, Height: wk.MediumHeight}
+		if v.Width == 0 || v.Height == 0 {
+			v.Width, v.Height = 0, 0
+		}
+		if wk.LocalMedium != "" {
+			v.Sources = wk.Variants["medium"]
+		}
+		return v
+	}
+
+	v := imageView{Src: publishedImageURL(wk.smallSrc()), Width: wk.SmallWidth, Height: wk.SmallHeight}
+	if validColor(wk.DominantColor) {
+		v.Class = colorClass(wk.DominantColor)
+	}
+	if v.Width == 0 || v.Height == 0 {
+		v.Width, v.Height = 0, 0
+	}
+	if wk.LocalSmall != "" {
+		v.Sources = wk.Variants["small"]
+	}
+
+	return v
+}
@@ -0,0 +1,405 @@
+// test subcommand: regression testing of the generated site against golden output built from embedded sample feeds,
+// plus a generator that synthesizes works feeds of any size for load and template testing.
+
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sample works feeds the golden output is built from
+//
+//go:embed fixtures
+var fixtureFiles embed.FS
+
+// suffix of the overrides file a fixture is built with, in place of its .xml
+const fixtureOverridesSuffix = ".overrides.yaml"
+
+// size and seed of the synthesized fixture included in every golden run
+const (
+	generatedFixtureWorks = 40
+	generatedFixtureSeed  = 1
+)
+
+func init() {
+	registerCommand(&command{
+		Name:    "test",
+		Usage:   "--golden <dir> [--update] [--fixtures dir] | --generate N [--seed S] [--output file]",
+		Summary: "compare the site built from sample feeds with golden output, or generate a sample feed of N works",
+		Setup:   setupTest,
+	})
+}
+
+// type struct representing a works feed to build during a golden run
+type fixture struct {
+	Name      string // also the name of its golden sub-directory
+	Feed      []byte
+	Overrides []byte // overrides file the feed is built with, from <name>.overrides.yaml beside it (nil if there's none)
+}
+
+// define the test subcommand's flags and return its runner: run the golden comparison or the fixture generator, depending on the
+// flags given
+func setupTest(flags *flag.FlagSet) func(positional []string) int {
+	golden := flags.String("golden", "", "directory holding the expected output, one sub-directory per fixture")
+	update := flags.Bool("update", false, "write the current output to the golden directory instead of comparing against it")
+	fixturesDir := flags.String("fixtures", "", "directory of *.xml feeds to build instead of the built-in fixtures (each with an optional <name>.overrides.yaml)")
+	generate := flags.Int("generate", 0, "write a synthesized works feed with this many works instead of running the golden comparison")
+	seed := flags.Int64("seed", 1, "random seed for --generate (the same seed always gives the same feed)")
+	output := flags.String("output", "", "file to write the --generate feed to (default stdout)")
+
+	return func([]string) int {
+		if *generate > 0 {
+			var out io.Writer = os.Stdout
+			if *output != "" {
+				f, err := os.Create(*output)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error creating fixture file: %v\n", err)
+					return 1
+				}
+				defer f.Close()
+				out = f
+			}
+
+			if err := generateFixture(out, *generate, *seed); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing fixture: %v\n", err)
+				return 1
+			}
+
+			return 0
+		}
+
+		if *golden == "" {
+			fmt.Fprintln(os.Stderr, "Error: please enter the golden output directory (e.g. >go run ImageProcessor test --golden ./testdata) or --generate N")
+			return 2
+		}
+
+		// golden output mustn't depend on what earlier builds left in the persistent cache
+		cacheDisabled = true
+
+		fixtures, err := loadFixtures(*fixturesDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		failed := 0
+		for _, fx := range fixtures {
+			problems, err := runGoldenFixture(fx, filepath.Join(*golden, fx.Name), *update)
+			if err != nil {
+				fmt.Printf("[FAIL] %s: %v\n", fx.Name, err)
+				failed++
+				continue
+			}
+
+			if *update {
+				fmt.Printf("[UPDATED] %s\n", fx.Name)
+				continue
+			}
+
+			if len(problems) > 0 {
+				fmt.Printf("[FAIL] %s\n", fx.Name)
+				for _, p := range problems {
+					fmt.Printf("       %s\n", p)
+				}
+				failed++
+				continue
+			}
+
+			fmt.Printf("[PASS] %s\n", fx.Name)
+		}
+
+		if failed > 0 {
+			fmt.Printf("%d of %d fixtures failed (run with --update to accept the new output).\n", failed, len(fixtures))
+			return 1
+		}
+
+		if !*update {
+			fmt.Println("All fixtures passed.")
+		}
+
+		return 0
+	}
+}
+
+// return the feeds to build: the *.xml files in dir, or the built-in fixtures plus a synthesized one when dir is empty
+func loadFixtures(dir string) ([]fixture, error) {
+	var fixtures []fixture
+
+	if dir != "" {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.xml"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range paths {
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return nil, fmt.Errorf("Error reading fixture: %v", err)
+			}
+			overrides, err := os.ReadFile(strings.TrimSuffix(p, ".xml") + fixtureOverridesSuffix)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("Error reading fixture: %v", err)
+			}
+			fixtures = append(fixtures, fixture{Name: strings.TrimSuffix(filepath.Base(p), ".xml"), Feed: data, Overrides: overrides})
+		}
+
+		if len(fixtures) == 0 {
+			return nil, fmt.Errorf("Error: no *.xml fixtures found in %s", dir)
+		}
+
+		return fixtures, nil
+	}
+
+	entries, err := fixtureFiles.ReadDir("fixtures")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".xml")
+		if !ok {
+			continue
+		}
+
+		data, err := fixtureFiles.ReadFile(path.Join("fixtures", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		overrides, err := fixtureFiles.ReadFile(path.Join("fixtures", name+fixtureOverridesSuffix))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		fixtures = append(fixtures, fixture{Name: name, Feed: data, Overrides: overrides})
+	}
+
+	var generated bytes.Buffer
+	if err := generateFixture(&generated, generatedFixtureWorks, generatedFixtureSeed); err != nil {
+		return nil, err
+	}
+	fixtures = append(fixtures, fixture{Name: "generated-" + strconv.Itoa(generatedFixtureWorks), Feed: generated.Bytes()})
+
+	return fixtures, nil
+}
+
+// build a fixture into a scratch directory and compare the result with its golden directory (or replace the golden directory with it)
+// returns a description of every difference found
+func runGoldenFixture(fx fixture, goldenDir string, update bool) ([]string, error) {
+	// the build writes relative to the working directory, so the scratch directory has to live under it
+	scratch, err := os.MkdirTemp(".", ".imgproc-test-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(scratch)
+
+	// fixtures are built with --strict, so a page failing HTML validation fails its fixture
+	opts := newBuildOptions()
+	opts.Strict = true
+	if fx.Overrides != nil {
+		if opts.Overrides, err = parseOverrides(fx.Overrides, fx.Name+fixtureOverridesSuffix); err != nil {
+			return nil, err
+		}
+	}
+
+	// and through an after-parse hook handing back the catalog it's given, so nothing may be lost on the way through a hook command
+	saved := hooks.afterParse
+	onAfterParse(exportRoundTrip)
+	defer func() { hooks.afterParse = saved }()
+
+	outputFolderLocation := filepath.Base(scratch)
+	if err := quietly(func() error { return buildSite(bytes.NewReader(fx.Feed), outputFolderLocation, opts) }); err != nil {
+		return nil, err
+	}
+
+	got, err := readTree(scratch)
+	if err != nil {
+		return nil, err
+	}
+
+	if update {
+		if err := os.RemoveAll(goldenDir); err != nil {
+			return nil, err
+		}
+
+		for name, data := range got {
+			target := filepath.Join(goldenDir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(target, data, 0644); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	}
+
+	want, err := readTree(goldenDir)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading golden output: %v", err)
+	}
+
+	return compareTrees(want, got), nil
+}
+
+// pass the catalog through the export format and back, as an after-parse hook command printing its input unchanged (e.g. cat) does
+func exportRoundTrip(catalog *Catalog) error {
+	var b bytes.Buffer
+	if err := writeJSONExport(&b, catalog); err != nil {
+		return err
+	}
+
+	return replaceCatalog(catalog, b.Bytes())
+}
+
+// read every file under dir (except the build cache) into a map keyed by slash-separated relative path
+func readTree(dir string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() == buildCacheFile {
+			return err
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		files[filepath.ToSlash(rel)] = data
+		return nil
+	})
+
+	return files, err
+}
+
+// describe the differences between the expected and actual output files
+func compareTrees(want, got map[string][]byte) []string {
+	var problems []string
+
+	for name, data := range want {
+		actual, ok := got[name]
+		if !ok {
+			problems = append(problems, "missing "+name)
+		} else if !bytes.Equal(data, actual) {
+			problems = append(problems, fmt.Sprintf("changed %s: %s", name, firstDifference(data, actual)))
+		}
+	}
+
+	for name := range got {
+		if _, ok := want[name]; !ok {
+			problems = append(problems, "unexpected "+name)
+		}
+	}
+
+	sort.Strings(problems)
+	return problems
+}
+
+// describe where two versions of a file first differ, with a little context from each
+func firstDifference(want, got []byte) string {
+	i := 0
+	for i < len(want) && i < len(got) && want[i] == got[i] {
+		i++
+	}
+
+	start := i - 20
+	if start < 0 {
+		start = 0
+	}
+
+	excerpt := func(b []byte) string {
+		end := i + 40
+		if end > len(b) {
+			end = len(b)
+		}
+		if start >= end {
+			return `""`
+		}
+		return strconv.Quote(string(b[start:end]))
+	}
+
+	return fmt.Sprintf("byte %d: want %s, got %s", i, excerpt(want), excerpt(got))
+}
+
+// run fn with the build's progress output on stdout discarded
+func quietly(fn func() error) error {
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return fn()
+	}
+	defer devNull.Close()
+
+	stdout := os.Stdout
+	os.Stdout = devNull
+	defer func() { os.Stdout = stdout }()
+
+	return fn()
+}
+
+//----------------- fixture generator -------------------------------
+
+// camera makes and models synthesized feeds pick from
+var fixtureCameras = []struct {
+	Make   string
+	Models []string
+}{
+	{"Canon", []string{"Canon EOS 20D", "Canon EOS 400D DIGITAL", "Canon EOS 5D Mark II"}},
+	{"NIKON CORPORATION", []string{"NIKON D80", "NIKON D750"}},
+	{"FUJIFILM", []string{"X-T3", "X100F"}},
+	{"LEICA", []string{"M10"}},
+	{"Panasonic", []string{"DMC-GX7", ""}},
+}
+
+// write a works feed with n works, with makes, models and capture dates chosen pseudo-randomly from seed
+// about one work in twelve has no make, so the generic page is exercised too
+func generateFixture(out io.Writer, n int, seed int64) error {
+	rnd := rand.New(rand.NewSource(seed))
+	base := time.Date(2005, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	var b strings.Builder
+	b.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<works>\n")
+
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "  <work>\n    <id>%d</id>\n    <filename>work-%d.jpg</filename>\n    <urls>\n", i, i)
+		for _, size := range []string{"small", "medium", "large"} {
+			fmt.Fprintf(&b, "      <url type=\"%s\">http://images.example.com/%d/%s.jpg</url>\n", size, i, size)
+		}
+		b.WriteString("    </urls>\n")
+
+		if rnd.Intn(12) == 0 {
+			b.WriteString("    <exif/>\n  </work>\n")
+			continue
+		}
+
+		camera := fixtureCameras[rnd.Intn(len(fixtureCameras))]
+		model := camera.Models[rnd.Intn(len(camera.Models))]
+		date := base.Add(time.Duration(rnd.Int63n(int64(15 * 365 * 24 * time.Hour)))).Truncate(time.Second)
+
+		fmt.Fprintf(&b, "    <exif>\n      <model>%s</model>\n      <make>%s</make>\n      <date>%s</date>\n    </exif>\n  </work>\n",
+			model, camera.Make, date.Format("2006-01-02T15:04:05"))
+	}
+
+	b.WriteString("</works>\n")
+
+	_, err := io.WriteString(out, b.String())
+	return err
+}
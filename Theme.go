@@ -0,0 +1,237 @@
+// site themes: the default page templates, stylesheet and navigation script are embedded in the binary (theme/), so it needs no
+// files beside it. "theme init <dir>" extracts them as the starting point of a custom theme:
+//
+//	>go run ImageProcessor theme init mytheme
+//	>go run ImageProcessor --theme mytheme http://localhost/test/api/v1/works.xml code/html/output
+//
+// --theme takes such a directory - each of its files replaces the default of the same name, so a theme only needs the files it
+// changes - or a single CSS file, which is appended to the default stylesheet.
+//
+// A theme directory can also give particular makes and models a page template of their own, for landing pages of flagship
+// categories: templates/make/<slug>.html and templates/model/<slug>.html, named after the page of the make or model (leica.html
+// for LEICA.html, canon-eos-20d.html for Canon-EOS-20D.html, case aside). Such a file is read on top of the theme's templates - its
+// {{define}}s replace the shared pieces (head, gallery, description, ...) on that page only, and any other content replaces the whole
+// page template, with the generic page still there to render as {{template "base" .}}:
+//
+//	{{define "description"}}<p class="flagship">The M10: our favourite camera, and every photo we took with it.</p>{{end}}
+//	{{define "gallery"}}<div class="flagship-grid">{{range .Items}}<a href="{{.Page}}">{{template "image" .Image}}</a>{{end}}</div>{{end}}
+//
+// Makes and models without a file of their own get the generic template.
+
+package main
+
+import (
+	"embed"
+	"flag"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// default theme files
+//
+//go:embed theme
+var defaultThemeFiles embed.FS
+
+// file names of a theme's page templates, stylesheet and navigation script
+const (
+	themeTemplatesFile  = "templates.html"
+	themeStylesheetFile = "style.css"
+	themeScriptFile     = "nav.js"
+)
+
+// directory of a theme's per-make and per-model page templates
+const themeOverridesDir = "templates"
+
+// page templates that can be replaced for a particular make or model
+var overridableTemplates = []string{"make", "model"}
+
+// type struct representing the theme a site is generated with
+type siteTheme struct {
+	Templates *template.Template
+	Source    string // text of the page templates
+	CSS       string // stylesheet, before the gallery rules
+	ExtraCSS  string // stylesheet appended after the gallery rules (a --theme CSS file)
+	JS        string // navigation script
+
+	Overrides       map[string]*template.Template // page templates of particular makes and models, by template and slug, e.g. make/leica
+	OverridesSource string                        // text of the override files, for the incremental build's theme hash
+}
+
+func init() {
+	registerCommand(&command{
+		Name:    "theme",
+		Usage:   "init [--force] <dir>",
+		Summary: "write the default templates, stylesheet and script to a directory as the start of a custom theme",
+		Setup:   setupTheme,
+	})
+}
+
+// return the content of a default theme file - they're embedded, so a missing one is a build error
+func defaultThemeFile(name string) string {
+	data, err := defaultThemeFiles.ReadFile("theme/" + name)
+	if err != nil {
+		panic(err)
+	}
+
+	return string(data)
+}
+
+// load the theme at the given path: "" for the default theme, a directory of theme files or a CSS file
+func loadTheme(path string) (*siteTheme, error) {
+	theme := &siteTheme{Templates: siteTemplates, Source: defaultThemeFile(themeTemplatesFile), CSS: defaultThemeFile(themeStylesheetFile), JS: defaultThemeFile(themeScriptFile)}
+	if path == "" {
+		return theme, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading theme: %v", err)
+	}
+
+	if !info.IsDir() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading theme stylesheet: %v", err)
+		}
+
+		theme.ExtraCSS = string(data)
+		return theme, nil
+	}
+
+	// each file the theme has replaces the default one
+	read := func(name string, into *string) error {
+		data, err := os.ReadFile(filepath.Join(path, name))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading theme file: %v", err)
+		}
+
+		*into = string(data)
+		return nil
+	}
+
+	templates := ""
+	for name, into := range map[string]*string{themeTemplatesFile: &templates, themeStylesheetFile: &theme.CSS, themeScriptFile: &theme.JS} {
+		if err := read(name, into); err != nil {
+			return nil, err
+		}
+	}
+
+	if templates != "" {
+		theme.Templates, err = template.New("site").Funcs(templateFuncs).Parse(templates)
+		if err != nil {
+			return nil, fmt.Errorf("Error in theme templates (%s): %v", filepath.Join(path, themeTemplatesFile), err)
+		}
+		theme.Source = templates
+	}
+
+	if err := theme.loadOverrides(filepath.Join(path, themeOverridesDir)); err != nil {
+		return nil, err
+	}
+
+	return theme, nil
+}
+
+// read the per-make and per-model page templates in the given directory, each on top of its own copy of the theme's templates
+func (theme *siteTheme) loadOverrides(dir string) error {
+	var source strings.Builder
+	for _, name := range overridableTemplates {
+		files, err := filepath.Glob(filepath.Join(dir, name, "*.html"))
+		if err != nil {
+			return fmt.Errorf("Error reading theme templates: %v", err)
+		}
+		sort.Strings(files)
+
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("Error reading theme file: %v", err)
+			}
+
+			// a fresh copy of the theme's templates - an executed template set can't be cloned
+			t, err := template.New("site").Funcs(templateFuncs).Parse(theme.Source)
+			if err == nil {
+				_, err = t.AddParseTree("base", t.Lookup(name).Tree)
+			}
+			if err == nil {
+				_, err = t.New(name).Parse(string(data))
+			}
+			if err != nil {
+				return fmt.Errorf("Error in theme templates (%s): %v", file, err)
+			}
+
+			if theme.Overrides == nil {
+				theme.Overrides = map[string]*template.Template{}
+			}
+			key := name + "/" + strings.ToLower(strings.TrimSuffix(filepath.Base(file), ".html"))
+			theme.Overrides[key] = t
+			fmt.Fprintf(&source, "%s:%d:%s", key, len(data), data)
+		}
+	}
+
+	theme.OverridesSource = source.String()
+	return nil
+}
+
+// return the templates to render a page with: those of the make or model the page is about if the theme has some for it, the
+// theme's own otherwise
+func (theme *siteTheme) templatesFor(kind string, view *pageView) *template.Template {
+	var slug string
+	switch {
+	case kind == "make" && view.Make != nil:
+		slug = view.Make.PageURL
+	case kind == "model" && view.Model != nil:
+		slug = view.Model.PageURL
+	}
+
+	if t := theme.Overrides[kind+"/"+strings.ToLower(slug)]; slug != "" && t != nil {
+		return t
+	}
+
+	return theme.Templates
+}
+
+// define the theme subcommand's flags and return its runner: init extracts the default theme into a directory
+func setupTheme(flags *flag.FlagSet) func(positional []string) int {
+	force := flags.Bool("force", false, "overwrite theme files already in the directory")
+
+	return func(positional []string) int {
+		if len(positional) != 2 || positional[0] != "init" {
+			fmt.Fprintln(os.Stderr, "Error: please enter init and the directory to write the theme to (e.g. >go run ImageProcessor theme init mytheme)")
+			return 2
+		}
+
+		dir := positional[1]
+		names := []string{themeTemplatesFile, themeStylesheetFile, themeScriptFile}
+
+		if !*force {
+			for _, name := range names {
+				if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+					fmt.Fprintf(os.Stderr, "Error: %s already exists - use --force to overwrite it\n", filepath.Join(dir, name))
+					return 1
+				}
+			}
+		}
+
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating theme directory: %v\n", err)
+			return 1
+		}
+
+		for _, name := range names {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(defaultThemeFile(name)), 0644); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing theme file: %v\n", err)
+				return 1
+			}
+		}
+
+		fmt.Printf("Default theme written to %s - edit its files and build with --theme %s.\n", dir, dir)
+		return 0
+	}
+}
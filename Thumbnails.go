@@ -0,0 +1,185 @@
+// thumbnail generation: creates consistent local thumbnails from each work's large image.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // register GIF decoding for image.Decode
+	"image/jpeg"
+	_ "image/png" // register PNG decoding for image.Decode
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// type struct representing the thumbnail generation settings
+type thumbnailOptions struct {
+	Regenerate bool // generate thumbnails for every work, not just those missing a small URI
+	Width      int
+	Height     int
+	Quality    int // JPEG quality (1-100)
+}
+
+// generate a local thumbnail from the large image for every work without a small URI (or for every work if opts.Regenerate is set)
+// the large image is downloaded into <output-dir>/images unless it was already localized - failures are reported and the work is left as it was
+func generateThumbnails(catalog *Catalog, outputFolderLocation string, opts thumbnailOptions) error {
+	if opts.Width <= 0 || opts.Height <= 0 || opts.Quality < 1 || opts.Quality > 100 {
+		return fmt.Errorf("Error: invalid thumbnail settings (%dx%d, quality %d) - dimensions must be positive and quality between 1 and 100", opts.Width, opts.Height, opts.Quality)
+	}
+
+	dir := filepath.Join("./"+outputFolderLocation, imagesDir)
+	generated, failed := 0, 0
+
+	for _, wk := range catalog.Works {
+		if wk == nil || (!opts.Regenerate && strings.TrimSpace(wk.URISmall) != "") {
+			continue
+		}
+
+		// when regenerating, works without a large image keep their feed thumbnail
+		if opts.Regenerate && strings.TrimSpace(wk.URILarge) == "" && strings.TrimSpace(wk.URISmall) != "" {
+			continue
+		}
+
+		name := wk.ID.String() + "-thumb.jpg"
+		target := filepath.Join(dir, name)
+
+		// a thumbnail from a previous run is reused unless regeneration was requested
+		if _, err := os.Stat(target); err == nil && !opts.Regenerate {
+			wk.LocalSmall = imagesDir + "/" + name
+			continue
+		}
+
+		// the images directory is only created once there's a thumbnail to put in it
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("Error creating images directory (%s): %v", dir, err)
+		}
+
+		source, err := largeImageFile(wk, outputFolderLocation)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error fetching large image of work %s for thumbnail generation: %v\n", wk.ID, err)
+			failed++
+			continue
+		}
+
+		if err := writeThumbnail(source, target, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error generating thumbnail for work %s: %v\n", wk.ID, err)
+			failed++
+			continue
+		}
+
+		wk.LocalSmall = imagesDir + "/" + name
+		generated++
+	}
+
+	if generated > 0 || failed > 0 {
+		fmt.Printf("Thumbnails: %d generated, %d failed.\n", generated, failed)
+	}
+	return nil
+}
+
+// return the path of a local copy of the work's large image, downloading it into the images directory if needed
+func largeImageFile(wk *Work, outputFolderLocation string) (string, error) {
+	if wk.LocalLarge != "" {
+		return filepath.Join("./"+outputFolderLocation, filepath.FromSlash(wk.LocalLarge)), nil
+	}
+
+	uri := strings.TrimSpace(wk.URILarge)
+	if uri == "" {
+		return "", fmt.Errorf("work has no large URI")
+	}
+
+	if !strings.HasPrefix(uri, "http://") && !strings.HasPrefix(uri, "https://") {
+		// relative URIs point into the output directory already
+		return filepath.Join("./"+outputFolderLocation, filepath.FromSlash(uri)), nil
+	}
+
+	target := filepath.Join("./"+outputFolderLocation, imagesDir, localImageName(wk, "large", uri))
+	if info, err := os.Stat(target); err == nil && info.Size() > 0 {
+		return target, nil
+	}
+
+	return target, downloadFile(uri, target, wk.checksumDigests("large"))
+}
+
+// decode the source image, turn it upright, scale and centre-crop it to exactly the configured dimensions and write it as a JPEG
+func writeThumbnail(source, target string, opts thumbnailOptions) error {
+	img, _, err := decodeImageFile(source)
+	if err != nil {
+		return err
+	}
+
+	thumb := resizeImage(cropToAspect(img, opts.Width, opts.Height), opts.Width, opts.Height)
+
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+
+	err = jpeg.Encode(out, thumb, &jpeg.Options{Quality: opts.Quality})
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// return the largest centred region of img with the aspect ratio width:height
+func cropToAspect(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	cw, ch := b.Dx(), b.Dy()
+
+	if cw*height > ch*width {
+		cw = ch * width / height
+	} else {
+		ch = cw * height / width
+	}
+
+	x0 := b.Min.X + (b.Dx()-cw)/2
+	y0 := b.Min.Y + (b.Dy()-ch)/2
+	rect := image.Rect(x0, y0, x0+cw, y0+ch)
+
+	if sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(rect)
+	}
+
+	return img
+}
+
+// scale img to width x height by averaging the source pixels covered by each destination pixel (a box filter - good quality for downscaling)
+func resizeImage(img image.Image, width, height int) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		sy0 := b.Min.Y + y*b.Dy()/height
+		sy1 := b.Min.Y + (y+1)*b.Dy()/height
+		if sy1 <= sy0 {
+			sy1 = sy0 + 1
+		}
+
+		for x := 0; x < width; x++ {
+			sx0 := b.Min.X + x*b.Dx()/width
+			sx1 := b.Min.X + (x+1)*b.Dx()/width
+			if sx1 <= sx0 {
+				sx1 = sx0 + 1
+			}
+
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(bl / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,94 @@
+// slug transliteration: make and model names are turned into page file names letter by letter rather than by dropping everything
+// outside A-Z and 0-9. Full-width forms (as in Japanese catalogs, "Ｃａｎｏｎ（日本）") are folded to ASCII, accented Latin, Greek
+// and Cyrillic letters are romanized (Émile -> Emile, Øresund -> Oresund, Зенит -> Zenit), and letters of other scripts (日本) are kept
+// as UTF-8, percent-encoded in links, so every name still gets a page of its own.
+
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// romanization of accented Latin, Greek and Cyrillic letters
+var transliterations = map[rune]string{}
+
+func init() {
+	// groups of letters sharing one romanization
+	for from, to := range map[string]string{
+		"ÀÁÂÃÄÅĀĂĄǍ": "A", "àáâãäåāăąǎ": "a", "ÇĆĈĊČ": "C", "çćĉċč": "c", "ĎĐ": "D", "ďđ": "d",
+		"ÈÉÊËĒĔĖĘĚ": "E", "èéêëēĕėęě": "e", "ĜĞĠĢ": "G", "ĝğġģ": "g", "ĤĦ": "H", "ĥħ": "h",
+		"ÌÍÎÏĨĪĬĮİǏ": "I", "ìíîïĩīĭįıǐ": "i", "Ĵ": "J", "ĵ": "j", "Ķ": "K", "ķ": "k", "ĹĻĽĿŁ": "L", "ĺļľŀł": "l",
+		"ÑŃŅŇ": "N", "ñńņň": "n", "ÒÓÔÕÖØŌŎŐǑ": "O", "òóôõöøōŏőǒ": "o", "ŔŖŘ": "R", "ŕŗř": "r",
+		"ŚŜŞŠȘ": "S", "śŝşšș": "s", "ŢŤŦȚ": "T", "ţťŧț": "t", "ÙÚÛÜŨŪŬŮŰŲǓ": "U", "ùúûüũūŭůűųǔ": "u",
+		"Ŵ": "W", "ŵ": "w", "ÝŶŸ": "Y", "ýÿŷ": "y", "ŹŻŽ": "Z", "źżž": "z",
+		"Æ": "AE", "æ": "ae", "Œ": "OE", "œ": "oe", "ß": "ss", "Þ": "TH", "þ": "th", "Ð": "D", "ð": "d",
+
+		"ΑΆ": "A", "αά": "a", "Β": "V", "β": "v", "Γ": "G", "γ": "g", "Δ": "D", "δ": "d", "ΕΈ": "E", "εέ": "e",
+		"Ζ": "Z", "ζ": "z", "ΗΉ": "I", "ηή": "i", "Θ": "Th", "θ": "th", "ΙΊΪ": "I", "ιίϊΐ": "i", "Κ": "K", "κ": "k",
+		"Λ": "L", "λ": "l", "Μ": "M", "μ": "m", "Ν": "N", "ν": "n", "Ξ": "X", "ξ": "x", "ΟΌ": "O", "οό": "o",
+		"Π": "P", "π": "p", "Ρ": "R", "ρ": "r", "Σ": "S", "σς": "s", "Τ": "T", "τ": "t", "ΥΎΫ": "Y", "υύϋΰ": "y",
+		"Φ": "F", "φ": "f", "Χ": "Ch", "χ": "ch", "Ψ": "Ps", "ψ": "ps", "ΩΏ": "O", "ωώ": "o",
+
+		"А": "A", "а": "a", "Б": "B", "б": "b", "В": "V", "в": "v", "ГҐ": "G", "гґ": "g", "Д": "D", "д": "d",
+		"ЕЁЄ": "E", "еёє": "e", "Ж": "Zh", "ж": "zh", "З": "Z", "з": "z", "ИІЇЙ": "I", "иіїй": "i",
+		"К": "K", "к": "k", "Л": "L", "л": "l", "М": "M", "м": "m", "Н": "N", "н": "n", "О": "O", "о": "o",
+		"П": "P", "п": "p", "Р": "R", "р": "r", "С": "S", "с": "s", "Т": "T", "т": "t", "У": "U", "у": "u",
+		"Ф": "F", "ф": "f", "Х": "Kh", "х": "kh", "Ц": "Ts", "ц": "ts", "Ч": "Ch", "ч": "ch", "Ш": "Sh", "ш": "sh",
+		"Щ": "Shch", "щ": "shch", "Ы": "Y", "ы": "y", "Э": "E", "э": "e", "Ю": "Yu", "ю": "yu", "Я": "Ya", "я": "ya",
+		"ЪЬъь": "",
+	} {
+		for _, r := range from {
+			transliterations[r] = to
+		}
+	}
+}
+
+// return the name as a slug: runs of anything but letters and digits become a dash, letters with a romanization are romanized and
+// the letters and digits of other scripts are kept
+func transliterateSlug(name string) string {
+	var b strings.Builder
+	dash := false // a separator is pending, written before the next letter (or at the end)
+
+	emit := func(s string) {
+		if s == "" {
+			return
+		}
+		if dash {
+			b.WriteByte('-')
+			dash = false
+		}
+		b.WriteString(s)
+	}
+
+	for _, r := range name {
+		// full-width ASCII (！ to ～) and the ideographic space
+		if r >= 0xFF01 && r <= 0xFF5E {
+			r -= 0xFEE0
+		} else if r == 0x3000 {
+			r = ' '
+		}
+
+		to, romanized := transliterations[r]
+
+		switch {
+		case r < unicode.MaxASCII && (r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9'):
+			emit(string(r))
+		case romanized:
+			emit(to) // "" for letters that are dropped (Cyrillic hard and soft signs)
+		case r > unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			emit(string(r))
+		case unicode.Is(unicode.Mn, r):
+			// a combining accent - the letter it's on is kept
+		default:
+			dash = true
+		}
+	}
+
+	// a trailing separator is kept as a dash too, as slugs always have
+	if dash {
+		b.WriteByte('-')
+	}
+
+	return b.String()
+}
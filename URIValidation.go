@@ -0,0 +1,81 @@
+// image URI validation: only http(s) and relative URIs make it into the generated pages (and image downloads) -
+// javascript:, data:, file: and any other scheme is dropped or fails the build.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// check every work's small/medium/large URI and handle unsafe ones according to policy:
+// "clear" removes the URI (the work is kept, with a warning) and "fail" returns an error listing them
+func validateImageURIs(catalog *Catalog, policy string) error {
+	if policy != "clear" && policy != "fail" {
+		return fmt.Errorf("Error: unknown unsafe URI policy %q (expected clear or fail)", policy)
+	}
+
+	var unsafe []string
+
+	for _, wk := range catalog.Works {
+		if wk == nil {
+			continue
+		}
+
+		for _, u := range []struct {
+			size string
+			uri  *string
+		}{{"small", &wk.URISmall}, {"medium", &wk.URIMedium}, {"large", &wk.URILarge}} {
+			err := checkImageURI(*u.uri)
+			if err == nil {
+				continue
+			}
+
+			unsafe = append(unsafe, fmt.Sprintf("work %s %s URI %q: %v", wk.ID, u.size, *u.uri, err))
+			if policy == "clear" {
+				fmt.Fprintf(os.Stderr, "Ignoring unsafe %s URI of work %s (%q): %v\n", u.size, wk.ID, *u.uri, err)
+				*u.uri = ""
+			}
+		}
+	}
+
+	if policy == "fail" && len(unsafe) > 0 {
+		return fmt.Errorf("Error: %d unsafe image URIs in works data:\n  %s", len(unsafe), strings.Join(unsafe, "\n  "))
+	}
+
+	return nil
+}
+
+// return an error unless the URI is empty, relative, or an absolute http(s) URL with a host
+func checkImageURI(uri string) error {
+	// browsers ignore tabs, newlines and leading control characters/spaces when reading a scheme (e.g. "java\tscript:"), so they are removed before checking
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, uri)
+
+	if cleaned == "" {
+		return nil
+	}
+
+	u, err := url.Parse(cleaned)
+	if err != nil {
+		return fmt.Errorf("unreadable URI: %v", err)
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "":
+		return nil
+	case "http", "https":
+		if u.Host == "" {
+			return fmt.Errorf("%s URI without a host", u.Scheme)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s: URIs are not allowed (only http, https and relative URIs)", u.Scheme)
+	}
+}
@@ -0,0 +1,392 @@
+// version and self-update: version prints the version of this build, and with --check asks GitHub for the latest release; self-update
+// downloads the release's binary for this platform, checks it against the release's SHA256SUMS and that file's ed25519 signature,
+// SHA256SUMS.sig, and replaces the running executable with it. A build without the release public key can't check the signature, so
+// it refuses to update unless told with --insecure to trust the checksums alone:
+//
+//	>imgproc version --check
+//	>imgproc self-update
+//
+// Release builds set the version and key with
+//
+//	go build -ldflags "-X main.version=v1.4.0 -X main.releasePublicKey=<base64 ed25519 public key>"
+//
+// and the release carries imgproc_<os>_<arch> binaries (.exe on Windows), SHA256SUMS (in sha256sum's format) and SHA256SUMS.sig.
+
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerCommand(&command{
+		Name:    "version",
+		Usage:   "[--check]",
+		Summary: "print the version of this build, and with --check whether a newer release is out",
+		Setup:   setupVersion,
+	})
+	registerCommand(&command{
+		Name:    "self-update",
+		Usage:   "[--force] [--insecure]",
+		Summary: "replace this executable with the latest release's, after checking its checksum and signature",
+		Setup:   setupSelfUpdate,
+	})
+}
+
+// version of this build ("dev" unless set at release time with -ldflags "-X main.version=...")
+var version = "dev"
+
+// base64 ed25519 public key the release checksums are signed with (set at release time - without it self-update needs --insecure)
+var releasePublicKey = ""
+
+// GitHub API URL of the latest release
+const latestReleaseURL = "https://api.github.com/repos/astdb/GoXMLProcessor/releases/latest"
+
+// names of the release's checksums file and its signature
+const (
+	releaseChecksums = "SHA256SUMS"
+	releaseSignature = "SHA256SUMS.sig"
+)
+
+// type struct representing a GitHub release, as far as updating needs it
+type githubRelease struct {
+	Tag    string `json:"tag_name"`
+	URL    string `json:"html_url"`
+	Assets []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// return the download URL of a release asset ("" if the release hasn't got it)
+func (r *githubRelease) asset(name string) string {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL
+		}
+	}
+
+	return ""
+}
+
+// return the name of the release binary for this platform
+func releaseBinaryName() string {
+	name := "imgproc_" + runtime.GOOS + "_" + runtime.GOARCH
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+
+	return name
+}
+
+// fetch the latest release from the GitHub API
+func fetchLatestRelease(url string) (*githubRelease, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching the latest release: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error fetching the latest release (%s): HTTP %s", url, resp.Status)
+	}
+
+	var r githubRelease
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("Error decoding the latest release (%s): %v", url, err)
+	}
+	if r.Tag == "" {
+		return nil, fmt.Errorf("Error: the latest release (%s) has no tag", url)
+	}
+
+	return &r, nil
+}
+
+// return the numeric parts of a version like v1.4.2 (a pre-release suffix like -rc1 is ignored), and whether it is one
+func versionParts(v string) ([]int, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+
+	var parts []int
+	for _, p := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, false
+		}
+		parts = append(parts, n)
+	}
+
+	return parts, true
+}
+
+// return whether the release version is newer than this build's - a development build is older than every release
+func newerVersion(release, current string) bool {
+	r, ok := versionParts(release)
+	if !ok {
+		return false
+	}
+	c, ok := versionParts(current)
+	if !ok {
+		return true
+	}
+
+	for i := 0; i < len(r) || i < len(c); i++ {
+		var a, b int
+		if i < len(r) {
+			a = r[i]
+		}
+		if i < len(c) {
+			b = c[i]
+		}
+		if a != b {
+			return a > b
+		}
+	}
+
+	return false
+}
+
+// define the version subcommand's flags and return its runner: print the version, and with --check whether a newer release is out -
+// returning 0, 1 if there is one, and 2 on errors
+func setupVersion(fs *flag.FlagSet) func(positional []string) int {
+	check := fs.Bool("check", false, "ask GitHub whether a newer release is out (exits 1 if there is)")
+	releaseURL := fs.String("release-url", latestReleaseURL, "GitHub API URL of the latest release, for forks and mirrors")
+
+	return func(positional []string) int {
+		fmt.Printf("imgproc %s (%s, %s/%s)\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+		if !*check {
+			return 0
+		}
+
+		r, err := fetchLatestRelease(*releaseURL)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+
+		if !newerVersion(r.Tag, version) {
+			fmt.Printf("Up to date: the latest release is %s.\n", r.Tag)
+			return 0
+		}
+
+		fmt.Printf("A newer release is out: %s (%s) - update with >imgproc self-update\n", r.Tag, r.URL)
+		return 1
+	}
+}
+
+// download a release asset into memory
+func downloadAsset(name, url string) ([]byte, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("Error downloading %s: %v", name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error downloading %s (%s): HTTP %s", name, url, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error downloading %s (%s): %v", name, url, err)
+	}
+
+	return data, nil
+}
+
+// return the checksum a sha256sum-format checksums file lists for a file
+func listedChecksum(sums []byte, name string) (string, bool) {
+	scanner := bufio.NewScanner(strings.NewReader(string(sums)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), true
+		}
+	}
+
+	return "", false
+}
+
+// return an error unless the signature (raw or base64) is the release key's signature of the checksums file
+func verifyReleaseSignature(sums, sig []byte, key string) error {
+	pub, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("Error: this build's release public key isn't a base64 ed25519 key")
+	}
+
+	if len(sig) != ed25519.SignatureSize {
+		if sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err != nil {
+			return fmt.Errorf("Error: %s is neither a raw nor a base64 signature", releaseSignature)
+		}
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(pub), sums, sig) {
+		return fmt.Errorf("Error: the signature of %s doesn't match the release key - not updating", releaseChecksums)
+	}
+
+	return nil
+}
+
+// download the release binary for this platform and check it against the release's checksums and their signature - or, if insecure
+// is set and the build has no release public key, against the checksums alone
+func downloadRelease(r *githubRelease, insecure bool) ([]byte, error) {
+	if releasePublicKey == "" && !insecure {
+		return nil, fmt.Errorf("Error: this build has no release public key to check the signature of %s with - not updating (update with --insecure to trust the checksums alone)", releaseChecksums)
+	}
+
+	name := releaseBinaryName()
+	binURL, sumsURL := r.asset(name), r.asset(releaseChecksums)
+	if binURL == "" {
+		return nil, fmt.Errorf("Error: release %s has no binary for %s/%s (%s)", r.Tag, runtime.GOOS, runtime.GOARCH, name)
+	}
+	if sumsURL == "" {
+		return nil, fmt.Errorf("Error: release %s has no %s to check the binary against", r.Tag, releaseChecksums)
+	}
+
+	sums, err := downloadAsset(releaseChecksums, sumsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if releasePublicKey != "" {
+		sigURL := r.asset(releaseSignature)
+		if sigURL == "" {
+			return nil, fmt.Errorf("Error: release %s has no %s - not updating", r.Tag, releaseSignature)
+		}
+		sig, err := downloadAsset(releaseSignature, sigURL)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifyReleaseSignature(sums, sig, releasePublicKey); err != nil {
+			return nil, err
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "Warning: --insecure - checking the binary against %s only, not its signature\n", releaseChecksums)
+	}
+
+	want, ok := listedChecksum(sums, name)
+	if !ok {
+		return nil, fmt.Errorf("Error: %s of release %s doesn't list %s", releaseChecksums, r.Tag, name)
+	}
+
+	bin, err := downloadAsset(name, binURL)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(bin)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return nil, fmt.Errorf("Error: checksum of %s is %s, %s lists %s - not updating", name, got, releaseChecksums, want)
+	}
+
+	return bin, nil
+}
+
+// replace the running executable with the new binary - written beside it and renamed over it, so a failure leaves the old one in
+// place (Windows won't replace a running executable, so it's moved aside to <name>.old first)
+func replaceExecutable(bin []byte) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("Error finding this executable: %v", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return "", fmt.Errorf("Error finding this executable: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(exe), "."+filepath.Base(exe)+".new-*")
+	if err != nil {
+		return "", fmt.Errorf("Error writing the new executable beside %s: %v", exe, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(bin); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("Error writing the new executable: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("Error writing the new executable: %v", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return "", fmt.Errorf("Error making the new executable executable: %v", err)
+	}
+
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return "", fmt.Errorf("Error moving %s aside: %v", exe, err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return "", fmt.Errorf("Error replacing %s: %v", exe, err)
+	}
+
+	return exe, nil
+}
+
+// define the self-update subcommand's flags and return its runner: replace this executable with the latest release's if it's newer,
+// returning 0, 1 if the update failed, and 2 on usage errors
+func setupSelfUpdate(fs *flag.FlagSet) func(positional []string) int {
+	force := fs.Bool("force", false, "install the latest release even if it isn't newer than this build")
+	releaseURL := fs.String("release-url", latestReleaseURL, "GitHub API URL of the latest release, for forks and mirrors")
+	insecure := fs.Bool("insecure", false, "in a build without the release public key, install a binary checked against the release's checksums alone")
+
+	return func(positional []string) int {
+		if len(positional) > 0 {
+			fmt.Fprintln(os.Stderr, "Error: self-update takes no arguments (e.g. >imgproc self-update)")
+			return 2
+		}
+
+		r, err := fetchLatestRelease(*releaseURL)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		if version == "dev" && !*force {
+			fmt.Fprintf(os.Stderr, "Error: this is a development build - rebuild it from source, or install release %s over it with --force\n", r.Tag)
+			return 1
+		}
+		if !newerVersion(r.Tag, version) && !*force {
+			fmt.Printf("Up to date: imgproc %s, the latest release is %s.\n", version, r.Tag)
+			return 0
+		}
+
+		bin, err := downloadRelease(r, *insecure)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		exe, err := replaceExecutable(bin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		fmt.Printf("Updated %s from %s to %s.\n", exe, version, r.Tag)
+		return 0
+	}
+}
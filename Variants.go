@@ -0,0 +1,156 @@
+// modern image format variants: transcodes local images to WebP/AVIF and renders <picture> elements that fall back to the original.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// type struct representing an alternative encoding of a local image
+type imageVariant struct {
+	Type string // MIME type, e.g. image/webp
+	Src  string // path relative to the output directory
+}
+
+// type struct representing an external encoder used to produce a variant format
+type variantEncoder struct {
+	Format string // format name as given to --image-formats
+	Type   string // MIME type of the output
+	Tool   string // executable that performs the conversion
+	args   func(in, out string, quality int) []string
+}
+
+// supported variant formats, in order of preference for <source> elements (most efficient first)
+var variantEncoders = []variantEncoder{
+	{Format: "avif", Type: "image/avif", Tool: "avifenc", args: func(in, out string, quality int) []string {
+		return []string{"-q", strconv.Itoa(quality), in, out}
+	}},
+	{Format: "webp", Type: "image/webp", Tool: "cwebp", args: func(in, out string, quality int) []string {
+		return []string{"-quiet", "-q", strconv.Itoa(quality), in, "-o", out}
+	}},
+}
+
+// transcode every local image rendition of every work into each requested format (e.g. "webp,avif") and record the variants on the works
+// formats whose encoder isn't installed are skipped with a warning; variants newer than their source are reused
+func generateVariants(catalog *Catalog, outputFolderLocation string, formats string, quality int) error {
+	var encoders []variantEncoder
+
+	for _, format := range strings.Split(formats, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format == "" {
+			continue
+		}
+
+		found := false
+		for _, enc := range variantEncoders {
+			if enc.Format != format {
+				continue
+			}
+
+			found = true
+			if _, err := exec.LookPath(enc.Tool); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %s variants skipped - the %s encoder wasn't found on PATH\n", format, enc.Tool)
+				break
+			}
+
+			encoders = append(encoders, enc)
+		}
+
+		if !found {
+			return fmt.Errorf("Error: unknown image format %q in --image-formats (expected webp and/or avif)", format)
+		}
+	}
+
+	if len(encoders) == 0 {
+		return nil
+	}
+
+	encoders = variantsInPreferenceOrder(encoders)
+
+	outputDir := "./" + outputFolderLocation
+	created, failed := 0, 0
+
+	for _, wk := range catalog.Works {
+		if wk == nil {
+			continue
+		}
+
+		for size, local := range map[string]string{"small": wk.LocalSmall, "medium": wk.LocalMedium, "large": wk.LocalLarge} {
+			if local == "" {
+				continue
+			}
+
+			source := filepath.Join(outputDir, filepath.FromSlash(local))
+
+			for _, enc := range encoders {
+				variant := strings.TrimSuffix(local, path.Ext(local)) + "." + enc.Format
+				target := filepath.Join(outputDir, filepath.FromSlash(variant))
+
+				if !isUpToDate(target, source) {
+					// the encoders drop the EXIF orientation, so they're given an upright copy
+					upright, cleanup, err := uprightImageFile(source)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Error encoding %s as %s: %v\n", source, enc.Format, err)
+						failed++
+						continue
+					}
+
+					out, err := exec.Command(enc.Tool, enc.args(upright, target, quality)...).CombinedOutput()
+					cleanup()
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Error encoding %s as %s: %v %s\n", source, enc.Format, err, strings.TrimSpace(string(out)))
+						os.Remove(target)
+						failed++
+						continue
+					}
+
+					created++
+				}
+
+				if wk.Variants == nil {
+					wk.Variants = map[string][]imageVariant{}
+				}
+				wk.Variants[size] = append(wk.Variants[size], imageVariant{Type: enc.Type, Src: variant})
+			}
+		}
+	}
+
+	fmt.Printf("Image variants: %d encoded, %d failed.\n", created, failed)
+	return nil
+}
+
+// return the given encoders sorted into the order of variantEncoders
+func variantsInPreferenceOrder(encoders []variantEncoder) []variantEncoder {
+	var ordered []variantEncoder
+	for _, pref := range variantEncoders {
+		for _, enc := range encoders {
+			if enc.Format == pref.Format {
+				ordered = append(ordered, enc)
+				break
+			}
+		}
+	}
+
+	return ordered
+}
+
+// report whether target exists and was modified no earlier than source
+func isUpToDate(target, source string) bool {
+	t, err := os.Stat(target)
+	if err != nil || t.Size() == 0 {
+		return false
+	}
+
+	s, err := os.Stat(source)
+	if err != nil {
+		return false
+	}
+
+	return !t.ModTime().Before(s.ModTime())
+}
@@ -0,0 +1,158 @@
+// watch mode: poll the works source - and the --theme's files - and regenerate the static site whenever their content changes.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+)
+
+// type struct representing what a watching build polls and how it rebuilds
+type watchJob struct {
+	Location           string                     // works source
+	Theme              string                     // theme directory or CSS file whose changes also trigger a rebuild ("" for the default theme)
+	Output             string                     // output directory (of the first site, with site profiles)
+	Build              func(feed io.Reader) error // generates the site(s) from a version of the feed
+	Interval, Debounce time.Duration
+	Notifiers          notifierSet     // fetch errors are sent as warnings and failed builds as failures
+	OnBuild            func(err error) // if not nil, called after every rebuild with the build's error, if any
+}
+
+// poll the works source (and theme) every interval and rebuild the site once a changed version has stayed unchanged for the debounce
+// period - runs until interrupted
+func watchAndBuild(job watchJob) int {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	stop := make(chan struct{})
+	go func() {
+		<-interrupt
+		close(stop)
+	}()
+
+	watchUntil(stop, job)
+	return 0
+}
+
+// poll and rebuild as watchAndBuild does until the stop channel is closed - for callers handling the interrupt themselves
+func watchUntil(stop <-chan struct{}, job watchJob) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	var builtHash, pendingHash [sha256.Size]byte // content hash of the last build, and of a changed version waiting out the debounce period
+	var builtFeed [sha256.Size]byte              // hash of the works data alone at the last build, to tell what changed
+	var pendingSince time.Time
+	built := false
+
+	if job.Theme != "" {
+		fmt.Printf("Watching %s and the theme %s for changes every %v (press Ctrl+C to stop)...\n", job.Location, job.Theme, job.Interval)
+	} else {
+		fmt.Printf("Watching %s for changes every %v (press Ctrl+C to stop)...\n", job.Location, job.Interval)
+	}
+
+	for {
+		phases.reset("fetch")
+		data, err := readFeed(job.Location)
+		if err != nil {
+			// keep watching - the API may just be restarting or the file may be mid-save
+			fmt.Fprintf(os.Stderr, "Error fetching XML works data from %s: %v\n", job.Location, err)
+			job.Notifiers.send(SeverityWarn, "Works source unavailable", fmt.Sprintf("Error fetching XML works data from %s: %v", job.Location, err))
+		}
+
+		var theme []byte
+		if err == nil {
+			if theme, err = readThemeFiles(job.Theme); err != nil {
+				// keep watching - a theme file may be mid-save
+				fmt.Fprintf(os.Stderr, "Error reading theme %s: %v\n", job.Theme, err)
+			}
+		}
+
+		if err == nil {
+			feedHash := sha256.Sum256(data)
+			hash := sha256.Sum256(append(feedHash[:], theme...))
+
+			if built && hash == builtHash {
+				// nothing changed since the last build
+				pendingSince = time.Time{}
+			} else if pendingSince.IsZero() || hash != pendingHash {
+				// a (new) change was detected - start the debounce period
+				pendingHash = hash
+				pendingSince = time.Now()
+			}
+
+			if !pendingSince.IsZero() && (!built || time.Since(pendingSince) >= job.Debounce) {
+				what := "works data"
+				if built && feedHash == builtFeed {
+					what = "theme"
+				}
+				fmt.Printf("[%s] %s changed - rebuilding...\n", time.Now().Format("15:04:05"), what)
+
+				err := job.Build(bytes.NewReader(data))
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					job.Notifiers.send(SeverityFail, "Static site rebuild failed", err.Error())
+				}
+
+				if job.OnBuild != nil {
+					job.OnBuild(err)
+				}
+
+				// remember the content even if the build failed, so a broken feed isn't rebuilt on every poll
+				builtHash, builtFeed = hash, feedHash
+				built = true
+				pendingSince = time.Time{}
+			}
+		}
+
+		select {
+		case <-stop:
+			fmt.Println("Watch mode stopped.")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// return the names and content of the theme's files - the default theme (path "") has none to watch
+func readThemeFiles(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	var b bytes.Buffer
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(&b, "%s\x00%d\x00", filepath.ToSlash(p), len(data))
+		b.Write(data)
+		return nil
+	})
+
+	return b.Bytes(), err
+}
+
+// read the full works XML source into memory
+func readFeed(location string) ([]byte, error) {
+	feed, err := openFeed(location)
+	if err != nil {
+		return nil, err
+	}
+	defer feed.Close()
+
+	return io.ReadAll(limits.reader(feed))
+}
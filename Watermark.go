@@ -0,0 +1,247 @@
+// watermarks: when images are localized (--download-images), a text or PNG watermark can be composited onto the medium and large
+// renditions in the output directory - thumbnails are left clean. Text is drawn with a built-in 5x7 pixel font scaled to the image
+// (printable ASCII and ©), white over a dark shadow so it reads on light and dark photos. Watermarked renditions are rewritten
+// from the downloaded originals (usually copied from the persistent cache) on every build, so a watermark is never applied twice.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+)
+
+// JPEG quality watermarked renditions are re-encoded at
+const watermarkJPEGQuality = 90
+
+// type struct representing the watermark settings
+type watermarkOptions struct {
+	Text     string  // text to draw ("" for none)
+	Image    string  // PNG file to draw ("" for none)
+	Position string  // top-left, top-right, bottom-left, bottom-right or center
+	Opacity  float64 // 0 (invisible) to 1 (opaque)
+}
+
+// return whether a watermark is configured
+func (o watermarkOptions) enabled() bool {
+	return o.Text != "" || o.Image != ""
+}
+
+// return an error describing the first invalid setting
+func (o watermarkOptions) check() error {
+	if o.Text != "" && o.Image != "" {
+		return fmt.Errorf("Error: use either --watermark-text or --watermark-image, not both")
+	}
+
+	switch o.Position {
+	case "top-left", "top-right", "bottom-left", "bottom-right", "center":
+	default:
+		return fmt.Errorf("Error: unknown watermark position %q (expected top-left, top-right, bottom-left, bottom-right or center)", o.Position)
+	}
+
+	if o.Opacity <= 0 || o.Opacity > 1 {
+		return fmt.Errorf("Error: watermark opacity must be above 0 and at most 1 (got %g)", o.Opacity)
+	}
+
+	return nil
+}
+
+// watermark the local medium and large image of every work - images that can't be read or re-encoded are reported and left as they are
+func watermarkImages(catalog *Catalog, outputFolderLocation string, opts watermarkOptions) error {
+	var mark image.Image
+	if opts.Image != "" {
+		f, err := os.Open(opts.Image)
+		if err != nil {
+			return fmt.Errorf("Error reading watermark image: %v", err)
+		}
+		mark, err = png.Decode(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("Error reading watermark image (%s): %v", opts.Image, err)
+		}
+	}
+
+	done := map[string]bool{}
+	watermarked, failed := 0, 0
+
+	for _, wk := range catalog.Works {
+		if wk == nil {
+			continue
+		}
+
+		for _, local := range []string{wk.LocalMedium, wk.LocalLarge} {
+			if local == "" || done[local] {
+				continue
+			}
+			done[local] = true
+
+			if err := watermarkFile(filepath.Join("./"+outputFolderLocation, filepath.FromSlash(local)), mark, opts); err != nil {
+				fmt.Fprintf(os.Stderr, "Error watermarking image of work %s (%s): %v\n", wk.ID, local, err)
+				failed++
+				continue
+			}
+			watermarked++
+		}
+	}
+
+	fmt.Printf("Watermarks: %d images watermarked, %d failed.\n", watermarked, failed)
+	return nil
+}
+
+// composite the watermark onto the image file, re-encoding it upright in its own format (JPEG or PNG)
+func watermarkFile(path string, mark image.Image, opts watermarkOptions) error {
+	img, format, err := decodeImageFile(path)
+	if err != nil {
+		return err
+	}
+
+	if format != "jpeg" && format != "png" {
+		return fmt.Errorf("can't re-encode %s images", format)
+	}
+
+	canvas := image.NewRGBA(img.Bounds())
+	draw.Draw(canvas, canvas.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	if mark != nil {
+		drawImageWatermark(canvas, mark, opts)
+	} else {
+		drawTextWatermark(canvas, opts)
+	}
+
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, canvas, &jpeg.Options{Quality: watermarkJPEGQuality})
+	} else {
+		err = png.Encode(&buf, canvas)
+	}
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// draw the PNG watermark, shrunk to at most a quarter of the image's width
+func drawImageWatermark(canvas *image.RGBA, mark image.Image, opts watermarkOptions) {
+	mb := mark.Bounds()
+	if maxWidth := canvas.Bounds().Dx() / 4; mb.Dx() > maxWidth && maxWidth > 0 {
+		height := mb.Dy() * maxWidth / mb.Dx()
+		if height < 1 {
+			height = 1
+		}
+		mark = resizeImage(mark, maxWidth, height)
+		mb = mark.Bounds()
+	}
+
+	r := watermarkRect(canvas.Bounds(), mb.Size(), opts.Position)
+	opacity := image.NewUniform(color.Alpha{A: uint8(opts.Opacity * 255)})
+	draw.DrawMask(canvas, r, mark, mb.Min, opacity, image.Point{}, draw.Over)
+}
+
+// draw the text watermark in white over a dark shadow, scaled to about 1/25 of the image's shorter side
+func drawTextWatermark(canvas *image.RGBA, opts watermarkOptions) {
+	b := canvas.Bounds()
+	short := b.Dx()
+	if b.Dy() < short {
+		short = b.Dy()
+	}
+
+	scale := short / 25 / 7
+	if scale < 1 {
+		scale = 1
+	}
+
+	mask := textMask(opts.Text, scale, uint8(opts.Opacity*255))
+	r := watermarkRect(b, mask.Bounds().Size(), opts.Position)
+
+	shadow := (scale + 1) / 2
+	draw.DrawMask(canvas, r.Add(image.Pt(shadow, shadow)), image.NewUniform(color.RGBA{0, 0, 0, 255}), image.Point{}, mask, image.Point{}, draw.Over)
+	draw.DrawMask(canvas, r, image.NewUniform(color.RGBA{255, 255, 255, 255}), image.Point{}, mask, image.Point{}, draw.Over)
+}
+
+// return where a watermark of the given size goes in the image, a small margin in from the edges
+func watermarkRect(b image.Rectangle, size image.Point, position string) image.Rectangle {
+	margin := b.Dx() / 50
+	if b.Dy()/50 < margin {
+		margin = b.Dy() / 50
+	}
+
+	x, y := b.Max.X-margin-size.X, b.Max.Y-margin-size.Y
+	switch position {
+	case "top-left":
+		x, y = b.Min.X+margin, b.Min.Y+margin
+	case "top-right":
+		y = b.Min.Y + margin
+	case "bottom-left":
+		x = b.Min.X + margin
+	case "center":
+		x, y = b.Min.X+(b.Dx()-size.X)/2, b.Min.Y+(b.Dy()-size.Y)/2
+	}
+
+	return image.Rect(x, y, x+size.X, y+size.Y)
+}
+
+// return the text rendered with the pixel font at the given scale, as a mask of the given alpha
+func textMask(text string, scale int, alpha uint8) *image.Alpha {
+	runes := []rune(text)
+	width := len(runes)*6*scale - scale
+	if width < 1 {
+		width = 1
+	}
+
+	mask := image.NewAlpha(image.Rect(0, 0, width, 7*scale))
+
+	for i, r := range runes {
+		glyph, ok := pixelFont[r]
+		if !ok {
+			glyph = pixelFont['?']
+		}
+
+		for col, bits := range glyph {
+			for row := 0; row < 7; row++ {
+				if bits&(1<<uint(row)) == 0 {
+					continue
+				}
+
+				x0, y0 := (i*6+col)*scale, row*scale
+				draw.Draw(mask, image.Rect(x0, y0, x0+scale, y0+scale), image.NewUniform(color.Alpha{A: alpha}), image.Point{}, draw.Src)
+			}
+		}
+	}
+
+	return mask
+}
+
+// 5x7 pixel font: five columns per character, the lowest bit of each the top row
+var pixelFont = map[rune][5]byte{
+	' ': {0x00, 0x00, 0x00, 0x00, 0x00}, '!': {0x00, 0x00, 0x5F, 0x00, 0x00}, '"': {0x00, 0x07, 0x00, 0x07, 0x00}, '#': {0x14, 0x7F, 0x14, 0x7F, 0x14},
+	'$': {0x24, 0x2A, 0x7F, 0x2A, 0x12}, '%': {0x23, 0x13, 0x08, 0x64, 0x62}, '&': {0x36, 0x49, 0x55, 0x22, 0x50}, '\'': {0x00, 0x05, 0x03, 0x00, 0x00},
+	'(': {0x00, 0x1C, 0x22, 0x41, 0x00}, ')': {0x00, 0x41, 0x22, 0x1C, 0x00}, '*': {0x08, 0x2A, 0x1C, 0x2A, 0x08}, '+': {0x08, 0x08, 0x3E, 0x08, 0x08},
+	',': {0x00, 0x50, 0x30, 0x00, 0x00}, '-': {0x08, 0x08, 0x08, 0x08, 0x08}, '.': {0x00, 0x60, 0x60, 0x00, 0x00}, '/': {0x20, 0x10, 0x08, 0x04, 0x02},
+	'0': {0x3E, 0x51, 0x49, 0x45, 0x3E}, '1': {0x00, 0x42, 0x7F, 0x40, 0x00}, '2': {0x42, 0x61, 0x51, 0x49, 0x46}, '3': {0x21, 0x41, 0x45, 0x4B, 0x31},
+	'4': {0x18, 0x14, 0x12, 0x7F, 0x10}, '5': {0x27, 0x45, 0x45, 0x45, 0x39}, '6': {0x3C, 0x4A, 0x49, 0x49, 0x30}, '7': {0x01, 0x71, 0x09, 0x05, 0x03},
+	'8': {0x36, 0x49, 0x49, 0x49, 0x36}, '9': {0x06, 0x49, 0x49, 0x29, 0x1E}, ':': {0x00, 0x36, 0x36, 0x00, 0x00}, ';': {0x00, 0x56, 0x36, 0x00, 0x00},
+	'<': {0x08, 0x14, 0x22, 0x41, 0x00}, '=': {0x14, 0x14, 0x14, 0x14, 0x14}, '>': {0x00, 0x41, 0x22, 0x14, 0x08}, '?': {0x02, 0x01, 0x51, 0x09, 0x06},
+	'@': {0x32, 0x49, 0x79, 0x41, 0x3E}, 'A': {0x7E, 0x11, 0x11, 0x11, 0x7E}, 'B': {0x7F, 0x49, 0x49, 0x49, 0x36}, 'C': {0x3E, 0x41, 0x41, 0x41, 0x22},
+	'D': {0x7F, 0x41, 0x41, 0x22, 0x1C}, 'E': {0x7F, 0x49, 0x49, 0x49, 0x41}, 'F': {0x7F, 0x09, 0x09, 0x01, 0x01}, 'G': {0x3E, 0x41, 0x41, 0x51, 0x32},
+	'H': {0x7F, 0x08, 0x08, 0x08, 0x7F}, 'I': {0x00, 0x41, 0x7F, 0x41, 0x00}, 'J': {0x20, 0x40, 0x41, 0x3F, 0x01}, 'K': {0x7F, 0x08, 0x14, 0x22, 0x41},
+	'L': {0x7F, 0x40, 0x40, 0x40, 0x40}, 'M': {0x7F, 0x02, 0x04, 0x02, 0x7F}, 'N': {0x7F, 0x04, 0x08, 0x10, 0x7F}, 'O': {0x3E, 0x41, 0x41, 0x41, 0x3E},
+	'P': {0x7F, 0x09, 0x09, 0x09, 0x06}, 'Q': {0x3E, 0x41, 0x51, 0x21, 0x5E}, 'R': {0x7F, 0x09, 0x19, 0x29, 0x46}, 'S': {0x46, 0x49, 0x49, 0x49, 0x31},
+	'T': {0x01, 0x01, 0x7F, 0x01, 0x01}, 'U': {0x3F, 0x40, 0x40, 0x40, 0x3F}, 'V': {0x1F, 0x20, 0x40, 0x20, 0x1F}, 'W': {0x7F, 0x20, 0x18, 0x20, 0x7F},
+	'X': {0x63, 0x14, 0x08, 0x14, 0x63}, 'Y': {0x03, 0x04, 0x78, 0x04, 0x03}, 'Z': {0x61, 0x51, 0x49, 0x45, 0x43}, '[': {0x00, 0x7F, 0x41, 0x41, 0x00},
+	'\\': {0x02, 0x04, 0x08, 0x10, 0x20}, ']': {0x00, 0x41, 0x41, 0x7F, 0x00}, '^': {0x04, 0x02, 0x01, 0x02, 0x04}, '_': {0x40, 0x40, 0x40, 0x40, 0x40},
+	'`': {0x00, 0x01, 0x02, 0x04, 0x00}, 'a': {0x20, 0x54, 0x54, 0x54, 0x78}, 'b': {0x7F, 0x48, 0x44, 0x44, 0x38}, 'c': {0x38, 0x44, 0x44, 0x44, 0x20},
+	'd': {0x38, 0x44, 0x44, 0x48, 0x7F}, 'e': {0x38, 0x54, 0x54, 0x54, 0x18}, 'f': {0x08, 0x7E, 0x09, 0x01, 0x02}, 'g': {0x0C, 0x52, 0x52, 0x52, 0x3E},
+	'h': {0x7F, 0x08, 0x04, 0x04, 0x78}, 'i': {0x00, 0x44, 0x7D, 0x40, 0x00}, 'j': {0x20, 0x40, 0x44, 0x3D, 0x00}, 'k': {0x7F, 0x10, 0x28, 0x44, 0x00},
+	'l': {0x00, 0x41, 0x7F, 0x40, 0x00}, 'm': {0x7C, 0x04, 0x18, 0x04, 0x78}, 'n': {0x7C, 0x08, 0x04, 0x04, 0x78}, 'o': {0x38, 0x44, 0x44, 0x44, 0x38},
+	'p': {0x7C, 0x14, 0x14, 0x14, 0x08}, 'q': {0x08, 0x14, 0x14, 0x18, 0x7C}, 'r': {0x7C, 0x08, 0x04, 0x04, 0x08}, 's': {0x48, 0x54, 0x54, 0x54, 0x20},
+	't': {0x04, 0x3F, 0x44, 0x40, 0x20}, 'u': {0x3C, 0x40, 0x40, 0x20, 0x7C}, 'v': {0x1C, 0x20, 0x40, 0x20, 0x1C}, 'w': {0x3C, 0x40, 0x30, 0x40, 0x3C},
+	'x': {0x44, 0x28, 0x10, 0x28, 0x44}, 'y': {0x0C, 0x50, 0x50, 0x50, 0x3C}, 'z': {0x44, 0x64, 0x54, 0x4C, 0x44}, '{': {0x00, 0x08, 0x36, 0x41, 0x00},
+	'|': {0x00, 0x00, 0x7F, 0x00, 0x00}, '}': {0x00, 0x41, 0x36, 0x08, 0x00}, '~': {0x08, 0x04, 0x08, 0x10, 0x08}, '©': {0x3E, 0x5D, 0x55, 0x41, 0x3E},
+}
@@ -0,0 +1,104 @@
+// work ids: a work is identified by the text of its <id> element - a number, a UUID or any other token of letters, digits, dashes,
+// underscores and dots (at most 128 of them), which goes into the work's page and image file names. An id that isn't one of those
+// stops the build, naming it.
+//
+// Feeds whose consumers expect numeric ids can keep them numeric with --numeric-ids: ids must then be whole numbers, and are
+// written without leading zeros or a plus sign (so 007 and 7 are the same work, as before ids could be text).
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// type representing the identifier of a work ("" for a work without one)
+type WorkID string
+
+// longest id a work can have
+const maxWorkIDLength = 128
+
+// whether work ids must be whole numbers (--numeric-ids)
+var numericWorkIDs bool
+
+var workIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// return the id written in a feed as a work id, checking it's usable in file names (and a whole number with --numeric-ids)
+func parseWorkID(text string) (WorkID, error) {
+	text = strings.TrimSpace(text)
+
+	if numericWorkIDs {
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return "", fmt.Errorf("work id %q isn't a whole number (--numeric-ids)", text)
+		}
+		return workIDFromInt(n), nil
+	}
+
+	if text == "" || len(text) > maxWorkIDLength || !workIDPattern.MatchString(text) || strings.Trim(text, ".") == "" {
+		return "", fmt.Errorf("work id %q should be 1 to %d letters, digits, dashes, underscores or dots", text, maxWorkIDLength)
+	}
+
+	return WorkID(text), nil
+}
+
+// return the work id of a number
+func workIDFromInt(n int) WorkID {
+	return WorkID(strconv.Itoa(n))
+}
+
+// return the id as text
+func (id WorkID) String() string {
+	return string(id)
+}
+
+// return the id as a number, for sources and targets that only have numeric ids - ok is false if it isn't a whole number
+func (id WorkID) Int() (n int, ok bool) {
+	n, err := strconv.Atoi(string(id))
+	return n, err == nil
+}
+
+// return whether the id orders before another: numbers by value and before text ids, which are in alphabetical order
+func (id WorkID) Less(other WorkID) bool {
+	a, aNum := id.Int()
+	b, bNum := other.Int()
+	switch {
+	case aNum && bNum:
+		return a < b
+	case aNum != bNum:
+		return aNum
+	}
+
+	return id < other
+}
+
+// write the id as a JSON number when it is one (as exports, manifests and diffs wrote ids before they could be text), a string otherwise
+func (id WorkID) MarshalJSON() ([]byte, error) {
+	if n, ok := id.Int(); ok && workIDFromInt(n) == id {
+		return []byte(id), nil
+	}
+
+	return json.Marshal(string(id))
+}
+
+// read an id written as a JSON number or string (config exclusion lists, exports)
+func (id *WorkID) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("work id %s should be a number or a string", data)
+		}
+		text = n.String()
+	}
+
+	parsed, err := parseWorkID(text)
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
@@ -0,0 +1,100 @@
+// work index: every build writes work-index.json, mapping each work's id to the URL of its detail page, its make and model pages and
+// its images as the site serves them, so the system providing the feed can link back to the static site without guessing its file
+// names:
+//
+//	{"base_url": "https://photos.example.com/", "works": {"101": {
+//	  "page": "work-101.html", "url": "https://photos.example.com/work-101.html",
+//	  "make_page": "Canon.html", "model_page": "Canon-EOS-80D.html",
+//	  "images": {"small": "https://photos.example.com/images/101-small.jpg", ...}}, ...}}
+//
+// Page paths are relative to the output directory; the URLs are absolute when the build has a --base-url. Works left out of the site
+// aren't listed, and works in the flagged section (see Flagged.go) are listed without make and model pages.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// name of the JSON index of the works' pages and images
+const workIndexFile = "work-index.json"
+
+// type struct representing the work index
+type workIndex struct {
+	BaseURL string                    `json:"base_url,omitempty"`
+	Works   map[WorkID]workIndexEntry `json:"works"`
+}
+
+// type struct representing a work in the work index
+type workIndexEntry struct {
+	Page      string            `json:"page"`
+	URL       string            `json:"url,omitempty"` // with a base URL
+	MakePage  string            `json:"make_page,omitempty"`
+	ModelPage string            `json:"model_page,omitempty"`
+	Flagged   bool              `json:"flagged,omitempty"`
+	Images    map[string]string `json:"images,omitempty"` // by size: small, medium and large
+}
+
+// return the URL an image is served from - a local copy's is absolute with a base URL, a remote one is as published (mirrored and
+// signed - see ImageMirrors.go)
+func (s *siteWriter) imageURL(src string) string {
+	if src == "" || strings.Contains(src, "://") || strings.HasPrefix(src, "/") || s.baseURL == "" {
+		return src
+	}
+
+	return s.canonical(src)
+}
+
+// return a work's entry in the work index
+func (s *siteWriter) workIndexEntry(wk *Work) workIndexEntry {
+	e := workIndexEntry{Page: wk.pageURL(), URL: s.canonical(wk.pageURL()), Flagged: wk.Flagged}
+
+	if wk.WMake != nil && wk.WMake.PageURL != "" {
+		e.MakePage = wk.WMake.PageURL + ".html"
+	}
+	if wk.WModel != nil && wk.WModel.PageURL != "" {
+		e.ModelPage = wk.WModel.PageURL + ".html"
+	}
+
+	for size, src := range map[string]string{"small": wk.smallSrc(), "medium": wk.mediumSrc(), "large": wk.largeSrc()} {
+		if src = s.imageURL(strings.TrimSpace(publishedImageURL(src))); src != "" {
+			if e.Images == nil {
+				e.Images = map[string]string{}
+			}
+			e.Images[size] = src
+		}
+	}
+
+	return e
+}
+
+// write the work index of the works with pages on the site
+func writeWorkIndex(site *siteWriter, catalog *Catalog) {
+	index := workIndex{BaseURL: site.baseURL, Works: map[WorkID]workIndexEntry{}}
+
+	for _, wk := range catalog.Works {
+		if wk != nil {
+			index.Works[wk.ID] = site.workIndexEntry(wk)
+		}
+	}
+	for _, wk := range catalog.Flagged {
+		e := site.workIndexEntry(wk)
+		e.MakePage, e.ModelPage = "", "" // the make and model pages don't show it
+		index.Works[wk.ID] = e
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join("./"+site.outputFolderLocation, workIndexFile), append(data, '\n'), 0644)
+	}
+	if err != nil {
+		site.fail(fmt.Errorf("Error writing work index (%s): %v", workIndexFile, err))
+		return
+	}
+
+	site.written = append(site.written, workIndexFile)
+}
@@ -0,0 +1,162 @@
+// work pages: a detail page per work, with previous/next links through the work's model, its make and all works by capture date.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// type struct representing a work's neighbours in one browsing order (nil at either end)
+type workNeighbours struct {
+	Prev, Next *Work
+}
+
+// type struct representing a work's neighbours in each browsing order
+type workPager struct {
+	Model, Make, Date workNeighbours
+}
+
+// write a detail page for every work in the catalog and its flagged section - pages that can't be written are recorded with the site writer
+func generateWorkPages(site *siteWriter, catalog *Catalog, assets *siteAssets, policy *htmlPolicy) {
+	pagers := map[*Work]*workPager{}
+	pagerFor := func(wk *Work) *workPager {
+		if pagers[wk] == nil {
+			pagers[wk] = &workPager{}
+		}
+		return pagers[wk]
+	}
+
+	for _, mk := range catalog.Makes {
+		if mk == nil {
+			continue
+		}
+
+		for wk, n := range neighboursIn(mk.Works) {
+			pagerFor(wk).Make = n
+		}
+
+		for _, md := range mk.Models {
+			if md != nil {
+				for wk, n := range neighboursIn(md.Works) {
+					pagerFor(wk).Model = n
+				}
+			}
+		}
+	}
+
+	for wk, n := range neighboursIn(chronologicalWorks(catalog.Works)) {
+		pagerFor(wk).Date = n
+	}
+
+	// the flagged section's works only link to each other (see Flagged.go)
+	for wk, n := range neighboursIn(chronologicalWorks(catalog.Flagged)) {
+		pagerFor(wk).Date = n
+	}
+
+	for _, wk := range append(append([]*Work(nil), catalog.Works...), catalog.Flagged...) {
+		if wk == nil {
+			continue
+		}
+
+		view := workPageView(wk, assets, pagerFor(wk))
+		view.Canonical = site.canonical(wk.pageURL())
+		view.Description, view.DescriptionParts = catalog.renderDescription(wk.Description)
+		view.Caption = policy.sanitize(wk.Caption)
+
+		if err := site.writeView(wk.pageURL(), "work", view); err != nil {
+			site.fail(fmt.Errorf("Error writing output to work HTML file (%s): %v", wk.pageURL(), err))
+		}
+	}
+}
+
+// return the template view of a work's detail page
+func workPageView(wk *Work, assets *siteAssets, pager *workPager) *pageView {
+	view := &pageView{Title: titleOr(wk.Title, wk.FileName), Description: wk.Description, Assets: assets, Work: wk, Large: publishedImageURL(wk.largeSrc()), Comments: commentSystem, License: wk.license(), Author: wk.Author, Lens: wk.Lens, Location: workLocation(wk), Settings: workSettings(wk)}
+
+	view.Image = workImage(wk, "medium")
+	view.Image.Alt = wk.FileName
+
+	if wk.WModel != nil {
+		view.Pagers = appendPager(view.Pagers, wk.WModel.DisplayName, pager.Model)
+	}
+
+	if wk.WMake != nil {
+		view.Pagers = appendPager(view.Pagers, wk.WMake.DisplayName, pager.Make)
+	}
+
+	view.Pagers = appendPager(view.Pagers, "By date", pager.Date)
+
+	if wk.WMake != nil && wk.WModel != nil {
+		view.Details = "Taken with a " + wk.WMake.DisplayName + " " + wk.WModel.DisplayName
+	}
+	if !wk.Date.IsZero() {
+		if view.Details == "" {
+			view.Details = "Taken"
+		}
+		view.Details = view.Details + " on " + wk.Date.Format("2 January 2006")
+	}
+
+	return view
+}
+
+// add the previous/next links of one browsing order to a work page's pagers, unless the work has neither
+func appendPager(pagers []pagerView, label string, n workNeighbours) []pagerView {
+	if n.Prev == nil && n.Next == nil {
+		return pagers
+	}
+
+	p := pagerView{Label: label}
+	if n.Prev != nil {
+		p.Prev = n.Prev.pageURL()
+	}
+	if n.Next != nil {
+		p.Next = n.Next.pageURL()
+	}
+
+	return append(pagers, p)
+}
+
+// return each work's neighbours in the given order
+func neighboursIn(works []*Work) map[*Work]workNeighbours {
+	var list []*Work
+	for _, wk := range works {
+		if wk != nil {
+			list = append(list, wk)
+		}
+	}
+
+	neighbours := make(map[*Work]workNeighbours, len(list))
+	for i, wk := range list {
+		var n workNeighbours
+		if i > 0 {
+			n.Prev = list[i-1]
+		}
+		if i < len(list)-1 {
+			n.Next = list[i+1]
+		}
+		neighbours[wk] = n
+	}
+
+	return neighbours
+}
+
+// return the works ordered by capture date - works with no known date go last, in feed order
+func chronologicalWorks(works []*Work) []*Work {
+	var sorted []*Work
+	for _, wk := range works {
+		if wk != nil {
+			sorted = append(sorted, wk)
+		}
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].Date, sorted[j].Date
+		if a.IsZero() || b.IsZero() {
+			return !a.IsZero() && b.IsZero()
+		}
+		return a.Before(b)
+	})
+
+	return sorted
+}
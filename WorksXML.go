@@ -0,0 +1,147 @@
+// works XML writer: serializes a catalog in the works feed format the generator reads, so imported catalogs can be kept and rebuilt -
+// and, with export --format xml, so a feed can be cleaned up (ids and whitespace normalized, dates in one format, works with a
+// repeated id dropped) for other consumers:
+//
+//	>go run ImageProcessor export --format xml --output clean.xml http://localhost/test/api/v1/works.xml
+
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+)
+
+// type struct representing a <work> in a works feed
+type xmlWork struct {
+	ID        WorkID        `xml:"id"`
+	FileName  string        `xml:"filename"`
+	Author    string        `xml:"author,omitempty"`
+	Featured  *struct{}     `xml:"featured"`
+	Flagged   *struct{}     `xml:"flagged"`
+	Rating    string        `xml:"rating,omitempty"`
+	Tags      []string      `xml:"tags>tag,omitempty"`
+	Checksums []xmlChecksum `xml:"checksum"`
+	Copyright string        `xml:"copyright,omitempty"`
+	Caption   string        `xml:"caption,omitempty"`
+	License   *xmlLicense   `xml:"license"`
+	Latitude  string        `xml:"latitude,omitempty"`
+	Longitude string        `xml:"longitude,omitempty"`
+	URLs      []xmlURL      `xml:"urls>url"`
+	Exif      xmlExif       `xml:"exif"`
+}
+
+// type struct representing a <url> of a work
+type xmlURL struct {
+	Type string `xml:"type,attr"`
+	URI  string `xml:",chardata"`
+}
+
+// type struct representing a <checksum> of one of a work's images
+type xmlChecksum struct {
+	Type string `xml:"type,attr"`
+	Sum  string `xml:",chardata"`
+}
+
+// type struct representing the <license> of a work
+type xmlLicense struct {
+	URL  string `xml:"url,attr,omitempty"`
+	Name string `xml:",chardata"`
+}
+
+// type struct representing the <exif> block of a work - the model has to come before the make for the feed parser
+type xmlExif struct {
+	Model string `xml:"model,omitempty"`
+	Make  string `xml:"make,omitempty"`
+	Date  string `xml:"date,omitempty"`
+	Lens  string `xml:"lens,omitempty"`
+
+	FocalLength string `xml:"focal_length,omitempty"`
+	Aperture    string `xml:"aperture,omitempty"`
+	ISO         string `xml:"iso,omitempty"`
+}
+
+// write the catalog as a works feed - one work at a time, so a large catalog isn't held in memory twice over
+func writeWorksXML(out io.Writer, catalog *Catalog) error {
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(out)
+	enc.Indent("", "  ")
+
+	root := xml.StartElement{Name: xml.Name{Local: "works"}}
+	if err := enc.EncodeToken(root); err != nil {
+		return err
+	}
+
+	for _, ew := range exportWorks(catalog) {
+		w := xmlWork{ID: ew.ID, FileName: ew.FileName, Author: ew.Author, Tags: ew.Tags, Copyright: ew.Copyright, Caption: ew.Caption, Rating: ew.Rating, Exif: xmlExif{Model: ew.Model, Make: ew.Make, Date: ew.Date, Lens: ew.Lens}}
+
+		if ew.Featured {
+			w.Featured = &struct{}{}
+		}
+		if ew.Flagged {
+			w.Flagged = &struct{}{}
+		}
+		if ew.License != "" {
+			w.License = &xmlLicense{URL: ew.LicenseURL, Name: ew.License}
+		}
+		if ew.FocalLength > 0 {
+			w.Exif.FocalLength = strconv.FormatFloat(ew.FocalLength, 'f', -1, 64)
+		}
+		if ew.Aperture > 0 {
+			w.Exif.Aperture = strconv.FormatFloat(ew.Aperture, 'f', -1, 64)
+		}
+		if ew.ISO > 0 {
+			w.Exif.ISO = strconv.Itoa(ew.ISO)
+		}
+		if ew.GPS != nil {
+			w.Latitude, w.Longitude = strconv.FormatFloat(ew.GPS.Lat, 'f', -1, 64), strconv.FormatFloat(ew.GPS.Lon, 'f', -1, 64)
+		}
+
+		for _, u := range []xmlURL{{"small", ew.URISmall}, {"medium", ew.URIMedium}, {"large", ew.URILarge}} {
+			if u.URI != "" {
+				w.URLs = append(w.URLs, u)
+			}
+			if sum := ew.Checksums[u.Type]; sum != "" {
+				w.Checksums = append(w.Checksums, xmlChecksum{u.Type, sum})
+			}
+		}
+
+		if err := enc.EncodeElement(w, xml.StartElement{Name: xml.Name{Local: "work"}}); err != nil {
+			return err
+		}
+	}
+
+	if err := enc.EncodeToken(root.End()); err != nil {
+		return err
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(out, "\n")
+	return err
+}
+
+// remove the works whose id an earlier work in the feed already has (the site would only show one of them), returning how many went
+func dedupeWorks(catalog *Catalog) int {
+	seen := map[WorkID]bool{}
+	removed := 0
+
+	for _, wk := range append([]*Work(nil), catalog.Works...) {
+		if wk == nil {
+			continue
+		}
+
+		if seen[wk.ID] {
+			catalog.removeWork(wk)
+			removed++
+			continue
+		}
+		seen[wk.ID] = true
+	}
+
+	return removed
+}
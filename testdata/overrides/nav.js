@@ -0,0 +1,45 @@
+document.querySelectorAll("select[data-nav]").forEach(function (s) {
+	s.addEventListener("change", function () { if (this.value) window.location.href = this.value; });
+});
+document.querySelectorAll("[data-flagged]").forEach(function (a) {
+	a.addEventListener("click", function (e) {
+		if (!this.classList.contains("flagged")) return; // shown already: the link works as usual
+		this.classList.remove("flagged");
+		this.removeAttribute("title");
+		e.preventDefault();
+	});
+});
+document.querySelectorAll("[data-slideshow]").forEach(function (show) {
+	var slides = show.querySelectorAll("figure"), current = 0, timer = null;
+	var interval = parseInt(show.getAttribute("data-interval"), 10) * 1000 || 0;
+	var toggle = show.querySelector("[data-slideshow-toggle]"), position = show.querySelector("[data-slideshow-position]");
+	function go(n) {
+		slides[current].classList.remove("current");
+		current = (n + slides.length) % slides.length;
+		slides[current].classList.add("current");
+		position.textContent = (current + 1) + " / " + slides.length;
+		if (timer) play(true);
+	}
+	function play(on) {
+		clearInterval(timer);
+		timer = on ? setInterval(function () { go(current + 1); }, interval || 5000) : null;
+		toggle.textContent = timer ? "pause" : "play";
+	}
+	show.querySelector("[data-slideshow-prev]").addEventListener("click", function () { go(current - 1); });
+	show.querySelector("[data-slideshow-next]").addEventListener("click", function () { go(current + 1); });
+	toggle.addEventListener("click", function () { play(!timer); });
+	document.addEventListener("keydown", function (e) {
+		if (e.altKey || e.ctrlKey || e.metaKey) return;
+		if (e.key === " " && e.target.tagName === "BUTTON") return; // the focused button takes the space bar itself
+		switch (e.key) {
+		case "ArrowLeft": go(current - 1); break;
+		case "ArrowRight": go(current + 1); break;
+		case "Home": go(0); break;
+		case "End": go(slides.length - 1); break;
+		case " ": play(!timer); break;
+		default: return;
+		}
+		e.preventDefault();
+	});
+	if (interval) play(true);
+});